@@ -0,0 +1,249 @@
+// Package alert evaluates threshold rules against points as they are
+// written, for basic alerting without an external system.  Rules are
+// usually loaded from JSON such as
+//
+//	[{
+//	  "name": "cpu-high",
+//	  "pattern": "^servers\\..*\\.cpu$",
+//	  "above": 90,
+//	  "for": 5,
+//	  "webhook": "https://hooks.example.com/journal"
+//	}]
+//
+// which fires once the cpu series of any server has been above 90 for 5
+// consecutive points and resolves at its next point that is not.  Each
+// change is reported as an Event to Engine.OnEvent and, for rules with a
+// webhook, POSTed to it as JSON.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/ingest"
+	"github.com/jjneely/journal/stats"
+)
+
+// QueueSize is the number of webhook deliveries that may wait to be sent
+// before more are dropped.
+const QueueSize = 1024
+
+// WebhookTimeout bounds each webhook delivery.
+const WebhookTimeout = 10 * time.Second
+
+// Rule is a threshold on the values of the series matching a pattern.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"` // regular expression
+
+	// Above and Below, if set, are the values that points must exceed
+	// or fall short of to breach the rule.  At least one is required.
+	Above *float64 `json:"above"`
+	Below *float64 `json:"below"`
+
+	// For is how many consecutive points must breach the rule for it to
+	// fire, at least one.
+	For int `json:"for"`
+
+	// Webhook, if set, is the URL events are POSTed to.
+	Webhook string `json:"webhook"`
+
+	pattern *regexp.Regexp
+}
+
+// breached reports whether value breaches the rule.
+func (r *Rule) breached(value float64) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	return (r.Above != nil && value > *r.Above) || (r.Below != nil && value < *r.Below)
+}
+
+// Event reports that a rule fired or resolved for a series.
+type Event struct {
+	Rule      string  `json:"rule"`
+	Series    string  `json:"series"`
+	State     string  `json:"state"` // firing or resolved
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s for %s at %d with %g", e.Rule, e.State, e.Series, e.Timestamp, e.Value)
+}
+
+// streak tracks the consecutive breaches of a rule by a series.
+type streak struct {
+	last   int64 // timestamp of the last point seen
+	count  int
+	firing bool
+}
+
+// Engine evaluates Rules against points.  Its webhooks are delivered in
+// the background until Close.
+type Engine struct {
+	// OnEvent, if set, is called with every Event, such as to log it.
+	OnEvent func(e Event)
+
+	// Client, if set, is used in place of a client with WebhookTimeout
+	// to deliver webhooks.
+	Client *http.Client
+
+	// Fired counts the events of rules firing, Failed the webhooks that
+	// could not be delivered, and Dropped those discarded because too
+	// many were waiting.
+	Fired   stats.Counter
+	Failed  stats.Counter
+	Dropped stats.Counter
+
+	rules   []*Rule
+	mu      sync.Mutex
+	streaks map[string]map[string]*streak // by rule, then series
+	queue   chan delivery
+	wg      sync.WaitGroup
+}
+
+// delivery is an Event waiting to be POSTed to a webhook.
+type delivery struct {
+	url   string
+	event Event
+}
+
+// NewEngine checks and compiles rules and starts delivering webhooks.
+func NewEngine(rules []Rule) (*Engine, error) {
+	e := &Engine{
+		streaks: make(map[string]map[string]*streak),
+		queue:   make(chan delivery, QueueSize),
+	}
+	for i := range rules {
+		r := rules[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("Alert rule %d has no name", i)
+		}
+		if r.Above == nil && r.Below == nil {
+			return nil, fmt.Errorf("Alert rule %s needs above or below", r.Name)
+		}
+		var err error
+		if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			return nil, fmt.Errorf("Alert rule %s: %s", r.Name, err)
+		}
+		r.For = max(r.For, 1)
+		e.rules = append(e.rules, &r)
+		e.streaks[r.Name] = make(map[string]*streak)
+	}
+	e.wg.Add(1)
+	go e.deliver()
+	return e, nil
+}
+
+// Observe evaluates every rule matching the series of a point written.
+// Points no newer than the last seen for a series are ignored.
+func (e *Engine) Observe(p ingest.Point) {
+	var events []Event
+	var hooks []string
+	e.mu.Lock()
+	for _, r := range e.rules {
+		if !r.pattern.MatchString(p.Series) {
+			continue
+		}
+		s := e.streaks[r.Name][p.Series]
+		if s == nil {
+			s = &streak{}
+			e.streaks[r.Name][p.Series] = s
+		} else if p.Timestamp <= s.last {
+			continue
+		}
+		s.last = p.Timestamp
+
+		event := Event{Rule: r.Name, Series: p.Series, Timestamp: p.Timestamp, Value: p.Value}
+		if r.breached(p.Value) {
+			s.count++
+			if s.count < r.For || s.firing {
+				continue
+			}
+			s.firing = true
+			event.State = "firing"
+			e.Fired.Add(1)
+		} else {
+			s.count = 0
+			if !s.firing {
+				continue
+			}
+			s.firing = false
+			event.State = "resolved"
+		}
+		events = append(events, event)
+		hooks = append(hooks, r.Webhook)
+	}
+	e.mu.Unlock()
+
+	for i, event := range events {
+		if e.OnEvent != nil {
+			e.OnEvent(event)
+		}
+		if hooks[i] == "" {
+			continue
+		}
+		select {
+		case e.queue <- delivery{hooks[i], event}:
+		default:
+			e.Dropped.Add(1)
+		}
+	}
+}
+
+// Firing returns the events of the rules currently firing, in no
+// particular order.
+func (e *Engine) Firing() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var firing []Event
+	for rule, streaks := range e.streaks {
+		for series, s := range streaks {
+			if s.firing {
+				firing = append(firing, Event{Rule: rule, Series: series, State: "firing", Timestamp: s.last})
+			}
+		}
+	}
+	return firing
+}
+
+// deliver POSTs queued events to their webhooks until Close.
+func (e *Engine) deliver() {
+	defer e.wg.Done()
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: WebhookTimeout}
+	}
+	for d := range e.queue {
+		body, err := json.Marshal(d.event)
+		if err != nil {
+			e.Failed.Add(1)
+			continue
+		}
+		resp, err := client.Post(d.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			e.Failed.Add(1)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			e.Failed.Add(1)
+		}
+	}
+}
+
+// Close delivers the webhooks already queued and stops.  Observe must not
+// be called afterward.
+func (e *Engine) Close() {
+	close(e.queue)
+	e.wg.Wait()
+}
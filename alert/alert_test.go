@@ -0,0 +1,64 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+import (
+	"github.com/jjneely/journal/ingest"
+)
+
+func TestEngine(t *testing.T) {
+	var mu sync.Mutex
+	var posted []Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("Webhook body: %s", err)
+		}
+		mu.Lock()
+		posted = append(posted, e)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	high, low := 90.0, 1.0
+	e, err := NewEngine([]Rule{
+		{Name: "high", Pattern: `\.cpu$`, Above: &high, For: 3, Webhook: srv.URL},
+		{Name: "low", Pattern: `^a\.`, Below: &low},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	e.OnEvent = func(ev Event) { events = append(events, ev.String()) }
+
+	for i, v := range []float64{95, 99, 50, 91, 92, math.NaN(), 93, 94, 95, 96, 10} {
+		e.Observe(ingest.Point{Series: "a.cpu", Timestamp: int64(60 * (i + 1)), Value: v})
+	}
+	// Points no newer than the last are ignored
+	e.Observe(ingest.Point{Series: "a.cpu", Timestamp: 60, Value: 0})
+	e.Observe(ingest.Point{Series: "a.mem", Timestamp: 60, Value: 0})
+	e.Close()
+
+	want := "[high firing for a.cpu at 540 with 95 high resolved for a.cpu at 660 with 10 low firing for a.mem at 60 with 0]"
+	if fmt.Sprint(events) != want {
+		t.Errorf("Events %v", events)
+	}
+	if len(posted) != 2 || posted[0].State != "firing" || posted[1].State != "resolved" {
+		t.Errorf("Webhooks received %v", posted)
+	}
+	if e.Fired.Value() != 2 || e.Failed.Value() != 0 || len(e.Firing()) != 1 {
+		t.Errorf("Fired %d, failed %d, firing %v", e.Fired.Value(), e.Failed.Value(), e.Firing())
+	}
+
+	if _, err = NewEngine([]Rule{{Name: "x", Pattern: "."}}); err == nil {
+		t.Errorf("Rule without a threshold accepted")
+	}
+}
@@ -0,0 +1,94 @@
+// Package analytics examines the values stored in timeseries journals,
+// flagging outliers and forecasting future values.
+package analytics
+
+import (
+	"fmt"
+	"math"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Rules select which values Anomalies flags.  A zero Rules flags nothing.
+type Rules struct {
+	// Sigma flags values more than Sigma standard deviations from the
+	// mean of the range.  Zero disables the check.
+	Sigma float64
+
+	// Min and Max, if set, flag values below and above them.
+	Min, Max *float64
+}
+
+// Anomaly is a value flagged by Anomalies.
+type Anomaly struct {
+	Timestamp int64
+	Value     float64
+	Reason    string
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%d %g %s", a.Timestamp, a.Value, a.Reason)
+}
+
+// Anomalies scans the range from through until inclusive of a journal
+// and returns the non-null values that break the rules in time order.
+func Anomalies(j timeseries.Journal, from, until int64, r Rules) ([]Anomaly, error) {
+	values, start, err := read(j, from, until)
+	if err != nil {
+		return nil, err
+	}
+	return Flag(values, start, j.Interval(), r), nil
+}
+
+// Flag applies the rules to values, the first of which is at timestamp
+// start with interval time units between them.  Nulls (NaN) are ignored.
+func Flag(values []float64, start, interval int64, r Rules) []Anomaly {
+	mean, stddev := MeanStddev(values)
+
+	var out []Anomaly
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		reason := ""
+		switch {
+		case r.Min != nil && v < *r.Min:
+			reason = fmt.Sprintf("below minimum %g", *r.Min)
+		case r.Max != nil && v > *r.Max:
+			reason = fmt.Sprintf("above maximum %g", *r.Max)
+		case r.Sigma > 0 && math.Abs(v-mean) > r.Sigma*stddev:
+			reason = fmt.Sprintf("%.1f standard deviations from mean %g",
+				math.Abs(v-mean)/stddev, mean)
+		}
+		if reason != "" {
+			out = append(out, Anomaly{start + int64(i)*interval, v, reason})
+		}
+	}
+	return out
+}
+
+// MeanStddev returns the mean and population standard deviation of the
+// non-null values.  Both are NaN if there are none.
+func MeanStddev(values []float64) (float64, float64) {
+	sum, n := 0.0, 0
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			sum += v
+			n++
+		}
+	}
+	if n == 0 {
+		return math.NaN(), math.NaN()
+	}
+	mean := sum / float64(n)
+
+	squares := 0.0
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			squares += (v - mean) * (v - mean)
+		}
+	}
+	return mean, math.Sqrt(squares / float64(n))
+}
@@ -0,0 +1,57 @@
+package analytics
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const epoch = int64(1449240540)
+
+func TestAnomalies(t *testing.T) {
+	path := "/tmp/test-anomalies.tsj"
+	os.Remove(path)
+	j, err := timeseries.Create(path, 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(epoch, Float64Values{10, 11, 9, math.NaN(), 10, 100, 10, 11, 9, 10, -1})
+
+	a, err := Anomalies(j, 0, epoch+3600, Rules{Sigma: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || a[0].Timestamp != epoch+5*60 || a[0].Value != 100 {
+		t.Errorf("Sigma check flagged %v", a)
+	}
+
+	min, max := 0.0, 50.0
+	a, err = Anomalies(j, epoch+6*60, epoch+3600, Rules{Min: &min, Max: &max})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 1 || a[0].Timestamp != epoch+10*60 || a[0].Value != -1 {
+		t.Errorf("Bounds check flagged %v", a)
+	}
+
+	if a := Flag([]float64{1, 2, 3}, epoch, 60, Rules{}); len(a) != 0 {
+		t.Errorf("Zero rules flagged %v", a)
+	}
+}
+
+func TestMeanStddev(t *testing.T) {
+	mean, stddev := MeanStddev([]float64{2, 4, math.NaN(), 4, 4, 5, 5, 7, 9})
+	if mean != 5 || stddev != 2 {
+		t.Errorf("MeanStddev = %g, %g", mean, stddev)
+	}
+	mean, _ = MeanStddev([]float64{math.NaN()})
+	if !math.IsNaN(mean) {
+		t.Errorf("Mean of nulls is %g", mean)
+	}
+}
@@ -0,0 +1,157 @@
+package analytics
+
+import (
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Smooth applies simple exponential smoothing to values.  Each output is
+// the forecast for the corresponding input made from the inputs before
+// it.  Nulls (NaN) leave the forecast unchanged, and the output is null
+// until the first non-null input.
+func Smooth(values []float64, alpha float64) []float64 {
+	out := make([]float64, len(values))
+	level := math.NaN()
+	for i, v := range values {
+		out[i] = level
+		switch {
+		case math.IsNaN(v):
+		case math.IsNaN(level):
+			level = v
+		default:
+			level = alpha*v + (1-alpha)*level
+		}
+	}
+	return out
+}
+
+// HoltWinters holds the parameters of additive triple exponential
+// smoothing as implemented by Graphite's holtWintersForecast.
+type HoltWinters struct {
+	Alpha  float64 // level smoothing
+	Beta   float64 // trend smoothing
+	Gamma  float64 // seasonal and deviation smoothing
+	Season int     // points per season
+}
+
+// DefaultHoltWinters returns Graphite's parameters with a season of one
+// day for values every interval seconds.
+func DefaultHoltWinters(interval int64) HoltWinters {
+	season := int(86400 / interval)
+	if season < 1 {
+		season = 1
+	}
+	return HoltWinters{Alpha: 0.1, Beta: 0.0035, Gamma: 0.1, Season: season}
+}
+
+// Forecast is the result of a Holt-Winters analysis.  Predictions[i] is
+// the forecast of value i made from the values before it and
+// Deviations[i] is the smoothed seasonal deviation of the forecasts.
+type Forecast struct {
+	Predictions []float64
+	Deviations  []float64
+}
+
+// Analyze runs the Holt-Winters model over values.  Null values produce
+// null predictions and leave the model unchanged.
+func (hw HoltWinters) Analyze(values []float64) Forecast {
+	n := len(values)
+	f := Forecast{make([]float64, n), make([]float64, n)}
+	seasonals := make([]float64, n)
+	deviations := make([]float64, n)
+	season := func(s []float64, i int) float64 {
+		if i -= hw.Season; i >= 0 && !math.IsNaN(s[i]) {
+			return s[i]
+		}
+		return 0
+	}
+
+	intercept, slope, next := math.NaN(), 0.0, math.NaN()
+	for i, actual := range values {
+		if math.IsNaN(actual) {
+			f.Predictions[i] = math.NaN()
+			f.Deviations[i] = math.NaN()
+			seasonals[i] = math.NaN()
+			deviations[i] = math.NaN()
+			continue
+		}
+		prediction := next
+		if math.IsNaN(intercept) {
+			intercept, prediction = actual, actual
+		}
+
+		lastSeasonal := season(seasonals, i)
+		lastIntercept := intercept
+		intercept = hw.Alpha*(actual-lastSeasonal) + (1-hw.Alpha)*(intercept+slope)
+		slope = hw.Beta*(intercept-lastIntercept) + (1-hw.Beta)*slope
+		seasonals[i] = hw.Gamma*(actual-intercept) + (1-hw.Gamma)*lastSeasonal
+		deviations[i] = hw.Gamma*math.Abs(actual-prediction) +
+			(1-hw.Gamma)*season(deviations, i)
+		next = intercept + slope + season(seasonals, i+1)
+
+		f.Predictions[i] = prediction
+		f.Deviations[i] = deviations[i]
+	}
+	return f
+}
+
+// Bands returns the confidence band delta deviations either side of each
+// prediction.  Graphite uses a delta of 3.
+func (f Forecast) Bands(delta float64) (lower, upper []float64) {
+	lower = make([]float64, len(f.Predictions))
+	upper = make([]float64, len(f.Predictions))
+	for i, p := range f.Predictions {
+		lower[i] = p - delta*f.Deviations[i]
+		upper[i] = p + delta*f.Deviations[i]
+	}
+	return lower, upper
+}
+
+// ForecastJournal analyzes the range from through until inclusive of src
+// and writes the predictions and the lower and upper confidence bands at
+// delta deviations to the given float64 journals, each of which may be
+// nil.  The destination journals must have the interval of src.
+func ForecastJournal(src timeseries.Journal, from, until int64, hw HoltWinters, delta float64, forecast, lower, upper timeseries.Journal) error {
+	values, start, err := read(src, from, until)
+	if err != nil || len(values) == 0 {
+		return err
+	}
+
+	f := hw.Analyze(values)
+	low, high := f.Bands(delta)
+	for _, out := range []struct {
+		j      timeseries.Journal
+		values []float64
+	}{{forecast, f.Predictions}, {lower, low}, {upper, high}} {
+		if out.j == nil {
+			continue
+		}
+		if err = out.j.Write(start, Float64Values(out.values)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read returns the range from through until inclusive of a journal as
+// floats along with the timestamp of the first value.
+func read(j timeseries.Journal, from, until int64) ([]float64, int64, error) {
+	values, err := j.ReadRange(from, until)
+	if err != nil {
+		return nil, 0, err
+	}
+	floats, err := rollup.Floats(values)
+	if err != nil {
+		return nil, 0, err
+	}
+	start := from - from%j.Interval()
+	if start < j.Epoch() {
+		start = j.Epoch()
+	}
+	return floats, start, nil
+}
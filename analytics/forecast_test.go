@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func TestSmooth(t *testing.T) {
+	got := fmt.Sprint(Smooth([]float64{math.NaN(), 10, 20, math.NaN(), 10}, 0.5))
+	if want := "[NaN NaN 10 15 15]"; got != want {
+		t.Errorf("Smooth = %s, want %s", got, want)
+	}
+}
+
+func TestHoltWinters(t *testing.T) {
+	// A repeating pattern with a season of 4 points
+	values := make([]float64, 400)
+	for i := range values {
+		values[i] = []float64{10, 20, 30, 20}[i%4]
+	}
+	values[201] = math.NaN()
+	hw := HoltWinters{Alpha: 0.1, Beta: 0.0035, Gamma: 0.1, Season: 4}
+	f := hw.Analyze(values)
+
+	if !math.IsNaN(f.Predictions[201]) {
+		t.Errorf("Null value was predicted: %g", f.Predictions[201])
+	}
+	for i := 390; i < 400; i++ {
+		if math.Abs(f.Predictions[i]-values[i]) > 3 {
+			t.Errorf("Prediction %d is %g, want near %g", i, f.Predictions[i], values[i])
+		}
+	}
+
+	lower, upper := f.Bands(3)
+	if !(lower[399] <= f.Predictions[399] && f.Predictions[399] <= upper[399]) {
+		t.Errorf("Prediction %g is outside band %g to %g",
+			f.Predictions[399], lower[399], upper[399])
+	}
+}
+
+func TestForecastJournal(t *testing.T) {
+	paths := []string{"/tmp/test-forecast-src.tsj", "/tmp/test-forecast.tsj",
+		"/tmp/test-forecast-lower.tsj", "/tmp/test-forecast-upper.tsj"}
+	var js []*timeseries.FileJournal
+	for _, path := range paths {
+		os.Remove(path)
+		j, err := timeseries.Create(path, 60, NewFloat64ValueType(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer j.Close()
+		js = append(js, j)
+	}
+	js[0].Write(epoch, Float64Values{1, 2, 3, 4, 5})
+
+	err := ForecastJournal(js[0], 0, epoch+3600, DefaultHoltWinters(60), 3, js[1], js[2], js[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range js[1:] {
+		if j.Epoch() != epoch || j.Last() != epoch+4*60 {
+			t.Errorf("Forecast journal covers %d to %d", j.Epoch(), j.Last())
+		}
+	}
+	v, _ := js[1].Read(epoch, 2)
+	if fmt.Sprint(v) != "[1 1]" {
+		t.Errorf("Forecast starts %v", v)
+	}
+}
@@ -0,0 +1,294 @@
+// Package auth authenticates clients of the network services by bearer
+// token, HTTP basic authentication, or TLS client certificate, and grants
+// each a Role that bounds what it may do.
+//
+// An Authenticator is usually loaded from JSON such as
+//
+//	{
+//	  "tokens": {"s3cr3t": "read"},
+//	  "users": {"grafana": {"password_hash": "pbkdf2-sha256$600000$...", "role": "read"}},
+//	  "certs": {"relay01.example.com": "write"}
+//	}
+//
+// where password_hash is made by HashPassword, as by the journal
+// hash-password command, and certs maps the common name of verified
+// client certificates to a role.  Credentials may also be confined to the namespace of a tenant
+// of the store, as by
+//
+//	{
+//	  "tokens": {"t3am": "write"},
+//	  "token_tenants": {"t3am": "payments"},
+//	  "users": {"alice": {"password_hash": "...", "role": "read", "tenant": "payments"}},
+//	  "cert_tenants": {"relay01.example.com": "payments"}
+//	}
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Role is the level of access granted to a client.  Each Role includes
+// the access of those before it.
+type Role int
+
+const (
+	None  Role = iota // no access
+	Read              // queries
+	Write             // sending datapoints
+	Admin             // debugging and maintenance endpoints
+)
+
+var roleNames = []string{"none", "read", "write", "admin"}
+
+// ParseRole parses the name of a Role.
+func ParseRole(s string) (Role, error) {
+	for i, name := range roleNames {
+		if s == name {
+			return Role(i), nil
+		}
+	}
+	return None, fmt.Errorf("Unknown role: %q", s)
+}
+
+func (r Role) String() string {
+	if r < None || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+// MarshalJSON encodes the Role by name.
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON decodes the name of a Role.
+func (r *Role) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	var err error
+	*r, err = ParseRole(s)
+	return err
+}
+
+// User is an account for HTTP basic authentication.
+type User struct {
+	// PasswordHash is the salted hash of the password made by
+	// HashPassword.
+	PasswordHash string `json:"password_hash"`
+	Role         Role   `json:"role"`
+
+	// Tenant, if set, confines the user to the namespace of a tenant.
+	Tenant string `json:"tenant"`
+}
+
+// UnmarshalJSON decodes a User, refusing password hashes that are not
+// made by HashPassword, such as the unsalted password_sha256 of earlier
+// versions.
+func (u *User) UnmarshalJSON(b []byte) error {
+	type user User
+	var v struct {
+		user
+		PasswordSHA256 string `json:"password_sha256"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.PasswordSHA256 != "" {
+		return errors.New("Unsalted password_sha256 is no longer supported, use password_hash")
+	}
+	if _, _, _, err := parseHash(v.PasswordHash); err != nil {
+		return err
+	}
+	*u = User(v.user)
+	return nil
+}
+
+// Authenticator grants roles to clients.  The zero value grants nothing.
+type Authenticator struct {
+	// Tokens maps bearer tokens to the role they grant.
+	Tokens map[string]Role `json:"tokens"`
+
+	// Users maps basic authentication user names to their accounts.
+	Users map[string]User `json:"users"`
+
+	// Certs maps the common names of verified client certificates to
+	// the role they grant.
+	Certs map[string]Role `json:"certs"`
+
+	// Anonymous is the role of clients without credentials.
+	Anonymous Role `json:"anonymous"`
+
+	// TokenTenants and CertTenants confine the clients presenting the
+	// given bearer tokens or certificate common names to the namespace
+	// of a tenant.
+	TokenTenants map[string]string `json:"token_tenants"`
+	CertTenants  map[string]string `json:"cert_tenants"`
+
+	// verified holds a digest of the hash and password of each user
+	// whose password last matched, so that clients sending it with every
+	// request do not pay for the key derivation each time.
+	mu       sync.Mutex
+	verified map[string][sha256.Size]byte
+}
+
+// Authenticate returns the role of the client making an HTTP request.
+// The highest role granted by any of its credentials is returned and
+// invalid credentials grant nothing, not even Anonymous.
+func (a *Authenticator) Authenticate(r *http.Request) Role {
+	role, _ := a.Identify(r)
+	return role
+}
+
+// Identify returns the role of the client making an HTTP request, as
+// Authenticate does, and the tenant it is confined to, if any.  Clients
+// confined to a tenant are granted at most Write, since Admin reaches
+// the whole store, and those whose credentials name different tenants
+// are granted nothing.
+func (a *Authenticator) Identify(r *http.Request) (Role, string) {
+	var id identity
+	presented := false
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		presented = true
+		id.add(a.CertRole(*r.TLS), a.CertTenant(*r.TLS))
+	}
+
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		presented = true
+		id.add(a.tokenRole(strings.TrimPrefix(header, "Bearer ")))
+	} else if user, password, ok := r.BasicAuth(); ok {
+		presented = true
+		id.add(a.userRole(user, password))
+	}
+
+	if !presented {
+		return a.Anonymous, ""
+	}
+	if id.conflict {
+		return None, ""
+	}
+	if id.tenant != "" {
+		return min(id.role, Write), id.tenant
+	}
+	return id.role, ""
+}
+
+// identity accumulates the roles and tenants of a client's credentials.
+type identity struct {
+	role     Role
+	tenant   string
+	conflict bool
+}
+
+func (id *identity) add(role Role, tenant string) {
+	if role == None {
+		return
+	}
+	id.role = max(id.role, role)
+	if tenant == "" {
+		return
+	}
+	if id.tenant != "" && id.tenant != tenant {
+		id.conflict = true
+	}
+	id.tenant = tenant
+}
+
+// CertRole returns the role granted to the verified client certificate of
+// a TLS connection.
+func (a *Authenticator) CertRole(cs tls.ConnectionState) Role {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return None
+	}
+	return a.Certs[cs.VerifiedChains[0][0].Subject.CommonName]
+}
+
+// CertTenant returns the tenant that the verified client certificate of a
+// TLS connection is confined to, if any.
+func (a *Authenticator) CertTenant(cs tls.ConnectionState) string {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return a.CertTenants[cs.VerifiedChains[0][0].Subject.CommonName]
+}
+
+// tokenRole compares token with every known token in constant time.
+func (a *Authenticator) tokenRole(token string) (Role, string) {
+	role, tenant := None, ""
+	for t, r := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			role, tenant = r, a.TokenTenants[t]
+		}
+	}
+	return role, tenant
+}
+
+func (a *Authenticator) userRole(name, password string) (Role, string) {
+	user, ok := a.Users[name]
+	if !ok {
+		return None, ""
+	}
+	sum := sha256.Sum256([]byte(user.PasswordHash + "$" + password))
+	a.mu.Lock()
+	cached, ok := a.verified[name]
+	a.mu.Unlock()
+	if !ok || subtle.ConstantTimeCompare(sum[:], cached[:]) != 1 {
+		if !checkPassword(user.PasswordHash, password) {
+			return None, ""
+		}
+		a.mu.Lock()
+		if a.verified == nil {
+			a.verified = make(map[string][sha256.Size]byte)
+		}
+		a.verified[name] = sum
+		a.mu.Unlock()
+	}
+	return user.Role, user.Tenant
+}
+
+type tenantKey struct{}
+
+// Tenant returns the tenant that the client of a request served through
+// Require is confined to, or "" if it may reach the whole store.
+func Tenant(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	return tenant
+}
+
+// Require wraps handler so that it is only served to clients with at
+// least the given role.  Clients that present no credentials are asked
+// for them with 401 and others that lack the role are refused with 403.
+// A nil Authenticator serves everyone.  The tenant of the client, if
+// any, is given to handler through the request for Tenant.
+func (a *Authenticator) Require(role Role, handler http.Handler) http.Handler {
+	if a == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if granted, tenant := a.Identify(r); granted >= role {
+			if tenant != "" {
+				r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+			}
+			handler.ServeHTTP(w, r)
+			return
+		}
+		anonymous := r.TLS == nil || len(r.TLS.VerifiedChains) == 0
+		if anonymous && r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="journal"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
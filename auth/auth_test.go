@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const config = `{
+	"tokens": {"t0ken": "write"},
+	"users": {"grafana": {"password_hash": "pbkdf2-sha256$1000$am91cm5hbC10ZXN0LXNsdA$IGwzO3Bo7kfsCB3sjgJtLdw0vSmc/6wGOKIeAkUx37E", "role": "read"}},
+	"certs": {"relay": "admin"}
+}`
+
+func TestAuthenticate(t *testing.T) {
+	var a Authenticator
+	if err := json.Unmarshal([]byte(config), &a); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(header string) *http.Request {
+		r := httptest.NewRequest("GET", "/render", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		return r
+	}
+	tests := []struct {
+		r    *http.Request
+		want Role
+	}{
+		{request(""), None},
+		{request("Bearer t0ken"), Write},
+		{request("Bearer wrong"), None},
+		{request("Basic Z3JhZmFuYTp0ZXN0"), Read}, // grafana:test
+		{request("Basic Z3JhZmFuYTpiYWQ="), None}, // grafana:bad
+	}
+	for i, test := range tests {
+		if role := a.Authenticate(test.r); role != test.want {
+			t.Errorf("Test %d: role %s, want %s", i, role, test.want)
+		}
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "relay"}}
+	r := request("")
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if role := a.Authenticate(r); role != Admin {
+		t.Errorf("Client certificate granted %s", role)
+	}
+
+	a.Anonymous = Read
+	if role := a.Authenticate(request("")); role != Read {
+		t.Errorf("Anonymous client granted %s", role)
+	}
+	if role := a.Authenticate(request("Bearer wrong")); role != None {
+		t.Errorf("Invalid token granted %s", role)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	a := &Authenticator{Tokens: map[string]Role{"reader": Read}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := a.Require(Write, ok)
+
+	for header, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer reade":  http.StatusForbidden,
+		"Bearer reader": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%q returned %d, want %d", header, w.Code, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer reader")
+	a.Require(Read, ok).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Reader refused with %d", w.Code)
+	}
+
+	var none *Authenticator
+	w = httptest.NewRecorder()
+	none.Require(Admin, ok).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Nil Authenticator refused with %d", w.Code)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	a := &Authenticator{
+		Tokens:       map[string]Role{"red": Admin, "blue": Read, "root": Admin},
+		TokenTenants: map[string]string{"red": "red", "blue": "blue"},
+		Certs:        map[string]Role{"relay": Write},
+		CertTenants:  map[string]string{"relay": "red"},
+	}
+	request := func(token, cn string) *http.Request {
+		r := httptest.NewRequest("GET", "/render", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if cn != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		return r
+	}
+	tests := []struct {
+		r      *http.Request
+		role   Role
+		tenant string
+	}{
+		{request("root", ""), Admin, ""},
+		{request("red", ""), Write, "red"},
+		{request("blue", ""), Read, "blue"},
+		{request("red", "relay"), Write, "red"},
+		{request("blue", "relay"), None, ""},
+		{request("root", "relay"), Write, "red"},
+	}
+	for i, test := range tests {
+		if role, tenant := a.Identify(test.r); role != test.role || tenant != test.tenant {
+			t.Errorf("Test %d: %s of %q, want %s of %q", i, role, tenant, test.role, test.tenant)
+		}
+	}
+
+	var tenant string
+	h := a.Require(Read, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = Tenant(r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), request("blue", ""))
+	if tenant != "blue" {
+		t.Errorf("Require passed tenant %q", tenant)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("s3cr3t", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := HashPassword("s3cr3t", 1000)
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") || hash == again {
+		t.Errorf("Hashes %s and %s", hash, again)
+	}
+	if !checkPassword(hash, "s3cr3t") || checkPassword(hash, "secret") || checkPassword("s3cr3t", "s3cr3t") {
+		t.Errorf("Password checked wrongly against %s", hash)
+	}
+
+	a := &Authenticator{Users: map[string]User{"u": {PasswordHash: hash, Role: Read}}}
+	for i := 0; i < 2; i++ {
+		if role, _ := a.userRole("u", "s3cr3t"); role != Read {
+			t.Errorf("Attempt %d granted %s", i, role)
+		}
+		if role, _ := a.userRole("u", "secret"); role != None {
+			t.Errorf("Wrong password granted %s", role)
+		}
+	}
+
+	var u User
+	for _, config := range []string{
+		`{"password_sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", "role": "read"}`,
+		`{"password_hash": "pbkdf2-sha256$0$c2FsdA$a2V5", "role": "read"}`,
+		`{"role": "read"}`,
+	} {
+		if err = json.Unmarshal([]byte(config), &u); err == nil {
+			t.Errorf("Accepted %s", config)
+		}
+	}
+}
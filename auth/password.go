@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PasswordScheme names the key derivation function of password
+	// hashes: PBKDF2 with HMAC-SHA-256.
+	PasswordScheme = "pbkdf2-sha256"
+
+	// DefaultIterations is the PBKDF2 iteration count of HashPassword.
+	DefaultIterations = 600000
+
+	saltSize = 16
+	keySize  = 32
+)
+
+// HashPassword returns the hash of a password for User.PasswordHash in
+// the form
+//
+//	pbkdf2-sha256$<iterations>$<salt>$<key>
+//
+// where the salt is random and it and the derived key are encoded with
+// unpadded standard base64.  Iterations less than one use
+// DefaultIterations.
+func HashPassword(password string, iterations int) (string, error) {
+	if iterations < 1 {
+		iterations = DefaultIterations
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, keySize)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", PasswordScheme, iterations,
+		enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// parseHash splits a hash made by HashPassword into its iteration count,
+// salt, and key.
+func parseHash(hash string) (int, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != PasswordScheme {
+		return 0, nil, nil, fmt.Errorf("Password hash is not %s", PasswordScheme)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("Invalid iterations in password hash: %q", parts[1])
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("Invalid salt in password hash: %s", err)
+	}
+	key, err := enc.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("Invalid key in password hash")
+	}
+	return iterations, salt, key, nil
+}
+
+// checkPassword reports whether password matches a hash made by
+// HashPassword.
+func checkPassword(hash, password string) bool {
+	iterations, salt, want, err := parseHash(hash)
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(key, want) == 1
+}
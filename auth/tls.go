@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLS describes the certificates a server presents and accepts.
+type TLS struct {
+	// Cert and Key are the paths of the PEM encoded server certificate
+	// chain and private key.
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+
+	// ClientCA, if set, is the path of PEM encoded certificates that
+	// client certificates are verified against.
+	ClientCA string `json:"client_ca"`
+
+	// RequireClientCert refuses connections without a verified client
+	// certificate.  Otherwise client certificates are optional.
+	RequireClientCert bool `json:"require_client_cert"`
+}
+
+// Config loads the certificates and returns the tls.Config for a server.
+func (t *TLS) Config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if t.ClientCA != "" {
+		pem, err := os.ReadFile(t.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates in %s", t.ClientCA)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if t.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if t.RequireClientCert {
+		return nil, fmt.Errorf("Client certificates are required but there is no client CA")
+	}
+	return config, nil
+}
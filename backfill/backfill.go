@@ -0,0 +1,173 @@
+// Package backfill repairs the missing values of timeseries journals by
+// requesting them again from the source they were collected from.  The
+// ranges to request come from FileJournal.Gaps.
+package backfill
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const (
+	// DefaultMaxPoints is the default limit on the points requested from
+	// the Source at once.
+	DefaultMaxPoints = 10000
+
+	// DefaultBackoff is the default delay before the first retry.
+	DefaultBackoff = time.Second
+)
+
+// Journal is a journal that can report its missing values.
+// *timeseries.FileJournal implements it.
+type Journal interface {
+	timeseries.Journal
+	Gaps(from, until int64) ([]timeseries.Range, error)
+	Factory() ValueType
+}
+
+// Source fetches the values of the timestamps in r, at the interval of
+// the journal being filled, starting with r.From.  It may return fewer
+// values than requested and nulls for values it does not have; those
+// remain missing.
+type Source func(r timeseries.Range) (Values, error)
+
+// Progress reports the outcome of filling one range.
+type Progress struct {
+	Range  timeseries.Range
+	Points int64 // values written, including nulls between them
+	Done   int   // ranges finished so far, including this one
+	Total  int   // ranges to fill
+	Err    error // set if the range could not be filled
+}
+
+// Filler fills the gaps in journals from a Source.
+type Filler struct {
+	Source Source
+
+	// MaxPoints bounds the size of each request to the Source.  Larger
+	// gaps are split.  Zero uses DefaultMaxPoints.
+	MaxPoints int64
+
+	// Retries is the number of times a failed request is retried.  The
+	// delay starts at Backoff and doubles with each retry.
+	Retries int
+	Backoff time.Duration
+
+	// OnProgress, if set, is called after each range is filled or fails.
+	OnProgress func(p Progress)
+}
+
+// Result summarizes a Fill.
+type Result struct {
+	Ranges int                // ranges requested from the Source
+	Points int64              // values written
+	Failed []timeseries.Range // ranges that could not be filled
+}
+
+// New returns a Filler for the given Source with default settings.
+func New(src Source) *Filler {
+	return &Filler{
+		Source:    src,
+		MaxPoints: DefaultMaxPoints,
+		Backoff:   DefaultBackoff,
+	}
+}
+
+// Fill requests the values missing from j for the timestamps from
+// through until inclusive and writes them.  Each range is written with a
+// single Write trimmed of leading and trailing nulls so that nothing is
+// appended to the journal that the Source did not have.  Ranges that
+// still fail after the retries are skipped and reported in the Result
+// and the returned error.
+func (f *Filler) Fill(j Journal, from, until int64) (Result, error) {
+	var result Result
+	gaps, err := j.Gaps(from, until)
+	if err != nil {
+		return result, err
+	}
+	ranges := f.split(gaps, j.Interval())
+	result.Ranges = len(ranges)
+
+	var last error
+	for i, r := range ranges {
+		p := Progress{Range: r, Done: i + 1, Total: len(ranges)}
+		p.Points, p.Err = f.fill(j, r)
+		if p.Err != nil {
+			result.Failed = append(result.Failed, r)
+			last = p.Err
+		}
+		result.Points += p.Points
+		if f.OnProgress != nil {
+			f.OnProgress(p)
+		}
+	}
+
+	if last != nil {
+		return result, fmt.Errorf("%d of %d ranges failed: %s",
+			len(result.Failed), len(ranges), last)
+	}
+	return result, nil
+}
+
+// split breaks gaps into ranges of at most MaxPoints values.
+func (f *Filler) split(gaps []timeseries.Range, interval int64) []timeseries.Range {
+	max := f.MaxPoints
+	if max <= 0 {
+		max = DefaultMaxPoints
+	}
+	var ranges []timeseries.Range
+	for _, g := range gaps {
+		for from := g.From; from <= g.Until; from += max * interval {
+			until := from + (max-1)*interval
+			if until > g.Until {
+				until = g.Until
+			}
+			ranges = append(ranges, timeseries.Range{From: from, Until: until})
+		}
+	}
+	return ranges
+}
+
+// fill fetches one range, retrying failures, and writes it.
+func (f *Filler) fill(j Journal, r timeseries.Range) (int64, error) {
+	values, err := f.Source(r)
+	delay := f.Backoff
+	for retry := 0; err != nil && retry < f.Retries; retry++ {
+		time.Sleep(delay)
+		delay *= 2
+		values, err = f.Source(r)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if max := int((r.Until-r.From)/j.Interval()) + 1; values.Len() > max {
+		values = values.Slice(0, max)
+	}
+	null := j.Factory().Null()
+	isNull := func(i int) bool {
+		return bytes.Equal(values.Slice(i, i+1).Encode(), null)
+	}
+	first, end := 0, values.Len()
+	for first < end && isNull(first) {
+		first++
+	}
+	for end > first && isNull(end-1) {
+		end--
+	}
+	if first == end {
+		return 0, nil
+	}
+
+	err = j.Write(r.From+int64(first)*j.Interval(), values.Slice(first, end))
+	if err != nil {
+		return 0, err
+	}
+	return int64(end - first), nil
+}
@@ -0,0 +1,72 @@
+package backfill
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func TestFill(t *testing.T) {
+	path := "/tmp/test-backfill.tsj"
+	os.Remove(path)
+	j, err := timeseries.Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	null := int64(math.MinInt64)
+	j.Write(60, Int64Values{1, null, null, null, 5, null, 7})
+
+	// The source knows every value up to 600 and fails its first request
+	calls := 0
+	src := func(r timeseries.Range) (Values, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("Flaky source")
+		}
+		var values Int64Values
+		for ts := r.From; ts <= r.Until; ts += 60 {
+			if ts <= 600 {
+				values = append(values, ts/60)
+			} else {
+				values = append(values, null)
+			}
+		}
+		return values, nil
+	}
+
+	f := New(src)
+	f.MaxPoints = 2
+	f.Retries = 1
+	f.Backoff = time.Millisecond
+	var progress []Progress
+	f.OnProgress = func(p Progress) { progress = append(progress, p) }
+
+	result, err := f.Fill(j, 0, 900)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Gaps 120-240, 360, and 480-900 split into requests of 2 points
+	if result.Ranges != 7 || result.Points != 7 || len(progress) != 7 {
+		t.Errorf("Fill returned %+v with %d progress reports", result, len(progress))
+	}
+	v, _ := j.Read(0, 100)
+	if fmt.Sprint(v) != "[1 2 3 4 5 6 7 8 9 10]" {
+		t.Errorf("Filled journal contains %v", v)
+	}
+
+	// Nothing is requested once the source has no more values
+	calls = 1
+	result, err = f.Fill(j, 0, 900)
+	if err != nil || result.Points != 0 || calls != 4 {
+		t.Errorf("Second Fill returned %+v after %d calls: %v", result, calls-1, err)
+	}
+}
@@ -0,0 +1,142 @@
+package journal
+
+import (
+	"bytes"
+	"io"
+)
+
+var (
+	_ ValueType = (*BitfieldValueType)(nil)
+	_ Values    = BitfieldValues(nil)
+)
+
+// BitfieldValueType implements ValueType for bitmasks of a fixed number
+// of bytes, such as the health check results or feature flags of each
+// interval.  Bit n of a mask is bit n%8 of its byte n/8, so that masks of
+// up to 8 bytes read as Little Endian integers have the same bits.  A
+// mask with every bit set is null.
+type BitfieldValueType struct {
+	width int32
+	null  []byte
+}
+
+// NewBitfieldValueType returns a BitfieldValueType for masks of the given
+// number of bytes.
+func NewBitfieldValueType(width int32) *BitfieldValueType {
+	return &BitfieldValueType{width: width}
+}
+
+// Width is the number of bytes in each mask.
+func (t *BitfieldValueType) Width() int32 {
+	return t.width
+}
+
+// Type returns the type encoding as stored on disk
+func (t *BitfieldValueType) Type() int32 {
+	return 0x30
+}
+
+// Null returns a mask with every bit set.
+func (t *BitfieldValueType) Null() []byte {
+	if t.null == nil {
+		t.null = bytes.Repeat([]byte{0xff}, int(t.width))
+	}
+	return t.null
+}
+
+// Decode takes a byte slice presumably read from disk and splits it into
+// masks that share its storage but are capped at their width.
+func (t *BitfieldValueType) Decode(buffer []byte) Values {
+	w := int(t.width)
+	masks := make(BitfieldValues, len(buffer)/w)
+	for i := range masks {
+		masks[i] = buffer[i*w : (i+1)*w : (i+1)*w]
+	}
+	return masks
+}
+
+// DecodeStream reads n masks from r.  Unlike Decode each mask is a copy.
+func (t *BitfieldValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(BitfieldValues, 0, n)
+	err := readChunks(r, n, t.width, func(chunk []byte) {
+		chunk = append([]byte(nil), chunk...)
+		values = append(values, t.Decode(chunk).(BitfieldValues)...)
+	})
+	return values, err
+}
+
+// BitfieldValues implements Values and wraps a slice of masks, all of the
+// same width.
+type BitfieldValues []Bitmask
+
+// Encode returns the masks one after the other.
+func (v BitfieldValues) Encode() []byte {
+	n := 0
+	for _, m := range v {
+		n += len(m)
+	}
+	return v.EncodeTo(make([]byte, 0, n))
+}
+
+// EncodeTo appends each mask to dst.
+func (v BitfieldValues) EncodeTo(dst []byte) []byte {
+	for _, m := range v {
+		dst = append(dst, m...)
+	}
+	return dst
+}
+
+// WriteTo writes each mask to w.
+func (v BitfieldValues) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
+}
+
+// Len returns the number of masks.
+func (v BitfieldValues) Len() int {
+	return len(v)
+}
+
+// Slice returns the masks from index i up to j without copying.
+func (v BitfieldValues) Slice(i, j int) Values {
+	return v[i:j]
+}
+
+// Bitmask is one value of a BitfieldValueType.
+type Bitmask []byte
+
+// NewBitmask returns a mask of the given number of bytes with the given
+// bits set.
+func NewBitmask(width int, bits ...int) Bitmask {
+	m := make(Bitmask, width)
+	for _, n := range bits {
+		m.Set(n)
+	}
+	return m
+}
+
+// NullBitmask returns the null mask of the given number of bytes.
+func NullBitmask(width int) Bitmask {
+	return bytes.Repeat([]byte{0xff}, width)
+}
+
+// IsNull reports whether every bit of m is set.
+func (m Bitmask) IsNull() bool {
+	return len(m) > 0 && bytes.Count(m, []byte{0xff}) == len(m)
+}
+
+// Bit reports whether bit n is set.  Bits beyond the mask are not.
+func (m Bitmask) Bit(n int) bool {
+	return n >= 0 && n/8 < len(m) && m[n/8]&(1<<(n%8)) != 0
+}
+
+// Set sets bit n, which must be within the mask.
+func (m Bitmask) Set(n int) {
+	m[n/8] |= 1 << (n % 8)
+}
+
+// Clear clears bit n, which must be within the mask.
+func (m Bitmask) Clear(n int) {
+	m[n/8] &^= 1 << (n % 8)
+}
@@ -2,6 +2,12 @@ package journal
 
 import (
 	"bytes"
+	"io"
+)
+
+var (
+	_ ValueType = (*ByteValueType)(nil)
+	_ Values    = ByteValues(nil)
 )
 
 // ByteValueType implements ValueType and defines a []byte of fixed size
@@ -49,29 +55,63 @@ func (t *ByteValueType) Type() int32 {
 }
 
 // Decode takes a []byte slice usually read from disk to a slice of byte
-// slices represented by ByteValues.
+// slices represented by ByteValues.  The records share storage with
+// buffer but are capped at their width so appending to one cannot
+// overwrite the next.
 func (t *ByteValueType) Decode(buffer []byte) Values {
-	b := make([][]byte, 0)
-	for i := int32(0); i < int32(len(buffer)); i += t.width {
-		b = append(b, buffer[i:i+t.width])
+	w := int(t.width)
+	b := make([][]byte, len(buffer)/w)
+	for i := range b {
+		b[i] = buffer[i*w : (i+1)*w : (i+1)*w]
 	}
 	return ByteValues(b)
 }
 
+// DecodeStream reads n records from r.  Unlike Decode each record is a
+// copy.
+func (t *ByteValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(ByteValues, 0, n)
+	err := readChunks(r, n, t.width, func(chunk []byte) {
+		chunk = append([]byte(nil), chunk...)
+		values = append(values, t.Decode(chunk).(ByteValues)...)
+	})
+	return values, err
+}
+
 // ByteValues wraps a slice of byte slices so that they can be encoded
 // to one long slice of bytes for on disk storage.
 type ByteValues [][]byte
 
 // Encode returns a byte slice representing slice of byte slices.
 func (v ByteValues) Encode() []byte {
-	b := make([]byte, 0)
+	n := 0
 	for i := range v {
-		b = append(b, v[i]...)
+		n += len(v[i])
 	}
-	return b
+	return v.EncodeTo(make([]byte, 0, n))
+}
+
+// EncodeTo appends each byte slice to dst.
+func (v ByteValues) EncodeTo(dst []byte) []byte {
+	for i := range v {
+		dst = append(dst, v[i]...)
+	}
+	return dst
+}
+
+// WriteTo writes each byte slice to w.
+func (v ByteValues) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
 }
 
 // Len returns the length of the slice of byte slices.
 func (v ByteValues) Len() int {
 	return len(v)
 }
+
+// Slice returns the slice of byte slices from index i up to j without copying.
+func (v ByteValues) Slice(i, j int) Values {
+	return v[i:j]
+}
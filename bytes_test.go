@@ -42,3 +42,37 @@ func TestByteValues(t *testing.T) {
 		}
 	}
 }
+
+func TestByteDecodeCapped(t *testing.T) {
+	raw := []byte("AABBCC")
+	values := NewByteValueType(2, nil).Decode(raw).(ByteValues)
+	_ = append(values[0], 'X')
+	if string(raw) != "AABBCC" {
+		t.Errorf("Appending to a decoded record overwrote the next: %s", raw)
+	}
+}
+
+func byteBenchmarkValues(n int) ByteValues {
+	values := make(ByteValues, n)
+	for i := range values {
+		values[i] = []byte("abcd")
+	}
+	return values
+}
+
+func BenchmarkByteEncode(b *testing.B) {
+	values := byteBenchmarkValues(4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		values.Encode()
+	}
+}
+
+func BenchmarkByteDecode(b *testing.B) {
+	raw := byteBenchmarkValues(4096).Encode()
+	factory := NewByteValueType(4, nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		factory.Decode(raw)
+	}
+}
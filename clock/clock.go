@@ -0,0 +1,77 @@
+// Package clock abstracts the current time so that time dependent
+// behavior, such as modification times, rate limits, and flush
+// schedules, can be tested without waiting.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Wall is the system clock.
+var Wall Clock = wall{}
+
+type wall struct{}
+
+func (wall) Now() time.Time {
+	return time.Now()
+}
+
+// Or returns c, or Wall if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Wall
+	}
+	return c
+}
+
+// Offset returns a Clock that runs at the rate of the system clock but
+// reads start as of now.
+func Offset(start time.Time) Clock {
+	return offset(time.Until(start))
+}
+
+type offset time.Duration
+
+func (o offset) Now() time.Time {
+	return time.Now().Add(time.Duration(o))
+}
+
+// Fake is a Clock that only moves when told to.  It is safe for
+// concurrent use.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a Fake reading now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the time of the Fake.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set sets the time of the Fake.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// Advance moves the Fake forward by d and returns the new time.
+func (f *Fake) Advance(d time.Duration) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	return f.now
+}
@@ -0,0 +1,31 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1449240540, 0)
+	f := NewFake(start)
+	if !f.Now().Equal(start) {
+		t.Errorf("Fake reads %s", f.Now())
+	}
+	if now := f.Advance(time.Hour); !now.Equal(start.Add(time.Hour)) || !f.Now().Equal(now) {
+		t.Errorf("Advanced Fake reads %s", f.Now())
+	}
+	f.Set(start)
+	if !f.Now().Equal(start) {
+		t.Errorf("Set Fake reads %s", f.Now())
+	}
+}
+
+func TestOffset(t *testing.T) {
+	start := time.Unix(1449240540, 0)
+	if d := Offset(start).Now().Sub(start); d < 0 || d > time.Minute {
+		t.Errorf("Offset clock is %s from its start", d)
+	}
+	if Or(nil) != Wall {
+		t.Errorf("Or(nil) is not the wall clock")
+	}
+}
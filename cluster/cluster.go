@@ -0,0 +1,215 @@
+// Package cluster assigns series to the nodes of a cluster of journal
+// servers by consistent hashing, so that adding or removing a node moves
+// as few series as possible.  The hashes match those of carbon-c-relay
+// so that a cluster can be shared with, or migrated from, carbon relays:
+//
+//	carbon_ch      the MD5 ring of Graphite's carbon-relay
+//	fnv1a_ch       a ring using the FNV-1a hash
+//	jump_fnv1a_ch  jump consistent hashing of FNV-1a hashes
+package cluster
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// DefaultReplicas is the number of points each node has on a hash ring.
+const DefaultReplicas = 100
+
+// Node is a member of a cluster.
+type Node struct {
+	Address  string // host:port
+	Instance string // optional name that identifies the node on the ring
+}
+
+// ParseNode parses a node in the carbon-c-relay form host:port=instance
+// where the instance is optional.
+func ParseNode(s string) (Node, error) {
+	address, instance, _ := strings.Cut(s, "=")
+	if address == "" || !strings.Contains(address, ":") {
+		return Node{}, fmt.Errorf("Invalid node %q: want host:port[=instance]", s)
+	}
+	return Node{Address: address, Instance: instance}, nil
+}
+
+func (n Node) String() string {
+	if n.Instance == "" {
+		return n.Address
+	}
+	return n.Address + "=" + n.Instance
+}
+
+// host returns the address without its port.
+func (n Node) host() string {
+	if i := strings.LastIndex(n.Address, ":"); i >= 0 {
+		return strings.Trim(n.Address[:i], "[]")
+	}
+	return n.Address
+}
+
+// Router picks the nodes that store a series.
+type Router interface {
+	// Route returns up to replicas distinct nodes for series in order
+	// of preference.  The same series always gets the same nodes.
+	Route(series string, replicas int) []Node
+}
+
+// New returns a Router for nodes using the named hash method.
+func New(method string, nodes []Node) (Router, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("A cluster needs at least one node")
+	}
+	switch method {
+	case "carbon_ch":
+		return NewCarbonRing(nodes, DefaultReplicas), nil
+	case "fnv1a_ch":
+		return NewFNV1aRing(nodes, DefaultReplicas), nil
+	case "jump_fnv1a_ch":
+		return NewJump(nodes), nil
+	}
+	return nil, fmt.Errorf("Unknown hash method: %q", method)
+}
+
+// Ring is a consistent hash ring holding several points for each node.
+// A series belongs to the node of the first point at or after its own
+// position, and its replicas to the next distinct nodes around the ring.
+type Ring struct {
+	points []ringPoint
+	nodes  int
+	hash   func(key string) uint16
+}
+
+type ringPoint struct {
+	position uint16
+	key      string
+	node     Node
+}
+
+// NewCarbonRing returns the ring of Graphite's carbon-relay, which places
+// nodes by the MD5 hash of a Python tuple of host and instance.  Ports
+// are not part of the ring so nodes must differ by host or instance.
+func NewCarbonRing(nodes []Node, replicas int) *Ring {
+	return newRing(nodes, replicas, md5Position, func(n Node, i int) string {
+		instance := "None"
+		if n.Instance != "" {
+			instance = "'" + n.Instance + "'"
+		}
+		return fmt.Sprintf("('%s', %s):%d", n.host(), instance, i)
+	})
+}
+
+// NewFNV1aRing returns a ring that places nodes by the FNV-1a hash of
+// their instance, or their address if they have none.
+func NewFNV1aRing(nodes []Node, replicas int) *Ring {
+	return newRing(nodes, replicas, fnv1aPosition, func(n Node, i int) string {
+		key := n.Instance
+		if key == "" {
+			key = n.Address
+		}
+		return fmt.Sprintf("%d-%s", i, key)
+	})
+}
+
+func newRing(nodes []Node, replicas int, hash func(string) uint16, key func(Node, int) string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{nodes: len(nodes), hash: hash}
+	for _, n := range nodes {
+		for i := 0; i < replicas; i++ {
+			k := key(n, i)
+			r.points = append(r.points, ringPoint{hash(k), k, n})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		a, b := r.points[i], r.points[j]
+		if a.position != b.position {
+			return a.position < b.position
+		}
+		return a.key < b.key
+	})
+	return r
+}
+
+// Route implements Router.
+func (r *Ring) Route(series string, replicas int) []Node {
+	replicas = min(replicas, r.nodes)
+	position := r.hash(series)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].position >= position
+	})
+
+	nodes := make([]Node, 0, replicas)
+	seen := make(map[Node]bool, replicas)
+	for n := 0; n < len(r.points) && len(nodes) < replicas; n++ {
+		p := r.points[(i+n)%len(r.points)]
+		if !seen[p.node] {
+			seen[p.node] = true
+			nodes = append(nodes, p.node)
+		}
+	}
+	return nodes
+}
+
+func md5Position(key string) uint16 {
+	sum := md5.Sum([]byte(key))
+	return uint16(sum[0])<<8 | uint16(sum[1])
+}
+
+func fnv1aPosition(key string) uint16 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	sum := h.Sum32()
+	return uint16(sum>>16) ^ uint16(sum)
+}
+
+// Jump assigns series to nodes with jump consistent hashing, which
+// spreads series evenly and needs no ring, but only moves the fewest
+// series when nodes are added or removed at the end of the list.  Nodes
+// are ordered by instance, or address if they have none.
+type Jump struct {
+	nodes []Node
+}
+
+// NewJump returns a Jump router for nodes.
+func NewJump(nodes []Node) *Jump {
+	sorted := append([]Node(nil), nodes...)
+	key := func(n Node) string {
+		if n.Instance != "" {
+			return n.Instance
+		}
+		return n.Address
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return key(sorted[i]) < key(sorted[j])
+	})
+	return &Jump{nodes: sorted}
+}
+
+// Route implements Router.  Replicas are the nodes following the first.
+func (j *Jump) Route(series string, replicas int) []Node {
+	replicas = min(replicas, len(j.nodes))
+	h := fnv.New64a()
+	h.Write([]byte(series))
+	first := jump(h.Sum64(), len(j.nodes))
+
+	nodes := make([]Node, replicas)
+	for i := range nodes {
+		nodes[i] = j.nodes[(first+i)%len(j.nodes)]
+	}
+	return nodes
+}
+
+// jump is the jump consistent hash of Lamping and Veach.
+func jump(key uint64, buckets int) int {
+	b, j := int64(-1), int64(0)
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,217 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/ingest"
+)
+
+func testNodes(t *testing.T, specs ...string) []Node {
+	var nodes []Node
+	for _, s := range specs {
+		n, err := ParseNode(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func TestParseNode(t *testing.T) {
+	n, err := ParseNode("10.0.0.1:2003=a")
+	if err != nil || n.Address != "10.0.0.1:2003" || n.Instance != "a" || n.host() != "10.0.0.1" {
+		t.Errorf("Parsed %+v, %v", n, err)
+	}
+	if _, err = ParseNode("10.0.0.1"); err == nil {
+		t.Errorf("Node without a port accepted")
+	}
+}
+
+func TestRouters(t *testing.T) {
+	nodes := testNodes(t, "10.0.0.1:2003=a", "10.0.0.2:2003=b", "10.0.0.3:2003=c")
+	for _, method := range []string{"carbon_ch", "fnv1a_ch", "jump_fnv1a_ch"} {
+		r, err := New(method, nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[Node]int)
+		for i := 0; i < 3000; i++ {
+			series := fmt.Sprintf("servers.web%02d.cpu%d", i%100, i/100)
+			route := r.Route(series, 2)
+			if len(route) != 2 || route[0] == route[1] {
+				t.Fatalf("%s routed %s to %v", method, series, route)
+			}
+			if again := r.Route(series, 5); len(again) != 3 || again[0] != route[0] {
+				t.Fatalf("%s is not stable for %s: %v then %v", method, series, route, again)
+			}
+			counts[route[0]]++
+		}
+		for _, n := range nodes {
+			if counts[n] < 500 {
+				t.Errorf("%s gave %s only %d of 3000 series", method, n, counts[n])
+			}
+		}
+
+		// Removing a node only moves the series it held
+		smaller, _ := New(method, nodes[:2])
+		moved := 0
+		for i := 0; i < 3000; i++ {
+			series := fmt.Sprintf("servers.web%02d.cpu%d", i%100, i/100)
+			if before := r.Route(series, 1)[0]; before != nodes[2] && smaller.Route(series, 1)[0] != before {
+				moved++
+			}
+		}
+		if moved != 0 {
+			t.Errorf("%s moved %d series that stayed on their node", method, moved)
+		}
+	}
+
+	if _, err := New("random", nodes); err == nil {
+		t.Errorf("Unknown hash method accepted")
+	}
+}
+
+func TestCarbonRing(t *testing.T) {
+	// Graphite places ('127.0.0.1', 'a'):0 at the first four hex digits
+	// of its MD5 sum
+	if p := md5Position("('127.0.0.1', 'a'):0"); p != 0x5deb {
+		t.Errorf("Ring position %#x", p)
+	}
+}
+
+// testServer is a line protocol server that records what it receives.
+type testServer struct {
+	listener *ingest.Listener
+	mu       sync.Mutex
+	points   map[string]int
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{points: make(map[string]int)}
+	s.listener = &ingest.Listener{Sink: func(p ingest.Point) error {
+		s.mu.Lock()
+		s.points[p.Series]++
+		s.mu.Unlock()
+		return nil
+	}}
+	if err := s.listener.ListenTCP("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *testServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.points {
+		n += c
+	}
+	return n
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	for i := 0; i < 500 && !done(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !done() {
+		t.Fatalf("Timed out waiting for %s", what)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	a, b := newTestServer(t), newTestServer(t)
+	defer a.listener.Close()
+	defer b.listener.Close()
+	nodes := testNodes(t, a.listener.TCPAddr().String()+"=a", b.listener.TCPAddr().String()+"=b")
+	router, _ := New("fnv1a_ch", nodes)
+
+	r := NewRelay(router)
+	r.Replicas = 2
+	for i := 0; i < 100; i++ {
+		r.Add(ingest.Point{Series: fmt.Sprintf("relay.test%d", i), Timestamp: 1500000000, Value: float64(i)})
+	}
+	waitFor(t, "replicas", func() bool { return a.count() == 100 && b.count() == 100 })
+	r.Close()
+	for _, d := range r.Destinations() {
+		if d.Sent.Value() != 100 || d.Queued() != 0 || d.Lag() != 0 {
+			t.Errorf("%s sent %d, queued %d, lag %s", d.Node, d.Sent.Value(), d.Queued(), d.Lag())
+		}
+	}
+	if err := r.Add(ingest.Point{Series: "relay.late", Timestamp: 1500000000}); err != ErrRelayClosed {
+		t.Errorf("Add after Close returned %v", err)
+	}
+}
+
+func TestRelaySpill(t *testing.T) {
+	dir := "/tmp/journal-relay-test"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t)
+	defer s.listener.Close()
+	nodes := testNodes(t, s.listener.TCPAddr().String())
+	router, _ := New("carbon_ch", nodes)
+
+	// The node is down: points overflow the queue to disk and the rest
+	// are spilled at Close
+	var up atomic.Bool
+	dial := func(address string) (net.Conn, error) {
+		if !up.Load() {
+			return nil, errors.New("Node down")
+		}
+		return net.Dial("tcp", address)
+	}
+	clk := clock.NewFake(time.Unix(1500000000, 0))
+	r := NewRelay(router)
+	r.QueueSize = 10
+	r.SpillDir = dir
+	r.RetryInterval = 10 * time.Millisecond
+	r.Dial = dial
+	r.Clock = clk
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 25; i++ {
+		if err := r.Add(ingest.Point{Series: "relay.spill", Timestamp: 1500000000 + int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := r.Destinations()[0]
+	clk.Advance(time.Minute)
+	if d.Queued() != 25 || d.Spilled.Value() != 15 || d.Lag() != time.Minute {
+		t.Errorf("Queued %d, spilled %d, lag %s", d.Queued(), d.Spilled.Value(), d.Lag())
+	}
+	r.Close()
+	if d.Spilled.Value() != 25 || d.Dropped.Value() != 0 {
+		t.Errorf("Spilled %d, dropped %d at Close", d.Spilled.Value(), d.Dropped.Value())
+	}
+
+	// A new relay sends what was spilled once the node is up
+	up.Store(true)
+	r = NewRelay(router)
+	r.SpillDir = dir
+	r.Dial = dial
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "spilled points", func() bool { return s.count() == 25 })
+	d = r.Destinations()[0]
+	waitFor(t, "replay", func() bool { return d.Queued() == 0 })
+	r.Close()
+	if paths, _ := filepath.Glob(dir + "/*"); len(paths) != 0 {
+		t.Errorf("Spill files left behind: %v", paths)
+	}
+}
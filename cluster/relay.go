@@ -0,0 +1,539 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/ingest"
+	"github.com/jjneely/journal/stats"
+)
+
+// Relay defaults
+const (
+	DefaultQueueSize     = 100000
+	DefaultRetryInterval = 5 * time.Second
+	DefaultTimeout       = 10 * time.Second
+	DefaultBatch         = 1000
+)
+
+// ErrRelayClosed is returned by Relay.Add after Close.
+var ErrRelayClosed = errors.New("Relay is closed")
+
+// Relay forwards points over the Graphite line protocol to the nodes that
+// a Router picks for each series, keeping a queue for every destination
+// so that a slow or failed node does not hold up the others.  Points that
+// cannot be sent are retried until they are, and points that overflow a
+// queue are spilled to disk, if SpillDir is set, or dropped.  Delivery is
+// at least once: a point may be sent again after an error, which is
+// harmless as a journal keeps one value per timestamp.
+type Relay struct {
+	Router Router
+
+	// Replicas is the number of nodes each point is sent to.
+	Replicas int
+
+	// QueueSize bounds the points held in memory for each destination.
+	QueueSize int
+
+	// SpillDir, if set, is the directory where points that overflow a
+	// queue, or are still queued at Close, are kept until they can be
+	// sent.  Spilled points survive restarts.
+	SpillDir string
+
+	// RetryInterval is the delay before reconnecting to a node after an
+	// error and Timeout bounds connecting and each write.
+	RetryInterval time.Duration
+	Timeout       time.Duration
+
+	// Dial, if set, connects to a node in place of TCP.
+	Dial func(address string) (net.Conn, error)
+
+	// OnError, if set, is called for errors sending to a node.
+	OnError func(node Node, err error)
+
+	// Clock, if set, is used in place of the system clock to measure
+	// lag.
+	Clock clock.Clock
+
+	// Received counts points accepted by Add.
+	Received stats.Counter
+
+	mu     sync.Mutex
+	dests  map[Node]*Destination
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Destination is the queue of points for one node.
+type Destination struct {
+	Node Node
+
+	// Sent, Spilled, and Dropped count points written to the node,
+	// spilled to disk, and lost to a full queue.
+	Sent    stats.Counter
+	Spilled stats.Counter
+	Dropped stats.Counter
+
+	relay *Relay
+	wake  chan struct{}
+
+	mu          sync.Mutex
+	queue       []queued
+	spill       *os.File // open for appending, if any
+	spilled     int64    // points in the spill and replay files
+	spillOldest time.Time
+}
+
+// queued is a point and when it was received.
+type queued struct {
+	ingest.Point
+	at time.Time
+}
+
+// NewRelay returns a Relay to the nodes of router with default settings.
+// Call Start to begin sending.
+func NewRelay(router Router) *Relay {
+	return &Relay{
+		Router:        router,
+		Replicas:      1,
+		QueueSize:     DefaultQueueSize,
+		RetryInterval: DefaultRetryInterval,
+		Timeout:       DefaultTimeout,
+		dests:         make(map[Node]*Destination),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Start resumes sending the points spilled by an earlier run.  Other
+// nodes are connected to when they are first sent a point.
+func (r *Relay) Start() error {
+	if r.SpillDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(r.SpillDir, 0755); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(r.SpillDir, "*.spill*"))
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".replay"), ".spill")
+		if name, err = url.PathUnescape(name); err != nil {
+			continue
+		}
+		if n, err := ParseNode(name); err == nil {
+			r.destination(n)
+		}
+	}
+	return nil
+}
+
+// Add queues a point for each of its nodes.  It is an ingest.Sink.
+func (r *Relay) Add(p ingest.Point) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrRelayClosed
+	}
+	nodes := r.Router.Route(p.Series, max(r.Replicas, 1))
+	dests := make([]*Destination, len(nodes))
+	for i, n := range nodes {
+		dests[i] = r.destination(n)
+	}
+	r.mu.Unlock()
+
+	r.Received.Add(1)
+	q := queued{p, clock.Or(r.Clock).Now()}
+	var err error
+	for _, d := range dests {
+		if e := d.add(q); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// destination returns the destination of node, starting its sender on
+// first use.  The caller holds r.mu.
+func (r *Relay) destination(n Node) *Destination {
+	d, ok := r.dests[n]
+	if ok {
+		return d
+	}
+	d = &Destination{Node: n, relay: r, wake: make(chan struct{}, 1)}
+	d.countSpill()
+	r.dests[n] = d
+	r.wg.Add(1)
+	go d.run()
+	return d
+}
+
+// Destinations returns the destinations that have been sent points,
+// ordered by node.
+func (r *Relay) Destinations() []*Destination {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dests := make([]*Destination, 0, len(r.dests))
+	for _, d := range r.dests {
+		dests = append(dests, d)
+	}
+	sort.Slice(dests, func(i, j int) bool {
+		return dests[i].Node.String() < dests[j].Node.String()
+	})
+	return dests
+}
+
+// Close stops accepting points, makes a last attempt to send each queue,
+// and spills what remains to disk or drops it.
+func (r *Relay) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.stop)
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	return nil
+}
+
+// Queued returns the number of points waiting to be sent, in memory and
+// on disk.
+func (d *Destination) Queued() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return int64(len(d.queue)) + d.spilled
+}
+
+// Lag returns how long the oldest point waiting to be sent has waited,
+// or zero if none are.
+func (d *Destination) Lag() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var oldest time.Time
+	if d.spilled > 0 {
+		oldest = d.spillOldest
+	}
+	if len(d.queue) > 0 && (oldest.IsZero() || d.queue[0].at.Before(oldest)) {
+		oldest = d.queue[0].at
+	}
+	if oldest.IsZero() {
+		return 0
+	}
+	return clock.Or(d.relay.Clock).Now().Sub(oldest)
+}
+
+// add queues a point, spilling or dropping it if the queue is full.
+func (d *Destination) add(q queued) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.queue) < d.relay.QueueSize {
+		d.queue = append(d.queue, q)
+		d.notify()
+		return nil
+	}
+	if d.relay.SpillDir == "" {
+		d.Dropped.Add(1)
+		return fmt.Errorf("Queue for %s is full", d.Node)
+	}
+	if err := d.spillLocked([]queued{q}); err != nil {
+		d.Dropped.Add(1)
+		return err
+	}
+	return nil
+}
+
+func (d *Destination) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// spillPath is the file that overflowing points are appended to and
+// replayPath the file the sender is replaying.
+func (d *Destination) spillPath() string {
+	return filepath.Join(d.relay.SpillDir, url.PathEscape(d.Node.String())+".spill")
+}
+
+func (d *Destination) replayPath() string {
+	return d.spillPath() + ".replay"
+}
+
+// countSpill counts the points left on disk by an earlier run.
+func (d *Destination) countSpill() {
+	if d.relay.SpillDir == "" {
+		return
+	}
+	for _, path := range []string{d.replayPath(), d.spillPath()} {
+		fd, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if stat, err := fd.Stat(); err == nil && d.spillOldest.IsZero() {
+			d.spillOldest = stat.ModTime()
+		}
+		scanner := bufio.NewScanner(fd)
+		for scanner.Scan() {
+			d.spilled++
+		}
+		fd.Close()
+	}
+}
+
+// spillLocked appends points to the spill file.  The caller holds d.mu.
+func (d *Destination) spillLocked(points []queued) error {
+	if d.spill == nil {
+		fd, err := os.OpenFile(d.spillPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		d.spill = fd
+	}
+	w := bufio.NewWriter(d.spill)
+	for _, q := range points {
+		writeLine(w, q.Point)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if d.spilled == 0 {
+		d.spillOldest = points[0].at
+	}
+	d.spilled += int64(len(points))
+	d.Spilled.Add(int64(len(points)))
+	return nil
+}
+
+// take removes up to n points from the front of the queue.
+func (d *Destination) take(n int) []queued {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n = min(n, len(d.queue))
+	batch := append([]queued(nil), d.queue[:n]...)
+	d.queue = d.queue[n:]
+	return batch
+}
+
+// requeue returns a batch that could not be sent to the front of the
+// queue.  Points pushed past QueueSize are spilled or dropped.
+func (d *Destination) requeue(batch []queued) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queue = append(batch, d.queue...)
+	if over := len(d.queue) - d.relay.QueueSize; over > 0 {
+		tail := d.queue[len(d.queue)-over:]
+		d.queue = d.queue[:len(d.queue)-over]
+		if d.relay.SpillDir == "" || d.spillLocked(tail) != nil {
+			d.Dropped.Add(int64(len(tail)))
+		}
+	}
+}
+
+// run sends the destination's points until the relay is closed.
+func (d *Destination) run() {
+	defer d.relay.wg.Done()
+	r := d.relay
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		if conn == nil {
+			var err error
+			if conn, err = r.dial(d.Node); err != nil {
+				d.error(err)
+				if !d.sleep(r.RetryInterval) {
+					d.finish(nil)
+					return
+				}
+				continue
+			}
+		}
+
+		if err := d.replay(conn); err != nil {
+			d.error(err)
+			conn.Close()
+			conn = nil
+			continue
+		}
+		batch := d.take(DefaultBatch)
+		if len(batch) == 0 {
+			select {
+			case <-d.wake:
+				continue
+			case <-r.stop:
+				d.finish(conn)
+				return
+			}
+		}
+		if err := d.send(conn, batch); err != nil {
+			d.requeue(batch)
+			d.error(err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// finish makes a last attempt to send the queue to conn, if any, and
+// spills or drops what remains.
+func (d *Destination) finish(conn net.Conn) {
+	for conn != nil {
+		batch := d.take(DefaultBatch)
+		if len(batch) == 0 {
+			break
+		}
+		if err := d.send(conn, batch); err != nil {
+			d.requeue(batch)
+			d.error(err)
+			break
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.queue) > 0 {
+		if d.relay.SpillDir == "" || d.spillLocked(d.queue) != nil {
+			d.Dropped.Add(int64(len(d.queue)))
+		}
+		d.queue = nil
+	}
+	if d.spill != nil {
+		d.spill.Close()
+		d.spill = nil
+	}
+}
+
+// sleep waits for the retry interval and reports whether the relay is
+// still running.
+func (d *Destination) sleep(wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-d.relay.stop:
+		return false
+	}
+}
+
+func (d *Destination) error(err error) {
+	if d.relay.OnError != nil {
+		d.relay.OnError(d.Node, err)
+	}
+}
+
+func (r *Relay) dial(n Node) (net.Conn, error) {
+	if r.Dial != nil {
+		return r.Dial(n.Address)
+	}
+	return net.DialTimeout("tcp", n.Address, r.Timeout)
+}
+
+// send writes a batch of points to conn.
+func (d *Destination) send(conn net.Conn, batch []queued) error {
+	if d.relay.Timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(d.relay.Timeout))
+	}
+	w := bufio.NewWriter(conn)
+	for _, q := range batch {
+		writeLine(w, q.Point)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	d.Sent.Add(int64(len(batch)))
+	return nil
+}
+
+// replay sends the points spilled to disk, if any, to conn.  The spill
+// file is renamed so that new points can be spilled meanwhile, and
+// removed once it has all been sent.  After an error the whole file is
+// sent again.
+func (d *Destination) replay(conn net.Conn) error {
+	d.mu.Lock()
+	if d.spilled == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if _, err := os.Stat(d.replayPath()); os.IsNotExist(err) {
+		if d.spill != nil {
+			d.spill.Close()
+			d.spill = nil
+		}
+		if err = os.Rename(d.spillPath(), d.replayPath()); err != nil {
+			d.mu.Unlock()
+			return err
+		}
+	}
+	d.mu.Unlock()
+
+	fd, err := os.Open(d.replayPath())
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if d.relay.Timeout > 0 {
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+
+	var sent int64
+	scanner := bufio.NewScanner(fd)
+	w := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		if d.relay.Timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(d.relay.Timeout))
+		}
+		w.Write(scanner.Bytes())
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+		sent++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	d.Sent.Add(sent)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.spilled = max(d.spilled-sent, 0)
+	if d.spilled > 0 {
+		// Points spilled during the replay
+		d.spillOldest = clock.Or(d.relay.Clock).Now()
+	}
+	return os.Remove(d.replayPath())
+}
+
+// writeLine writes a point in the plaintext protocol.
+func writeLine(w io.Writer, p ingest.Point) {
+	fmt.Fprintf(w, "%s %s %d\n", p.Series, strconv.FormatFloat(p.Value, 'g', -1, 64), p.Timestamp)
+}
@@ -0,0 +1,79 @@
+// journal-fixture writes journals of reproducible synthetic data for
+// tests and benchmarks:
+//
+//	journal-fixture -shape sine:10:1440 -points 10080 -gaps 0.01 sine.tsj
+//
+// creates a week of one minute values oscillating between -10 and 10
+// with about one null in a hundred.  See package fixture for the shapes.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/fixture"
+	"github.com/jjneely/journal/retention"
+)
+
+func main() {
+	shape := flag.String("shape", "sine:1:60",
+		"Shape of the values: constant:V, sine:AMPLITUDE:PERIOD, counter:RATE:RESET_EVERY, or walk:STEP")
+	typ := flag.String("type", "float64", "Value type: float32, float64, or int64")
+	start := flag.Int64("start", 1449240540, "Timestamp of the first value")
+	interval := flag.Int64("interval", 60, "Seconds between values")
+	points := flag.Int("points", 1440, "Number of values")
+	seed := flag.Int64("seed", 1, "Random seed")
+	gaps := flag.Float64("gaps", 0, "Chance of each value starting a gap of nulls")
+	gapLength := flag.Int("gap-length", 1, "Number of nulls in each gap")
+	spikes := flag.Float64("spikes", 0, "Chance of each value being a spike")
+	spikeSize := flag.Float64("spike-size", 10, "Factor spikes multiply values by")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] path...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	factory, ok := retention.Types[*typ]
+	if !ok {
+		var names []string
+		for name := range retention.Types {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Fatalf("Unknown type %q, use one of %s", *typ, strings.Join(names, ", "))
+	}
+
+	// Each path gets the next seed so that they differ reproducibly
+	for i, path := range flag.Args() {
+		s, err := fixture.ParseShape(*shape)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f := &fixture.Fixture{
+			Shape:     s,
+			Start:     *start,
+			Interval:  *interval,
+			Points:    *points,
+			Seed:      *seed + int64(i),
+			GapRate:   *gaps,
+			GapLength: *gapLength,
+			SpikeRate: *spikes,
+			SpikeSize: *spikeSize,
+		}
+		j, err := f.Create(path, factory())
+		if err != nil {
+			log.Fatalf("%s: %s", path, err)
+		}
+		j.Close()
+	}
+}
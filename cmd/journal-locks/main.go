@@ -0,0 +1,74 @@
+// journal-locks lists the timeseries journals under a directory that are
+// currently locked, and the processes holding them where discoverable.
+// With -force-unlock the listed locks are broken, which is intended for
+// cleaning up after crashed or hung writers.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <dir>...\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	force := flag.Bool("force-unlock", false,
+		"Break the locks held on every locked journal found")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	status := 0
+	for _, dir := range flag.Args() {
+		locked, err := timeseries.Locks(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", dir, err)
+			status = 1
+			continue
+		}
+
+		for _, l := range locked {
+			fmt.Printf("%s\t%s\n", l.Path, holders(l))
+			if !*force {
+				continue
+			}
+			if err := timeseries.ForceUnlock(l.Path); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", l.Path, err)
+				status = 1
+			} else {
+				fmt.Printf("%s\tunlocked\n", l.Path)
+			}
+		}
+	}
+
+	os.Exit(status)
+}
+
+func holders(l timeseries.LockStatus) string {
+	if len(l.Holders) == 0 {
+		return "unknown"
+	}
+
+	s := make([]string, 0, len(l.Holders))
+	for _, h := range l.Holders {
+		mode := "shared"
+		if h.Exclusive {
+			mode = "exclusive"
+		}
+		s = append(s, fmt.Sprintf("pid=%d %s", h.PID, mode))
+	}
+	return strings.Join(s, ",")
+}
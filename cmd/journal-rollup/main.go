@@ -0,0 +1,94 @@
+// journal-rollup downsamples every journal in a store into the rollup
+// archives described by a carbon style retention ladder, or by the rules
+// of a retention configuration file given with -config, and trims the
+// raw journals and archives to the retention of each.  It runs a single
+// pass by default or repeats on an interval with -every.  Interrupted
+// passes resume where they left off.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/retention"
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/store"
+)
+
+func main() {
+	root := flag.String("root", ".", "Root directory of the journal store")
+	config := flag.String("config", "",
+		"Retention configuration file; overrides -retentions, -method, and -xff")
+	retentions := flag.String("retentions", "60s:1d,5m:30d,1h:5y",
+		"Carbon style retention ladder; the first archive is the raw journal")
+	method := flag.String("method", "average",
+		"Aggregation method: average, sum, min, max, or last")
+	xff := flag.Float64("xff", rollup.DefaultXFilesFactor,
+		"Fraction of values a bucket needs to be non-null")
+	workers := flag.Int("workers", store.DefaultWorkers,
+		"Number of series to roll up concurrently")
+	state := flag.String("state", "",
+		"Progress file used to resume an interrupted pass (default <root>/.rollup-state)")
+	every := flag.Duration("every", 0,
+		"Run continuously with this delay between passes")
+	flag.Parse()
+
+	var policy func(string) *rollup.Policy
+	if *config != "" {
+		c, err := retention.Load(*config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		policy = c.Policy
+	} else {
+		archives, err := rollup.ParseRetentions(*retentions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fn, err := rollup.GetAggregator(*method)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p := &rollup.Policy{
+			Archives:     archives,
+			Method:       fn,
+			XFilesFactor: *xff,
+		}
+		policy = func(string) *rollup.Policy { return p }
+	}
+	if *state == "" {
+		*state = filepath.Join(*root, ".rollup-state")
+	}
+
+	r := &rollup.Runner{
+		Store:   store.New(*root),
+		Policy:  policy,
+		Workers: *workers,
+		State:   *state,
+		OnError: func(series string, err error) {
+			log.Printf("%s: %s", series, err)
+		},
+	}
+
+	for {
+		start := time.Now()
+		err := r.Run()
+		if err != nil {
+			log.Print(err)
+		}
+		if *every == 0 {
+			if err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Rollup pass finished in %s\n", time.Since(start))
+		time.Sleep(*every)
+	}
+}
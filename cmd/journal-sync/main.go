@@ -0,0 +1,154 @@
+// journal-sync copies the series of a journal server into a local store
+// over the replication protocol, such as to seed a new replica or to
+// migrate a store to another datacenter.  Only the values the local
+// copies lack are shipped, so an interrupted sync continues where it
+// stopped, and with -state the series already copied are not asked for
+// again.  With -verify each copy is checked against the server's by
+// fingerprint once it is pulled.  With -rate the copy is paced to spare
+// the server's disks and network; the server does not hold its journals
+// while it waits on a paced copy, so its writes carry on.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/replication"
+	"github.com/jjneely/journal/store"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] -root <dir> -leader <url> [<pattern>...]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	root := flag.String("root", "", "Root directory of the local journal store")
+	leader := flag.String("leader", "", "URL of the server's replication endpoint, such as http://host:8080/replicate")
+	rate := flag.Int64("rate", 0, "Bytes of values per second to read from the server, 0 for unlimited")
+	state := flag.String("state", "", "File recording the series copied, to skip them when run again")
+	verify := flag.Bool("verify", false, "Compare the fingerprint of each copy with the server's")
+	token := flag.String("token", "", "Bearer token to present to the server")
+	flag.Usage = usage
+	flag.Parse()
+	if *root == "" || *leader == "" {
+		usage()
+		os.Exit(2)
+	}
+
+	f := &replication.Follower{
+		Store:  store.New(*root),
+		Leader: *leader,
+		Rate:   *rate,
+	}
+	if *token != "" {
+		f.Client = &http.Client{Transport: bearer(*token)}
+	}
+
+	done, err := loadState(*state)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var series []string
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{""}
+	}
+	for _, pattern := range patterns {
+		names, err := f.List(pattern)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		series = append(series, names...)
+	}
+
+	var log *os.File
+	if *state != "" {
+		if log, err = os.OpenFile(*state, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer log.Close()
+	}
+
+	status, copied := 0, 0
+	for _, name := range series {
+		if done[name] {
+			continue
+		}
+		done[name] = true
+		if err := copySeries(f, name, *verify); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+			status = 1
+			continue
+		}
+		copied++
+		if log != nil {
+			if _, err := fmt.Fprintln(log, name); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+	fmt.Printf("Copied %d of %d series\n", copied, len(series))
+	os.Exit(status)
+}
+
+// copySeries pulls a series and verifies the copy if asked to.
+func copySeries(f *replication.Follower, series string, verify bool) error {
+	n, err := f.Pull(series)
+	if err != nil {
+		return err
+	}
+	if verify {
+		ok, err := f.Verify(series)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("Fingerprint does not match the server's")
+		}
+	}
+	fmt.Printf("%s\t%d values\n", series, n)
+	return nil
+}
+
+// loadState returns the series recorded in the state file at path, if
+// any.
+func loadState(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	if path == "" {
+		return done, nil
+	}
+	fd, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			done[name] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// bearer is an http.RoundTripper presenting a bearer token.
+type bearer string
+
+func (b bearer) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+string(b))
+	return http.DefaultTransport.RoundTrip(r)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+)
+
+import (
+	"github.com/jjneely/journal/analytics"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func init() {
+	commands["anomalies"] = &command{
+		usage: "[-sigma N] [-min X] [-max Y] [-from time] [-until time] <path>...",
+		help:  "List values outside standard deviation or fixed bounds",
+		run:   anomalies,
+	}
+}
+
+func anomalies(flags *flag.FlagSet, args []string) error {
+	sigma := flags.Float64("sigma", 3, "Flag values this many standard deviations from the mean, 0 disables")
+	min := flags.Float64("min", math.NaN(), "Flag values below this")
+	max := flags.Float64("max", math.NaN(), "Flag values above this")
+	fromFlag := flags.String("from", "0", "Start of the range to scan")
+	untilFlag := flags.String("until", "now", "End of the range to scan")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	from, err := parseTime(*fromFlag)
+	if err != nil {
+		return err
+	}
+	until, err := parseTime(*untilFlag)
+	if err != nil {
+		return err
+	}
+	rules := analytics.Rules{Sigma: *sigma}
+	if !math.IsNaN(*min) {
+		rules.Min = min
+	}
+	if !math.IsNaN(*max) {
+		rules.Max = max
+	}
+
+	files, err := journals(flags.Args())
+	if err != nil {
+		return err
+	}
+	found := 0
+	for _, path := range files {
+		j, err := timeseries.OpenWithOptions(path, &timeseries.Options{ReadOnly: true})
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		list, err := analytics.Anomalies(j, from, until, rules)
+		j.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		for _, a := range list {
+			fmt.Printf("%s: %s\n", path, a)
+		}
+		found += len(list)
+	}
+
+	if found > 0 {
+		return fmt.Errorf("%d anomalies found", found)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+func init() {
+	commands["append"] = &command{
+		usage: "[-dry-run] [-gap] <dst> <src>...",
+		help:  "Append journal segments onto the end of another journal",
+		run:   appendJournals,
+	}
+}
+
+func appendJournals(flags *flag.FlagSet, args []string) error {
+	dryRun := flags.Bool("dry-run", false, "Report what would be appended")
+	gap := flags.Bool("gap", false, "Fill gaps between segments with nulls")
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := &timeseries.Options{ReadOnly: *dryRun}
+	dst, err := timeseries.OpenWithOptions(args[0], opts)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	verb := "appended"
+	if *dryRun {
+		verb = "would append"
+	}
+	for _, path := range args[1:] {
+		src, err := timeseries.OpenWithOptions(path, &timeseries.Options{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		from, n, err := timeseries.Appendable(dst, src, *gap)
+		if err == nil && !*dryRun {
+			n, err = timeseries.Append(dst, src, *gap)
+		}
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		fmt.Printf("append %s: %s %d points from %s starting at %d\n",
+			args[0], verb, n, path, from)
+	}
+	return nil
+}
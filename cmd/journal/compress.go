@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+func init() {
+	commands["compress"] = &command{
+		usage: "[-dry-run] [-codec <name>] <path>...",
+		help:  "Compress sealed journals for cold storage, verifying each copy",
+		run:   compress,
+	}
+	commands["decompress"] = &command{
+		usage: "[-dry-run] <path>...",
+		help:  "Restore compressed journals to their original files",
+		run:   decompress,
+	}
+}
+
+// savings totals the sizes of the files a command changed.
+type savings struct {
+	files         int
+	before, after int64
+}
+
+// add records a file changing from before to after bytes and prints it.
+func (s *savings) add(path string, before, after int64) {
+	s.files++
+	s.before += before
+	s.after += after
+	fmt.Printf("%s: %d to %d bytes%s\n", path, before, after, saved(before, after))
+}
+
+// print prints the totals.
+func (s *savings) print(verb string, dryRun bool) {
+	if dryRun {
+		verb = "would be " + verb
+	}
+	fmt.Printf("%d journals %s: %d to %d bytes%s\n", s.files, verb, s.before, s.after, saved(s.before, s.after))
+}
+
+// saved describes the fraction of before saved by after, or how much
+// larger after is.
+func saved(before, after int64) string {
+	switch {
+	case before == 0:
+		return ""
+	case after > before:
+		return fmt.Sprintf(", %.1fx larger", float64(after)/float64(before))
+	}
+	return fmt.Sprintf(", %.1f%% saved", 100*float64(before-after)/float64(before))
+}
+
+func compress(flags *flag.FlagSet, args []string) error {
+	name := flags.String("codec", timeseries.Gzip.Name, "Compression codec")
+	dryRun := flags.Bool("dry-run", false, "Report the space that would be saved")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	codec := timeseries.GetCodec(*name)
+	if codec == nil {
+		return fmt.Errorf("Unknown codec: %s", *name)
+	}
+
+	files, err := journals(flags.Args())
+	if err != nil {
+		return err
+	}
+	var total savings
+	bad := 0
+	for _, path := range files {
+		if timeseries.CompressedExt(path) != "" {
+			continue
+		}
+		stat, err := os.Stat(path)
+		if err == nil && !sealed(path) {
+			fmt.Printf("%s: not sealed, skipped\n", path)
+			continue
+		}
+		var c timeseries.Change
+		if err == nil {
+			c, err = timeseries.CompressJournal(path, codec, *dryRun, nil)
+		}
+		if err != nil {
+			fmt.Printf("%s: %s\n", path, err)
+			bad++
+			continue
+		}
+		total.add(c.Path, stat.Size(), stat.Size()-c.Bytes)
+	}
+	total.print("compressed", *dryRun)
+
+	if bad > 0 {
+		return fmt.Errorf("%d of %d journals could not be compressed", bad, len(files))
+	}
+	return nil
+}
+
+// sealed reports whether the journal at path is sealed.  Journals that
+// cannot be read are left for CompressJournal to report.
+func sealed(path string) bool {
+	header, err := timeseries.Peek(path)
+	return err != nil || header.Flags&timeseries.FlagSealed != 0
+}
+
+func decompress(flags *flag.FlagSet, args []string) error {
+	dryRun := flags.Bool("dry-run", false, "Report the space that would be used")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	files, err := journals(flags.Args())
+	if err != nil {
+		return err
+	}
+	var total savings
+	bad := 0
+	for _, path := range files {
+		ext := timeseries.CompressedExt(path)
+		if ext == "" {
+			continue
+		}
+		stat, err := os.Stat(path)
+		var c timeseries.Change
+		if err == nil {
+			c, err = timeseries.DecompressJournal(strings.TrimSuffix(path, ext), *dryRun, nil)
+		}
+		if err != nil {
+			fmt.Printf("%s: %s\n", path, err)
+			bad++
+			continue
+		}
+		total.add(c.Path, stat.Size(), stat.Size()+c.Bytes)
+	}
+	total.print("decompressed", *dryRun)
+
+	if bad > 0 {
+		return fmt.Errorf("%d of %d journals could not be decompressed", bad, len(files))
+	}
+	return nil
+}
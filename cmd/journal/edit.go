@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func init() {
+	commands["edit"] = &command{
+		usage: "[-dry-run] set <path> <time> <value> | null <path> <from> <until>",
+		help:  "Set or null out individual values in a journal",
+		run:   edit,
+	}
+}
+
+func edit(flags *flag.FlagSet, args []string) error {
+	dryRun := flags.Bool("dry-run", false, "Report the change without making it")
+	flags.Parse(args)
+	args = flags.Args()
+	if len(args) != 4 || (args[0] != "set" && args[0] != "null") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	j, err := timeseries.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	ts, err := parseTime(args[2])
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "null" {
+		until, err := parseTime(args[3])
+		if err != nil {
+			return err
+		}
+		c, err := j.Delete(ts, until, *dryRun)
+		if err != nil {
+			return err
+		}
+		fmt.Println(c)
+		return nil
+	}
+
+	value, err := parseValue(j.Factory(), args[3])
+	if err != nil {
+		return err
+	}
+	ts = ts - ts%j.Interval()
+	if *dryRun {
+		fmt.Printf("set %s: would write %s at %d\n", args[1], args[3], ts)
+		return nil
+	}
+	if err = j.Write(ts, value); err != nil {
+		return err
+	}
+	fmt.Printf("set %s: wrote %s at %d\n", args[1], args[3], ts)
+	return nil
+}
+
+// parseValue converts a command line argument to a single value of the
+// journal's type.
+func parseValue(factory ValueType, s string) (Values, error) {
+	switch factory.(type) {
+	case *Float64ValueType:
+		f, err := strconv.ParseFloat(s, 64)
+		return Float64Values{f}, err
+	case *Float32ValueType:
+		f, err := strconv.ParseFloat(s, 32)
+		return Float32Values{float32(f)}, err
+	case *Int64ValueType:
+		i, err := strconv.ParseInt(s, 10, 64)
+		return Int64Values{i}, err
+	case *ByteValueType:
+		if int32(len(s)) > factory.Width() {
+			return nil, fmt.Errorf("Value is longer than %d bytes", factory.Width())
+		}
+		b := make([]byte, factory.Width())
+		copy(b, s)
+		return ByteValues{b}, nil
+	}
+	return nil, fmt.Errorf("Unsupported journal type %#x", factory.Type())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"os"
+)
+
+import (
+	"github.com/jjneely/journal/export"
+	"github.com/jjneely/journal/store"
+)
+
+func init() {
+	commands["export"] = &command{
+		usage: "-root <dir> [-from time] [-until time] [-skip-nulls] <pattern>...",
+		help:  "Write series from a store as CSV rows of series, timestamp, and value",
+		run:   exportSeries,
+	}
+}
+
+func exportSeries(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	fromFlag := flags.String("from", "0", "Start of the range to export")
+	untilFlag := flags.String("until", "now", "End of the range to export")
+	skipNulls := flags.Bool("skip-nulls", false, "Leave out null values")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	from, err := parseTime(*fromFlag)
+	if err != nil {
+		return err
+	}
+	until, err := parseTime(*untilFlag)
+	if err != nil {
+		return err
+	}
+	s := store.New(*root)
+	var series []string
+	for _, pattern := range flags.Args() {
+		found, err := s.Find(pattern)
+		if err != nil {
+			return err
+		}
+		series = append(series, found...)
+	}
+
+	w := export.NewCSVWriter(os.Stdout)
+	e := &export.Exporter{Store: s, SkipNulls: *skipNulls}
+	if _, err = e.Export(w, series, from, until); err != nil {
+		return err
+	}
+	return w.Close()
+}
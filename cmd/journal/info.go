@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+func init() {
+	commands["info"] = &command{
+		usage: "[-json] [-top N] <path>...",
+		help:  "Summarize the journals in a directory tree",
+		run:   info,
+	}
+}
+
+// File is the summary of one journal.
+type File struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Summary aggregates statistics over many journals.
+type Summary struct {
+	Journals    int           `json:"journals"`
+	Errors      int           `json:"errors"`
+	Bytes       int64         `json:"bytes"`
+	Points      int64         `json:"points"`
+	Nulls       int64         `json:"nulls"`
+	NullDensity float64       `json:"null_density"`
+	Oldest      int64         `json:"oldest_epoch"`
+	Newest      int64         `json:"newest_last"`
+	Intervals   map[int64]int `json:"intervals"`
+	Largest     []File        `json:"largest"`
+}
+
+func info(flags *flag.FlagSet, args []string) error {
+	asJSON := flags.Bool("json", false, "Write the summary as JSON")
+	top := flags.Int("top", 10, "Number of largest journals to list")
+	flags.Parse(args)
+
+	files, err := journals(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	s := Summary{Intervals: make(map[int64]int)}
+	var sizes []File
+	for _, path := range files {
+		if err := s.add(path, &sizes); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			s.Errors++
+		}
+	}
+	if s.Points > 0 {
+		s.NullDensity = float64(s.Nulls) / float64(s.Points)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].Bytes > sizes[j].Bytes })
+	if len(sizes) > *top {
+		sizes = sizes[:*top]
+	}
+	s.Largest = sizes
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	s.print()
+	return nil
+}
+
+func (s *Summary) add(path string, sizes *[]File) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	j, err := timeseries.OpenWithOptions(path, &timeseries.Options{ReadOnly: true})
+	if err == timeseries.ErrLocked {
+		// Held by a writer; summarize its header without the nulls
+		return s.addPeek(path, stat.Size(), sizes)
+	} else if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	nulls, err := j.Nulls()
+	if err != nil {
+		return err
+	}
+	s.Nulls += nulls
+	s.addJournal(path, stat.Size(), j.Epoch(), j.Interval(), j.Points(), sizes)
+	return nil
+}
+
+// addPeek adds a journal known only by its header and size.
+func (s *Summary) addPeek(path string, size int64, sizes *[]File) error {
+	header, ext, err := timeseries.PeekExt(path)
+	if err != nil {
+		return err
+	}
+	points := max(size-header.ValuesOffset(ext), 0) / int64(header.Width)
+	s.addJournal(path, size, header.Epoch, header.Interval, points, sizes)
+	return nil
+}
+
+func (s *Summary) addJournal(path string, size, epoch, interval, points int64, sizes *[]File) {
+	s.Journals++
+	s.Bytes += size
+	s.Points += points
+	s.Intervals[interval]++
+	*sizes = append(*sizes, File{path, size})
+	if epoch != 0 {
+		if s.Oldest == 0 || epoch < s.Oldest {
+			s.Oldest = epoch
+		}
+		if last := epoch + (points-1)*interval; last > s.Newest {
+			s.Newest = last
+		}
+	}
+}
+
+func (s *Summary) print() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Journals:\t%d\n", s.Journals)
+	if s.Errors > 0 {
+		fmt.Fprintf(w, "Errors:\t%d\n", s.Errors)
+	}
+	fmt.Fprintf(w, "Total bytes:\t%d\n", s.Bytes)
+	fmt.Fprintf(w, "Points:\t%d\n", s.Points)
+	fmt.Fprintf(w, "Null density:\t%.2f%%\n", 100*s.NullDensity)
+	fmt.Fprintf(w, "Oldest epoch:\t%s\n", timestamp(s.Oldest))
+	fmt.Fprintf(w, "Newest last:\t%s\n", timestamp(s.Newest))
+	w.Flush()
+
+	fmt.Println("\nInterval\tJournals")
+	intervals := make([]int64, 0, len(s.Intervals))
+	for i := range s.Intervals {
+		intervals = append(intervals, i)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	for _, i := range intervals {
+		fmt.Printf("%ds\t\t%d\n", i, s.Intervals[i])
+	}
+
+	fmt.Println("\nLargest journals")
+	for _, f := range s.Largest {
+		fmt.Printf("%12d  %s\n", f.Bytes, f.Path)
+	}
+}
+
+func timestamp(ts int64) string {
+	if ts == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d (%s)", ts, time.Unix(ts, 0).UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,93 @@
+// journal is a command line tool for inspecting and maintaining
+// timeseries journals.  Run "journal help" for the list of commands.
+//
+// Commands that modify journals accept -dry-run to report exactly what
+// would change without touching the disk.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/httpapi"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// command is a journal subcommand.
+type command struct {
+	usage string
+	help  string
+	run   func(flags *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: journal <command> [options] [args]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].help)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: journal %s %s\n", os.Args[1], cmd.usage)
+		flags.PrintDefaults()
+	}
+	if err := cmd.run(flags, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "journal %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// parseTime accepts Unix timestamps and relative times such as "-1d".
+func parseTime(s string) (int64, error) {
+	now := time.Now()
+	return httpapi.ParseTime(s, now, now)
+}
+
+// journals expands directories in paths into the journal files beneath
+// them, compressed or not.
+func journals(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			name := strings.TrimSuffix(p, timeseries.CompressedExt(p))
+			if info.Mode().IsRegular() && (p == path || filepath.Ext(name) == ".tsj") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
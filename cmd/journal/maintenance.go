@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func init() {
+	commands["fsck"] = &command{
+		usage: "[-repair] [-optimize [-min-nulls <n>]] [-dry-run] <path>...",
+		help:  "Check journals for corruption and optionally repair or optimize them",
+		run:   fsck,
+	}
+	commands["trim"] = &command{
+		usage: "[-dry-run] [-audit <log>] -before <time> <path>...",
+		help:  "Discard values older than a timestamp",
+		run:   trim,
+	}
+	commands["delete"] = &command{
+		usage: "[-dry-run] [-audit <log>] [-trash=false] -root <dir> <series>...",
+		help:  "Remove series and their rollup archives from a store",
+		run:   deleteSeries,
+	}
+	commands["undelete"] = &command{
+		usage: "[-audit <log>] -root <dir> <series>...",
+		help:  "Restore deleted series from the trash of a store",
+		run:   undelete,
+	}
+	commands["trash"] = &command{
+		usage: "[-purge <duration> [-audit <log>]] -root <dir>",
+		help:  "List the series in the trash of a store or purge those deleted long ago",
+		run:   trash,
+	}
+	commands["rename"] = &command{
+		usage: "[-merge] [-audit <log>] -root <dir> <old> <new>",
+		help:  "Rename a series in a store, optionally merging into an existing one",
+		run:   rename,
+	}
+	commands["alias"] = &command{
+		usage: "-root <dir> [-delete <alias> | <alias> <series>]",
+		help:  "List, add, or remove series aliases in a store",
+		run:   alias,
+	}
+	commands["snapshot"] = &command{
+		usage: "[-lock-timeout <duration>] [-key-file <key>] -root <dir> <dest> [<pattern>...]",
+		help:  "Copy series from a store as they were at one instant",
+		run:   snapshot,
+	}
+	commands["backup"] = &command{
+		usage: "-root <dir> [-state <file>] [-key-file <key>] > <stream>",
+		help:  "Write the changes to a store since the last backup to stdout",
+		run:   backup,
+	}
+	commands["restore"] = &command{
+		usage: "[-key-file <key>] <dir> < <stream>",
+		help:  "Apply a backup stream to a store directory",
+		run:   restore,
+	}
+	commands["reap"] = &command{
+		usage: "[-dry-run] [-audit <log>] [-delete [-trash=false] | -archive <dir> [-key-file <key>]] -root <dir> -before <time>",
+		help:  "List, archive, or remove series not written since a time",
+		run:   reap,
+	}
+	commands["decrypt"] = &command{
+		usage: "-key-file <key> <dir>",
+		help:  "Decrypt the files of an encrypted snapshot or archive in place",
+		run:   decrypt,
+	}
+	commands["clone"] = &command{
+		usage: "<src> <dst>",
+		help:  "Copy a journal, sharing its blocks if the file system supports reflinks",
+		run:   clone,
+	}
+	commands["audit"] = &command{
+		usage: "<log>",
+		help:  "Print an audit log of destructive operations and verify its chain",
+		run:   audit,
+	}
+}
+
+func fsck(flags *flag.FlagSet, args []string) error {
+	repair := flags.Bool("repair", false, "Truncate partially written values")
+	optimize := flags.Bool("optimize", false, "Rebase journals that begin with a run of nulls")
+	minNulls := flags.Int64("min-nulls", 1024, "Shortest run of leading nulls -optimize removes")
+	dryRun := flags.Bool("dry-run", false, "Report repairs without making them")
+	flags.Parse(args)
+
+	files, err := journals(flags.Args())
+	if err != nil {
+		return err
+	}
+	bad := 0
+	for _, path := range files {
+		j, err := timeseries.OpenWithOptions(path, &timeseries.Options{ReadOnly: true})
+		if err == nil {
+			if j.Dirty() {
+				fmt.Printf("%s: not synced after its last write\n", path)
+			}
+			sealed := j.Sealed()
+			j.Close()
+			if *optimize && !sealed {
+				if err = rebase(path, *minNulls, *dryRun); err != nil {
+					fmt.Printf("%s: %s\n", path, err)
+					bad++
+				}
+			}
+			continue
+		}
+		if err == timeseries.ErrLocked {
+			if _, err = timeseries.Peek(path); err == nil {
+				fmt.Printf("%s: in use, only the header was checked\n", path)
+				continue
+			}
+		}
+		if err == timeseries.ErrPartial && *repair {
+			c, err := timeseries.Repair(path, *dryRun, nil)
+			if err == nil {
+				fmt.Println(c)
+				continue
+			}
+		}
+		fmt.Printf("%s: %s\n", path, err)
+		bad++
+	}
+
+	if bad > 0 {
+		return fmt.Errorf("%d of %d journals have errors", bad, len(files))
+	}
+	return nil
+}
+
+// rebase removes the leading nulls of the journal at path if there are
+// at least minNulls of them.
+func rebase(path string, minNulls int64, dryRun bool) error {
+	j, err := timeseries.OpenWithOptions(path, &timeseries.Options{ReadOnly: dryRun})
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	c, err := j.Rebase(minNulls, dryRun)
+	if err == nil && c.Points > 0 {
+		fmt.Println(c)
+	}
+	return err
+}
+
+func trim(flags *flag.FlagSet, args []string) error {
+	before := flags.String("before", "", "Discard values older than this time")
+	dryRun := flags.Bool("dry-run", false, "Report what would be discarded")
+	auditLog := flags.String("audit", "", "Record the trims in this audit log")
+	flags.Parse(args)
+	if *before == "" || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	ts, err := parseTime(*before)
+	if err != nil {
+		return err
+	}
+
+	files, err := journals(flags.Args())
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		j, err := timeseries.Open(path)
+		if err != nil {
+			return err
+		}
+		if *auditLog != "" && !*dryRun {
+			c, err := j.Trim(ts, true)
+			if err == nil && c.Points > 0 {
+				err = store.AppendAudit(*auditLog, store.AuditEntry{
+					Op: "trim", Path: path, From: c.From, Until: c.Until, Points: c.Points, Bytes: c.Bytes,
+				})
+			}
+			if err != nil {
+				j.Close()
+				return err
+			}
+		}
+		c, err := j.Trim(ts, *dryRun)
+		j.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Println(c)
+	}
+	return nil
+}
+
+func deleteSeries(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	dryRun := flags.Bool("dry-run", false, "Report the files that would be removed")
+	auditLog := flags.String("audit", "", "Record the deletions in this audit log")
+	toTrash := flags.Bool("trash", true, trashUsage)
+	flags.Parse(args)
+
+	s := store.New(*root)
+	s.AuditLog = *auditLog
+	s.Trash = *toTrash
+	for _, series := range flags.Args() {
+		changes, err := s.Delete(series, *dryRun)
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", series, err)
+		}
+	}
+	return nil
+}
+
+// trashUsage describes the -trash flag of commands that delete series.
+const trashUsage = "Move removed series to the trash of the store, from which undelete restores them"
+
+func undelete(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	auditLog := flags.String("audit", "", "Record the restorations in this audit log")
+	flags.Parse(args)
+
+	s := store.New(*root)
+	s.AuditLog = *auditLog
+	for _, series := range flags.Args() {
+		if err := s.Undelete(series); err != nil {
+			return fmt.Errorf("%s: %s", series, err)
+		}
+	}
+	return nil
+}
+
+func trash(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	purge := flags.Duration("purge", 0, "Permanently remove series deleted longer ago than this")
+	auditLog := flags.String("audit", "", "Record the purged series in this audit log")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	s := store.New(*root)
+	s.AuditLog = *auditLog
+	var entries []store.TrashEntry
+	var err error
+	if *purge > 0 {
+		entries, err = s.PurgeTrash(time.Now().Add(-*purge))
+	} else {
+		entries, err = s.Trashed()
+	}
+	for _, e := range entries {
+		fmt.Printf("%s\tdeleted %s\t%d files\n", e.Series, timestamp(e.Deleted.Unix()), len(e.Files))
+	}
+	return err
+}
+
+func rename(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	merge := flags.Bool("merge", false, "Combine with the new series if it exists")
+	auditLog := flags.String("audit", "", "Record the rename in this audit log")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	s := store.New(*root)
+	s.AuditLog = *auditLog
+	return s.Rename(flags.Arg(0), flags.Arg(1), *merge)
+}
+
+func alias(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	remove := flags.String("delete", "", "Alias to remove")
+	flags.Parse(args)
+
+	s := store.New(*root)
+	switch {
+	case *remove != "" && flags.NArg() == 0:
+		return s.Unalias(*remove)
+	case *remove == "" && flags.NArg() == 2:
+		return s.Alias(flags.Arg(0), flags.Arg(1))
+	case *remove != "" || flags.NArg() != 0:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	aliases, err := s.Aliases()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s -> %s\n", name, aliases[name])
+	}
+	return nil
+}
+
+func reap(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	before := flags.String("before", "", "Reap series not written since this time")
+	archive := flags.String("archive", "", "Move stale series into a store rooted here")
+	remove := flags.Bool("delete", false, "Remove stale series")
+	dryRun := flags.Bool("dry-run", false, "Report the files that would be moved or removed")
+	auditLog := flags.String("audit", "", "Record the moves and removals in this audit log")
+	keyFile := flags.String("key-file", "", encryptUsage)
+	toTrash := flags.Bool("trash", true, trashUsage)
+	flags.Parse(args)
+	if *before == "" || flags.NArg() != 0 || (*remove && *archive != "") {
+		flags.Usage()
+		os.Exit(2)
+	}
+	ts, err := parseTime(*before)
+	if err != nil {
+		return err
+	}
+
+	s := store.New(*root)
+	s.AuditLog = *auditLog
+	s.Encryption = encryption(*keyFile)
+	s.Trash = *toTrash
+	stale, err := s.Stale(ts)
+	if err != nil {
+		return err
+	}
+	for _, st := range stale {
+		var changes []timeseries.Change
+		switch {
+		case *remove:
+			changes, err = s.Delete(st.Series, *dryRun)
+		case *archive != "":
+			changes, err = s.Archive(st.Series, *archive, *dryRun)
+		default:
+			fmt.Printf("%s\tlast %s\n", st.Series, timestamp(st.Last))
+			continue
+		}
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", st.Series, err)
+		}
+	}
+	return nil
+}
+
+func snapshot(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	timeout := flags.Duration("lock-timeout", 10*time.Second,
+		"How long to wait for journals locked by another process")
+	keyFile := flags.String("key-file", "", encryptUsage)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	s := store.New(*root)
+	s.Options = &timeseries.Options{LockTimeout: *timeout}
+	s.Encryption = encryption(*keyFile)
+	var series []string
+	if flags.NArg() == 1 {
+		var err error
+		if series, err = s.List(); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range flags.Args()[1:] {
+		matches, err := s.Find(pattern)
+		if err != nil {
+			return err
+		}
+		series = append(series, matches...)
+	}
+
+	changes, err := s.Snapshot(series, flags.Arg(0))
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	return err
+}
+
+func backup(flags *flag.FlagSet, args []string) error {
+	root := flags.String("root", ".", "Root directory of the journal store")
+	statePath := flags.String("state", "",
+		"File recording the state of the last backup; without it the backup is full")
+	keyFile := flags.String("key-file", "", encryptUsage)
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var prev *store.BackupState
+	if *statePath != "" {
+		buf, err := os.ReadFile(*statePath)
+		if err == nil {
+			prev = new(store.BackupState)
+			if err = json.Unmarshal(buf, prev); err != nil {
+				return fmt.Errorf("%s: %s", *statePath, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	s := store.New(*root)
+	s.Encryption = encryption(*keyFile)
+	r, next := s.BackupSince(prev)
+	if _, err := io.Copy(os.Stdout, r); err != nil {
+		return err
+	}
+	if *statePath == "" {
+		return nil
+	}
+	buf, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	tmp := *statePath + ".tmp"
+	if err = os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, *statePath)
+}
+
+func restore(flags *flag.FlagSet, args []string) error {
+	keyFile := flags.String("key-file", "", "Decrypt the stream with this key file or others in its directory")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var r io.Reader = os.Stdin
+	if e := encryption(*keyFile); e != nil {
+		var err error
+		if r, err = e.Decrypt(r); err != nil {
+			return err
+		}
+	}
+	return store.Restore(r, flags.Arg(0))
+}
+
+func decrypt(flags *flag.FlagSet, args []string) error {
+	keyFile := flags.String("key-file", "", "Decrypt with this key file or others in its directory")
+	flags.Parse(args)
+	if *keyFile == "" || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	changes, err := encryption(*keyFile).DecryptTree(flags.Arg(0))
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	return err
+}
+
+// encryptUsage describes the -key-file flag of commands that encrypt.
+const encryptUsage = "Encrypt with AES-GCM using the key, raw or hex, in this file"
+
+// encryption returns the Encryption of a -key-file flag, nil if unset.
+func encryption(keyFile string) *store.Encryption {
+	if keyFile == "" {
+		return nil
+	}
+	return store.KeyFile(keyFile)
+}
+
+func clone(flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return timeseries.CloneJournal(flags.Arg(0), flags.Arg(1))
+}
+
+func audit(flags *flag.FlagSet, args []string) error {
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	buf, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, line := range bytes.Split(bytes.TrimSuffix(buf, []byte("\n")), []byte("\n")) {
+		var e store.AuditEntry
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s", timestamp(e.Time/1e9), e.User, e.Op, e.Series)
+		if e.Path != "" {
+			fmt.Printf("\t%s", e.Path)
+		}
+		if e.Points > 0 {
+			fmt.Printf("\t%d points from %s until %s", e.Points, timestamp(e.From), timestamp(e.Until))
+		}
+		if e.Detail != "" {
+			fmt.Printf("\t%s", e.Detail)
+		}
+		fmt.Println()
+	}
+	n, err := store.VerifyAudit(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d entries verified\n", n)
+	return nil
+}
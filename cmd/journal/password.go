@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/auth"
+)
+
+func init() {
+	commands["hash-password"] = &command{
+		usage: "[-iterations <n>] < <password>",
+		help:  "Hash a password read from stdin for the password_hash of an auth user",
+		run:   hashPassword,
+	}
+}
+
+func hashPassword(flags *flag.FlagSet, args []string) error {
+	iterations := flags.Int("iterations", auth.DefaultIterations, "PBKDF2 iterations")
+	flags.Parse(args)
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err != nil {
+			return fmt.Errorf("Reading password: %s", err)
+		}
+		return fmt.Errorf("Empty password")
+	}
+	hash, err := auth.HashPassword(password, *iterations)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/alert"
+	"github.com/jjneely/journal/auth"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/cluster"
+	"github.com/jjneely/journal/store"
+)
+
+// Config is the journald configuration file, in JSON.
+type Config struct {
+	// Root is the directory of the journal store.
+	Root string `json:"root"`
+
+	// Retentions is the path of the retention configuration file used
+	// to create new series and roll them up.
+	Retentions string `json:"retentions"`
+
+	// GraphiteTCP and GraphiteUDP are the addresses of the plaintext
+	// line protocol listeners.  Empty disables a listener.
+	GraphiteTCP string `json:"graphite_tcp"`
+	GraphiteUDP string `json:"graphite_udp"`
+
+	// IngestRate and IngestBurst bound the points per second and the
+	// burst of points each Graphite client may send.  Zero IngestRate is
+	// unlimited.
+	IngestRate  float64 `json:"ingest_rate"`
+	IngestBurst int     `json:"ingest_burst"`
+
+	// MaxNewSeries bounds the series without a journal that each
+	// Graphite client may send per hour.  Zero is unlimited.
+	MaxNewSeries int `json:"max_new_series"`
+
+	// HTTP is the address of the query API.  Empty disables it.
+	HTTP string `json:"http"`
+
+	// TLS, if set, serves the HTTP API and the Graphite TCP listener
+	// over TLS.
+	TLS *auth.TLS `json:"tls"`
+
+	// Auth, if set, authenticates clients.  Queries need the read role
+	// and the debug endpoints the admin role.  Graphite clients need the
+	// write role, which can only be granted by a TLS client certificate
+	// unless it is the anonymous role.  The passwords of users are given
+	// as a password_hash of the form pbkdf2-sha256$<iterations>$<salt>$<key>,
+	// as printed by journal hash-password and described by
+	// auth.HashPassword.
+	Auth *auth.Authenticator `json:"auth"`
+
+	// Debug serves /debug/vars and /debug/pprof on the HTTP address.
+	// They are open to everyone unless Auth is set, so it is off by
+	// default.
+	Debug bool `json:"debug"`
+
+	// FlushInterval is how often buffered points are written.
+	FlushInterval Duration `json:"flush_interval"`
+
+	// FlushAligned writes buffered points at multiples of
+	// flush_interval, such as on every minute, and SyncOnFlush syncs
+	// the journals written to disk.
+	FlushAligned bool `json:"flush_aligned"`
+	SyncOnFlush  bool `json:"sync_on_flush"`
+
+	// MaxPending bounds the number of buffered points.
+	MaxPending int `json:"max_pending"`
+
+	// RollupInterval is the delay between rollup passes.  Zero disables
+	// rollups.
+	RollupInterval Duration `json:"rollup_interval"`
+
+	// RollupOnWrite updates rollup archives as points are written, as
+	// described by rollup.Propagator, in place of rollup passes.
+	RollupOnWrite bool `json:"rollup_on_write"`
+
+	// ScrubInterval is the delay between passes of the scrubber, which
+	// reads every journal looking for damage.  Zero disables it.
+	// ScrubRate bounds the bytes it reads per second and ScrubRepair
+	// lets it truncate partially written values.
+	ScrubInterval Duration `json:"scrub_interval"`
+	ScrubRate     int64    `json:"scrub_rate"`
+	ScrubRepair   bool     `json:"scrub_repair"`
+
+	// SlowRead, SlowWrite, and SlowQuery, if set, log the reads of a
+	// series, the writes of a series' buffered points, and the HTTP
+	// queries that take at least as long, with what they touched.
+	SlowRead  Duration `json:"slow_read"`
+	SlowWrite Duration `json:"slow_write"`
+	SlowQuery Duration `json:"slow_query"`
+
+	// ActiveWindow is how long a series counts as active after it was
+	// last written, for the series_active and series_stopped statistics.
+	ActiveWindow Duration `json:"active_window"`
+
+	// IndexInterval, if set, keeps an index of the store's series so
+	// that listing and finding them does not walk the tree.  The index
+	// is rebuilt from disk at this interval to pick up changes made by
+	// other processes, and saved in the store as store.SeriesIndexFile.
+	IndexInterval Duration `json:"index_interval"`
+
+	// AuditLog, if set, is the path of a log recording destructive
+	// operations such as scrub repairs, as described by store.AuditEntry.
+	AuditLog string `json:"audit_log"`
+
+	// PoolSize is the number of journals kept open.
+	PoolSize int `json:"pool_size"`
+
+	// Workers bounds concurrent journal operations.
+	Workers int `json:"workers"`
+
+	// ClockStart, if set, is an RFC 3339 time that the daemon's clock
+	// reads at startup, for replaying or testing traffic as of another
+	// time.  The clock then advances normally.
+	ClockStart string `json:"clock_start"`
+
+	// MaxReadPoints bounds the values a query may read from each
+	// journal.  Zero is unlimited.
+	MaxReadPoints int64 `json:"max_read_points"`
+
+	// Summarize keeps the minimum, maximum, and newest value of new
+	// numeric journals in their headers.
+	Summarize bool `json:"summarize"`
+
+	// BlockSummaries keeps summaries of each block of values of numeric
+	// journals in files beside them for aggregate queries.
+	BlockSummaries bool `json:"block_summaries"`
+
+	// MaxGap bounds the null values a write may fill after the last
+	// value of a journal.  Zero is unlimited.
+	MaxGap int64 `json:"max_gap"`
+
+	// MaxFuture, if positive, rejects writes more than this many
+	// intervals past the current time, or with ClampFuture writes the
+	// values up to the limit and discards the rest.
+	MaxFuture   int64 `json:"max_future"`
+	ClampFuture bool  `json:"clamp_future"`
+
+	// Tenants, if set, divides the store into the namespaces of the
+	// tenants it names, each with its own quota.  Clients that auth
+	// confines to a tenant reach only its namespace.
+	Tenants map[string]*store.Tenant `json:"tenants"`
+
+	// TrashTTL, if set, moves series deleted through the daemon, such
+	// as by replication, into the store's trash and purges them once
+	// they have been there this long.
+	TrashTTL Duration `json:"trash_ttl"`
+
+	// Alerts are threshold rules evaluated against every point received
+	// that trigger webhooks or log events.
+	Alerts []alert.Rule `json:"alerts"`
+
+	// Relay, if set, forwards received points to other journal servers
+	// rather than writing them locally.
+	Relay *RelayConfig `json:"relay"`
+
+	// Lease, if set, runs journald as one of several instances sharing
+	// the store of which only the holder of the lease is active.
+	Lease *LeaseConfig `json:"lease"`
+}
+
+// LeaseConfig describes the lease that elects the active instance.
+type LeaseConfig struct {
+	// Path is the lease file, on storage shared by every instance.
+	Path string `json:"path"`
+
+	// Holder identifies this instance, by default its host name and
+	// process ID.
+	Holder string `json:"holder"`
+
+	// TTL is how long the lease lasts without being renewed.
+	TTL Duration `json:"ttl"`
+}
+
+// RelayConfig describes the servers that a relay forwards points to.
+type RelayConfig struct {
+	// Nodes are the servers' Graphite addresses as host:port=instance,
+	// where the instance is optional.
+	Nodes []string `json:"nodes"`
+
+	// Hash is the cluster hash method: carbon_ch, fnv1a_ch, or
+	// jump_fnv1a_ch.
+	Hash string `json:"hash"`
+
+	// Replicas is the number of servers each point is sent to.
+	Replicas int `json:"replicas"`
+
+	// QueueSize bounds the points kept in memory for each server, and
+	// SpillDir, if set, keeps the points that overflow it on disk.
+	QueueSize int    `json:"queue_size"`
+	SpillDir  string `json:"spill_dir"`
+
+	// RetryInterval is the delay before reconnecting to a server.
+	RetryInterval Duration `json:"retry_interval"`
+
+	// Local also writes received points to the local store.
+	Local bool `json:"local"`
+}
+
+// Router returns the cluster router of the relay's nodes.
+func (c *RelayConfig) Router() (cluster.Router, error) {
+	nodes := make([]cluster.Node, len(c.Nodes))
+	for i, s := range c.Nodes {
+		n, err := cluster.ParseNode(s)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return cluster.New(c.Hash, nodes)
+}
+
+// Duration is a time.Duration that is written as a string such as "10s"
+// in the configuration file.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// Clock returns the clock described by ClockStart, or the system clock.
+func (c *Config) Clock() clock.Clock {
+	start, err := time.Parse(time.RFC3339, c.ClockStart)
+	if err != nil {
+		return clock.Wall
+	}
+	return clock.Offset(start)
+}
+
+// LoadConfig reads the configuration file at path and fills in defaults.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{
+		GraphiteTCP:    ":2003",
+		HTTP:           ":8080",
+		FlushInterval:  Duration{10 * time.Second},
+		MaxPending:     1000000,
+		RollupInterval: Duration{10 * time.Minute},
+		ScrubRate:      1 << 20,
+		ActiveWindow:   Duration{store.DefaultChurnWindow},
+		PoolSize:       1024,
+		Workers:        8,
+		MaxReadPoints:  1000000,
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	if err = json.NewDecoder(fd).Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if c.Root == "" {
+		return nil, fmt.Errorf("%s: root is required", path)
+	}
+	if c.Retentions == "" {
+		return nil, fmt.Errorf("%s: retentions is required", path)
+	}
+	if c.FlushInterval.Duration <= 0 {
+		return nil, fmt.Errorf("%s: flush_interval must be positive", path)
+	}
+	if c.ClockStart != "" {
+		if _, err := time.Parse(time.RFC3339, c.ClockStart); err != nil {
+			return nil, fmt.Errorf("%s: clock_start: %s", path, err)
+		}
+	}
+	if c.Auth != nil && c.Auth.Anonymous < auth.Write {
+		if c.GraphiteUDP != "" {
+			return nil, fmt.Errorf("%s: graphite_udp cannot authenticate clients", path)
+		}
+		if c.GraphiteTCP != "" && c.TLS == nil {
+			return nil, fmt.Errorf("%s: graphite_tcp needs tls to authenticate clients", path)
+		}
+	}
+	if c.Auth != nil && c.Tenants != nil {
+		for _, tenant := range c.authTenants() {
+			if _, ok := c.Tenants[tenant]; !ok {
+				return nil, fmt.Errorf("%s: auth: unknown tenant %q", path, tenant)
+			}
+		}
+	}
+	if c.Lease != nil && c.Lease.Path == "" {
+		return nil, fmt.Errorf("%s: lease: path is required", path)
+	}
+	if c.Relay != nil {
+		if c.Relay.Hash == "" {
+			c.Relay.Hash = "carbon_ch"
+		}
+		if c.Relay.Replicas <= 0 {
+			c.Relay.Replicas = 1
+		}
+		if c.Relay.QueueSize <= 0 {
+			c.Relay.QueueSize = cluster.DefaultQueueSize
+		}
+		if c.Relay.RetryInterval.Duration <= 0 {
+			c.Relay.RetryInterval.Duration = cluster.DefaultRetryInterval
+		}
+		if _, err := c.Relay.Router(); err != nil {
+			return nil, fmt.Errorf("%s: relay: %s", path, err)
+		}
+	}
+	return c, nil
+}
+
+// authTenants returns the tenants that Auth confines clients to.
+func (c *Config) authTenants() []string {
+	var tenants []string
+	for _, tenant := range c.Auth.TokenTenants {
+		tenants = append(tenants, tenant)
+	}
+	for _, tenant := range c.Auth.CertTenants {
+		tenants = append(tenants, tenant)
+	}
+	for _, user := range c.Auth.Users {
+		if user.Tenant != "" {
+			tenants = append(tenants, user.Tenant)
+		}
+	}
+	return tenants
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"expvar"
+	"net/http/pprof"
+)
+
+import (
+	"github.com/jjneely/journal/httpapi"
+)
+
+// publish registers the daemon's runtime statistics with expvar under
+// the "journald" key.
+func (d *daemon) publish() {
+	m := new(expvar.Map).Init()
+	m.Set("open_journals", expvar.Func(func() interface{} {
+		return d.pool.Len()
+	}))
+	m.Set("queue_depth", expvar.Func(func() interface{} {
+		return d.writer.Pending()
+	}))
+	m.Set("points_received", &d.writer.Received)
+	m.Set("points_written", &d.writer.Written)
+	m.Set("points_dropped", &d.writer.Dropped)
+	m.Set("write_latency", d.writer.Latency)
+	m.Set("series_created", &d.store.Churn.Created)
+	m.Set("series_stopped", &d.store.Churn.Stopped)
+	m.Set("series_active", expvar.Func(func() interface{} {
+		return d.store.Churn.Active()
+	}))
+	if d.store.Index != nil {
+		m.Set("series_total", expvar.Func(func() interface{} {
+			return d.store.Index.Len()
+		}))
+	}
+	if l := d.listener.Limiter; l != nil {
+		m.Set("points_rate_limited", &l.Limited)
+		m.Set("points_over_quota", &l.OverQuota)
+	}
+	if f := d.store.Options.Fence; f != nil {
+		m.Set("points_future_rejected", &f.Rejected)
+		m.Set("points_future_discarded", &f.Discarded)
+	}
+	if sc := d.scrubber; sc != nil {
+		m.Set("scrub_files", &sc.Files)
+		m.Set("scrub_bytes", &sc.Bytes)
+		m.Set("scrub_problems", &sc.Problems)
+		m.Set("scrub_repaired", &sc.Repaired)
+	}
+	if a := d.alerts; a != nil {
+		m.Set("alerts_fired", &a.Fired)
+		m.Set("alerts_firing", expvar.Func(func() interface{} {
+			return len(a.Firing())
+		}))
+		m.Set("webhooks_failed", &a.Failed)
+		m.Set("webhooks_dropped", &a.Dropped)
+	}
+	if h := d.hub; h != nil {
+		m.Set("subscribers", expvar.Func(func() interface{} {
+			return h.Subscribers()
+		}))
+		m.Set("subscribe_published", &h.Published)
+		m.Set("subscribe_dropped", &h.Dropped)
+	}
+	if d.relay != nil {
+		m.Set("relay", expvar.Func(d.relayStats))
+	}
+	expvar.Publish("journald", m)
+}
+
+// relayStats returns the queue, counters, and lag in seconds of each
+// relay destination.
+func (d *daemon) relayStats() interface{} {
+	stats := make(map[string]interface{})
+	for _, dest := range d.relay.Destinations() {
+		stats[dest.Node.String()] = map[string]interface{}{
+			"queued":      dest.Queued(),
+			"sent":        dest.Sent.Value(),
+			"spilled":     dest.Spilled.Value(),
+			"dropped":     dest.Dropped.Value(),
+			"lag_seconds": dest.Lag().Seconds(),
+		}
+	}
+	return stats
+}
+
+// debugHandlers mounts /debug/vars and /debug/pprof on the API server.
+func debugHandlers(srv *httpapi.Server) {
+	srv.Handle("/debug/vars", expvar.Handler())
+	srv.HandleFunc("/debug/pprof/", pprof.Index)
+	srv.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	srv.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	srv.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	srv.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/jjneely/journal/auth"
+	"github.com/jjneely/journal/httpapi"
+)
+
+// healthHandlers mounts /healthz, which checks that the store can be
+// written, and /readyz, which also checks that the write queue has room
+// and that buffered points are being written.  Neither requires
+// authentication.
+func (d *daemon) healthHandlers(srv *httpapi.Server) {
+	storage := httpapi.Check{Name: "store", Run: d.store.Check}
+	srv.HandleRole("/healthz", auth.None, httpapi.HealthHandler(storage))
+	srv.HandleRole("/readyz", auth.None, httpapi.HealthHandler(
+		storage,
+		httpapi.Check{Name: "queue", Run: d.writer.CheckQueue},
+		httpapi.Check{Name: "flush", Run: func() error { return d.writer.CheckFlush(d.started) }},
+	))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/lease"
+)
+
+// Lease returns the lease described by the config.
+func (c *LeaseConfig) Lease() *lease.Lease {
+	return &lease.Lease{Path: c.Path, Holder: c.Holder, TTL: c.TTL.Duration}
+}
+
+// acquireLease waits as a standby until the lease is acquired.  It
+// returns false if a signal other than SIGHUP arrives first.
+func acquireLease(l *lease.Lease, signals <-chan os.Signal) bool {
+	log.Printf("Waiting for lease %s", l.Path)
+	for {
+		ok, err := l.TryAcquire()
+		if ok {
+			log.Printf("Acquired lease %s as %s", l.Path, l.Holder)
+			return true
+		}
+		if err != nil {
+			log.Printf("Lease %s: %s", l.Path, err)
+		}
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				return false
+			}
+		case <-time.After(l.Interval()):
+		}
+	}
+}
+
+// keepLease renews the lease until the daemon stops.  If the lease is
+// lost the daemon exits at once, without writing its buffered points, so
+// that the instance taking over has the store to itself.
+func (d *daemon) keepLease(l *lease.Lease) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		if err := l.Keep(d.stop); err != nil {
+			log.Fatalf("Lost lease %s: %s", l.Path, err)
+		}
+	}()
+}
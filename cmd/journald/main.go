@@ -0,0 +1,563 @@
+// journald is a long running daemon that receives datapoints over the
+// Graphite line protocol, buffers and writes them to a journal store,
+// keeps rollup archives up to date, and answers queries over HTTP.
+// Runtime statistics are served at /debug/vars and profiles at
+// /debug/pprof if "debug" is true in the configuration, and health
+// checks at /healthz and /readyz.  Followers pull journals from
+// /replicate as described by package replication, and clients stream
+// points live as they are written from /subscribe as described by package
+// subscribe.  The "tls" and "auth" settings serve TLS and authenticate
+// clients as described by package auth.
+//
+// The "relay" setting forwards points to a cluster of journal servers,
+// each to as many servers as it has replicas, as described by package
+// cluster.  A scrubber slowly reads every journal looking for damage if
+// "scrub_interval" is set, and "index_interval" keeps an index of the
+// series so that finding them does not walk the store.  Rollup archives
+// are brought up to date every "rollup_interval", or as points are
+// written with "rollup_on_write".
+//
+// With "lease" set several instances can share a store on shared
+// storage: only the holder of the lease file, as described by package
+// lease, starts, and the others wait as standbys to take over when it
+// stops renewing the lease.  An instance that loses the lease exits at
+// once without writing its buffered points.
+//
+// SIGHUP reloads the retention configuration.  Listener addresses and
+// other settings require a restart.  SIGTERM or SIGINT stop the
+// listeners, write every buffered point, and exit.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/alert"
+	"github.com/jjneely/journal/auth"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/cluster"
+	"github.com/jjneely/journal/httpapi"
+	"github.com/jjneely/journal/ingest"
+	"github.com/jjneely/journal/lease"
+	"github.com/jjneely/journal/replication"
+	"github.com/jjneely/journal/retention"
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/scrub"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/subscribe"
+	"github.com/jjneely/journal/timeseries"
+	"github.com/jjneely/journal/writer"
+)
+
+// daemon holds the running components.
+type daemon struct {
+	config   *Config
+	clock    clock.Clock
+	started  time.Time
+	store    *store.Store
+	pool     *store.Pool
+	writer   *writer.Writer
+	listener *ingest.Listener
+	relay    *cluster.Relay
+	http     *http.Server
+	rollup   *rollup.Runner
+	scrubber *scrub.Scrubber
+	alerts   *alert.Engine
+	hub      *subscribe.Hub
+
+	mu        sync.RWMutex
+	retention *retention.Config
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func main() {
+	path := flag.String("config", "/etc/journald.json", "Configuration file")
+	flag.Parse()
+
+	config, err := LoadConfig(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+	var l *lease.Lease
+	if config.Lease != nil {
+		l = config.Lease.Lease()
+		if !acquireLease(l, signals) {
+			return
+		}
+	}
+	d, err := start(config)
+	if err != nil {
+		if l != nil {
+			l.Release()
+		}
+		log.Fatal(err)
+	}
+	if l != nil {
+		d.keepLease(l)
+	}
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if err := d.reload(); err != nil {
+				log.Printf("Reload failed: %s", err)
+			} else {
+				log.Printf("Reloaded %s", config.Retentions)
+			}
+			continue
+		}
+
+		log.Printf("Received %s, shutting down", sig)
+		d.shutdown()
+		if l != nil {
+			if err := l.Release(); err != nil {
+				log.Printf("Releasing lease %s: %s", l.Path, err)
+			}
+		}
+		return
+	}
+}
+
+// start creates and starts every component described by the config.
+func start(config *Config) (*daemon, error) {
+	d := &daemon{config: config, clock: config.Clock(), stop: make(chan struct{})}
+	d.started = d.clock.Now()
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+
+	d.store = store.New(config.Root)
+	d.store.Workers = config.Workers
+	d.store.Schema = d.schema
+	d.store.CarryForward = d.carryForward
+	d.store.AuditLog = config.AuditLog
+	d.store.AuditUser = "journald"
+	d.store.Tenants = config.Tenants
+	d.store.Trash = config.TrashTTL.Duration > 0
+	d.store.Churn = &store.Churn{Window: config.ActiveWindow.Duration, Clock: d.clock}
+	if config.SlowRead.Duration > 0 || config.SlowWrite.Duration > 0 || config.SlowQuery.Duration > 0 {
+		d.store.Slow = &store.SlowLog{
+			Read:  config.SlowRead.Duration,
+			Write: config.SlowWrite.Duration,
+			Query: config.SlowQuery.Duration,
+			Log: func(op store.SlowOp) {
+				log.Printf("Slow %s", op)
+			},
+		}
+	}
+	d.store.Options = &timeseries.Options{
+		MaxReadPoints:  config.MaxReadPoints,
+		MaxGap:         config.MaxGap,
+		Summarize:      config.Summarize,
+		BlockSummaries: config.BlockSummaries,
+		Clock:          d.clock,
+	}
+	if config.MaxFuture > 0 {
+		d.store.Options.Fence = &timeseries.Fence{MaxFuture: config.MaxFuture, Clamp: config.ClampFuture}
+	}
+	d.pool = store.NewPool(d.store, config.PoolSize)
+
+	d.writer = writer.New(d.store)
+	d.writer.FlushInterval = config.FlushInterval.Duration
+	d.writer.AlignFlush = config.FlushAligned
+	d.writer.SyncOnFlush = config.SyncOnFlush
+	d.writer.Clock = d.clock
+	d.writer.MaxPending = config.MaxPending
+	d.writer.Workers = config.Workers
+	d.writer.OnError = func(series string, err error) {
+		log.Printf("Write %s: %s", series, err)
+	}
+	if config.RollupOnWrite {
+		p := &rollup.Propagator{Store: d.store, Policy: d.policy}
+		d.writer.Propagate = p.Propagate
+	}
+	d.writer.Start()
+	d.store.Pending = d.writer.Buffered
+
+	var tlsConfig *tls.Config
+	if config.TLS != nil {
+		var err error
+		if tlsConfig, err = config.TLS.Config(); err != nil {
+			return nil, err
+		}
+	}
+
+	sink := ingest.Sink(d.writer.Add)
+	if config.Relay != nil {
+		if err := d.startRelay(); err != nil {
+			return nil, err
+		}
+		sink = d.relay.Add
+		if config.Relay.Local {
+			sink = func(p ingest.Point) error {
+				err := d.relay.Add(p)
+				if e := d.writer.Add(p); e != nil {
+					err = e
+				}
+				return err
+			}
+		}
+	}
+
+	if len(config.Alerts) > 0 {
+		var err error
+		if d.alerts, err = alert.NewEngine(config.Alerts); err != nil {
+			return nil, err
+		}
+		d.alerts.OnEvent = func(e alert.Event) {
+			log.Printf("Alert %s", e)
+		}
+		written := sink
+		sink = func(p ingest.Point) error {
+			err := written(p)
+			if err == nil {
+				d.alerts.Observe(p)
+			}
+			return err
+		}
+	}
+
+	if config.HTTP != "" {
+		d.hub = subscribe.NewHub()
+		written := sink
+		sink = func(p ingest.Point) error {
+			err := written(p)
+			if err == nil {
+				d.hub.Publish(p)
+			}
+			return err
+		}
+	}
+
+	d.listener = &ingest.Listener{
+		Sink: sink,
+		OnError: func(err error) {
+			log.Printf("Ingest: %s", err)
+		},
+		TLS: tlsConfig,
+	}
+	if config.IngestRate > 0 || config.MaxNewSeries > 0 {
+		d.listener.Limiter = &ingest.Limiter{
+			Rate:      config.IngestRate,
+			Burst:     config.IngestBurst,
+			MaxSeries: config.MaxNewSeries,
+			Clock:     d.clock,
+			Exists: func(series string) bool {
+				path, err := d.store.Path(series)
+				if err != nil {
+					return false
+				}
+				_, err = os.Stat(path)
+				return err == nil
+			},
+		}
+	}
+	if config.Auth != nil {
+		d.listener.Authorize = func(cs tls.ConnectionState) bool {
+			return max(config.Auth.Anonymous, config.Auth.CertRole(cs)) >= auth.Write
+		}
+		d.listener.Tenant = config.Auth.CertTenant
+	}
+	if config.GraphiteTCP != "" {
+		if err := d.listener.ListenTCP(config.GraphiteTCP); err != nil {
+			return nil, err
+		}
+	}
+	if config.GraphiteUDP != "" {
+		if err := d.listener.ListenUDP(config.GraphiteUDP); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.HTTP != "" {
+		api := httpapi.New(d.store)
+		api.Auth = config.Auth
+		api.Clock = d.clock
+		d.healthHandlers(api)
+		api.HandleRole("/replicate", auth.Read, &replication.Leader{Store: d.store})
+		api.HandleRole("/subscribe", auth.Read, d.hub)
+		if config.Debug {
+			debugHandlers(api)
+		}
+		d.http = &http.Server{
+			Addr:      config.HTTP,
+			Handler:   api,
+			TLSConfig: tlsConfig,
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			var err error
+			if tlsConfig != nil {
+				err = d.http.ListenAndServeTLS("", "")
+			} else {
+				err = d.http.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Printf("HTTP: %s", err)
+			}
+		}()
+	}
+
+	d.wg.Add(1)
+	go d.sweeps()
+
+	if config.TrashTTL.Duration > 0 {
+		d.wg.Add(1)
+		go d.purges()
+	}
+
+	if config.IndexInterval.Duration > 0 {
+		if err := d.loadIndex(); err != nil {
+			return nil, err
+		}
+		d.wg.Add(1)
+		go d.indexes()
+	}
+
+	if config.RollupInterval.Duration > 0 && !config.RollupOnWrite {
+		d.rollup = &rollup.Runner{
+			Store:   d.store,
+			Policy:  d.policy,
+			Workers: config.Workers,
+			OnError: func(series string, err error) {
+				log.Printf("Rollup %s: %s", series, err)
+			},
+			Clock: d.clock,
+		}
+		d.wg.Add(1)
+		go d.rollups()
+	}
+
+	if config.ScrubInterval.Duration > 0 {
+		d.scrubber = &scrub.Scrubber{
+			Store:  d.store,
+			Rate:   config.ScrubRate,
+			Repair: config.ScrubRepair,
+			Clock:  d.clock,
+			OnProblem: func(p scrub.Problem) {
+				log.Printf("Scrub %s", p)
+			},
+		}
+		d.wg.Add(1)
+		go d.scrubs()
+	}
+
+	d.publish()
+	return d, nil
+}
+
+// startRelay starts forwarding points to the relay's nodes.
+func (d *daemon) startRelay() error {
+	c := d.config.Relay
+	router, err := c.Router()
+	if err != nil {
+		return err
+	}
+	d.relay = cluster.NewRelay(router)
+	d.relay.Replicas = c.Replicas
+	d.relay.QueueSize = c.QueueSize
+	d.relay.SpillDir = c.SpillDir
+	d.relay.RetryInterval = c.RetryInterval.Duration
+	d.relay.Clock = d.clock
+	d.relay.OnError = func(node cluster.Node, err error) {
+		log.Printf("Relay %s: %s", node, err)
+	}
+	return d.relay.Start()
+}
+
+// reload reads the retention configuration.
+func (d *daemon) reload() error {
+	r, err := retention.Load(d.config.Retentions)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.retention = r
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *daemon) schema(series string) (int64, ValueType, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.retention.Schema(series)
+}
+
+func (d *daemon) carryForward(series string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.retention.CarryForward(series)
+}
+
+func (d *daemon) policy(series string) *rollup.Policy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.retention.Policy(series)
+}
+
+// rollups runs rollup passes until shutdown.
+func (d *daemon) rollups() {
+	defer d.wg.Done()
+	timer := time.NewTimer(d.config.RollupInterval.Duration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := d.rollup.Run(); err != nil {
+				log.Printf("Rollup: %s", err)
+			}
+			timer.Reset(d.config.RollupInterval.Duration)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// sweeps counts the series that stopped receiving data until shutdown.
+func (d *daemon) sweeps() {
+	defer d.wg.Done()
+	interval := time.Minute
+	if w := d.config.ActiveWindow.Duration; w > 0 && w < interval {
+		interval = w
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if stopped := d.store.Churn.Sweep(); len(stopped) > 0 {
+				log.Printf("%d series stopped receiving data, including %s",
+					len(stopped), stopped[0])
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// purges removes series that have been in the trash for longer than the
+// TrashTTL until shutdown.
+func (d *daemon) purges() {
+	defer d.wg.Done()
+	interval := min(time.Hour, d.config.TrashTTL.Duration)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			before := d.clock.Now().Add(-d.config.TrashTTL.Duration)
+			purged, err := d.store.PurgeTrash(before)
+			if len(purged) > 0 {
+				log.Printf("Purged %d series from the trash", len(purged))
+			}
+			if err != nil {
+				log.Printf("Trash: %s", err)
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// loadIndex gives the store the index saved by the last run, or builds
+// one if there is none.
+func (d *daemon) loadIndex() error {
+	path := filepath.Join(d.config.Root, store.SeriesIndexFile)
+	index, err := store.LoadSeriesIndex(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Series index: %s", err)
+		}
+		if index, err = d.store.BuildIndex(); err != nil {
+			return err
+		}
+	}
+	log.Printf("Indexed %d series", index.Len())
+	d.store.Index = index
+	return nil
+}
+
+// indexes rebuilds and saves the series index until shutdown.
+func (d *daemon) indexes() {
+	defer d.wg.Done()
+	path := filepath.Join(d.config.Root, store.SeriesIndexFile)
+	timer := time.NewTimer(d.config.IndexInterval.Duration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if index, err := d.store.BuildIndex(); err != nil {
+				log.Printf("Series index: %s", err)
+			} else {
+				d.store.Index.Replace(index)
+			}
+			if err := d.store.Index.Save(path); err != nil {
+				log.Printf("Series index: %s", err)
+			}
+			timer.Reset(d.config.IndexInterval.Duration)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// scrubs runs scrub passes until shutdown.
+func (d *daemon) scrubs() {
+	defer d.wg.Done()
+	timer := time.NewTimer(d.config.ScrubInterval.Duration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := d.scrubber.Run(d.stop); err != nil {
+				log.Printf("Scrub: %s", err)
+			}
+			timer.Reset(d.config.ScrubInterval.Duration)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// shutdown stops accepting data, drains the write queue, and closes
+// every journal.
+func (d *daemon) shutdown() {
+	d.listener.Close()
+	close(d.stop)
+	if d.http != nil {
+		d.hub.Close()
+		d.http.Close()
+	}
+	d.wg.Wait()
+
+	if d.alerts != nil {
+		d.alerts.Close()
+	}
+	if d.relay != nil {
+		d.relay.Close()
+	}
+	log.Printf("Writing %d buffered points", d.writer.Pending())
+	d.writer.Close()
+	d.pool.Close()
+	if d.store.Index != nil {
+		if err := d.store.Index.Save(filepath.Join(d.config.Root, store.SeriesIndexFile)); err != nil {
+			log.Printf("Series index: %s", err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package journal
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// decodeFloat64 converts little endian encoded float64 values.  When
+// built with the journal_zerocopy tag on a little endian machine an
+// aligned buffer is reinterpreted in place, so the result shares storage
+// with buffer.
+func decodeFloat64(buffer []byte) []float64 {
+	if floats := castFloat64(buffer); floats != nil {
+		return floats
+	}
+	floats := make([]float64, len(buffer)/8)
+	for i := range floats {
+		floats[i] = math.Float64frombits(binary.LittleEndian.Uint64(buffer[i*8:]))
+	}
+	return floats
+}
+
+// decodeInt64 is decodeFloat64 for int64 values.
+func decodeInt64(buffer []byte) []int64 {
+	if ints := castInt64(buffer); ints != nil {
+		return ints
+	}
+	ints := make([]int64, len(buffer)/8)
+	for i := range ints {
+		ints[i] = int64(binary.LittleEndian.Uint64(buffer[i*8:]))
+	}
+	return ints
+}
@@ -0,0 +1,12 @@
+//go:build !journal_zerocopy || !(386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm)
+// +build !journal_zerocopy !386,!amd64,!arm,!arm64,!loong64,!mips64le,!mipsle,!ppc64le,!riscv64,!wasm
+
+package journal
+
+// ZeroCopy reports whether Decode may return values that share storage
+// with the decoded buffer.
+const ZeroCopy = false
+
+func castFloat64(b []byte) []float64 { return nil }
+
+func castInt64(b []byte) []int64 { return nil }
@@ -0,0 +1,77 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	floats := Float64Values{1.5, math.Inf(-1), 0, -3}
+	ints := Int64Values{1, math.MinInt64, 0, -3}
+	for _, values := range []Values{floats, ints} {
+		raw := values.Encode()
+		var decoded Values
+		switch values.(type) {
+		case Float64Values:
+			decoded = NewFloat64ValueType().Decode(raw)
+		case Int64Values:
+			decoded = NewInt64ValueType().Decode(raw)
+		}
+		if decoded.Len() != values.Len() {
+			t.Fatalf("Decoded %d of %d values", decoded.Len(), values.Len())
+		}
+		for i := 0; i < values.Len(); i++ {
+			if string(decoded.Slice(i, i+1).Encode()) != string(values.Slice(i, i+1).Encode()) {
+				t.Errorf("%T value %d decoded wrongly", values, i)
+			}
+		}
+
+		// Unaligned buffers always take the copying path
+		unaligned := append([]byte{0}, raw...)[1:]
+		if got := NewInt64ValueType().Decode(unaligned).Len(); got != values.Len() {
+			t.Errorf("Unaligned decode returned %d values", got)
+		}
+	}
+}
+
+func benchmarkBuffer(n int) []byte {
+	values := make(Float64Values, n)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	return values.Encode()
+}
+
+// BenchmarkFloat64Decode measures Decode, which reinterprets the buffer
+// when built with -tags journal_zerocopy.
+func BenchmarkFloat64Decode(b *testing.B) {
+	buf := benchmarkBuffer(4096)
+	factory := NewFloat64ValueType()
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		factory.Decode(buf)
+	}
+}
+
+// BenchmarkInt64Decode is BenchmarkFloat64Decode for Int64ValueType.
+func BenchmarkInt64Decode(b *testing.B) {
+	buf := benchmarkBuffer(4096)
+	factory := NewInt64ValueType()
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		factory.Decode(buf)
+	}
+}
+
+// BenchmarkFloat64DecodeBinaryRead is the bytes.Buffer and binary.Read
+// decode that Decode used to use, for comparison.
+func BenchmarkFloat64DecodeBinaryRead(b *testing.B) {
+	buf := benchmarkBuffer(4096)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		floats := make([]float64, len(buf)/8)
+		binary.Read(bytes.NewBuffer(buf), binary.LittleEndian, floats)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build journal_zerocopy && (386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64 || wasm)
+// +build journal_zerocopy
+// +build 386 amd64 arm arm64 loong64 mips64le mipsle ppc64le riscv64 wasm
+
+package journal
+
+import (
+	"unsafe"
+)
+
+// ZeroCopy reports whether Decode may return values that share storage
+// with the decoded buffer.
+const ZeroCopy = true
+
+// castFloat64 reinterprets an 8 byte aligned buffer as float64s, or
+// returns nil if it is not aligned.
+func castFloat64(b []byte) []float64 {
+	if len(b) < 8 || uintptr(unsafe.Pointer(&b[0]))%8 != 0 {
+		return nil
+	}
+	return unsafe.Slice((*float64)(unsafe.Pointer(&b[0])), len(b)/8)
+}
+
+// castInt64 is castFloat64 for int64s.
+func castInt64(b []byte) []int64 {
+	if len(b) < 8 || uintptr(unsafe.Pointer(&b[0]))%8 != 0 {
+		return nil
+	}
+	return unsafe.Slice((*int64)(unsafe.Pointer(&b[0])), len(b)/8)
+}
@@ -0,0 +1,158 @@
+// Package export converts ranges of journals in a store into columnar
+// batches of (series, timestamp, value) rows for analysis in tools such
+// as Spark or DuckDB.
+//
+// Batches are shaped like Apache Arrow record batches so that a
+// BatchWriter built on the Arrow or Parquet libraries can consume them
+// directly.  This repository does not depend on those libraries and
+// provides only a CSV BatchWriter, which both tools read natively.
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+import (
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/store"
+)
+
+// DefaultBatchSize is the default number of rows in a Batch.
+const DefaultBatchSize = 65536
+
+// Batch holds rows as equal length columns.  Null values are NaN.
+type Batch struct {
+	Series     []string
+	Timestamps []int64
+	Values     []float64
+}
+
+// Len returns the number of rows in the batch.
+func (b *Batch) Len() int {
+	return len(b.Timestamps)
+}
+
+func (b *Batch) reset() {
+	b.Series = b.Series[:0]
+	b.Timestamps = b.Timestamps[:0]
+	b.Values = b.Values[:0]
+}
+
+// BatchWriter consumes batches.  The batch is reused after WriteBatch
+// returns.
+type BatchWriter interface {
+	WriteBatch(b *Batch) error
+	Close() error
+}
+
+// Exporter reads series from a store in batches.
+type Exporter struct {
+	Store *store.Store
+
+	// BatchSize is the number of rows in each batch but the last.  Zero
+	// uses DefaultBatchSize.
+	BatchSize int
+
+	// SkipNulls leaves null values out of the batches.
+	SkipNulls bool
+}
+
+// Export writes the values of each series from through until inclusive
+// to w in time order, one series after another, and returns the number
+// of rows written.  It does not close w.  Series without a journal are
+// skipped.
+func (e *Exporter) Export(w BatchWriter, series []string, from, until int64) (int64, error) {
+	size := e.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	b := &Batch{
+		Series:     make([]string, 0, size),
+		Timestamps: make([]int64, 0, size),
+		Values:     make([]float64, 0, size),
+	}
+	rows := int64(0)
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+		rows += int64(b.Len())
+		err := w.WriteBatch(b)
+		b.reset()
+		return err
+	}
+
+	for _, name := range series {
+		r := e.Store.ReadMany([]string{name}, from, until)[name]
+		if r.Err != nil {
+			if os.IsNotExist(r.Err) {
+				continue
+			}
+			return rows, r.Err
+		}
+		floats, err := rollup.Floats(r.Values)
+		if err != nil {
+			return rows, err
+		}
+		for i, v := range floats {
+			if e.SkipNulls && math.IsNaN(v) {
+				continue
+			}
+			b.Series = append(b.Series, name)
+			b.Timestamps = append(b.Timestamps, r.Start+int64(i)*r.Interval)
+			b.Values = append(b.Values, v)
+			if b.Len() == size {
+				if err = flush(); err != nil {
+					return rows, err
+				}
+			}
+		}
+	}
+	return rows, flush()
+}
+
+// CSVWriter writes batches as CSV with a header row of series, timestamp,
+// and value.  Nulls are empty fields.
+type CSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVWriter returns a CSVWriter writing to w.
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w)}
+}
+
+// WriteBatch implements BatchWriter.
+func (c *CSVWriter) WriteBatch(b *Batch) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write([]string{"series", "timestamp", "value"}); err != nil {
+			return err
+		}
+	}
+	row := make([]string, 3)
+	for i := range b.Timestamps {
+		row[0] = b.Series[i]
+		row[1] = strconv.FormatInt(b.Timestamps[i], 10)
+		row[2] = ""
+		if !math.IsNaN(b.Values[i]) {
+			row[2] = strconv.FormatFloat(b.Values[i], 'g', -1, 64)
+		}
+		if err := c.w.Write(row); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Close flushes buffered rows.  It does not close the underlying writer.
+func (c *CSVWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
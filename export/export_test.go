@@ -0,0 +1,61 @@
+package export
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/store"
+)
+
+const epoch = int64(1449240540)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.New(dir)
+	j, _ := s.Create("a.b", 60, NewFloat64ValueType(), nil)
+	j.Write(epoch, Float64Values{1.5, math.NaN(), 3})
+	j.Close()
+	j, _ = s.Create("a.c", 60, NewInt64ValueType(), nil)
+	j.Write(epoch, Int64Values{7})
+	j.Close()
+
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf)
+	e := &Exporter{Store: s, BatchSize: 2}
+	rows, err := e.Export(w, []string{"a.b", "a.missing", "a.c"}, 0, epoch+600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	want := "series,timestamp,value\n" +
+		"a.b,1449240540,1.5\n" +
+		"a.b,1449240600,\n" +
+		"a.b,1449240660,3\n" +
+		"a.c,1449240540,7\n"
+	if rows != 4 || buf.String() != want {
+		t.Errorf("Exported %d rows:\n%s", rows, buf.String())
+	}
+
+	e.SkipNulls = true
+	var b collect
+	if rows, _ = e.Export(&b, []string{"a.b"}, 0, epoch+600); rows != 2 || b.batches != 1 {
+		t.Errorf("Exported %d rows without nulls in %d batches", rows, b.batches)
+	}
+}
+
+type collect struct {
+	batches int
+}
+
+func (c *collect) WriteBatch(b *Batch) error { c.batches++; return nil }
+func (c *collect) Close() error              { return nil }
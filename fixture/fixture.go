@@ -0,0 +1,204 @@
+// Package fixture generates reproducible journals of synthetic data, such
+// as sine waves and counters with gaps and spikes, for tests and
+// benchmarks.  The same Fixture and Seed always produce the same values.
+//
+//	f := &fixture.Fixture{
+//		Shape:    fixture.Counter(5, 1000),
+//		Start:    1449240540,
+//		Interval: 60,
+//		Points:   10080,
+//		Seed:     1,
+//		GapRate:  0.01,
+//	}
+//	j, err := f.Create("/tmp/counter.tsj", journal.NewInt64ValueType())
+package fixture
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Shape returns the value of the i'th point.  It may draw from r, which is
+// seeded by the Fixture.
+type Shape func(i int, r *rand.Rand) float64
+
+// Constant is always v.
+func Constant(v float64) Shape {
+	return func(i int, r *rand.Rand) float64 {
+		return v
+	}
+}
+
+// Sine oscillates between -amplitude and amplitude, completing a cycle
+// every period points.
+func Sine(amplitude float64, period int) Shape {
+	return func(i int, r *rand.Rand) float64 {
+		return amplitude * math.Sin(2*math.Pi*float64(i)/float64(period))
+	}
+}
+
+// Counter increases by a random amount averaging rate each point and
+// resets to zero every resetEvery points, or never if resetEvery is not
+// positive.
+func Counter(rate float64, resetEvery int) Shape {
+	total, next := 0.0, 0
+	return func(i int, r *rand.Rand) float64 {
+		if i < next || (resetEvery > 0 && i%resetEvery == 0) {
+			total = 0
+		}
+		next = i + 1
+		total += math.Floor(r.Float64() * 2 * rate)
+		return total
+	}
+}
+
+// Walk is a random walk starting at zero that moves up to step each
+// point.
+func Walk(step float64) Shape {
+	v, next := 0.0, 0
+	return func(i int, r *rand.Rand) float64 {
+		if i < next {
+			v = 0
+		}
+		next = i + 1
+		v += (r.Float64()*2 - 1) * step
+		return v
+	}
+}
+
+// ParseShape parses a shape such as "sine:10:1440", "counter:5:1000",
+// "walk:1", or "constant:3", where the numbers are the arguments of the
+// functions of the same name.  Missing arguments default to 1, and to no
+// resets for counters.
+func ParseShape(s string) (Shape, error) {
+	fields := strings.Split(s, ":")
+	args := make([]float64, len(fields)-1)
+	for i, f := range fields[1:] {
+		var err error
+		if args[i], err = strconv.ParseFloat(f, 64); err != nil {
+			return nil, fmt.Errorf("Invalid shape argument: %q", s)
+		}
+	}
+	arg := func(i int, def float64) float64 {
+		if i < len(args) {
+			return args[i]
+		}
+		return def
+	}
+
+	switch fields[0] {
+	case "constant":
+		return Constant(arg(0, 1)), nil
+	case "sine":
+		if arg(1, 1) < 1 {
+			return nil, fmt.Errorf("Sine period must be at least 1: %q", s)
+		}
+		return Sine(arg(0, 1), int(arg(1, 1))), nil
+	case "counter":
+		return Counter(arg(0, 1), int(arg(1, 0))), nil
+	case "walk":
+		return Walk(arg(0, 1)), nil
+	}
+	return nil, fmt.Errorf("Unknown shape: %q", s)
+}
+
+// Fixture describes a series of synthetic values.
+type Fixture struct {
+	Shape    Shape
+	Start    int64 // timestamp of the first point
+	Interval int64
+	Points   int
+	Seed     int64
+
+	// GapRate is the chance that each point starts a run of GapLength
+	// nulls, or one null if GapLength is not positive.
+	GapRate   float64
+	GapLength int
+
+	// SpikeRate is the chance that each point is multiplied by
+	// SpikeSize.
+	SpikeRate float64
+	SpikeSize float64
+}
+
+// Values generates the values of the Fixture.  Nulls are NaN.
+func (f *Fixture) Values() []float64 {
+	// Separate sources keep the shape independent of gaps and spikes
+	shape := rand.New(rand.NewSource(f.Seed))
+	noise := rand.New(rand.NewSource(f.Seed + 1))
+	gapLength := max(f.GapLength, 1)
+
+	values := make([]float64, f.Points)
+	gap := 0
+	for i := range values {
+		values[i] = f.Shape(i, shape)
+		if noise.Float64() < f.SpikeRate {
+			values[i] *= f.SpikeSize
+		}
+		if gap == 0 && noise.Float64() < f.GapRate {
+			gap = gapLength
+		}
+		if gap > 0 {
+			values[i] = math.NaN()
+			gap--
+		}
+	}
+	return values
+}
+
+// Create creates a journal at path of the given type holding the values
+// of the Fixture.  Int64 journals hold the values rounded to the nearest
+// integer.
+func (f *Fixture) Create(path string, factory ValueType) (*timeseries.FileJournal, error) {
+	if f.Interval <= 0 || f.Start <= 0 {
+		return nil, fmt.Errorf("Fixture needs a positive start and interval")
+	}
+	values, err := encode(f.Values(), factory)
+	if err != nil {
+		return nil, err
+	}
+	j, err := timeseries.Create(path, f.Interval, factory, nil)
+	if err != nil {
+		return nil, err
+	}
+	if values.Len() > 0 {
+		if err = j.Write(f.Start, values); err != nil {
+			j.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// encode converts floats to Values of the given numeric type.
+func encode(floats []float64, factory ValueType) (Values, error) {
+	switch factory.(type) {
+	case *Float64ValueType:
+		return Float64Values(floats), nil
+	case *Float32ValueType:
+		values := make(Float32Values, len(floats))
+		for i, v := range floats {
+			values[i] = float32(v)
+		}
+		return values, nil
+	case *Int64ValueType:
+		values := make(Int64Values, len(floats))
+		for i, v := range floats {
+			if math.IsNaN(v) {
+				values[i] = math.MinInt64
+			} else {
+				values[i] = int64(math.Round(v))
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("Cannot generate values of journal type %#x", factory.Type())
+}
@@ -0,0 +1,78 @@
+package fixture
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+func TestShapes(t *testing.T) {
+	f := &Fixture{Shape: Sine(2, 4), Points: 5}
+	if v := fmt.Sprintf("%.0f", f.Values()); v != "[0 2 0 -2 -0]" {
+		t.Errorf("Sine generated %s", v)
+	}
+
+	f = &Fixture{Shape: Counter(3, 4), Points: 8, Seed: 7}
+	values := f.Values()
+	for i := 1; i < len(values); i++ {
+		if i%4 != 0 && values[i] < values[i-1] {
+			t.Errorf("Counter decreased without a reset: %v", values)
+		}
+	}
+	if fmt.Sprint(f.Values()) != fmt.Sprint(values) {
+		t.Errorf("Counter is not reproducible: %v then %v", values, f.Values())
+	}
+
+	for _, s := range []string{"constant:3", "sine:1:60", "counter:5:100", "walk", "walk:0.5"} {
+		if _, err := ParseShape(s); err != nil {
+			t.Errorf("ParseShape(%q): %s", s, err)
+		}
+	}
+	for _, s := range []string{"square", "sine:1:0", "walk:x"} {
+		if _, err := ParseShape(s); err == nil {
+			t.Errorf("ParseShape(%q) succeeded", s)
+		}
+	}
+}
+
+func TestGapsAndSpikes(t *testing.T) {
+	f := &Fixture{Shape: Constant(1), Points: 1000, Seed: 1,
+		GapRate: 0.05, GapLength: 3, SpikeRate: 0.05, SpikeSize: 100}
+	values := f.Values()
+	nulls, spikes := 0, 0
+	for _, v := range values {
+		switch {
+		case math.IsNaN(v):
+			nulls++
+		case v == 100:
+			spikes++
+		}
+	}
+	if nulls < 50 || nulls > 300 || spikes < 10 || spikes > 100 {
+		t.Errorf("Generated %d nulls and %d spikes", nulls, spikes)
+	}
+	if fmt.Sprint(f.Values()) != fmt.Sprint(values) {
+		t.Errorf("Gaps and spikes are not reproducible")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	path := "/tmp/test-fixture.tsj"
+	os.Remove(path)
+	defer os.Remove(path)
+	f := &Fixture{Shape: Sine(10, 4), Start: 1449240540, Interval: 60, Points: 4}
+	j, err := f.Create(path, NewInt64ValueType())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	v, err := j.ReadRange(1449240540, 1449240720)
+	if err != nil || fmt.Sprint(v) != "[0 10 0 -10]" {
+		t.Errorf("Created journal holds %v, %v", v, err)
+	}
+}
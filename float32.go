@@ -0,0 +1,102 @@
+package journal
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+var (
+	_ ValueType = (*Float32ValueType)(nil)
+	_ Values    = Float32Values(nil)
+)
+
+// Float32ValueType implements ValueType and defines the characteristics
+// of dealing with marshaling float32 values.  Float32 values are stored
+// on disk with Little Endian encoding and use half the space of float64
+// values for series that do not need the precision.
+type Float32ValueType struct {
+	null []byte
+}
+
+// NewFloat32ValueType is a constructor for a new Float32ValueType factory
+// and is equivalent to new(Float32ValueType).
+func NewFloat32ValueType() *Float32ValueType {
+	return &Float32ValueType{}
+}
+
+// Width is always 4 bytes for Float32 values.
+func (t *Float32ValueType) Width() int32 {
+	return 4
+}
+
+// Type returns the type encoding as stored on disk
+func (t *Float32ValueType) Type() int32 {
+	return 0x12
+}
+
+// Null returns the 4 byte encoding of the IEEE floating point NaN.
+func (t *Float32ValueType) Null() []byte {
+	if t.null == nil {
+		t.null = binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(math.NaN())))
+	}
+
+	return t.null
+}
+
+// Decode takes a byte slice presumably read from disk and decodes into
+// a slice of float32 using Little Endian encoding.
+func (t *Float32ValueType) Decode(buffer []byte) Values {
+	return Float32Values(decodeFloat32(buffer))
+}
+
+// DecodeStream reads and decodes n float32s from r.
+func (t *Float32ValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(Float32Values, 0, n)
+	err := readChunks(r, n, t.Width(), func(chunk []byte) {
+		values = append(values, decodeFloat32(chunk)...)
+	})
+	return values, err
+}
+
+func decodeFloat32(buffer []byte) []float32 {
+	floats := make([]float32, len(buffer)/4)
+	for i := range floats {
+		floats[i] = math.Float32frombits(binary.LittleEndian.Uint32(buffer[i*4:]))
+	}
+	return floats
+}
+
+// Float32Values implements Values and wraps a float32 slice.
+type Float32Values []float32
+
+// Encode will encode (Little Endian) the float32 slice to a byte slice for
+// writing to disk.
+func (v Float32Values) Encode() []byte {
+	return v.EncodeTo(make([]byte, 0, len(v)*4))
+}
+
+// EncodeTo appends the Little Endian encoding of the float32 slice to dst.
+func (v Float32Values) EncodeTo(dst []byte) []byte {
+	for _, f := range v {
+		dst = binary.LittleEndian.AppendUint32(dst, math.Float32bits(f))
+	}
+	return dst
+}
+
+// WriteTo writes the Little Endian encoding of the float32 slice to w.
+func (v Float32Values) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
+}
+
+// Len returns the length of the float32 slice.
+func (v Float32Values) Len() int {
+	return len(v)
+}
+
+// Slice returns the float32 slice from index i up to j without copying.
+func (v Float32Values) Slice(i, j int) Values {
+	return v[i:j]
+}
@@ -3,9 +3,15 @@ package journal
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"math"
 )
 
+var (
+	_ ValueType = (*Float64ValueType)(nil)
+	_ Values    = Float64Values(nil)
+)
+
 // Float64ValueType implements ValueType and defines the characteristics
 // of dealing with marshaling float64 values.  Float64 values are stored
 // on disk with Little Endian encoding.
@@ -41,15 +47,19 @@ func (t *Float64ValueType) Null() []byte {
 }
 
 // Decode takes a byte slice presumably read from disk and decodes into
-// a slice of float64 using Little Endian encoding.
+// a slice of float64 using Little Endian encoding.  If ZeroCopy is set the
+// result may share storage with buffer.
 func (t *Float64ValueType) Decode(buffer []byte) Values {
-	floats := make([]float64, int32(len(buffer))/t.Width())
-	buf := bytes.NewBuffer(buffer)
-	err := binary.Read(buf, binary.LittleEndian, floats)
-	if err != nil {
-		return nil
-	}
-	return Float64Values(floats)
+	return Float64Values(decodeFloat64(buffer))
+}
+
+// DecodeStream reads and decodes n float64s from r.
+func (t *Float64ValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(Float64Values, 0, n)
+	err := readChunks(r, n, t.Width(), func(chunk []byte) {
+		values = append(values, decodeFloat64(chunk)...)
+	})
+	return values, err
 }
 
 // Float64Values implements Values and wraps a float64 slice.
@@ -58,15 +68,30 @@ type Float64Values []float64
 // Encode will encode (Little Endian) the float64 slice to a byte slice for
 // writing to disk.
 func (v Float64Values) Encode() []byte {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, []float64(v))
-	if err != nil {
-		return nil
+	return v.EncodeTo(make([]byte, 0, len(v)*8))
+}
+
+// EncodeTo appends the Little Endian encoding of the float64 slice to dst.
+func (v Float64Values) EncodeTo(dst []byte) []byte {
+	for _, f := range v {
+		dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(f))
 	}
-	return buf.Bytes()
+	return dst
+}
+
+// WriteTo writes the Little Endian encoding of the float64 slice to w.
+func (v Float64Values) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
 }
 
 // Len returns the length of the float64 slice.
 func (v Float64Values) Len() int {
 	return len(v)
 }
+
+// Slice returns the float64 slice from index i up to j without copying.
+func (v Float64Values) Slice(i, j int) Values {
+	return v[i:j]
+}
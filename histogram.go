@@ -0,0 +1,256 @@
+package journal
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+var (
+	_ ValueType = (*HistogramValueType)(nil)
+	_ Values    = HistogramValues(nil)
+)
+
+// HistogramValueType implements ValueType for histograms of a fixed
+// number of uint32 bucket counts, such as the latencies observed during
+// each interval.  What each bucket counts is described by Buckets, which
+// the application keeps alongside the journal.  Counts are stored on disk
+// with Little Endian encoding and a histogram with every count
+// math.MaxUint32 is null.
+type HistogramValueType struct {
+	buckets int
+	null    []byte
+}
+
+// NewHistogramValueType returns a HistogramValueType for histograms of
+// the given number of buckets.
+func NewHistogramValueType(buckets int) *HistogramValueType {
+	return &HistogramValueType{buckets: buckets}
+}
+
+// Width is 4 bytes for each bucket.
+func (t *HistogramValueType) Width() int32 {
+	return int32(4 * t.buckets)
+}
+
+// Type returns the type encoding as stored on disk
+func (t *HistogramValueType) Type() int32 {
+	return 0x20
+}
+
+// Null returns the encoding of a histogram with every count
+// math.MaxUint32.
+func (t *HistogramValueType) Null() []byte {
+	if t.null == nil {
+		t.null = NullHistogram(t.buckets).EncodeTo(nil)
+	}
+	return t.null
+}
+
+// Decode takes a byte slice presumably read from disk and decodes it into
+// a slice of histograms.
+func (t *HistogramValueType) Decode(buffer []byte) Values {
+	return HistogramValues(t.decode(buffer))
+}
+
+// DecodeStream reads and decodes n histograms from r.
+func (t *HistogramValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(HistogramValues, 0, n)
+	err := readChunks(r, n, t.Width(), func(chunk []byte) {
+		values = append(values, t.decode(chunk)...)
+	})
+	return values, err
+}
+
+// decode decodes every histogram into one slice of counts.
+func (t *HistogramValueType) decode(buffer []byte) []Histogram {
+	if t.buckets == 0 {
+		return nil
+	}
+	counts := make([]uint32, len(buffer)/4)
+	for i := range counts {
+		counts[i] = binary.LittleEndian.Uint32(buffer[i*4:])
+	}
+	h := make([]Histogram, len(counts)/t.buckets)
+	for i := range h {
+		h[i] = counts[i*t.buckets : (i+1)*t.buckets : (i+1)*t.buckets]
+	}
+	return h
+}
+
+// HistogramValues implements Values and wraps a slice of histograms, all
+// with the same number of buckets.
+type HistogramValues []Histogram
+
+// Encode will encode (Little Endian) the counts of every histogram for
+// writing to disk.
+func (v HistogramValues) Encode() []byte {
+	n := 0
+	for _, h := range v {
+		n += 4 * len(h)
+	}
+	return v.EncodeTo(make([]byte, 0, n))
+}
+
+// EncodeTo appends the Little Endian encoding of every histogram to dst.
+func (v HistogramValues) EncodeTo(dst []byte) []byte {
+	for _, h := range v {
+		dst = h.EncodeTo(dst)
+	}
+	return dst
+}
+
+// WriteTo writes the Little Endian encoding of every histogram to w.
+func (v HistogramValues) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
+}
+
+// Len returns the number of histograms.
+func (v HistogramValues) Len() int {
+	return len(v)
+}
+
+// Slice returns the histograms from index i up to j without copying.
+func (v HistogramValues) Slice(i, j int) Values {
+	return v[i:j]
+}
+
+// Histogram is the count of observations in each bucket of a histogram.
+type Histogram []uint32
+
+// NullHistogram returns the null histogram of the given number of
+// buckets.
+func NullHistogram(buckets int) Histogram {
+	h := make(Histogram, buckets)
+	for i := range h {
+		h[i] = math.MaxUint32
+	}
+	return h
+}
+
+// IsNull reports whether h is a null histogram.
+func (h Histogram) IsNull() bool {
+	for _, c := range h {
+		if c != math.MaxUint32 {
+			return false
+		}
+	}
+	return len(h) > 0
+}
+
+// EncodeTo appends the Little Endian encoding of the counts to dst.
+func (h Histogram) EncodeTo(dst []byte) []byte {
+	for _, c := range h {
+		dst = binary.LittleEndian.AppendUint32(dst, c)
+	}
+	return dst
+}
+
+// Count returns the total number of observations, zero for a null
+// histogram.
+func (h Histogram) Count() uint64 {
+	if h.IsNull() {
+		return 0
+	}
+	var n uint64
+	for _, c := range h {
+		n += uint64(c)
+	}
+	return n
+}
+
+// MergeHistograms returns the sum of histograms with the same number of
+// buckets, such as those of consecutive intervals.  Null histograms are
+// skipped and the result is null if all of them are.  Counts saturate
+// one short of math.MaxUint32 so that a sum is never mistaken for null.
+func MergeHistograms(hs ...Histogram) Histogram {
+	var sum Histogram
+	for _, h := range hs {
+		if h.IsNull() {
+			continue
+		}
+		if sum == nil {
+			sum = make(Histogram, len(h))
+		}
+		for i, c := range h {
+			sum[i] = uint32(min(uint64(sum[i])+uint64(c), math.MaxUint32-1))
+		}
+	}
+	if sum == nil && len(hs) > 0 {
+		return NullHistogram(len(hs[0]))
+	}
+	return sum
+}
+
+// Buckets are the upper bounds of the buckets of histograms, ascending:
+// bucket i counts the observations greater than bound i-1 and no greater
+// than bound i.  The first bucket counts observations from zero.  The
+// last bound is usually +Inf so that every observation is counted.
+type Buckets []float64
+
+// LinearBuckets returns n buckets, the first start wide and the rest
+// width wide, with the last bound +Inf.
+func LinearBuckets(start, width float64, n int) Buckets {
+	b := make(Buckets, n)
+	for i := range b {
+		b[i] = start + float64(i)*width
+	}
+	if n > 0 {
+		b[n-1] = math.Inf(1)
+	}
+	return b
+}
+
+// ExponentialBuckets returns n buckets whose bounds start at start and
+// grow by factor, with the last bound +Inf.
+func ExponentialBuckets(start, factor float64, n int) Buckets {
+	b := make(Buckets, n)
+	for i := range b {
+		b[i] = start * math.Pow(factor, float64(i))
+	}
+	if n > 0 {
+		b[n-1] = math.Inf(1)
+	}
+	return b
+}
+
+// Observe counts v in the bucket of h it falls in.  Values beyond the
+// last bound are not counted.
+func (b Buckets) Observe(h Histogram, v float64) {
+	if i := sort.SearchFloat64s(b, v); i < len(h) && i < len(b) {
+		h[i]++
+	}
+}
+
+// Quantile estimates the q-quantile, 0 <= q <= 1, of the observations
+// counted by h by interpolating linearly within the bucket the quantile
+// falls in, as Prometheus' histogram_quantile does.  A quantile in a
+// bucket whose bound is +Inf is the bound before it.  It returns NaN for
+// null and empty histograms.
+func (b Buckets) Quantile(h Histogram, q float64) float64 {
+	total := h.Count()
+	if total == 0 || q < 0 || q > 1 || len(h) != len(b) {
+		return math.NaN()
+	}
+
+	rank := q * float64(total)
+	var seen float64
+	for i, c := range h {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			continue
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = b[i-1]
+		}
+		if math.IsInf(b[i], 1) {
+			return lower
+		}
+		return lower + (b[i]-lower)*(rank-seen)/float64(c)
+	}
+	return b[len(b)-1]
+}
@@ -0,0 +1,61 @@
+package journal
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	b := ExponentialBuckets(1, 2, 5)
+	if fmt.Sprint(b) != "[1 2 4 8 +Inf]" {
+		t.Errorf("Exponential buckets are %v", b)
+	}
+	if l := LinearBuckets(10, 5, 3); fmt.Sprint(l) != "[10 15 +Inf]" {
+		t.Errorf("Linear buckets are %v", l)
+	}
+
+	h := make(Histogram, len(b))
+	for _, v := range []float64{0.5, 1, 1.5, 3, 3, 3, 7, 100} {
+		b.Observe(h, v)
+	}
+	if fmt.Sprint(h) != "[2 1 3 1 1]" || h.Count() != 8 {
+		t.Errorf("Observed %v, %d in all", h, h.Count())
+	}
+
+	tests := map[float64]float64{
+		0:    0,
+		0.25: 1,
+		0.5:  8.0 / 3,
+		0.75: 4,
+		0.9:  8,
+		1:    8,
+	}
+	for q, want := range tests {
+		if got := b.Quantile(h, q); math.Abs(got-want) > 1e-9 {
+			t.Errorf("Quantile %g = %g, want %g", q, got, want)
+		}
+	}
+
+	null := NullHistogram(len(b))
+	if !null.IsNull() || null.Count() != 0 || !math.IsNaN(b.Quantile(null, 0.5)) {
+		t.Errorf("Null histogram %v has %d observations", null, null.Count())
+	}
+	if !math.IsNaN(b.Quantile(make(Histogram, len(b)), 0.5)) {
+		t.Errorf("Empty histogram has a median")
+	}
+
+	sum := MergeHistograms(h, null, Histogram{1, 0, 0, 0, math.MaxUint32 - 2})
+	if fmt.Sprint(sum) != fmt.Sprint(Histogram{3, 1, 3, 1, math.MaxUint32 - 1}) {
+		t.Errorf("Merged %v", sum)
+	}
+	if !MergeHistograms(null, null).IsNull() {
+		t.Errorf("Merging nulls is not null")
+	}
+
+	factory := GetValueType(0x20, 20).(*HistogramValueType)
+	values := factory.Decode(HistogramValues{h, null}.Encode()).(HistogramValues)
+	if fmt.Sprint(values[0]) != fmt.Sprint(h) || !values[1].IsNull() {
+		t.Errorf("Decoded %v", values)
+	}
+}
@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"net/http"
+)
+
+// Check is a named health check for HealthHandler.
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Health is the response of a HealthHandler.
+type Health struct {
+	Status string        `json:"status"` // "ok" or "fail"
+	Checks []CheckResult `json:"checks"`
+}
+
+// CheckResult is the outcome of one Check.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthHandler returns a handler that runs every check and responds with
+// a Health, with status 503 if any check fails.  It suits endpoints such
+// as /healthz and /readyz probed by load balancers and orchestrators.
+func HealthHandler(checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := Health{Status: "ok", Checks: make([]CheckResult, len(checks))}
+		for i, c := range checks {
+			h.Checks[i] = CheckResult{Name: c.Name, Status: "ok"}
+			if err := c.Run(); err != nil {
+				h.Status = "fail"
+				h.Checks[i].Status = "fail"
+				h.Checks[i].Error = err.Error()
+			}
+		}
+		if h.Status != "ok" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, h)
+	})
+}
@@ -0,0 +1,529 @@
+// Package httpapi serves read queries against a store over HTTP using a
+// subset of the Graphite render API:
+//
+//	GET /render?target=servers.*.cpu&from=-1h&until=now
+//
+// responds with JSON of the form
+//
+//	[{"target": "servers.web01.cpu", "datapoints": [[1.5, 1449240540], [null, 1449240600]]}]
+//
+// Targets may also be expressions evaluated by package query, such as
+// sumSeries(servers.*.cpu).
+//
+// With format=ndjson the results are instead streamed as they are read,
+// one JSON object per line with each series split across as many lines
+// as it takes, so that long ranges are answered in bounded memory:
+//
+//	{"target": "servers.web01.cpu", "datapoints": [[1.5, 1449240540], ...]}
+//	{"target": "servers.web01.cpu", "datapoints": [[2.5, 1449486300], ...]}
+//
+// An error once streaming has begun is reported as a final line with an
+// "error" member.
+//
+// With maxDataPoints=N, as sent by Grafana, each series is consolidated
+// as it is read to at most N points over the range, aggregating the
+// values of each point with consolidateBy: average (the default), sum,
+// min, max, or last.  Stored series are then read from their rollup
+// archives where those are fine enough, or where the raw journal no
+// longer reaches back far enough, as described by rollup.ReadBest.
+//
+// GET /metrics/find?query=servers.* lists matching series names.
+//
+// GET /federate?match[]=servers.*.cpu exposes the latest value of each
+// matching series in the OpenMetrics text format for Prometheus servers
+// to scrape.
+//
+// GET /preview?target=servers.*.cpu&points=128 returns the whole of each
+// matching series consolidated to a few points, as cached by
+// store.Store.Preview, for sparklines.
+//
+// Clients that auth confines to a tenant query the Store as scoped by
+// store.Store.Scope, naming series without the tenant's prefix.
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/auth"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/query"
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const (
+	// DefaultRange is how far back queries reach when from is not given.
+	DefaultRange = 24 * time.Hour
+
+	// StreamChunk is the most datapoints in each line of a streamed
+	// response.
+	StreamChunk = 4096
+)
+
+// Series is one result of a render query.
+type Series struct {
+	Target     string      `json:"target"`
+	Datapoints []Datapoint `json:"datapoints"`
+	Error      string      `json:"error,omitempty"` // streamed errors only
+}
+
+// Datapoint is a [value, timestamp] pair.  Null values are NaN and are
+// encoded as JSON null.
+type Datapoint struct {
+	Value     float64
+	Timestamp int64
+}
+
+// MarshalJSON encodes the Datapoint as a two element array.
+func (d Datapoint) MarshalJSON() ([]byte, error) {
+	value := "null"
+	if !math.IsNaN(d.Value) && !math.IsInf(d.Value, 0) {
+		value = strconv.FormatFloat(d.Value, 'g', -1, 64)
+	}
+	return []byte(fmt.Sprintf("[%s,%d]", value, d.Timestamp)), nil
+}
+
+// Server is an http.Handler answering queries from a Store.
+type Server struct {
+	Store *store.Store
+
+	// Auth, if set, authenticates requests.  Queries require the Read
+	// role.  Nil serves everyone.
+	Auth *auth.Authenticator
+
+	// Clock, if set, is used in place of the system clock to resolve
+	// relative query times.
+	Clock clock.Clock
+
+	mux *http.ServeMux
+}
+
+// New returns a Server for the given store.
+func New(s *store.Store) *Server {
+	srv := &Server{Store: s, mux: http.NewServeMux()}
+	srv.handle("/render", auth.Read, http.HandlerFunc(srv.render), true)
+	srv.handle("/metrics/find", auth.Read, http.HandlerFunc(srv.find), true)
+	srv.handle("/federate", auth.Read, http.HandlerFunc(srv.federate), true)
+	srv.handle("/preview", auth.Read, http.HandlerFunc(srv.preview), true)
+	return srv
+}
+
+// HandleRole registers an additional handler on the Server's mux that is
+// only served to clients with at least the given role.  Unless the role
+// is None, clients confined to a tenant are refused, since the handler
+// is not scoped to one.
+func (srv *Server) HandleRole(pattern string, role auth.Role, handler http.Handler) {
+	srv.handle(pattern, role, handler, role == auth.None)
+}
+
+// handle registers a handler requiring role, which serves clients
+// confined to a tenant only if tenants is set.
+func (srv *Server) handle(pattern string, role auth.Role, handler http.Handler, tenants bool) {
+	if !tenants {
+		inner := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if auth.Tenant(r) != "" {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			inner.ServeHTTP(w, r)
+		})
+	}
+	srv.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.Auth.Require(role, handler).ServeHTTP(w, r)
+	}))
+}
+
+// storeOf returns the Store answering a request: Store, scoped to the
+// tenant of the client if it has one.
+func (srv *Server) storeOf(r *http.Request) (*store.Store, error) {
+	if tenant := auth.Tenant(r); tenant != "" {
+		return srv.Store.Scope(tenant)
+	}
+	return srv.Store, nil
+}
+
+// Handle registers an additional handler on the Server's mux that
+// requires the Admin role.
+func (srv *Server) Handle(pattern string, handler http.Handler) {
+	srv.HandleRole(pattern, auth.Admin, handler)
+}
+
+// HandleFunc registers an additional handler function on the Server's mux
+// that requires the Admin role.
+func (srv *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	srv.HandleRole(pattern, auth.Admin, http.HandlerFunc(handler))
+}
+
+// ServeHTTP implements http.Handler.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+func (srv *Server) render(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	r.ParseForm()
+	now := clock.Or(srv.Clock).Now()
+	from, err := ParseTime(r.Form.Get("from"), now.Add(-DefaultRange), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := ParseTime(r.Form.Get("until"), now, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	st, err := srv.storeOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if srv.Store.Slow != nil {
+		cw := &countingWriter{ResponseWriter: w}
+		w = cw
+		defer func() {
+			srv.Store.Slow.Observe(store.SlowOp{
+				Op:       "query",
+				Series:   strings.Join(r.Form["target"], ","),
+				From:     from,
+				Until:    until,
+				Bytes:    cw.n,
+				Duration: time.Since(start),
+			})
+		}()
+	}
+
+	var targets []string
+	var exprs []query.Expr
+	for _, target := range r.Form["target"] {
+		if !query.IsExpression(target) {
+			targets = append(targets, target)
+			continue
+		}
+		e, err := query.Parse(target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		exprs = append(exprs, e)
+	}
+	if len(targets) == 0 && len(exprs) == 0 {
+		http.Error(w, "Missing target", http.StatusBadRequest)
+		return
+	}
+
+	series, err := expand(st, targets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cons, err := parseConsolidation(r, from, until)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Form.Get("format") {
+	case "", "json":
+	case "ndjson":
+		stream(w, st, series, exprs, cons)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format: %q", r.Form.Get("format")), http.StatusBadRequest)
+		return
+	}
+
+	out := make([]Series, 0, len(series))
+	var results map[string]store.Result
+	if cons.maxPoints == 0 {
+		results = st.ReadMany(series, from, until)
+	}
+	for _, name := range series {
+		var q *query.Series
+		var err error
+		if cons.maxPoints > 0 {
+			q, err = readConsolidated(st, name, cons)
+		} else {
+			q, err = seriesOf(name, results[name])
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			if timeseries.IsReadLimit(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("%s: %s", name, err), status)
+			return
+		}
+		out = append(out, newSeries(q, 0, len(q.Values)))
+	}
+
+	c := &query.Context{Store: st, From: from, Until: until}
+	for _, e := range exprs {
+		computed, err := c.Eval(e)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, q := range computed {
+			q = cons.apply(q)
+			out = append(out, newSeries(q, 0, len(q.Values)))
+		}
+	}
+
+	writeJSON(w, out)
+}
+
+// consolidation is how a render query consolidates its results, as
+// given by its maxDataPoints and consolidateBy parameters.
+type consolidation struct {
+	from, until int64
+	maxPoints   int // 0 if results are not consolidated
+	fn          rollup.Aggregator
+}
+
+func parseConsolidation(r *http.Request, from, until int64) (consolidation, error) {
+	c := consolidation{from: from, until: until, fn: rollup.Average}
+	if s := r.Form.Get("maxDataPoints"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return c, fmt.Errorf("Invalid maxDataPoints: %q", s)
+		}
+		c.maxPoints = n
+	}
+	if s := r.Form.Get("consolidateBy"); s != "" {
+		fn, err := rollup.GetAggregator(s)
+		if err != nil {
+			return c, err
+		}
+		c.fn = fn
+	}
+	return c, nil
+}
+
+// apply consolidates a computed series held in memory.
+func (c consolidation) apply(q *query.Series) *query.Series {
+	if c.maxPoints == 0 {
+		return q
+	}
+	cons := rollup.NewConsolidation(c.from, c.until, q.Step, c.maxPoints, c.fn)
+	cons.Add(q.Start, q.Step, q.Values)
+	cons.Finish()
+	return &query.Series{Name: q.Name, Start: cons.Start, Step: cons.Step, Values: cons.Values}
+}
+
+// readConsolidated reads a stored series from the best of its journal
+// and rollup archives and consolidates it as it is read.
+func readConsolidated(st *store.Store, name string, c consolidation) (*query.Series, error) {
+	cons, err := rollup.ReadBest(st, name, c.from, c.until, c.maxPoints, c.fn)
+	if err != nil {
+		return nil, err
+	}
+	return &query.Series{Name: name, Start: cons.Start, Step: cons.Step, Values: cons.Values}, nil
+}
+
+// seriesOf converts the Result of reading a stored series.
+func seriesOf(name string, r store.Result) (*query.Series, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	floats, err := rollup.Floats(r.Values)
+	if err != nil {
+		return nil, err
+	}
+	return &query.Series{Name: name, Start: r.Start, Step: r.Interval, Values: floats}, nil
+}
+
+// newSeries returns the values of q from first up to end as a Series.
+func newSeries(q *query.Series, first, end int) Series {
+	s := Series{Target: q.Name, Datapoints: make([]Datapoint, end-first)}
+	for i, v := range q.Values[first:end] {
+		s.Datapoints[i] = Datapoint{v, q.Start + int64(first+i)*q.Step}
+	}
+	return s
+}
+
+// stream writes the results of a render query in the ndjson format,
+// reading each series with a store.Cursor and flushing every line.
+// Consolidated series are sent once each has been read.
+func stream(w http.ResponseWriter, st *store.Store, series []string, exprs []query.Expr, cons consolidation) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(s Series) bool {
+		if enc.Encode(s) != nil {
+			return false // the client has gone away
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return s.Error == ""
+	}
+	sendAll := func(q *query.Series) bool {
+		for first := 0; first < len(q.Values); first += StreamChunk {
+			if !send(newSeries(q, first, min(first+StreamChunk, len(q.Values)))) {
+				return false
+			}
+		}
+		return true
+	}
+	fail := func(name string, err error) {
+		send(Series{Target: name, Datapoints: []Datapoint{}, Error: err.Error()})
+	}
+
+	for _, name := range series {
+		if cons.maxPoints > 0 {
+			q, err := readConsolidated(st, name, cons)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				fail(name, err)
+				return
+			}
+			if !sendAll(q) {
+				return
+			}
+			continue
+		}
+
+		c := st.Cursor(name, cons.from, cons.until, StreamChunk)
+		for c.Next() {
+			q, err := seriesOf(name, c.Result())
+			if err != nil {
+				fail(name, err)
+				return
+			}
+			if !send(newSeries(q, 0, len(q.Values))) {
+				return
+			}
+		}
+		if err := c.Err(); err != nil && !os.IsNotExist(err) {
+			fail(name, err)
+			return
+		}
+	}
+
+	c := &query.Context{Store: st, From: cons.from, Until: cons.until}
+	for _, e := range exprs {
+		computed, err := c.Eval(e)
+		if err != nil {
+			fail(e.String(), err)
+			return
+		}
+		for _, q := range computed {
+			if !sendAll(cons.apply(q)) {
+				return
+			}
+		}
+	}
+}
+
+func (srv *Server) find(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+	if query == "" {
+		http.Error(w, "Missing query", http.StatusBadRequest)
+		return
+	}
+	st, err := srv.storeOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	series, err := st.Find(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if series == nil {
+		series = []string{}
+	}
+	sort.Strings(series)
+	writeJSON(w, series)
+}
+
+// expand resolves wildcard targets into series names, removing duplicates.
+func expand(st *store.Store, targets []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var series []string
+	for _, target := range targets {
+		names := []string{target}
+		if strings.ContainsAny(target, "*?[") {
+			var err error
+			if names, err = st.Find(target); err != nil {
+				return nil, err
+			}
+			sort.Strings(names)
+		} else if err := store.Validate(target); err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				series = append(series, name)
+			}
+		}
+	}
+	return series, nil
+}
+
+// countingWriter counts the bytes of a response.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// ParseTime parses a query time which is either a Unix timestamp, "now",
+// or a negative offset from now such as "-1h", "-30min", or "-7d".  An
+// empty string returns def.
+func ParseTime(s string, def, now time.Time) (int64, error) {
+	switch {
+	case s == "":
+		return def.Unix(), nil
+	case s == "now":
+		return now.Unix(), nil
+	case s[0] == '-':
+		n, err := query.ParseOffset(s)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid relative time: %q", s)
+		}
+		return now.Unix() - n, nil
+	}
+
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time: %q", s)
+	}
+	return ts, nil
+}
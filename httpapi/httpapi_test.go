@@ -0,0 +1,295 @@
+package httpapi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/auth"
+	"github.com/jjneely/journal/store"
+)
+
+const epoch = int64(1449240540)
+
+func testServer(t *testing.T) *Server {
+	dir, err := ioutil.TempDir("/tmp", "journal-httpapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.New(dir)
+	for _, name := range []string{"a.b", "a.c"} {
+		j, err := s.Create(name, 60, NewFloat64ValueType(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Write(epoch, Float64Values{1.5, math.NaN(), 3})
+		j.Close()
+	}
+	return New(s)
+}
+
+func get(srv *Server, url string) (int, string) {
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestRender(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+
+	code, body := get(srv, "/render?target=a.*&target=a.b&target=missing&from=1449240540&until=1449240660")
+	want := `[{"target":"a.b","datapoints":[[1.5,1449240540],[null,1449240600],[3,1449240660]]},` +
+		`{"target":"a.c","datapoints":[[1.5,1449240540],[null,1449240600],[3,1449240660]]}]`
+	if code != 200 || body != want {
+		t.Errorf("Render returned %d %s", code, body)
+	}
+
+	var slow []store.SlowOp
+	srv.Store.Slow = &store.SlowLog{Query: time.Nanosecond, Log: func(op store.SlowOp) {
+		if op.Op == "query" {
+			slow = append(slow, op)
+		}
+	}}
+	get(srv, "/render?target=a.*&from=1449240540&until=1449240660")
+	if len(slow) != 1 || slow[0].Series != "a.*" || slow[0].From != epoch || slow[0].Bytes != int64(len(want)+1) {
+		t.Errorf("Slow queries logged %v", slow)
+	}
+	srv.Store.Slow = nil
+
+	code, _ = get(srv, "/render?from=-1h")
+	if code != 400 {
+		t.Errorf("Render without a target returned %d", code)
+	}
+	code, _ = get(srv, "/render?target=a.b&from=yesterday")
+	if code != 400 {
+		t.Errorf("Render with a bad time returned %d", code)
+	}
+}
+
+func TestFind(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+
+	code, body := get(srv, "/metrics/find?query=a.*")
+	if code != 200 || body != `["a.b","a.c"]` {
+		t.Errorf("Find returned %d %s", code, body)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Unix(1449240540, 0)
+	tests := map[string]int64{
+		"":           1,
+		"now":        1449240540,
+		"-1h":        1449240540 - 3600,
+		"-30min":     1449240540 - 1800,
+		"-7d":        1449240540 - 7*86400,
+		"1449000000": 1449000000,
+	}
+	for s, want := range tests {
+		ts, err := ParseTime(s, time.Unix(1, 0), now)
+		if err != nil || ts != want {
+			t.Errorf("ParseTime(%q) = %d, %v want %d", s, ts, err, want)
+		}
+	}
+	for _, bad := range []string{"-1x", "-h", "yesterday"} {
+		if _, err := ParseTime(bad, now, now); err == nil {
+			t.Errorf("Invalid time accepted: %q", bad)
+		}
+	}
+}
+
+func TestRenderExpression(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+
+	code, body := get(srv, "/render?target=sumSeries(a.*)&from=1449240540&until=1449240660")
+	want := `[{"target":"sumSeries(a.*)","datapoints":[[3,1449240540],[null,1449240600],[6,1449240660]]}]`
+	if code != 200 || body != want {
+		t.Errorf("Render returned %d %s", code, body)
+	}
+
+	code, _ = get(srv, "/render?target=sumSeries(a.*")
+	if code != 400 {
+		t.Errorf("Render with a bad expression returned %d", code)
+	}
+}
+
+func TestRenderStream(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+
+	code, body := get(srv, "/render?target=a.b&target=sumSeries(a.*)&from=1449240540&until=1449240660&format=ndjson")
+	want := `{"target":"a.b","datapoints":[[1.5,1449240540],[null,1449240600],[3,1449240660]]}` + "\n" +
+		`{"target":"sumSeries(a.*)","datapoints":[[3,1449240540],[null,1449240600],[6,1449240660]]}`
+	if code != 200 || body != want {
+		t.Errorf("Streamed render returned %d %s", code, body)
+	}
+
+	code, _ = get(srv, "/render?target=a.b&format=xml")
+	if code != 400 {
+		t.Errorf("Render with an unknown format returned %d", code)
+	}
+}
+
+func TestRenderMaxDataPoints(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+
+	// epoch is 1449240540, a multiple of 60 but not 120
+	code, body := get(srv, "/render?target=a.b&target=sumSeries(a.*)&from=1449240540&until=1449240660&maxDataPoints=2&consolidateBy=max")
+	want := `[{"target":"a.b","datapoints":[[1.5,1449240480],[3,1449240600]]},` +
+		`{"target":"sumSeries(a.*)","datapoints":[[3,1449240480],[6,1449240600]]}]`
+	if code != 200 || body != want {
+		t.Errorf("Consolidated render returned %d %s", code, body)
+	}
+
+	code, body = get(srv, "/render?target=a.b&from=1449240540&until=1449240660&maxDataPoints=2&format=ndjson")
+	want = `{"target":"a.b","datapoints":[[1.5,1449240480],[3,1449240600]]}`
+	if code != 200 || body != want {
+		t.Errorf("Consolidated stream returned %d %s", code, body)
+	}
+
+	for _, bad := range []string{"maxDataPoints=-1", "maxDataPoints=lots", "maxDataPoints=2&consolidateBy=median"} {
+		if code, _ = get(srv, "/render?target=a.b&"+bad); code != 400 {
+			t.Errorf("Render with %s returned %d", bad, code)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+	srv.Auth = &auth.Authenticator{Tokens: map[string]auth.Role{"reader": auth.Read}}
+	srv.HandleFunc("/debug", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, test := range []struct {
+		url, token string
+		want       int
+	}{
+		{"/metrics/find?query=a.*", "", 401},
+		{"/metrics/find?query=a.*", "reader", 200},
+		{"/debug", "reader", 403},
+	} {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s with token %q returned %d", test.url, test.token, w.Code)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+	srv.Handle("/healthz", HealthHandler(Check{"store", srv.Store.Check}))
+	srv.Handle("/readyz", HealthHandler(
+		Check{"store", srv.Store.Check},
+		Check{"queue", func() error { return fmt.Errorf("Full") }},
+	))
+
+	code, body := get(srv, "/healthz")
+	if code != 200 || body != `{"status":"ok","checks":[{"name":"store","status":"ok"}]}` {
+		t.Errorf("Health returned %d %s", code, body)
+	}
+	code, body = get(srv, "/readyz")
+	want := `{"status":"fail","checks":[{"name":"store","status":"ok"},{"name":"queue","status":"fail","error":"Full"}]}`
+	if code != 503 || body != want {
+		t.Errorf("Readiness returned %d %s", code, body)
+	}
+
+	os.RemoveAll(srv.Store.Root)
+	if code, _ = get(srv, "/healthz"); code != 503 {
+		t.Errorf("Health of a missing store returned %d", code)
+	}
+}
+
+func TestTenant(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+	srv.Auth = &auth.Authenticator{
+		Tokens:       map[string]auth.Role{"a": auth.Read},
+		TokenTenants: map[string]string{"a": "a"},
+	}
+	srv.HandleRole("/replicate", auth.Read, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, test := range []struct {
+		url, want string
+		code      int
+	}{
+		{"/metrics/find?query=*", `["b","c"]`, 200},
+		{"/render?target=b&from=1449240540&until=1449240540", `[{"target":"b","datapoints":[[1.5,1449240540]]}]`, 200},
+		{"/render?target=a.b&from=1449240540&until=1449240540", `[]`, 200},
+		{"/replicate", "Forbidden", 403},
+	} {
+		r := httptest.NewRequest("GET", test.url, nil)
+		r.Header.Set("Authorization", "Bearer a")
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if body := strings.TrimSpace(w.Body.String()); w.Code != test.code || body != test.want {
+			t.Errorf("%s returned %d %s", test.url, w.Code, body)
+		}
+	}
+}
+
+func TestFederate(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+	j, err := srv.Store.Create("9lives.x-y", 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch, Float64Values{math.Inf(1)})
+	j.Close()
+	srv.Store.Pending = func(series string, from, until int64) map[int64]float64 {
+		if series == "a.c" {
+			return map[int64]float64{epoch + 600: 4}
+		}
+		return nil
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/federate?match[]=a.*&match[]=9lives.*&match[]=missing", nil))
+	want := `# TYPE _lives_x_y gauge
+_lives_x_y{series="9lives.x-y"} +Inf 1449240540
+# TYPE a_b gauge
+a_b{series="a.b"} 3 1449240660
+# TYPE a_c gauge
+a_c{series="a.c"} 4 1449241140
+# EOF
+`
+	if w.Code != 200 || w.Body.String() != want || w.Header().Get("Content-Type") != OpenMetricsType {
+		t.Errorf("Federate returned %d %s", w.Code, w.Body)
+	}
+	if code, _ := get(srv, "/federate"); code != 400 {
+		t.Errorf("Federate without match[] returned %d", code)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	srv := testServer(t)
+	defer os.RemoveAll(srv.Store.Root)
+
+	code, body := get(srv, "/preview?target=a.*&points=2")
+	want := `[{"target":"a.b","datapoints":[[1.5,1449240480],[3,1449240660]]},{"target":"a.c","datapoints":[[1.5,1449240480],[3,1449240660]]}]`
+	if code != 200 || body != want {
+		t.Errorf("Preview returned %d %s", code, body)
+	}
+	if code, _ = get(srv, "/preview?target=a.b&points=x"); code != 400 {
+		t.Errorf("Invalid points returned %d", code)
+	}
+}
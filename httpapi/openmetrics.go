@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenMetricsType is the content type of the OpenMetrics text format.
+const OpenMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// sample is the latest value of a series exposed by federate.
+type sample struct {
+	metric, series string
+	value          float64
+	timestamp      int64
+}
+
+// federate exposes the latest value of each series matching the match[]
+// patterns in the OpenMetrics text format, so that Prometheus servers can
+// scrape the store.  Each series is a gauge named by MetricName with a
+// series label holding its name.  Series without values and those that
+// cannot be read, such as those that are not numeric, are left out.
+func (srv *Server) federate(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	patterns := r.Form["match[]"]
+	if len(patterns) == 0 {
+		http.Error(w, "Missing match[]", http.StatusBadRequest)
+		return
+	}
+	st, err := srv.storeOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	series, err := expand(st, patterns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var samples []sample
+	for _, name := range series {
+		ts, value, err := st.Latest(name)
+		if err != nil || ts == 0 {
+			continue
+		}
+		samples = append(samples, sample{MetricName(name), name, value, ts})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].metric != samples[j].metric {
+			return samples[i].metric < samples[j].metric
+		}
+		return samples[i].series < samples[j].series
+	})
+
+	w.Header().Set("Content-Type", OpenMetricsType)
+	var b strings.Builder
+	for i, s := range samples {
+		if i == 0 || samples[i-1].metric != s.metric {
+			fmt.Fprintf(&b, "# TYPE %s gauge\n", s.metric)
+		}
+		fmt.Fprintf(&b, "%s{series=%s} %s %d\n", s.metric, quoteLabel(s.series),
+			strconv.FormatFloat(s.value, 'g', -1, 64), s.timestamp)
+	}
+	b.WriteString("# EOF\n")
+	w.Write([]byte(b.String()))
+}
+
+// MetricName returns the Prometheus metric name of a series: its name
+// with every character that may not appear in one replaced by an
+// underscore, so that servers.web01.cpu becomes servers_web01_cpu.
+func MetricName(series string) string {
+	b := []byte(series)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// quoteLabel quotes a label value as the OpenMetrics text format
+// requires.
+func quoteLabel(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,57 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+import (
+	"github.com/jjneely/journal/query"
+)
+
+// preview answers GET /preview?target=servers.*.cpu&points=128 with the
+// store.Preview of each matching series, in the format of render, for
+// drawing sparklines of many series at once.
+func (srv *Server) preview(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	targets := r.Form["target"]
+	if len(targets) == 0 {
+		http.Error(w, "Missing target", http.StatusBadRequest)
+		return
+	}
+	size := 0
+	if s := r.Form.Get("points"); s != "" {
+		var err error
+		if size, err = strconv.Atoi(s); err != nil || size < 0 {
+			http.Error(w, fmt.Sprintf("Invalid points: %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	st, err := srv.storeOf(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	series, err := expand(st, targets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := make([]Series, 0, len(series))
+	for _, name := range series {
+		p, err := st.Preview(name, size)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", name, err), http.StatusInternalServerError)
+			return
+		}
+		q := &query.Series{Name: name, Start: p.Start, Step: p.Step, Values: p.Values}
+		out = append(out, newSeries(q, 0, len(q.Values)))
+	}
+	writeJSON(w, out)
+}
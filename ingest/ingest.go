@@ -0,0 +1,233 @@
+// Package ingest receives datapoints over the Graphite plaintext line
+// protocol:
+//
+//	<series> <value> <timestamp>\n
+//
+// over TCP or UDP and hands them to a Sink.
+package ingest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Point is a single datapoint for one series.
+type Point struct {
+	Series    string
+	Timestamp int64
+	Value     float64
+}
+
+// Sink accepts received points.  It must be safe for concurrent use.
+type Sink func(p Point) error
+
+// ParseLine parses one line of the plaintext protocol.
+func ParseLine(line string) (Point, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return Point{}, fmt.Errorf("Expected 3 fields: %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsInf(value, 0) {
+		return Point{}, fmt.Errorf("Invalid value: %q", line)
+	}
+	ts, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || ts <= 0 {
+		return Point{}, fmt.Errorf("Invalid timestamp: %q", line)
+	}
+
+	return Point{Series: fields[0], Timestamp: int64(ts), Value: value}, nil
+}
+
+// Listener serves the plaintext protocol on a TCP and/or UDP address.
+type Listener struct {
+	Sink Sink
+
+	// OnError, if set, is called for lines that cannot be parsed or that
+	// the Sink rejects.
+	OnError func(err error)
+
+	// TLS, if set, is used to serve TLS rather than plaintext on TCP.
+	// UDP is unaffected.
+	TLS *tls.Config
+
+	// Authorize, if set, decides from the handshake whether a TLS client
+	// may send datapoints, such as by its client certificate.
+	Authorize func(cs tls.ConnectionState) bool
+
+	// Tenant, if set, names from the handshake the tenant that a TLS
+	// client is confined to, if any.  The series of its points are
+	// prefixed with the tenant's name and a dot.
+	Tenant func(cs tls.ConnectionState) string
+
+	// Limiter, if set, drops points from clients that send too many.
+	Limiter *Limiter
+
+	mu    sync.Mutex
+	tcp   net.Listener
+	udp   net.PacketConn
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// ListenTCP starts accepting TCP connections on addr.
+func (l *Listener) ListenTCP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if l.TLS != nil {
+		ln = tls.NewListener(ln, l.TLS)
+	}
+	l.mu.Lock()
+	l.tcp = ln
+	l.conns = make(map[net.Conn]bool)
+	l.mu.Unlock()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			l.mu.Lock()
+			l.conns[conn] = true
+			l.mu.Unlock()
+
+			l.wg.Add(1)
+			go func() {
+				defer l.wg.Done()
+				if tenant, err := l.authorize(conn); err != nil {
+					if l.OnError != nil {
+						l.OnError(err)
+					}
+				} else {
+					l.handle(conn, clientName(conn), tenant)
+				}
+				l.mu.Lock()
+				delete(l.conns, conn)
+				l.mu.Unlock()
+				conn.Close()
+			}()
+		}
+	}()
+	return nil
+}
+
+// ListenUDP starts receiving datagrams on addr.  Each datagram may hold
+// several newline separated lines.
+func (l *Listener) ListenUDP(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.udp = pc
+	l.mu.Unlock()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			l.handle(strings.NewReader(string(buf[:n])), addrName(addr), "")
+		}
+	}()
+	return nil
+}
+
+// TCPAddr returns the address the TCP listener is bound to or nil.
+func (l *Listener) TCPAddr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tcp == nil {
+		return nil
+	}
+	return l.tcp.Addr()
+}
+
+// UDPAddr returns the address the UDP listener is bound to or nil.
+func (l *Listener) UDPAddr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.udp == nil {
+		return nil
+	}
+	return l.udp.LocalAddr()
+}
+
+// authorize completes the TLS handshake of conn, if any, checks the
+// client with Authorize, and returns its tenant.
+func (l *Listener) authorize(conn net.Conn) (string, error) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return "", err
+	}
+	if l.Authorize != nil && !l.Authorize(tc.ConnectionState()) {
+		return "", fmt.Errorf("Unauthorized client: %s", conn.RemoteAddr())
+	}
+	if l.Tenant != nil {
+		return l.Tenant(tc.ConnectionState()), nil
+	}
+	return "", nil
+}
+
+// handle passes the points read from the named client to the Sink,
+// prefixing their series with tenant if it is set.
+func (l *Listener) handle(r io.Reader, client, tenant string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := ParseLine(line)
+		if err == nil && tenant != "" {
+			p.Series = tenant + "." + p.Series
+		}
+		if err == nil && l.Limiter != nil && !l.Limiter.Allow(client, p.Series) {
+			continue
+		}
+		if err == nil {
+			err = l.Sink(p)
+		}
+		if err != nil && l.OnError != nil {
+			l.OnError(err)
+		}
+	}
+}
+
+// Close stops the listeners, closes open connections, and waits for all
+// received lines to be handed to the Sink.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.tcp != nil {
+		l.tcp.Close()
+	}
+	if l.udp != nil {
+		l.udp.Close()
+	}
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	return nil
+}
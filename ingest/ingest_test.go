@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+)
+
+func TestParseLine(t *testing.T) {
+	p, err := ParseLine("servers.web01.cpu 12.5 1449240543\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Series != "servers.web01.cpu" || p.Value != 12.5 ||
+		p.Timestamp != 1449240543 {
+		t.Errorf("Parsed the wrong point: %+v", p)
+	}
+
+	p, err = ParseLine("a 1 1449240543.25")
+	if err != nil || p.Timestamp != 1449240543 {
+		t.Errorf("Fractional timestamp parsed as %+v, %v", p, err)
+	}
+
+	for _, bad := range []string{"a 1", "a b 1449240543", "a 1 b", "a 1 2 3", "a +Inf 1449240543", "a 1 -5"} {
+		if _, err := ParseLine(bad); err == nil {
+			t.Errorf("Invalid line accepted: %q", bad)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	var mu sync.Mutex
+	var points []Point
+	var errors int
+	l := &Listener{
+		Sink: func(p Point) error {
+			mu.Lock()
+			defer mu.Unlock()
+			points = append(points, p)
+			return nil
+		},
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errors++
+		},
+	}
+	if err := l.ListenTCP("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", l.TCPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(conn, "a.b %d %d\n", i, 1449240540+i*60)
+	}
+	fmt.Fprintf(conn, "garbage\n")
+	conn.Close()
+
+	waitFor(t, "the connection to be handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(points)+errors == 11
+	})
+	l.Close()
+
+	if errors != 1 {
+		t.Errorf("%d lines were rejected instead of 1", errors)
+	}
+	for i, p := range points {
+		if p.Value != float64(i) {
+			t.Errorf("Point %d out of order: %+v", i, p)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	for i := 0; i < 500 && !done(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !done() {
+		t.Fatalf("Timed out waiting for %s", what)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := clock.NewFake(time.Unix(1449240540, 0))
+	l := &Limiter{Rate: 2, Burst: 3, Clock: now}
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if l.Allow("10.0.0.1", "a") {
+			allowed++
+		}
+	}
+	if allowed != 3 || l.Limited.Value() != 7 {
+		t.Errorf("Burst allowed %d points, limited %d", allowed, l.Limited.Value())
+	}
+	if !l.Allow("10.0.0.2", "a") {
+		t.Errorf("Another client was limited")
+	}
+	now.Advance(time.Second)
+	if !l.Allow("10.0.0.1", "a") || !l.Allow("10.0.0.1", "a") || l.Allow("10.0.0.1", "a") {
+		t.Errorf("Rate did not refill 2 points per second")
+	}
+
+	l = &Limiter{
+		MaxSeries: 2,
+		Exists:    func(series string) bool { return series == "old" },
+		Clock:     now,
+	}
+	for _, series := range []string{"a", "old", "b", "a", "old"} {
+		if !l.Allow("10.0.0.1", series) {
+			t.Errorf("Series %s was refused", series)
+		}
+	}
+	if l.Allow("10.0.0.1", "c") || l.OverQuota.Value() != 1 {
+		t.Errorf("Third new series was allowed")
+	}
+	now.Advance(DefaultQuotaPeriod)
+	if !l.Allow("10.0.0.1", "c") {
+		t.Errorf("Quota was not reset")
+	}
+
+	// A slow Exists holds up only its own point
+	release := make(chan struct{})
+	l.Exists = func(series string) bool {
+		if series == "slow" {
+			<-release
+		}
+		return false
+	}
+	slow := make(chan bool)
+	go func() {
+		slow <- l.Allow("10.0.0.1", "slow")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	fast := make(chan bool, 1)
+	go func() {
+		fast <- l.Allow("10.0.0.2", "fast")
+	}()
+	select {
+	case ok := <-fast:
+		if !ok {
+			t.Errorf("Series fast was refused")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Allow waited for another client's Exists")
+	}
+	close(release)
+	if !<-slow {
+		t.Errorf("Series slow was refused")
+	}
+}
@@ -0,0 +1,154 @@
+package ingest
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/stats"
+)
+
+// DefaultQuotaPeriod is how often a Limiter forgets its clients when no
+// Period is given.
+const DefaultQuotaPeriod = time.Hour
+
+// Limiter bounds the points each client may send and the series it may
+// create so that one misconfigured agent cannot fill the disk.  Clients
+// are identified by the common name of their verified TLS client
+// certificate or otherwise their IP address.  Points over a limit are
+// dropped and counted.
+type Limiter struct {
+	// Rate is the points per second each client may send on average and
+	// Burst the most it may send at once, at least one.  Zero Rate is
+	// unlimited.
+	Rate  float64
+	Burst int
+
+	// MaxSeries is the number of new series each client may send points
+	// for in each Period.  Zero is unlimited.
+	MaxSeries int
+
+	// Exists, if set, reports whether a series already has a journal.
+	// Only series without one count against MaxSeries.
+	Exists func(series string) bool
+
+	// Period is how often quotas are reset, DefaultQuotaPeriod if zero.
+	Period time.Duration
+
+	// Clock, if set, is used in place of the system clock.
+	Clock clock.Clock
+
+	// Limited and OverQuota count the points dropped for exceeding Rate
+	// and MaxSeries.
+	Limited   stats.Counter
+	OverQuota stats.Counter
+
+	mu      sync.Mutex
+	clients map[string]*client
+	reset   time.Time
+}
+
+// client is the state of one client for the current period.
+type client struct {
+	tokens float64
+	last   time.Time
+	series map[string]bool // seen series, true for new ones
+	new    int
+}
+
+// Allow reports whether the client may send a point for series, taking
+// one token from its bucket if so.
+func (l *Limiter) Allow(name, series string) bool {
+	allowed, check := l.allow(name, series, nil)
+	if check {
+		// Exists may touch the disk, so the lock is not held
+		exists := l.Exists(series)
+		allowed, _ = l.allow(name, series, &exists)
+	}
+	return allowed
+}
+
+// allow is Allow given whether series exists, if known.  When the series
+// is new to the client and that is not known it returns check without
+// counting the point, so that Exists can be called without the lock.
+func (l *Limiter) allow(name, series string, exists *bool) (allowed, check bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := clock.Or(l.Clock).Now()
+	period := l.Period
+	if period <= 0 {
+		period = DefaultQuotaPeriod
+	}
+	if l.clients == nil || now.Sub(l.reset) >= period {
+		l.clients = make(map[string]*client)
+		l.reset = now
+	}
+	c := l.clients[name]
+	if c == nil {
+		c = &client{tokens: l.burst(), last: now}
+		l.clients[name] = c
+	}
+
+	if l.Rate > 0 {
+		c.tokens = min(c.tokens+now.Sub(c.last).Seconds()*l.Rate, l.burst())
+		c.last = now
+		if c.tokens < 1 {
+			l.Limited.Add(1)
+			return false, false
+		}
+	}
+
+	if l.MaxSeries > 0 {
+		isNew, seen := c.series[series]
+		if !seen {
+			if l.Exists != nil && exists == nil {
+				return false, true
+			}
+			isNew = l.Exists == nil || !*exists
+			if isNew && c.new >= l.MaxSeries {
+				l.OverQuota.Add(1)
+				return false, false
+			}
+			if c.series == nil {
+				c.series = make(map[string]bool)
+			}
+			c.series[series] = isNew
+			if isNew {
+				c.new++
+			}
+		}
+	}
+
+	if l.Rate > 0 {
+		c.tokens--
+	}
+	return true, false
+}
+
+func (l *Limiter) burst() float64 {
+	return float64(max(l.Burst, 1))
+}
+
+// clientName identifies the client at the other end of conn.
+func clientName(conn net.Conn) string {
+	if tc, ok := conn.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		if len(cs.VerifiedChains) > 0 && len(cs.VerifiedChains[0]) > 0 {
+			return cs.VerifiedChains[0][0].Subject.CommonName
+		}
+	}
+	return addrName(conn.RemoteAddr())
+}
+
+// addrName returns the IP address of addr without its port.
+func addrName(addr net.Addr) string {
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
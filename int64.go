@@ -3,9 +3,15 @@ package journal
 import (
 	"bytes"
 	"encoding/binary"
+	"io"
 	"math"
 )
 
+var (
+	_ ValueType = (*Int64ValueType)(nil)
+	_ Values    = Int64Values(nil)
+)
+
 // Int64ValueType implements ValueType and defines the characteristics
 // of dealing with marshaling int64 values.  Int64 values are stored
 // on disk with Little Endian encoding.
@@ -43,15 +49,19 @@ func (t *Int64ValueType) Null() []byte {
 }
 
 // Decode takes a byte slice presumably read from disk and decodes into
-// a slice of int64 using Little Endian encoding.
+// a slice of int64 using Little Endian encoding.  If ZeroCopy is set the
+// result may share storage with buffer.
 func (t *Int64ValueType) Decode(buffer []byte) Values {
-	ints := make([]int64, int32(len(buffer))/t.Width())
-	buf := bytes.NewBuffer(buffer)
-	err := binary.Read(buf, binary.LittleEndian, ints)
-	if err != nil {
-		return nil
-	}
-	return Int64Values(ints)
+	return Int64Values(decodeInt64(buffer))
+}
+
+// DecodeStream reads and decodes n int64s from r.
+func (t *Int64ValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(Int64Values, 0, n)
+	err := readChunks(r, n, t.Width(), func(chunk []byte) {
+		values = append(values, decodeInt64(chunk)...)
+	})
+	return values, err
 }
 
 // Int64Values implements Values and wraps a int64 slice.
@@ -60,15 +70,30 @@ type Int64Values []int64
 // Encode will encode (Little Endian) the int64 slice to a byte slice for
 // writing to disk.
 func (v Int64Values) Encode() []byte {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, []int64(v))
-	if err != nil {
-		return nil
+	return v.EncodeTo(make([]byte, 0, len(v)*8))
+}
+
+// EncodeTo appends the Little Endian encoding of the int64 slice to dst.
+func (v Int64Values) EncodeTo(dst []byte) []byte {
+	for _, i := range v {
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(i))
 	}
-	return buf.Bytes()
+	return dst
+}
+
+// WriteTo writes the Little Endian encoding of the int64 slice to w.
+func (v Int64Values) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
 }
 
 // Len returns the length of the int64 slice
 func (v Int64Values) Len() int {
 	return len(v)
 }
+
+// Slice returns the int64 slice from index i up to j without copying.
+func (v Int64Values) Slice(i, j int) Values {
+	return v[i:j]
+}
@@ -0,0 +1,195 @@
+// Package lease elects one of several processes sharing a store to be
+// active, by holding a lease recorded in a file on the shared storage,
+// so that a standby can take over the store when the active process
+// fails.  The lease file is only read and written under an exclusive
+// flock, and a holder must renew its lease before it expires.  A holder
+// that cannot renew in time must stop using the store before another
+// process can acquire the lease and open its journals.
+package lease
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/lock"
+)
+
+// DefaultTTL is how long a lease lasts without being renewed when a
+// Lease has no TTL.
+const DefaultTTL = 30 * time.Second
+
+// ErrLost is returned by Renew and Keep when another process holds the
+// lease.
+var ErrLost = errors.New("Lease is held by another process")
+
+// Lease is one process's claim on a lease file.
+type Lease struct {
+	// Path is the lease file, on storage shared by every candidate.
+	Path string
+
+	// Holder identifies this process in the lease file.  Empty uses the
+	// host name and process ID.
+	Holder string
+
+	// TTL is how long the lease lasts after it is renewed.
+	TTL time.Duration
+
+	// Clock, if set, is used in place of the system clock.  Candidates
+	// must agree on the time to within a fraction of the TTL.
+	Clock clock.Clock
+
+	expires time.Time
+}
+
+// record is the content of the lease file.
+type record struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l *Lease) holder() string {
+	if l.Holder == "" {
+		host, _ := os.Hostname()
+		l.Holder = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	return l.Holder
+}
+
+func (l *Lease) ttl() time.Duration {
+	if l.TTL <= 0 {
+		return DefaultTTL
+	}
+	return l.TTL
+}
+
+// Interval is how often Keep renews the lease and a candidate should
+// retry TryAcquire: a third of the TTL.
+func (l *Lease) Interval() time.Duration {
+	return l.ttl() / 3
+}
+
+// Expires returns when the lease expires unless it is renewed, or the
+// zero time if it is not held.
+func (l *Lease) Expires() time.Time {
+	return l.expires
+}
+
+// TryAcquire acquires the lease if no other process holds it, or its
+// holder let it expire, and reports whether it did.
+func (l *Lease) TryAcquire() (bool, error) {
+	acquired := false
+	err := l.update(func(r *record, now time.Time) bool {
+		if r.Holder != l.holder() && now.Before(r.Expires) {
+			return false
+		}
+		r.Holder, r.Expires = l.holder(), now.Add(l.ttl())
+		acquired = true
+		return true
+	})
+	return acquired, err
+}
+
+// Renew extends the lease by its TTL.  It returns ErrLost if another
+// process holds the lease.
+func (l *Lease) Renew() error {
+	err := l.update(func(r *record, now time.Time) bool {
+		if r.Holder != l.holder() {
+			return false
+		}
+		r.Expires = now.Add(l.ttl())
+		return true
+	})
+	if err == nil && l.expires.IsZero() {
+		err = ErrLost
+	}
+	return err
+}
+
+// Release gives up the lease so that a standby can acquire it without
+// waiting for it to expire.
+func (l *Lease) Release() error {
+	return l.update(func(r *record, now time.Time) bool {
+		if r.Holder != l.holder() {
+			return false
+		}
+		r.Expires = time.Time{}
+		return true
+	})
+}
+
+// Keep renews the lease every Interval until stop is closed, returning
+// nil, or until the lease is lost.  Errors renewing are retried while
+// there is time, and the lease is lost when it would expire before the
+// next attempt, leaving its holder an Interval to stop before another
+// process can acquire it.
+func (l *Lease) Keep(stop <-chan struct{}) error {
+	ticker := time.NewTicker(l.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+		expires := l.expires
+		err := l.Renew()
+		switch {
+		case err == nil:
+		case err == ErrLost:
+			return err
+		case !clock.Or(l.Clock).Now().Add(l.Interval()).Before(expires):
+			return fmt.Errorf("Lease expiring: %s", err)
+		}
+	}
+}
+
+// update calls fn with the lease file's record while holding an exclusive
+// lock on it, and writes the record back if fn returns true.  A missing or
+// unreadable record is an expired lease.  The Lease's expiry is updated to
+// the record's if this process holds it and cleared otherwise.
+func (l *Lease) update(fn func(r *record, now time.Time) bool) error {
+	fd, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if err = lock.Exclusive(fd); err != nil {
+		return err
+	}
+
+	var r record
+	buf, err := io.ReadAll(fd)
+	if err != nil {
+		return err
+	}
+	if json.Unmarshal(buf, &r) != nil {
+		r = record{}
+	}
+	if fn(&r, clock.Or(l.Clock).Now()) {
+		if buf, err = json.Marshal(r); err != nil {
+			return err
+		}
+		if err = fd.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = fd.WriteAt(append(buf, '\n'), 0); err != nil {
+			return err
+		}
+		if err = fd.Sync(); err != nil {
+			return err
+		}
+	}
+
+	l.expires = time.Time{}
+	if r.Holder == l.holder() {
+		l.expires = r.Expires
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package lease
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+)
+
+func TestLease(t *testing.T) {
+	path := "/tmp/test-lease.json"
+	os.Remove(path)
+	c := clock.NewFake(time.Unix(1449240540, 0))
+	a := &Lease{Path: path, Holder: "a", TTL: 30 * time.Second, Clock: c}
+	b := &Lease{Path: path, Holder: "b", TTL: 30 * time.Second, Clock: c}
+
+	if ok, err := a.TryAcquire(); !ok || err != nil {
+		t.Fatalf("First TryAcquire failed: %v", err)
+	}
+	if ok, _ := b.TryAcquire(); ok {
+		t.Errorf("Acquired a held lease")
+	}
+	c.Advance(20 * time.Second)
+	if err := a.Renew(); err != nil || !a.Expires().Equal(c.Now().Add(30*time.Second)) {
+		t.Errorf("Renew expires at %s: %v", a.Expires(), err)
+	}
+	c.Advance(20 * time.Second)
+	if ok, _ := b.TryAcquire(); ok {
+		t.Errorf("Acquired a renewed lease")
+	}
+
+	// An expired lease is taken over and its old holder loses it
+	c.Advance(11 * time.Second)
+	if ok, err := b.TryAcquire(); !ok || err != nil {
+		t.Fatalf("TryAcquire of an expired lease failed: %v", err)
+	}
+	if err := a.Renew(); err != ErrLost || !a.Expires().IsZero() {
+		t.Errorf("Renew of a lost lease returned %v", err)
+	}
+
+	// A released lease is acquired at once
+	if err := b.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := a.TryAcquire(); !ok {
+		t.Errorf("TryAcquire of a released lease failed")
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	if err := a.Keep(stop); err != nil {
+		t.Errorf("Keep returned %v", err)
+	}
+}
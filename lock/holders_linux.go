@@ -0,0 +1,66 @@
+package lock
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ProcLocks is the kernel's table of active file locks.
+const ProcLocks = "/proc/locks"
+
+// Holders reports the processes currently holding a lock on the file at
+// the given path by consulting /proc/locks.  Processes waiting to obtain
+// a lock are not reported.  A nil slice is returned if the file is not
+// locked.
+func Holders(path string) ([]Holder, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return nil, err
+	}
+	dev := fmt.Sprintf("%02x:%02x:%d", major(uint64(st.Dev)),
+		minor(uint64(st.Dev)), st.Ino)
+
+	fd, err := os.Open(ProcLocks)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var holders []Holder
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		// 1: FLOCK  ADVISORY  WRITE 2906 fe:00:15933441 0 EOF
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[1] == "->" {
+			// Malformed or a blocked waiter
+			continue
+		}
+		if fields[5] != dev {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil {
+			pid = -1
+		}
+		holders = append(holders, Holder{
+			PID:       pid,
+			Kind:      fields[1],
+			Exclusive: fields[3] == "WRITE",
+		})
+	}
+
+	return holders, scanner.Err()
+}
+
+// major and minor decode a Linux dev_t as glibc does.
+func major(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) &^ 0xfff)
+}
+
+func minor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) &^ 0xff)
+}
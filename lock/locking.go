@@ -1,12 +1,21 @@
-// Package locking provides simple Flock based file locking utilities
+// Package lock provides simple Flock based file locking utilities
 // designed for synchronization around files on a single system.
 package lock
 
 import (
+	"io"
 	"os"
+	"path/filepath"
 	"syscall"
 )
 
+// Holder describes a process holding a lock on a file.
+type Holder struct {
+	PID       int    // process ID or -1 if not known
+	Kind      string // FLOCK, POSIX, or OFDLCK
+	Exclusive bool   // true for exclusive (write) locks
+}
+
 // Exclusive attempts to obtain an exclusive lock on the open file
 // descriptor.  This will block until the lock can be obtained.
 func Exclusive(file *os.File) error {
@@ -67,3 +76,71 @@ func IsResourceUnavailable(err error) bool {
 
 	return false
 }
+
+// IsLocked reports whether any other open file descriptor holds a lock on
+// the file at the given path.  The file is briefly opened read-only and
+// a non-blocking exclusive lock is attempted.
+func IsLocked(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	err = TryExclusive(file)
+	if IsResourceUnavailable(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// Break forcibly removes all locks on the file at the given path.  Flock
+// based locks belong to an inode and cannot be revoked, so the contents are
+// copied to a new file in the same directory which is then renamed over the
+// original.  Any process still holding the old file open continues to
+// write to the now unlinked inode and those writes are lost.  This is only
+// suitable for cleaning up after crashed or hung writers.
+func Break(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path),
+		"."+filepath.Base(path)+".unlock")
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err2 := dst.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// Make the rename durable
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
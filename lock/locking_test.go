@@ -1,8 +1,10 @@
 package lock
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"testing"
 )
 
@@ -99,3 +101,81 @@ func TestRelease(t *testing.T) {
 	file2.Close()
 	file.Close()
 }
+
+func TestIsLocked(t *testing.T) {
+	file, err := ioutil.TempFile("/tmp", "locking_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	locked, err := IsLocked(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked {
+		t.Errorf("Unlocked file reported as locked")
+	}
+
+	err = Share(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err = IsLocked(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked {
+		t.Errorf("Locked file reported as unlocked")
+	}
+
+	holders, err := Holders(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "linux" {
+		if len(holders) != 1 || holders[0].PID != os.Getpid() {
+			t.Errorf("Expected this process as the lock holder: %v", holders)
+		} else if holders[0].Exclusive {
+			t.Errorf("Shared lock reported as exclusive")
+		}
+	}
+}
+
+func TestBreak(t *testing.T) {
+	file, err := ioutil.TempFile("/tmp", "locking_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	data := []byte("some data")
+	file.Write(data)
+	err = Exclusive(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Break(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked, err := IsLocked(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked {
+		t.Errorf("File is still locked after Break")
+	}
+
+	buf, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Errorf("File contents changed by Break: %q", buf)
+	}
+}
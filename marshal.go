@@ -0,0 +1,229 @@
+package journal
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = Float64Values(nil)
+	_ encoding.BinaryUnmarshaler = (*Float64Values)(nil)
+	_ json.Marshaler             = Float64Values(nil)
+	_ json.Unmarshaler           = (*Float64Values)(nil)
+	_ encoding.BinaryMarshaler   = Float32Values(nil)
+	_ encoding.BinaryUnmarshaler = (*Float32Values)(nil)
+	_ json.Marshaler             = Float32Values(nil)
+	_ json.Unmarshaler           = (*Float32Values)(nil)
+	_ encoding.BinaryMarshaler   = Int64Values(nil)
+	_ encoding.BinaryUnmarshaler = (*Int64Values)(nil)
+	_ json.Marshaler             = Int64Values(nil)
+	_ json.Unmarshaler           = (*Int64Values)(nil)
+	_ encoding.BinaryMarshaler   = ByteValues(nil)
+	_ encoding.BinaryUnmarshaler = (*ByteValues)(nil)
+)
+
+// The Values types are registered with gob so that they can be sent as
+// the Values interface.
+func init() {
+	gob.Register(Float64Values(nil))
+	gob.Register(Float32Values(nil))
+	gob.Register(Int64Values(nil))
+	gob.Register(ByteValues(nil))
+	gob.Register(HistogramValues(nil))
+	gob.Register(SketchValues(nil))
+	gob.Register(BitfieldValues(nil))
+	gob.Register(UUIDValues(nil))
+}
+
+// The binary form of the fixed width numeric Values is their on disk
+// encoding.  Their JSON form is an array of numbers with nulls, NaN or
+// math.MinInt64, as JSON null.  Infinities have no JSON form.
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (v Float64Values) MarshalBinary() ([]byte, error) {
+	return v.Encode(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (v *Float64Values) UnmarshalBinary(data []byte) error {
+	if len(data)%8 != 0 {
+		return fmt.Errorf("Float64Values encoding is %d bytes", len(data))
+	}
+	*v = make(Float64Values, len(data)/8)
+	for i := range *v {
+		(*v)[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v Float64Values) MarshalJSON() ([]byte, error) {
+	return marshalFloats(len(v), 64, func(i int) float64 { return v[i] })
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Float64Values) UnmarshalJSON(data []byte) error {
+	floats, err := unmarshalFloats(data)
+	*v = Float64Values(floats)
+	return err
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (v Float32Values) MarshalBinary() ([]byte, error) {
+	return v.Encode(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (v *Float32Values) UnmarshalBinary(data []byte) error {
+	if len(data)%4 != 0 {
+		return fmt.Errorf("Float32Values encoding is %d bytes", len(data))
+	}
+	*v = make(Float32Values, len(data)/4)
+	for i := range *v {
+		(*v)[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v Float32Values) MarshalJSON() ([]byte, error) {
+	return marshalFloats(len(v), 32, func(i int) float64 { return float64(v[i]) })
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Float32Values) UnmarshalJSON(data []byte) error {
+	floats, err := unmarshalFloats(data)
+	*v = make(Float32Values, len(floats))
+	for i, f := range floats {
+		(*v)[i] = float32(f)
+	}
+	return err
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (v Int64Values) MarshalBinary() ([]byte, error) {
+	return v.Encode(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (v *Int64Values) UnmarshalBinary(data []byte) error {
+	if len(data)%8 != 0 {
+		return fmt.Errorf("Int64Values encoding is %d bytes", len(data))
+	}
+	*v = make(Int64Values, len(data)/8)
+	for i := range *v {
+		(*v)[i] = int64(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v Int64Values) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 0, 2+len(v)*8)
+	buf = append(buf, '[')
+	for i, x := range v {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if x == math.MinInt64 {
+			buf = append(buf, "null"...)
+		} else {
+			buf = strconv.AppendInt(buf, x, 10)
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Int64Values) UnmarshalJSON(data []byte) error {
+	var ints []*int64
+	if err := json.Unmarshal(data, &ints); err != nil {
+		return err
+	}
+	*v = make(Int64Values, len(ints))
+	for i, x := range ints {
+		if x == nil {
+			(*v)[i] = math.MinInt64
+		} else {
+			(*v)[i] = *x
+		}
+	}
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.  Records may differ
+// in length so each is preceded by its length as a uvarint, after the
+// number of records.
+func (v ByteValues) MarshalBinary() ([]byte, error) {
+	buf := binary.AppendUvarint(nil, uint64(len(v)))
+	for _, b := range v {
+		buf = binary.AppendUvarint(buf, uint64(len(b)))
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.  The records are
+// copied out of data.
+func (v *ByteValues) UnmarshalBinary(data []byte) error {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)) {
+		return fmt.Errorf("Corrupt ByteValues encoding")
+	}
+	data = data[size:]
+	values := make(ByteValues, n)
+	for i := range values {
+		l, size := binary.Uvarint(data)
+		if size <= 0 || l > uint64(len(data)-size) {
+			return fmt.Errorf("Corrupt ByteValues encoding")
+		}
+		values[i] = append([]byte(nil), data[size:size+int(l)]...)
+		data = data[size+int(l):]
+	}
+	*v = values
+	return nil
+}
+
+// marshalFloats encodes n floats of the given bit size as a JSON array.
+func marshalFloats(n, bits int, at func(i int) float64) ([]byte, error) {
+	buf := make([]byte, 0, 2+n*8)
+	buf = append(buf, '[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		f := at(i)
+		switch {
+		case math.IsNaN(f):
+			buf = append(buf, "null"...)
+		case math.IsInf(f, 0):
+			return nil, &json.UnsupportedValueError{Str: strconv.FormatFloat(f, 'g', -1, bits)}
+		default:
+			buf = strconv.AppendFloat(buf, f, 'g', -1, bits)
+		}
+	}
+	return append(buf, ']'), nil
+}
+
+// unmarshalFloats decodes a JSON array of numbers and nulls with nulls
+// as NaN.
+func unmarshalFloats(data []byte) ([]float64, error) {
+	var ptrs []*float64
+	if err := json.Unmarshal(data, &ptrs); err != nil {
+		return nil, err
+	}
+	floats := make([]float64, len(ptrs))
+	for i, f := range ptrs {
+		if f == nil {
+			floats[i] = math.NaN()
+		} else {
+			floats[i] = *f
+		}
+	}
+	return floats, nil
+}
@@ -0,0 +1,153 @@
+// Package promimport migrates series from Prometheus local storage into a
+// journal store.  Prometheus samples have millisecond timestamps and
+// arbitrary label sets; each label set is mapped to a dotted series name
+// and its samples are aligned to the interval of the series' journal, the
+// latest sample in each interval winning.
+//
+// Reading TSDB blocks requires the upstream Prometheus tsdb packages,
+// which this repository does not depend on.  Instead a block is read
+// through the small SeriesSet interface, which mirrors tsdb's
+// storage.SeriesSet and is simple to adapt from a querier over a block
+// opened with tsdb.OpenBlock in a separate migration program.
+package promimport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/ingest"
+	"github.com/jjneely/journal/writer"
+)
+
+// Labels are the labels of a Prometheus series, including the metric
+// name under the __name__ label.
+type Labels map[string]string
+
+// Sample is one Prometheus sample.
+type Sample struct {
+	T int64 // Unix time in milliseconds
+	V float64
+}
+
+// Series is a Prometheus series and its samples in time order.
+type Series interface {
+	Labels() Labels
+	Samples() ([]Sample, error)
+}
+
+// SeriesSet iterates over the series of a block.
+type SeriesSet interface {
+	Next() bool
+	At() Series
+	Err() error
+}
+
+// Mapper converts the labels of a series to the name of a journal series.
+type Mapper func(labels Labels) (string, error)
+
+// DefaultMapper names a series by its metric name followed by a node for
+// the name and value of each other label in label name order, e.g.
+// http_requests_total.code.200.method.get.  Dots and slashes in label
+// values are replaced with underscores.
+func DefaultMapper(labels Labels) (string, error) {
+	name := labels["__name__"]
+	if name == "" {
+		return "", fmt.Errorf("Series has no metric name: %v", labels)
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "__name__" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	nodes := []string{sanitize(name)}
+	for _, k := range keys {
+		if v := labels[k]; v != "" {
+			nodes = append(nodes, sanitize(k), sanitize(v))
+		}
+	}
+	return strings.Join(nodes, "."), nil
+}
+
+var sanitizer = strings.NewReplacer(".", "_", "/", "_", "@", "_", "\x00", "_")
+
+func sanitize(s string) string {
+	return sanitizer.Replace(s)
+}
+
+// Importer writes the series of a SeriesSet to a store through a Writer,
+// which creates journals for new series as described by the store's
+// Schema.
+type Importer struct {
+	Writer *writer.Writer
+
+	// Mapper names the journal series of each Prometheus series.  Nil
+	// uses DefaultMapper.
+	Mapper Mapper
+
+	// OnError, if set, is called for each series that cannot be imported,
+	// which is then skipped.
+	OnError func(labels Labels, err error)
+}
+
+// Result counts the outcome of an Import.
+type Result struct {
+	Series  int // series imported
+	Samples int // samples handed to the Writer
+	Skipped int // series that failed
+}
+
+// Import reads every series of set and writes its samples, flushing the
+// Writer whenever it is full and once at the end.  Write errors are
+// reported by the Writer's OnError.
+func (im *Importer) Import(set SeriesSet) (Result, error) {
+	var r Result
+	mapper := im.Mapper
+	if mapper == nil {
+		mapper = DefaultMapper
+	}
+
+	for set.Next() {
+		s := set.At()
+		n, err := im.series(s, mapper)
+		r.Samples += n
+		if err != nil {
+			r.Skipped++
+			if im.OnError != nil {
+				im.OnError(s.Labels(), err)
+			}
+			continue
+		}
+		r.Series++
+	}
+	im.Writer.Flush()
+	return r, set.Err()
+}
+
+func (im *Importer) series(s Series, mapper Mapper) (int, error) {
+	name, err := mapper(s.Labels())
+	if err != nil {
+		return 0, err
+	}
+	samples, err := s.Samples()
+	if err != nil {
+		return 0, err
+	}
+	for i, sample := range samples {
+		p := ingest.Point{Series: name, Timestamp: sample.T / 1000, Value: sample.V}
+		err = im.Writer.Add(p)
+		if err == writer.ErrFull {
+			im.Writer.Flush()
+			err = im.Writer.Add(p)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(samples), nil
+}
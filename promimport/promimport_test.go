@@ -0,0 +1,75 @@
+package promimport
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/writer"
+)
+
+type series struct {
+	labels  Labels
+	samples []Sample
+}
+
+func (s series) Labels() Labels             { return s.labels }
+func (s series) Samples() ([]Sample, error) { return s.samples, nil }
+
+type seriesSet struct {
+	series []series
+	i      int
+}
+
+func (s *seriesSet) Next() bool { s.i++; return s.i <= len(s.series) }
+func (s *seriesSet) At() Series { return s.series[s.i-1] }
+func (s *seriesSet) Err() error { return nil }
+
+func TestDefaultMapper(t *testing.T) {
+	name, err := DefaultMapper(Labels{"__name__": "up", "job": "node", "instance": "10.0.0.1:9100", "empty": ""})
+	if err != nil || name != "up.instance.10_0_0_1:9100.job.node" {
+		t.Errorf("DefaultMapper returned %q: %v", name, err)
+	}
+	if _, err = DefaultMapper(Labels{"job": "node"}); err == nil {
+		t.Errorf("DefaultMapper accepted a series without a name")
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-promimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.New(dir)
+	s.Schema = func(string) (int64, ValueType, error) {
+		return 60, NewFloat64ValueType(), nil
+	}
+	w := writer.New(s)
+	w.MaxPending = 2
+
+	set := &seriesSet{series: []series{
+		{Labels{"__name__": "up", "job": "a"}, []Sample{
+			{1449240540000, 1}, {1449240555000, 2}, {1449240600000, 3}, {1449240720000, 5}}},
+		{Labels{"job": "nameless"}, nil},
+	}}
+	var failed []Labels
+	im := &Importer{Writer: w, OnError: func(l Labels, err error) { failed = append(failed, l) }}
+	r, err := im.Import(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Series != 1 || r.Samples != 4 || r.Skipped != 1 || len(failed) != 1 {
+		t.Errorf("Import returned %+v", r)
+	}
+
+	got := s.ReadMany([]string{"up.job.a"}, 0, 1449241000)["up.job.a"]
+	if fmt.Sprint(got.Values) != "[2 3 NaN 5]" || got.Start != 1449240540 {
+		t.Errorf("Imported %v from %d: %v", got.Values, got.Start, got.Err)
+	}
+}
@@ -0,0 +1,93 @@
+package query
+
+import (
+	"fmt"
+	"math"
+)
+
+import (
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// FromJournal reads the range from through until inclusive of a journal
+// as a Series with the given name.
+func FromJournal(name string, j timeseries.Journal, from, until int64) (*Series, error) {
+	values, err := j.ReadRange(from, until)
+	if err != nil {
+		return nil, err
+	}
+	floats, err := rollup.Floats(values)
+	if err != nil {
+		return nil, err
+	}
+
+	start := from - from%j.Interval()
+	if start < j.Epoch() {
+		start = j.Epoch()
+	}
+	return &Series{Name: name, Start: start, Step: j.Interval(), Values: floats}, nil
+}
+
+// LCM returns the least common multiple of the steps of the series.
+func LCM(series ...*Series) int64 {
+	lcm := int64(1)
+	for _, s := range series {
+		a, b := lcm, s.Step
+		for b != 0 {
+			a, b = b, a%b
+		}
+		lcm = lcm / a * s.Step
+	}
+	return lcm
+}
+
+// Align re-grids series with differing steps and starts onto a common
+// time axis so that their values can be combined point by point.  The
+// returned series all share the given step, or the LCM of their steps if
+// step is 0, and cover the same range, padded with nulls.  Values are
+// consolidated into coarser steps with fn, ignoring nulls; a finer step
+// repeats each value for every point it covers.
+func Align(step int64, fn rollup.Aggregator, series ...*Series) ([]*Series, error) {
+	if len(series) == 0 {
+		return nil, nil
+	}
+	for _, s := range series {
+		if s.Step <= 0 {
+			return nil, fmt.Errorf("Series %s has invalid step %d", s.Name, s.Step)
+		}
+	}
+	if step == 0 {
+		step = LCM(series...)
+	} else if step < 0 {
+		return nil, fmt.Errorf("Invalid step %d", step)
+	}
+
+	start, end := series[0].Start, series[0].End()
+	for _, s := range series[1:] {
+		if s.Start < start {
+			start = s.Start
+		}
+		if s.End() > end {
+			end = s.End()
+		}
+	}
+	start -= start % step
+	if end%step != 0 {
+		end += step - end%step
+	}
+
+	out := make([]*Series, len(series))
+	for i, s := range series {
+		values := rollup.Downsample(s.Values, s.Start, s.Step, start, end, step, fn, 0)
+		if s.Step > step {
+			for b := range values {
+				if math.IsNaN(values[b]) {
+					values[b] = s.At(start + int64(b)*step)
+				}
+			}
+		}
+		out[i] = &Series{Name: s.Name, Start: start, Step: step, Values: values}
+	}
+	return out, nil
+}
@@ -0,0 +1,65 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func TestAlign(t *testing.T) {
+	a := &Series{Name: "a", Start: 60, Step: 60, Values: []float64{1, 2, 3, 4, math.NaN(), 6}}
+	b := &Series{Name: "b", Start: 0, Step: 90, Values: []float64{10, 20, 30}}
+
+	if lcm := LCM(a, b); lcm != 180 {
+		t.Errorf("LCM of 60 and 90 is %d", lcm)
+	}
+
+	out, err := Align(0, rollup.Sum, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%d %d %v %v", out[0].Start, out[0].Step, out[0].Values, out[1].Values)
+	if want := "0 180 [3 7 6] [30 30 NaN]"; got != want {
+		t.Errorf("Align to LCM = %s, want %s", got, want)
+	}
+
+	// A finer step repeats the coarser series' values
+	out, err = Align(30, rollup.Average, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = fmt.Sprint(out[0].Values)
+	if want := "[10 10 10 20 20 20 30 30 30]"; got != want {
+		t.Errorf("Align to a finer step = %s, want %s", got, want)
+	}
+
+	if _, err = Align(0, rollup.Sum, &Series{Name: "bad"}); err == nil {
+		t.Errorf("Align of a series without a step did not fail")
+	}
+}
+
+func TestFromJournal(t *testing.T) {
+	path := "/tmp/test-fromjournal.tsj"
+	os.Remove(path)
+	j, err := timeseries.Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(epoch, Int64Values{1, 2, 3})
+
+	s, err := FromJournal("x", j, epoch-120, epoch+60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Start != epoch || s.Step != 60 || fmt.Sprint(s.Values) != "[1 2]" {
+		t.Errorf("FromJournal returned %+v", s)
+	}
+}
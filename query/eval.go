@@ -0,0 +1,313 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/store"
+)
+
+// Series is a named sequence of values, one every Step time units from
+// Start.  Null values are NaN.
+type Series struct {
+	Name   string
+	Start  int64
+	Step   int64
+	Values []float64
+}
+
+// End returns the timestamp after the last value of the series.
+func (s *Series) End() int64 {
+	return s.Start + int64(len(s.Values))*s.Step
+}
+
+// At returns the value at timestamp ts, or NaN if it is out of range.
+func (s *Series) At(ts int64) float64 {
+	if ts < s.Start || ts >= s.End() {
+		return math.NaN()
+	}
+	return s.Values[(ts-s.Start)/s.Step]
+}
+
+// Func implements a function of the expression language.  It receives
+// its arguments unevaluated so that it may evaluate them over a
+// different time range, as timeShift does.
+type Func func(c *Context, args []Expr) ([]*Series, error)
+
+// Funcs are the functions available to expressions by name.
+var Funcs map[string]Func
+
+func init() {
+	Funcs = map[string]Func{
+		"sumSeries":     combine("sumSeries", rollup.Sum),
+		"sum":           combine("sum", rollup.Sum),
+		"averageSeries": combine("averageSeries", rollup.Average),
+		"avg":           combine("avg", rollup.Average),
+		"scale":         scale,
+		"movingAverage": movingAverage,
+		"timeShift":     timeShift,
+	}
+}
+
+// Context evaluates expressions over a store for the time range from
+// through until inclusive.
+type Context struct {
+	Store *store.Store
+	From  int64
+	Until int64
+}
+
+// Eval evaluates an expression to a list of series.
+func (c *Context) Eval(e Expr) ([]*Series, error) {
+	switch e := e.(type) {
+	case Path:
+		return c.fetch(string(e))
+	case *Call:
+		fn, ok := Funcs[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown function: %s", e.Name)
+		}
+		return fn(c, e.Args)
+	}
+	return nil, fmt.Errorf("Expected series but found %s", e)
+}
+
+// fetch reads the series matching a path expression.  Series that do not
+// exist are ignored.
+func (c *Context) fetch(pattern string) ([]*Series, error) {
+	names := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		if names, err = c.Store.Find(pattern); err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+	} else if err := store.Validate(pattern); err != nil {
+		return nil, err
+	}
+
+	var out []*Series
+	results := c.Store.ReadMany(names, c.From, c.Until)
+	for _, name := range names {
+		result := results[name]
+		if os.IsNotExist(result.Err) {
+			continue
+		}
+		var floats []float64
+		if result.Err == nil {
+			floats, result.Err = rollup.Floats(result.Values)
+		}
+		if result.Err != nil {
+			return nil, fmt.Errorf("%s: %s", name, result.Err)
+		}
+		out = append(out, &Series{
+			Name:   name,
+			Start:  result.Start,
+			Step:   result.Interval,
+			Values: floats,
+		})
+	}
+	return out, nil
+}
+
+// series evaluates every argument as a list of series.
+func (c *Context) series(args []Expr) ([]*Series, error) {
+	var out []*Series
+	for _, a := range args {
+		s, err := c.Eval(a)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s...)
+	}
+	return out, nil
+}
+
+func number(e Expr) (float64, error) {
+	n, ok := e.(Number)
+	if !ok {
+		return 0, fmt.Errorf("Expected a number but found %s", e)
+	}
+	return float64(n), nil
+}
+
+func argc(name string, args []Expr, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("%s takes %d arguments", name, n)
+	}
+	return nil
+}
+
+// combine returns a Func that aggregates all of its argument series into
+// one with fn, ignoring nulls.  Series with differing steps are first
+// aligned to the LCM of their steps by averaging.
+func combine(name string, fn rollup.Aggregator) Func {
+	return func(c *Context, args []Expr) ([]*Series, error) {
+		in, err := c.series(args)
+		if err != nil || len(in) == 0 {
+			return nil, err
+		}
+		in, err = Align(0, rollup.Average, in...)
+		if err != nil {
+			return nil, err
+		}
+
+		out := &Series{
+			Name:   (&Call{Name: name, Args: args}).String(),
+			Start:  in[0].Start,
+			Step:   in[0].Step,
+			Values: make([]float64, len(in[0].Values)),
+		}
+		bucket := make([]float64, 0, len(in))
+		for i := range out.Values {
+			bucket = bucket[:0]
+			for _, s := range in {
+				if v := s.Values[i]; !math.IsNaN(v) {
+					bucket = append(bucket, v)
+				}
+			}
+			if len(bucket) == 0 {
+				out.Values[i] = math.NaN()
+			} else {
+				out.Values[i] = fn(bucket)
+			}
+		}
+		return []*Series{out}, nil
+	}
+}
+
+// scale(series, factor) multiplies every value by factor.
+func scale(c *Context, args []Expr) ([]*Series, error) {
+	if err := argc("scale", args, 2); err != nil {
+		return nil, err
+	}
+	factor, err := number(args[1])
+	if err != nil {
+		return nil, err
+	}
+	in, err := c.Eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range in {
+		s.Name = fmt.Sprintf("scale(%s,%s)", s.Name, args[1])
+		for i := range s.Values {
+			s.Values[i] *= factor
+		}
+	}
+	return in, nil
+}
+
+// movingAverage(series, points) replaces each value with the average of
+// the non-null values in the window of that many points ending with it.
+func movingAverage(c *Context, args []Expr) ([]*Series, error) {
+	if err := argc("movingAverage", args, 2); err != nil {
+		return nil, err
+	}
+	n, err := number(args[1])
+	if err != nil {
+		return nil, err
+	}
+	window := int(n)
+	if window < 1 {
+		return nil, fmt.Errorf("movingAverage window must be at least 1")
+	}
+	in, err := c.Eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range in {
+		s.Name = fmt.Sprintf("movingAverage(%s,%s)", s.Name, args[1])
+		out := make([]float64, len(s.Values))
+		sum, count := 0.0, 0
+		for i, v := range s.Values {
+			if !math.IsNaN(v) {
+				sum += v
+				count++
+			}
+			if i >= window {
+				if old := s.Values[i-window]; !math.IsNaN(old) {
+					sum -= old
+					count--
+				}
+			}
+			if count == 0 {
+				out[i] = math.NaN()
+			} else {
+				out[i] = sum / float64(count)
+			}
+		}
+		s.Values = out
+	}
+	return in, nil
+}
+
+// timeShift(series, "1d") draws the series from the given time earlier,
+// or later with a leading "+", and moves it onto the current time range.
+func timeShift(c *Context, args []Expr) ([]*Series, error) {
+	if err := argc("timeShift", args, 2); err != nil {
+		return nil, err
+	}
+	offset, ok := args[1].(String)
+	if !ok {
+		return nil, fmt.Errorf("timeShift offset must be a string")
+	}
+	shift, err := ParseOffset(string(offset))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(string(offset), "+") {
+		shift = -shift
+	}
+
+	shifted := *c
+	shifted.From += shift
+	shifted.Until += shift
+	in, err := shifted.Eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range in {
+		s.Name = fmt.Sprintf("timeShift(%s,%s)", s.Name, args[1])
+		s.Start -= shift
+	}
+	return in, nil
+}
+
+var units = []struct {
+	suffix  string
+	seconds int64
+}{
+	{"min", 60},
+	{"s", 1},
+	{"h", 3600},
+	{"d", 86400},
+	{"w", 7 * 86400},
+	{"y", 365 * 86400},
+}
+
+// ParseOffset parses a duration such as "1h", "-30min", or "+7d" into a
+// positive number of seconds.  The sign is ignored.
+func ParseOffset(s string) (int64, error) {
+	t := strings.TrimLeft(s, "+-")
+	for _, u := range units {
+		if !strings.HasSuffix(t, u.suffix) {
+			continue
+		}
+		n, err := strconv.ParseInt(t[:len(t)-len(u.suffix)], 10, 64)
+		if err != nil || n < 0 {
+			break
+		}
+		return n * u.seconds, nil
+	}
+	return 0, fmt.Errorf("Invalid time offset: %q", s)
+}
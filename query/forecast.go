@@ -0,0 +1,97 @@
+package query
+
+import (
+	"fmt"
+)
+
+import (
+	"github.com/jjneely/journal/analytics"
+)
+
+// bootstrap is how much history the Holt-Winters functions read before
+// the requested range to train the model, as Graphite does.
+const bootstrap = 7 * 86400
+
+func init() {
+	Funcs["holtWintersForecast"] = holtWintersForecast
+	Funcs["holtWintersConfidenceBands"] = holtWintersConfidenceBands
+}
+
+// holtWinters evaluates the series with a week of extra history and
+// returns each with its forecast trimmed back to the requested range.
+func holtWinters(c *Context, e Expr) ([]*Series, []analytics.Forecast, error) {
+	trained := *c
+	trained.From -= bootstrap
+	in, err := trained.Eval(e)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	forecasts := make([]analytics.Forecast, len(in))
+	for i, s := range in {
+		f := analytics.DefaultHoltWinters(s.Step).Analyze(s.Values)
+		skip := 0
+		if s.Start < c.From {
+			skip = int((c.From - s.Start + s.Step - 1) / s.Step)
+		}
+		if skip > len(s.Values) {
+			skip = len(s.Values)
+		}
+		s.Start += int64(skip) * s.Step
+		s.Values = s.Values[skip:]
+		forecasts[i] = analytics.Forecast{
+			Predictions: f.Predictions[skip:],
+			Deviations:  f.Deviations[skip:],
+		}
+	}
+	return in, forecasts, nil
+}
+
+// holtWintersForecast(series) replaces each series with its Holt-Winters
+// forecast.
+func holtWintersForecast(c *Context, args []Expr) ([]*Series, error) {
+	if err := argc("holtWintersForecast", args, 1); err != nil {
+		return nil, err
+	}
+	in, forecasts, err := holtWinters(c, args[0])
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range in {
+		s.Name = fmt.Sprintf("holtWintersForecast(%s)", s.Name)
+		s.Values = forecasts[i].Predictions
+	}
+	return in, nil
+}
+
+// holtWintersConfidenceBands(series[, delta]) replaces each series with
+// the lower and upper bounds of its forecast, delta deviations (default
+// 3) either side.
+func holtWintersConfidenceBands(c *Context, args []Expr) ([]*Series, error) {
+	delta := 3.0
+	switch len(args) {
+	case 2:
+		var err error
+		if delta, err = number(args[1]); err != nil {
+			return nil, err
+		}
+	case 1:
+	default:
+		return nil, fmt.Errorf("holtWintersConfidenceBands takes 1 or 2 arguments")
+	}
+	in, forecasts, err := holtWinters(c, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*Series
+	for i, s := range in {
+		lower, upper := forecasts[i].Bands(delta)
+		out = append(out,
+			&Series{Name: fmt.Sprintf("holtWintersConfidenceLower(%s)", s.Name),
+				Start: s.Start, Step: s.Step, Values: lower},
+			&Series{Name: fmt.Sprintf("holtWintersConfidenceUpper(%s)", s.Name),
+				Start: s.Start, Step: s.Step, Values: upper})
+	}
+	return out, nil
+}
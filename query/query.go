@@ -0,0 +1,163 @@
+// Package query evaluates a small subset of Graphite's target expression
+// language over the series of a store, for example
+//
+//	scale(sumSeries(servers.*.requests), 0.5)
+//	movingAverage(timeShift(servers.web01.cpu, "1d"), 5)
+//
+// Targets are parsed into an Expr tree by Parse and evaluated by a
+// Context, which reads the series named by path expressions with
+// store.ReadMany and applies the functions registered in Funcs.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed target expression.  Its String method returns the
+// expression in the syntax accepted by Parse, which is also used to name
+// computed series.
+type Expr interface {
+	String() string
+}
+
+// Path is a series name which may contain glob wildcards.
+type Path string
+
+func (p Path) String() string { return string(p) }
+
+// Number is a numeric literal argument.
+type Number float64
+
+func (n Number) String() string { return strconv.FormatFloat(float64(n), 'g', -1, 64) }
+
+// String is a quoted string literal argument.
+type String string
+
+func (s String) String() string { return strconv.Quote(string(s)) }
+
+// Call is a function applied to its arguments.
+type Call struct {
+	Name string
+	Args []Expr
+}
+
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = a.String()
+	}
+	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(args, ","))
+}
+
+// IsExpression reports whether target uses any function calls rather than
+// simply naming series.
+func IsExpression(target string) bool {
+	return strings.ContainsRune(target, '(')
+}
+
+// Parse parses a target expression.
+func Parse(target string) (Expr, error) {
+	p := &parser{s: target}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("Unexpected %q", p.s[p.pos:])
+	}
+	return e, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid target %q at %d: %s", p.s, p.pos,
+		fmt.Sprintf(format, args...))
+}
+
+func (p *parser) space() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// token characters are those allowed in series names and glob patterns.
+func token(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("._-*?[]!:+", c) >= 0
+}
+
+func (p *parser) expr() (Expr, error) {
+	p.space()
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("Unexpected end of target")
+	}
+	if c := p.s[p.pos]; c == '"' || c == '\'' {
+		return p.str(c)
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && token(p.s[p.pos]) {
+		p.pos++
+	}
+	tok := p.s[start:p.pos]
+	if tok == "" {
+		return nil, p.errorf("Unexpected %q", p.s[p.pos:p.pos+1])
+	}
+
+	p.space()
+	if p.pos < len(p.s) && p.s[p.pos] == '(' {
+		p.pos++
+		return p.call(tok)
+	}
+	if n, err := strconv.ParseFloat(tok, 64); err == nil {
+		return Number(n), nil
+	}
+	return Path(tok), nil
+}
+
+func (p *parser) str(quote byte) (Expr, error) {
+	end := strings.IndexByte(p.s[p.pos+1:], quote)
+	if end < 0 {
+		return nil, p.errorf("Unterminated string")
+	}
+	s := p.s[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return String(s), nil
+}
+
+func (p *parser) call(name string) (Expr, error) {
+	c := &Call{Name: name}
+	p.space()
+	if p.pos < len(p.s) && p.s[p.pos] == ')' {
+		p.pos++
+		return c, nil
+	}
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.Args = append(c.Args, arg)
+
+		p.space()
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("Missing )")
+		}
+		switch p.s[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return c, nil
+		default:
+			return nil, p.errorf("Expected , or ) but found %q", p.s[p.pos:p.pos+1])
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package query
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/store"
+)
+
+const epoch = int64(1449240540)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		target, want string
+	}{
+		{"a.b.c", "a.b.c"},
+		{"sumSeries(a.*.c, b.c)", "sumSeries(a.*.c,b.c)"},
+		{"scale( a.b , -0.5 )", "scale(a.b,-0.5)"},
+		{"timeShift(movingAverage(a.b,5),'1d')", `timeShift(movingAverage(a.b,5),"1d")`},
+		{"f()", "f()"},
+	}
+	for _, test := range tests {
+		e, err := Parse(test.target)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %s", test.target, err)
+		} else if e.String() != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.target, e, test.want)
+		}
+	}
+
+	for _, bad := range []string{"", "sum(a.b", "sum(a.b,)", "a.b)", `scale(a.b,"2)`} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) did not fail", bad)
+		}
+	}
+}
+
+func testContext(t *testing.T) *Context {
+	dir, err := ioutil.TempDir("/tmp", "journal-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.New(dir)
+	data := map[string]Float64Values{
+		"a.b": {1, 2, math.NaN(), 4},
+		"a.c": {10, math.NaN(), math.NaN(), 40},
+	}
+	for name, values := range data {
+		j, err := s.Create(name, 60, NewFloat64ValueType(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Write(epoch, values)
+		j.Close()
+	}
+	return &Context{Store: s, From: epoch, Until: epoch + 180}
+}
+
+func eval(t *testing.T, c *Context, target string) string {
+	e, err := Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := c.Eval(e)
+	if err != nil {
+		t.Fatalf("%s: %s", target, err)
+	}
+	out := ""
+	for _, s := range series {
+		out += fmt.Sprintf("%s@%d:%v ", s.Name, s.Start, s.Values)
+	}
+	return out
+}
+
+func TestEval(t *testing.T) {
+	c := testContext(t)
+	defer os.RemoveAll(c.Store.Root)
+
+	tests := []struct {
+		target, want string
+	}{
+		{"a.*", "a.b@1449240540:[1 2 NaN 4] a.c@1449240540:[10 NaN NaN 40] "},
+		{"sumSeries(a.*)", "sumSeries(a.*)@1449240540:[11 2 NaN 44] "},
+		{"avg(a.b,a.c)", "avg(a.b,a.c)@1449240540:[5.5 2 NaN 22] "},
+		{"scale(a.b,2)", "scale(a.b,2)@1449240540:[2 4 NaN 8] "},
+		{"movingAverage(a.b,2)", "movingAverage(a.b,2)@1449240540:[1 1.5 2 4] "},
+		{`timeShift(a.b,"1min")`, `timeShift(a.b,"1min")@1449240600:[1 2 NaN] `},
+		{"sumSeries(missing)", ""},
+	}
+	for _, test := range tests {
+		if got := eval(t, c, test.target); got != test.want {
+			t.Errorf("%s = %s, want %s", test.target, got, test.want)
+		}
+	}
+
+	for _, bad := range []string{"nope(a.b)", "scale(a.b)", "scale(a.b,a.c)", "sum(1)"} {
+		e, _ := Parse(bad)
+		if _, err := c.Eval(e); err == nil {
+			t.Errorf("%s did not fail", bad)
+		}
+	}
+}
+
+func TestCombineSteps(t *testing.T) {
+	c := testContext(t)
+	defer os.RemoveAll(c.Store.Root)
+	j, err := c.Store.Create("a.d", 120, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch, Float64Values{100, 200})
+	j.Close()
+
+	got := eval(t, c, "sumSeries(a.b,a.d)")
+	if want := "sumSeries(a.b,a.d)@1449240480:[101 202 4] "; got != want {
+		t.Errorf("Sum of differing steps = %s, want %s", got, want)
+	}
+}
+
+func TestHoltWintersFuncs(t *testing.T) {
+	c := testContext(t)
+	defer os.RemoveAll(c.Store.Root)
+
+	got := eval(t, c, "holtWintersForecast(a.b)")
+	if want := "holtWintersForecast(a.b)@1449240540:[1 1 NaN 1.1003500000000002] "; got != want {
+		t.Errorf("holtWintersForecast = %s, want %s", got, want)
+	}
+	e, _ := Parse("holtWintersConfidenceBands(a.*)")
+	series, err := c.Eval(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 4 || series[0].Name != "holtWintersConfidenceLower(a.b)" {
+		t.Errorf("holtWintersConfidenceBands returned %d series", len(series))
+	}
+}
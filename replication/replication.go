@@ -0,0 +1,554 @@
+// Package replication keeps the journals of a follower store up to date
+// with those of a leader by shipping only the encoded values the follower
+// does not have yet.  A follower asks for a series with the epoch and
+// number of points of its copy:
+//
+//	GET /replicate?series=servers.web01.cpu&epoch=1449240540&points=1440
+//
+// and the leader responds with the values from the follower's next
+// point to its last, undecoded, and headers describing its journal:
+//
+//	Journal-Interval: 60
+//	Journal-Type: 16
+//	Journal-Width: 8
+//	Journal-Epoch: 1449240540
+//	Journal-Start: 1449326940
+//	Journal-Meta: 0,0,0
+//
+// The follower writes the values at Journal-Start as they are, moving its
+// epoch forward first if the leader's has been trimmed.  When the
+// follower's copy cannot be continued, because it reaches past the
+// leader's last point or does not line up with the leader's epoch, the
+// leader sends every value with "Journal-Resync: true" and the follower
+// replaces its copy.
+//
+// Only points past the follower's copy are shipped, so values the leader
+// rewrites in place are not replicated unless they are among the newest
+// Follower.Overlap points, which are shipped again on every pull.  Rollup
+// archives are not replicated.
+//
+// Followers discover the leader's series with
+//
+//	GET /replicate?list=servers.*.cpu
+//
+// answered with one name per line, every series if the pattern is empty.
+// They verify their copies with
+//
+//	GET /replicate?series=servers.web01.cpu&epoch=1449240540&points=1440&fingerprint=true
+//
+// answered without a body and with a Journal-Fingerprint header: the hex
+// encoded SHA-256 digest of the leader's encoded values for the given
+// points, or none if the leader's journal does not have them.
+package replication
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Chunk is the most values a Follower writes, or a Leader reads, at a
+// time.
+const Chunk = 65536
+
+// Leader is an http.Handler serving the journals of a store to followers.
+type Leader struct {
+	Store *store.Store
+}
+
+// ServeHTTP answers a follower's request for the values of a series.
+func (l *Leader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("list") {
+		l.list(w, r.FormValue("list"))
+		return
+	}
+	series := r.FormValue("series")
+	if err := store.Validate(series); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var epoch, points int64
+	var err error
+	if s := r.FormValue("epoch"); s != "" {
+		if epoch, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "Invalid epoch", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.FormValue("points"); s != "" {
+		if points, err = strconv.ParseInt(s, 10, 64); err != nil || points < 0 {
+			http.Error(w, "Invalid points", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if r.FormValue("fingerprint") == "true" {
+		l.fingerprint(w, series, epoch, points)
+		return
+	}
+
+	// The journal is only held while reading, never while sending, so
+	// that a slow follower does not hold up writes to it
+	var from, until, interval, width int64
+	err = l.Store.View(series, func(j *timeseries.FileJournal) error {
+		interval, width = j.Interval(), int64(j.Width())
+		var resync bool
+		from, resync = start(j, epoch, points)
+		h := w.Header()
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Journal-Interval", strconv.FormatInt(interval, 10))
+		h.Set("Journal-Type", strconv.FormatInt(int64(j.Factory().Type()), 10))
+		h.Set("Journal-Width", strconv.FormatInt(width, 10))
+		h.Set("Journal-Epoch", strconv.FormatInt(j.Epoch(), 10))
+		h.Set("Journal-Start", strconv.FormatInt(from, 10))
+		h.Set("Journal-Meta", formatMeta(j.Meta()))
+		if resync {
+			h.Set("Journal-Resync", "true")
+		}
+		if from != 0 && from <= j.Last() {
+			until = j.Last()
+			h.Set("Content-Length", strconv.FormatInt(j.RawReader(from, until).Size(), 10))
+		} else {
+			h.Set("Content-Length", "0")
+		}
+		return nil
+	})
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		// Too late for an error status, a short body tells the follower
+		if until != 0 {
+			l.send(w, series, from, until, interval, width)
+		}
+		return
+	}
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, "No such series", http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// send writes the encoded values of a series from through until, reading
+// at most Chunk values at a time.  It stops early if the journal no
+// longer holds them all, such as after a Trim.
+func (l *Leader) send(w io.Writer, series string, from, until, interval, width int64) {
+	buf := make([]byte, min(until-from+interval, Chunk*interval)/interval*width)
+	for from <= until {
+		last := min(until, from+(Chunk-1)*interval)
+		chunk := buf[:(last-from+interval)/interval*width]
+		err := l.Store.View(series, func(j *timeseries.FileJournal) error {
+			rr := j.RawReader(from, last)
+			if rr.Start() != from || rr.Size() != int64(len(chunk)) {
+				return fmt.Errorf("Journal changed: %s", series)
+			}
+			_, err := io.ReadFull(rr, chunk)
+			return err
+		})
+		if err != nil {
+			return
+		}
+		if _, err = w.Write(chunk); err != nil {
+			return
+		}
+		from = last + interval
+	}
+}
+
+// list answers a follower's request for the series matching pattern.
+func (l *Leader) list(w http.ResponseWriter, pattern string) {
+	var series []string
+	var err error
+	if pattern == "" {
+		series, err = l.Store.List()
+	} else {
+		series, err = l.Store.Find(pattern)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort.Strings(series)
+	w.Header().Set("Content-Type", "text/plain")
+	for _, name := range series {
+		fmt.Fprintln(w, name)
+	}
+}
+
+// fingerprint answers a follower's request for the Fingerprint of the
+// given points of a series.
+func (l *Leader) fingerprint(w http.ResponseWriter, series string, epoch, points int64) {
+	var sum string
+	err := l.Store.View(series, func(j *timeseries.FileJournal) error {
+		if j.Epoch() != epoch || j.Points() < points {
+			return nil
+		}
+		var err error
+		sum, err = Fingerprint(j, points)
+		return err
+	})
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, "No such series", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		if sum != "" {
+			w.Header().Set("Journal-Fingerprint", sum)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Fingerprint returns the hex encoded SHA-256 digest of the first points
+// encoded values of j, which a follower's copy shares with the leader's
+// journal once it is in sync.
+func Fingerprint(j *timeseries.FileJournal, points int64) (string, error) {
+	h := sha256.New()
+	if points > 0 {
+		rr := j.RawReader(j.Epoch(), j.Epoch()+(points-1)*j.Interval())
+		if _, err := io.Copy(h, rr); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// start returns the timestamp of the first value to send a follower whose
+// copy of j has the given epoch and points, or j's epoch and true if the
+// copy cannot be continued.
+func start(j *timeseries.FileJournal, epoch, points int64) (int64, bool) {
+	interval := j.Interval()
+	if j.Epoch() == 0 {
+		return 0, points > 0
+	}
+	if epoch == 0 || points == 0 {
+		return j.Epoch(), points > 0
+	}
+	next := epoch + points*interval
+	if epoch > j.Epoch() || (j.Epoch()-epoch)%interval != 0 ||
+		next < j.Epoch() || next > j.Last()+interval {
+		return j.Epoch(), true
+	}
+	return next, false
+}
+
+// Follower pulls journals from a Leader into its store.
+type Follower struct {
+	Store *store.Store
+
+	// Leader is the URL the Leader is served at, such as
+	// http://leader:8080/replicate.
+	Leader string
+
+	// Client, if set, is used in place of http.DefaultClient.
+	Client *http.Client
+
+	// Overlap is the number of the newest points of the follower's copy
+	// that are pulled again, so that values the leader rewrites shortly
+	// after writing them, such as a bucket of a rollup in progress, are
+	// replicated.
+	Overlap int64
+
+	// Rate, if positive, bounds the bytes of values per second read from
+	// the leader by all of the follower's pulls together.  The leader
+	// holds a journal only while it reads a Chunk of it, never while
+	// waiting on a follower, so paced pulls do not hold up its writes.
+	Rate int64
+
+	mu   sync.Mutex
+	pace *throttle
+}
+
+// Pull brings the follower's copy of series up to date with the leader,
+// creating it if needed.  It returns the number of values written.
+func (f *Follower) Pull(series string) (int64, error) {
+	var epoch, points int64
+	err := f.Store.View(series, func(j *timeseries.FileJournal) error {
+		epoch, points = j.Epoch(), j.Points()
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	points = max(points-f.Overlap, 0)
+
+	q := url.Values{}
+	q.Set("series", series)
+	q.Set("epoch", strconv.FormatInt(epoch, 10))
+	q.Set("points", strconv.FormatInt(points, 10))
+	resp, err := f.get(q)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", series, err)
+	}
+	defer resp.Body.Close()
+	h, err := parseHeaders(resp.Header)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid response for %s: %s", series, err)
+	}
+
+	if h.resync {
+		if _, err = f.Store.Delete(series, false); err != nil {
+			return 0, err
+		}
+	}
+	j, err := f.Store.Open(series)
+	if os.IsNotExist(err) {
+		var factory ValueType
+		if factory, err = valueType(h.typ, h.width); err == nil {
+			j, err = f.Store.Create(series, h.interval, factory, h.meta)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer j.Close()
+	if j.Interval() != h.interval || j.Width() != h.width || j.Factory().Type() != h.typ {
+		return 0, fmt.Errorf("Journal of %s does not match the leader's", series)
+	}
+	if j.Epoch() != 0 && j.Epoch() < h.epoch {
+		if _, err = j.Trim(h.epoch, false); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := apply(j, h.start, f.throttled(resp.Body))
+	if err != nil {
+		return n, err
+	}
+	if resp.ContentLength >= 0 && n*int64(h.width) != resp.ContentLength {
+		return n, fmt.Errorf("Truncated values from leader for %s", series)
+	}
+	return n, j.SetMeta(h.meta)
+}
+
+// get sends a request to the leader and returns its response if it is
+// successful.
+func (f *Follower) get(q url.Values) (*http.Response, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(f.Leader + "?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("Leader responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// List returns the leader's series matching pattern, or every series if
+// it is empty.
+func (f *Follower) List(pattern string) ([]string, error) {
+	resp, err := f.get(url.Values{"list": {pattern}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var series []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if name := scanner.Text(); name != "" {
+			series = append(series, name)
+		}
+	}
+	return series, scanner.Err()
+}
+
+// Verify reports whether the follower's copy of series holds the same
+// encoded values as the leader's journal, by comparing their
+// Fingerprints.  Values the leader has written since the last pull are
+// not compared.
+func (f *Follower) Verify(series string) (bool, error) {
+	var epoch, points int64
+	var sum string
+	err := f.Store.View(series, func(j *timeseries.FileJournal) error {
+		epoch, points = j.Epoch(), j.Points()
+		var err error
+		sum, err = Fingerprint(j, points)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	q := url.Values{}
+	q.Set("series", series)
+	q.Set("epoch", strconv.FormatInt(epoch, 10))
+	q.Set("points", strconv.FormatInt(points, 10))
+	q.Set("fingerprint", "true")
+	resp, err := f.get(q)
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", series, err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Journal-Fingerprint") == sum, nil
+}
+
+// throttled returns r, paced to the follower's Rate.
+func (f *Follower) throttled(r io.Reader) io.Reader {
+	if f.Rate <= 0 {
+		return r
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pace == nil {
+		f.pace = &throttle{rate: f.Rate, start: time.Now()}
+	}
+	return &throttledReader{r: r, t: f.pace}
+}
+
+// throttle paces reads to an average rate in bytes per second.
+type throttle struct {
+	mu    sync.Mutex
+	rate  int64
+	start time.Time
+	bytes int64
+}
+
+// wait records n bytes read and sleeps until the rate allows more.
+func (t *throttle) wait(n int64) {
+	t.mu.Lock()
+	t.bytes += n
+	due := t.start.Add(time.Duration(float64(t.bytes) / float64(t.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay < -time.Second {
+		// Idle time does not bank a burst
+		t.start, t.bytes = time.Now(), 0
+	}
+	t.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttledReader is a reader paced by a throttle.
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.t.wait(int64(n))
+	return n, err
+}
+
+// apply writes the values read from r to j starting at timestamp start,
+// Chunk values at a time.
+func apply(j *timeseries.FileJournal, start int64, r io.Reader) (int64, error) {
+	width := int(j.Width())
+	buf := make([]byte, Chunk*width)
+	var written int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n%width != 0 {
+			return written, io.ErrUnexpectedEOF
+		}
+		if n > 0 {
+			if werr := j.WriteRaw(start+written*j.Interval(), buf[:n]); werr != nil {
+				return written, werr
+			}
+			written += int64(n / width)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return written, nil
+		} else if err != nil {
+			return written, err
+		}
+	}
+}
+
+// valueType is GetValueType for a type sent by the leader, which may not
+// be one this build knows.
+func valueType(t, w int32) (factory ValueType, err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("Unknown journal type %#x from leader", t)
+		}
+	}()
+	return GetValueType(t, w), nil
+}
+
+// headers are the Journal-* headers of a response.
+type headers struct {
+	interval, epoch, start int64
+	typ, width             int32
+	meta                   []int64
+	resync                 bool
+}
+
+func parseHeaders(h http.Header) (*headers, error) {
+	var p headers
+	var err error
+	parse := func(name string) int64 {
+		if err != nil {
+			return 0
+		}
+		var n int64
+		if n, err = strconv.ParseInt(h.Get(name), 10, 64); err != nil {
+			err = fmt.Errorf("Invalid %s: %q", name, h.Get(name))
+		}
+		return n
+	}
+	p.interval = parse("Journal-Interval")
+	p.typ = int32(parse("Journal-Type"))
+	p.width = int32(parse("Journal-Width"))
+	p.epoch = parse("Journal-Epoch")
+	p.start = parse("Journal-Start")
+	if err != nil {
+		return nil, err
+	}
+	if p.interval <= 0 || p.width <= 0 {
+		return nil, fmt.Errorf("Invalid journal of interval %d and width %d", p.interval, p.width)
+	}
+	if p.meta, err = parseMeta(h.Get("Journal-Meta")); err != nil {
+		return nil, err
+	}
+	p.resync = h.Get("Journal-Resync") == "true"
+	return &p, nil
+}
+
+func formatMeta(meta []int64) string {
+	s := make([]string, len(meta))
+	for i, m := range meta {
+		s[i] = strconv.FormatInt(m, 10)
+	}
+	return strings.Join(s, ",")
+}
+
+func parseMeta(s string) ([]int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	meta := make([]int64, len(fields))
+	for i, f := range fields {
+		m, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Journal-Meta: %q", s)
+		}
+		meta[i] = m
+	}
+	return meta, nil
+}
@@ -0,0 +1,198 @@
+package replication
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func TestReplication(t *testing.T) {
+	os.RemoveAll("/tmp/test-replication")
+	leader := store.New("/tmp/test-replication/leader")
+	srv := httptest.NewServer(&Leader{Store: leader})
+	defer srv.Close()
+	f := &Follower{
+		Store:  store.New("/tmp/test-replication/follower"),
+		Leader: srv.URL,
+	}
+
+	j, err := leader.Create("a.b", 60, NewFloat64ValueType(), []int64{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	// The leader's journal is locked while it is written
+	leaderDo := func(fn func(j *timeseries.FileJournal)) {
+		leader.Do("a.b", func(j *timeseries.FileJournal) error {
+			fn(j)
+			return nil
+		})
+	}
+	read := func() string {
+		var got string
+		f.Store.View("a.b", func(j *timeseries.FileJournal) error {
+			values, _ := j.ReadRange(0, j.Last())
+			got = fmt.Sprint(j.Epoch(), values, j.Meta())
+			return nil
+		})
+		return got
+	}
+
+	leaderDo(func(j *timeseries.FileJournal) { j.Write(60, Float64Values{1, 2, 3}) })
+	if n, err := f.Pull("a.b"); err != nil || n != 3 {
+		t.Fatalf("First pull wrote %d values: %v", n, err)
+	}
+	// Only the new values are shipped
+	leaderDo(func(j *timeseries.FileJournal) { j.Write(240, Float64Values{4, 5}) })
+	if n, err := f.Pull("a.b"); err != nil || n != 2 {
+		t.Fatalf("Second pull wrote %d values: %v", n, err)
+	}
+	if got := read(); got != "60 [1 2 3 4 5] [7 0 0]" {
+		t.Errorf("Follower has %s", got)
+	}
+	if n, _ := f.Pull("a.b"); n != 0 {
+		t.Errorf("Pull of an unchanged journal wrote %d values", n)
+	}
+
+	// A trimmed leader moves the follower's epoch
+	leaderDo(func(j *timeseries.FileJournal) {
+		j.Trim(180, false)
+		j.Write(360, Float64Values{6})
+	})
+	f.Overlap = 1
+	if n, err := f.Pull("a.b"); err != nil || n != 2 {
+		t.Fatalf("Pull after Trim wrote %d values: %v", n, err)
+	}
+	if got := read(); got != "180 [3 4 5 6] [7 0 0]" {
+		t.Errorf("Follower has %s after Trim", got)
+	}
+
+	// A follower past the leader is replaced
+	leaderDo(func(j *timeseries.FileJournal) { j.TruncateAfter(240) })
+	if n, err := f.Pull("a.b"); err != nil || n != 2 {
+		t.Fatalf("Pull after TruncateAfter wrote %d values: %v", n, err)
+	}
+	if got := read(); got != "180 [3 4] [7 0 0]" {
+		t.Errorf("Follower has %s after TruncateAfter", got)
+	}
+
+	if _, err = f.Pull("no.such"); err == nil {
+		t.Errorf("Pull of a missing series succeeded")
+	}
+}
+
+func TestSync(t *testing.T) {
+	os.RemoveAll("/tmp/test-replication-sync")
+	defer os.RemoveAll("/tmp/test-replication-sync")
+	leader := store.New("/tmp/test-replication-sync/leader")
+	srv := httptest.NewServer(&Leader{Store: leader})
+	defer srv.Close()
+	f := &Follower{
+		Store:  store.New("/tmp/test-replication-sync/follower"),
+		Leader: srv.URL,
+		Rate:   1 << 20,
+	}
+
+	for _, name := range []string{"a.b", "a.c", "d"} {
+		j, err := leader.Create(name, 60, NewFloat64ValueType(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Write(60, Float64Values{1, 2, 3})
+		j.Close()
+	}
+	if series, err := f.List(""); err != nil || fmt.Sprint(series) != "[a.b a.c d]" {
+		t.Errorf("Listed %v, %v", series, err)
+	}
+	if series, err := f.List("a.*"); err != nil || fmt.Sprint(series) != "[a.b a.c]" {
+		t.Errorf("Listed %v, %v", series, err)
+	}
+
+	if _, err := f.Pull("a.b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := f.Verify("a.b"); err != nil || !ok {
+		t.Errorf("Verify of a fresh copy returned %v, %v", ok, err)
+	}
+	// New values on the leader are not compared
+	leader.Do("a.b", func(j *timeseries.FileJournal) error {
+		return j.Write(240, Float64Values{4})
+	})
+	if ok, err := f.Verify("a.b"); err != nil || !ok {
+		t.Errorf("Verify of an older copy returned %v, %v", ok, err)
+	}
+	f.Store.Do("a.b", func(j *timeseries.FileJournal) error {
+		return j.Write(120, Float64Values{9})
+	})
+	if ok, err := f.Verify("a.b"); err != nil || ok {
+		t.Errorf("Verify of a changed copy returned %v, %v", ok, err)
+	}
+}
+
+func TestLeaderUnlocked(t *testing.T) {
+	os.RemoveAll("/tmp/test-replication-unlocked")
+	defer os.RemoveAll("/tmp/test-replication-unlocked")
+	leader := store.New("/tmp/test-replication-unlocked")
+	store.NewPool(leader, 10)
+	defer leader.Pool.Close()
+	srv := httptest.NewServer(&Leader{Store: leader})
+	defer srv.Close()
+
+	values := make(Float64Values, 32*Chunk+10)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	j, err := leader.Create("a.b", 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	err = leader.Do("a.b", func(j *timeseries.FileJournal) error {
+		return j.Write(60, values)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The response is not read until the journal has been written again,
+	// which must not wait for it
+	resp, err := http.Get(srv.URL + "?series=a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	done := make(chan error)
+	go func() {
+		done <- leader.Do("a.b", func(j *timeseries.FileJournal) error {
+			return j.Write(60, Float64Values{-1})
+		})
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write waited for a follower")
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || int64(len(body)) != resp.ContentLength || len(body) != 8*len(values) {
+		t.Errorf("Read %d of %d bytes: %v", len(body), resp.ContentLength, err)
+	}
+	// The first value may have been sent before or after it was written
+	if len(body) > 8 && !bytes.Equal(body[8:], values.Encode()[8:]) {
+		t.Errorf("Read the wrong values")
+	}
+}
@@ -0,0 +1,217 @@
+// Package retention parses the retention configuration file that maps
+// series name patterns to rollup policies, in a format modeled on carbon's
+// storage-schemas.conf and storage-aggregation.conf combined:
+//
+//	# Comments start with a hash
+//	[servers]
+//	pattern = ^servers\.
+//	retentions = 60s:1d,5m:30d,1h:5y
+//	aggregationMethod = average
+//	xFilesFactor = 0.5
+//	type = float64
+//	carryForward = false
+//
+//	[default]
+//	pattern = .*
+//	retentions = 60s:7d
+//
+// Sections are matched against series names in the order they appear and
+// the first match wins.  Only pattern and retentions are required.
+package retention
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/rollup"
+)
+
+// Rule is one section of the configuration file.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Policy  rollup.Policy
+	Type    string // value type of newly created raw journals
+
+	// CarryForward fills gaps in the raw journal with the last value
+	// rather than nulls.
+	CarryForward bool
+}
+
+// Config is an ordered list of Rules.
+type Config struct {
+	Rules []*Rule
+}
+
+// DefaultMethod and DefaultType apply when a section does not set them.
+const (
+	DefaultMethod = "average"
+	DefaultType   = "float64"
+)
+
+// Types maps the names accepted by the type setting to constructors.
+var Types = map[string]func() ValueType{
+	"float64": func() ValueType { return NewFloat64ValueType() },
+	"float32": func() ValueType { return NewFloat32ValueType() },
+	"int64":   func() ValueType { return NewInt64ValueType() },
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	c, err := Parse(fd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Parse reads and validates a configuration.
+func Parse(r io.Reader) (*Config, error) {
+	c := new(Config)
+	var rule *Rule
+	var settings map[string]string
+
+	finish := func() error {
+		if rule == nil {
+			return nil
+		}
+		if err := rule.set(settings); err != nil {
+			return fmt.Errorf("[%s]: %s", rule.Name, err)
+		}
+		c.Rules = append(c.Rules, rule)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			rule = &Rule{Name: strings.TrimSpace(line[1 : len(line)-1])}
+			settings = make(map[string]string)
+		default:
+			i := strings.Index(line, "=")
+			if i < 0 {
+				return nil, fmt.Errorf("Line %d: expected key = value", lineno)
+			}
+			if rule == nil {
+				return nil, fmt.Errorf("Line %d: setting outside of a section", lineno)
+			}
+			key := strings.TrimSpace(line[:i])
+			settings[key] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// set fills in and validates the Rule from its section's settings.
+func (rule *Rule) set(settings map[string]string) error {
+	var err error
+	for key, value := range settings {
+		switch key {
+		case "pattern":
+			rule.Pattern, err = regexp.Compile(value)
+		case "retentions":
+			rule.Policy.Archives, err = rollup.ParseRetentions(value)
+		case "aggregationMethod":
+			rule.Policy.Method, err = rollup.GetAggregator(value)
+		case "xFilesFactor":
+			rule.Policy.XFilesFactor, err = strconv.ParseFloat(value, 64)
+			if err == nil && (rule.Policy.XFilesFactor < 0 || rule.Policy.XFilesFactor > 1) {
+				err = fmt.Errorf("xFilesFactor must be between 0 and 1")
+			}
+		case "type":
+			if _, ok := Types[value]; !ok {
+				err = fmt.Errorf("Unknown value type: %s", value)
+			}
+			rule.Type = value
+		case "carryForward":
+			rule.CarryForward, err = strconv.ParseBool(value)
+		default:
+			err = fmt.Errorf("Unknown setting: %s", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if rule.Pattern == nil {
+		return fmt.Errorf("Missing pattern")
+	}
+	if rule.Policy.Archives == nil {
+		return fmt.Errorf("Missing retentions")
+	}
+	if rule.Policy.Method == nil {
+		rule.Policy.Method, _ = rollup.GetAggregator(DefaultMethod)
+	}
+	if _, ok := settings["xFilesFactor"]; !ok {
+		rule.Policy.XFilesFactor = rollup.DefaultXFilesFactor
+	}
+	if rule.Type == "" {
+		rule.Type = DefaultType
+	}
+	return nil
+}
+
+// Match returns the first Rule whose pattern matches series, or nil.
+func (c *Config) Match(series string) *Rule {
+	for _, rule := range c.Rules {
+		if rule.Pattern.MatchString(series) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Policy returns the rollup policy for series or nil if no rule matches.
+// It is suitable for use as rollup.Runner.Policy.
+func (c *Config) Policy(series string) *rollup.Policy {
+	if rule := c.Match(series); rule != nil {
+		return &rule.Policy
+	}
+	return nil
+}
+
+// Schema returns the interval and value type used to create the raw
+// journal of a new series.  It is suitable for use as store.Store.Schema.
+func (c *Config) Schema(series string) (int64, ValueType, error) {
+	rule := c.Match(series)
+	if rule == nil {
+		return 0, nil, fmt.Errorf("No retention rule matches %s", series)
+	}
+	return rule.Policy.Archives[0].Interval, Types[rule.Type](), nil
+}
+
+// CarryForward reports whether gaps in the raw journal of series are
+// filled with its last value.  It is suitable for use as
+// store.Store.CarryForward.
+func (c *Config) CarryForward(series string) bool {
+	rule := c.Match(series)
+	return rule != nil && rule.CarryForward
+}
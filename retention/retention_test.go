@@ -0,0 +1,115 @@
+package retention
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/rollup"
+	"github.com/jjneely/journal/store"
+)
+
+const config = `
+# Test configuration
+[servers]
+pattern = ^servers\.
+retentions = 10s:6h, 1m:7d
+aggregationMethod = max
+xFilesFactor = 0
+type = int64
+carryForward = true
+
+[default]
+pattern = .*
+retentions = 60s:1d
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Rules) != 2 {
+		t.Fatalf("Parsed %d rules instead of 2", len(c.Rules))
+	}
+
+	p := c.Policy("servers.web01.cpu")
+	if p == nil || len(p.Archives) != 2 || p.Archives[1].Interval != 60 ||
+		p.XFilesFactor != 0 || p.Method([]float64{1, 3, 2}) != 3 {
+		t.Errorf("Wrong policy for servers: %+v", p)
+	}
+
+	p = c.Policy("other.metric")
+	if p == nil || len(p.Archives) != 1 ||
+		p.XFilesFactor != rollup.DefaultXFilesFactor ||
+		p.Method([]float64{1, 3, 2}) != 2 {
+		t.Errorf("Wrong default policy: %+v", p)
+	}
+
+	interval, factory, err := c.Schema("servers.web01.cpu")
+	if err != nil || interval != 10 || factory.Type() != 0x11 {
+		t.Errorf("Wrong schema for servers: %d %v %v", interval, factory, err)
+	}
+	if !c.CarryForward("servers.web01.cpu") || c.CarryForward("other.metric") {
+		t.Errorf("Wrong carryForward settings")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	bad := []string{
+		"pattern = .*",
+		"[a]\nretentions = 60s:1d",
+		"[a]\npattern = .*",
+		"[a]\npattern = (\nretentions = 60s:1d",
+		"[a]\npattern = .*\nretentions = 60s:1d\naggregationMethod = median",
+		"[a]\npattern = .*\nretentions = 60s:1d\nxFilesFactor = 2",
+		"[a]\npattern = .*\nretentions = 60s:1d\ntype = string",
+		"[a]\npattern = .*\nretentions = 60s:1d\nbogus = 1",
+		"[a]\npattern = .*\nretentions = 60s:1d\ncarryForward = maybe",
+		"[a]\npattern",
+	}
+	for _, s := range bad {
+		if _, err := Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("Invalid configuration accepted: %q", s)
+		}
+	}
+}
+
+func TestAutoCreate(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, _ := Parse(strings.NewReader(config))
+	s := store.New(dir)
+	s.Schema = c.Schema
+	s.CarryForward = c.CarryForward
+
+	j, err := s.OpenOrCreate("servers.web01.cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Interval() != 10 || j.Width() != 8 {
+		t.Errorf("Auto-created journal has interval %d width %d",
+			j.Interval(), j.Width())
+	}
+	j.Close()
+
+	j, err = s.OpenOrCreate("servers.web01.cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(1000, Int64Values{4})
+	j.Write(1030, Int64Values{7})
+	if values, _ := j.Read(1000, 4); fmt.Sprint(values) != "[4 4 4 7]" {
+		t.Errorf("Gap was not carried forward: %v", values)
+	}
+}
@@ -0,0 +1,130 @@
+package rollup
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Period is a calendar unit that values can be bucketed by.  Unlike a
+// fixed step, the length of a Period depends on the time zone and the
+// date: a day may be 23 or 25 hours long across a daylight saving
+// change and months have differing numbers of days.
+type Period int
+
+const (
+	Day   Period = iota // midnight to midnight
+	Week                // Monday midnight to Monday midnight
+	Month               // midnight on the first of the month
+)
+
+// ParsePeriod returns the Period named "day", "week", or "month".
+func ParsePeriod(name string) (Period, error) {
+	switch name {
+	case "day":
+		return Day, nil
+	case "week":
+		return Week, nil
+	case "month":
+		return Month, nil
+	}
+	return 0, fmt.Errorf("Unknown calendar period: %s", name)
+}
+
+func (p Period) String() string {
+	switch p {
+	case Day:
+		return "day"
+	case Week:
+		return "week"
+	case Month:
+		return "month"
+	}
+	return fmt.Sprintf("Period(%d)", int(p))
+}
+
+// Truncate returns the start of the period containing t in loc.
+func (p Period) Truncate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch p {
+	case Week:
+		d -= (int(t.Weekday()) + 6) % 7
+	case Month:
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// Next returns the start of the period after the one starting at start,
+// which must have been returned by Truncate or Next.
+func (p Period) Next(start time.Time) time.Time {
+	y, m, d := start.Date()
+	switch p {
+	case Day:
+		d++
+	case Week:
+		d += 7
+	default:
+		m++
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, start.Location())
+}
+
+// Bucket is one calendar period mapped onto the point indexes of a
+// journal.  Points First through First+N-1 have timestamps at or after
+// Start and before the start of the next period.  First is relative to
+// the journal's epoch and may be negative or past its last point.
+type Bucket struct {
+	Start time.Time
+	First int64
+	N     int64
+}
+
+// CalendarBuckets returns the buckets of every period in loc that
+// overlaps from through until (exclusive) for a journal whose first
+// point is at the Unix timestamp epoch with interval seconds between
+// points.
+func CalendarBuckets(epoch, interval int64, from, until time.Time, p Period, loc *time.Location) []Bucket {
+	// index of the first point at or after the Unix timestamp ts
+	index := func(ts int64) int64 {
+		d := ts - epoch
+		if d > 0 {
+			return (d + interval - 1) / interval
+		}
+		return -(-d / interval)
+	}
+
+	var buckets []Bucket
+	for start := p.Truncate(from, loc); start.Before(until); {
+		next := p.Next(start)
+		first := index(start.Unix())
+		buckets = append(buckets, Bucket{start, first, index(next.Unix()) - first})
+		start = next
+	}
+	return buckets
+}
+
+// Calendar consolidates values, whose first value is at point index 0,
+// into the given buckets with fn.  Points outside values count as null.
+// As with Downsample a bucket with less than xff of its possible values
+// present is null.  The returned slice has one value per bucket with
+// nulls as NaN.
+func Calendar(values []float64, buckets []Bucket, fn Aggregator, xff float64) []float64 {
+	out := make([]float64, len(buckets))
+	var bucket []float64
+	for b, k := range buckets {
+		bucket = bucket[:0]
+		for i := k.First; i < k.First+k.N; i++ {
+			if i >= 0 && i < int64(len(values)) && !math.IsNaN(values[i]) {
+				bucket = append(bucket, values[i])
+			}
+		}
+		if len(bucket) > 0 && float64(len(bucket))/float64(k.N) >= xff {
+			out[b] = fn(bucket)
+		} else {
+			out[b] = math.NaN()
+		}
+	}
+	return out
+}
@@ -0,0 +1,231 @@
+package rollup
+
+import (
+	"math"
+)
+
+import (
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Consolidation reduces a series to at most a given number of values as
+// they are read, in the manner of Graphite's maxDataPoints: consecutive
+// values are grouped into buckets of a whole number of intervals, aligned
+// to multiples of the bucket width, and each bucket is aggregated with
+// its Aggregator ignoring nulls.  Buckets without values are null.
+type Consolidation struct {
+	Start  int64     // timestamp of the first bucket
+	Step   int64     // time units between buckets
+	Values []float64 // one value per bucket with nulls as NaN
+
+	fn     Aggregator
+	bucket []float64
+	began  bool
+}
+
+// NewConsolidation returns a Consolidation of a series with the given
+// interval read from through until, choosing the smallest Step that
+// yields no more than maxPoints buckets over that range.  A maxPoints
+// that is not positive keeps the series' interval.
+func NewConsolidation(from, until, interval int64, maxPoints int, fn Aggregator) *Consolidation {
+	step := interval
+	if maxPoints > 0 && until >= from {
+		points := (until-from)/interval + 1
+		per := (points + int64(maxPoints) - 1) / int64(maxPoints)
+		for ; ; per++ {
+			step = per * interval
+			if (until-until%step)-(from-from%step) < int64(maxPoints)*step {
+				break
+			}
+		}
+	}
+	return &Consolidation{Step: step, fn: fn}
+}
+
+// Add consolidates values, whose first value is at timestamp start with
+// interval time units between them.  Values must be added in order and
+// must not overlap those already added.
+func (c *Consolidation) Add(start, interval int64, values []float64) {
+	for i, v := range values {
+		ts := start + int64(i)*interval
+		if !c.began {
+			c.Start = ts - ts%c.Step
+			c.began = true
+		}
+		for ts >= c.Start+int64(len(c.Values)+1)*c.Step {
+			c.flush()
+		}
+		if !math.IsNaN(v) {
+			c.bucket = append(c.bucket, v)
+		}
+	}
+}
+
+// Finish consolidates the last bucket.  Nothing may be added after it.
+func (c *Consolidation) Finish() {
+	if c.began {
+		c.flush()
+		c.began = false
+	}
+}
+
+func (c *Consolidation) flush() {
+	if len(c.bucket) > 0 {
+		c.Values = append(c.Values, c.fn(c.bucket))
+	} else {
+		c.Values = append(c.Values, math.NaN())
+	}
+	c.bucket = c.bucket[:0]
+}
+
+// ReadConsolidated reads series from s from through until with a
+// store.Cursor, a chunk of chunk values at a time, and consolidates it to
+// at most maxPoints values with fn, so that long ranges are reduced in
+// bounded memory.  A series with no values in the range returns a
+// Consolidation without Values.
+func ReadConsolidated(s *store.Store, series string, from, until int64, maxPoints, chunk int, fn Aggregator) (*Consolidation, error) {
+	var c *Consolidation
+	cur := s.Cursor(series, from, until, chunk)
+	for cur.Next() {
+		r := cur.Result()
+		if c == nil {
+			c = NewConsolidation(from, until, r.Interval, maxPoints, fn)
+		}
+		if err := c.addResult(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return &Consolidation{fn: fn}, nil
+	}
+	c.Finish()
+	return c, nil
+}
+
+func (c *Consolidation) addResult(r store.Result) error {
+	floats, err := Floats(r.Values)
+	if err != nil {
+		return err
+	}
+	c.Add(r.Start, r.Interval, floats)
+	return nil
+}
+
+// ReadBest is like ReadConsolidated but reads each part of the range from
+// the raw journal of series or one of its rollup archives.  The coarsest
+// of them still fine enough for maxPoints values over the range is read,
+// or the raw journal if maxPoints is not positive; if it does not reach
+// back to from, coarser archives that do are read first.  Archives lag
+// behind the journals they are rolled up from, so the newest values are
+// read from finer archives and the raw journal.  Every part is
+// consolidated to the step of the coarsest one read, switching between
+// them on bucket boundaries, so the result is seamless.
+func ReadBest(s *store.Store, series string, from, until int64, maxPoints int, fn Aggregator) (*Consolidation, error) {
+	levels, err := readLevels(s, series)
+	if err != nil {
+		return nil, err
+	}
+	if len(levels) == 0 {
+		return &Consolidation{fn: fn}, nil
+	}
+
+	first := 0
+	if maxPoints > 0 {
+		step := (until - from) / int64(maxPoints)
+		for i, l := range levels {
+			if l.interval <= step {
+				first = i
+			}
+		}
+	}
+	for first < len(levels)-1 && levels[first].epoch > from &&
+		levels[first+1].epoch < levels[first].epoch {
+		first++
+	}
+
+	c := NewConsolidation(from, until, levels[first].interval, maxPoints, fn)
+	next := from
+	for i := first; i >= 0 && next <= until; i-- {
+		l := levels[i]
+		end := until
+		if i > 0 {
+			// Finer levels take over at the first whole bucket this
+			// one lacks
+			past := l.last + l.interval
+			end = min(end, past-past%c.Step-1)
+		}
+		if end < next {
+			continue
+		}
+		if err = c.readLevel(s, series, l, next, end); err != nil {
+			return nil, err
+		}
+		next = end + 1
+	}
+	c.Finish()
+	return c, nil
+}
+
+// level is the raw journal of a series or one of its rollup archives.
+type level struct {
+	archive  int64 // the interval naming the archive, 0 for the journal
+	interval int64
+	epoch    int64
+	last     int64
+}
+
+// readLevels describes the raw journal and rollup archives of series
+// that hold any values, finest first.
+func readLevels(s *store.Store, series string) ([]level, error) {
+	var levels []level
+	describe := func(archive int64) func(j *timeseries.FileJournal) error {
+		return func(j *timeseries.FileJournal) error {
+			if j.Epoch() != 0 {
+				levels = append(levels, level{archive, j.Interval(), j.Epoch(), j.Last()})
+			}
+			return nil
+		}
+	}
+	if err := s.View(series, describe(0)); err != nil {
+		return nil, err
+	}
+	archives, err := s.Archives(series)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range archives {
+		if err = s.ViewArchive(series, a, describe(a)); err != nil {
+			return nil, err
+		}
+	}
+	return levels, nil
+}
+
+// readLevel adds the values of l from through until.  The raw journal is
+// read with a store.Cursor so that pending points are included.
+func (c *Consolidation) readLevel(s *store.Store, series string, l level, from, until int64) error {
+	if l.archive == 0 {
+		cur := s.Cursor(series, from, until, 0)
+		for cur.Next() {
+			if err := c.addResult(cur.Result()); err != nil {
+				return err
+			}
+		}
+		return cur.Err()
+	}
+	return s.ViewArchive(series, l.archive, func(j *timeseries.FileJournal) error {
+		values, err := j.ReadRange(from, until)
+		if err != nil {
+			return err
+		}
+		return c.addResult(store.Result{
+			Start:    max(from-from%l.interval, j.Epoch()),
+			Interval: l.interval,
+			Values:   values,
+		})
+	})
+}
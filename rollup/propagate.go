@@ -0,0 +1,136 @@
+package rollup
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Propagator updates the rollup archives of series as points are written
+// to them, rather than in Runner passes that re-read the raw journals.
+// The newest bucket of each archive is rewritten as its points arrive so
+// archives include the bucket in progress.  What has been folded into it
+// is kept in the archive's header Meta: the timestamp of the newest point
+// propagated, the number of non-null values in the bucket, and the bits
+// of their aggregate.  Points no newer than one already propagated are
+// ignored, as carbon's aggregator ignores late points.
+//
+// Every archive is aggregated from the raw points, so the policy's Method
+// must be Average or one whose result for a bucket is the same when
+// computed from its partial result and the next value, as Sum, Min, Max,
+// and Last are.
+type Propagator struct {
+	Store *store.Store
+
+	// Policy returns the rollup policy for a series, or nil to skip it.
+	Policy func(series string) *Policy
+}
+
+// Propagate folds points just written to j, the raw journal of series,
+// into its rollup archives.  It is suitable for writer.Writer.Propagate.
+func (p *Propagator) Propagate(series string, j *timeseries.FileJournal, points map[int64]float64) error {
+	if p.Policy == nil || len(points) == 0 {
+		return nil
+	}
+	policy := p.Policy(series)
+	if policy == nil || len(policy.Archives) < 2 {
+		return nil
+	}
+
+	times := make([]int64, 0, len(points))
+	for ts := range points {
+		times = append(times, ts)
+	}
+	sort.Slice(times, func(a, b int) bool { return times[a] < times[b] })
+	for _, a := range policy.Archives[1:] {
+		if err := p.archive(series, j.Interval(), a.Interval, policy, times, points); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partial is the bucket of an archive that points are being folded into.
+type partial struct {
+	newest int64   // timestamp of the newest point propagated
+	n      int64   // non-null values in the bucket
+	acc    float64 // their aggregate
+}
+
+// archive folds the points at times, in order, into the archive of series
+// with buckets of step time units.
+func (p *Propagator) archive(series string, interval, step int64, policy *Policy, times []int64, points map[int64]float64) error {
+	if step%interval != 0 {
+		return fmt.Errorf("Archive interval %d is not a multiple of %d",
+			step, interval)
+	}
+	path, err := p.Store.ArchivePath(series, step)
+	if err != nil {
+		return err
+	}
+	dst, err := timeseries.CreateOrOpen(path, step, NewFloat64ValueType(), nil, p.Store.Options)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	meta := dst.Meta()
+	b := partial{meta[0], meta[1], math.Float64frombits(uint64(meta[2]))}
+	if b.newest == 0 && dst.Epoch() != 0 {
+		// Rolled up by a Runner, which only writes whole buckets
+		b.newest = dst.Last() + step - 1
+	}
+	possible := float64(step / interval)
+	value := func() float64 {
+		if b.n > 0 && float64(b.n)/possible >= policy.XFilesFactor {
+			return b.acc
+		}
+		return math.NaN()
+	}
+
+	touched := false
+	for _, ts := range times {
+		if ts <= b.newest {
+			continue
+		}
+		if b.newest != 0 && ts-ts%step != b.newest-b.newest%step {
+			if touched {
+				if err = dst.Write(b.newest, Float64Values{value()}); err != nil {
+					return err
+				}
+			}
+			b.n, b.acc = 0, 0
+		}
+		if v := points[ts]; !math.IsNaN(v) {
+			b.acc = fold(policy.Method, b.acc, b.n, v)
+			b.n++
+		}
+		b.newest = ts
+		touched = true
+	}
+	if !touched {
+		return nil
+	}
+	if err = dst.Write(b.newest, Float64Values{value()}); err != nil {
+		return err
+	}
+	return dst.SetMeta([]int64{b.newest, b.n, int64(math.Float64bits(b.acc))})
+}
+
+// fold returns the aggregate of the n values aggregated as acc and v.
+func fold(fn Aggregator, acc float64, n int64, v float64) float64 {
+	switch {
+	case n == 0:
+		return fn([]float64{v})
+	case reflect.ValueOf(fn).Pointer() == reflect.ValueOf(Average).Pointer():
+		return acc + (v-acc)/float64(n+1)
+	}
+	return fn([]float64{acc, v})
+}
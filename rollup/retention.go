@@ -0,0 +1,108 @@
+package rollup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Archive is one rung of a retention ladder: values every Interval time
+// units kept for Retention time units.  The first Archive describes the
+// raw journal, each following Archive is downsampled from the one before.
+type Archive struct {
+	Interval  int64
+	Retention int64
+}
+
+// String formats the Archive as carbon does, e.g. "60s:1d".
+func (a Archive) String() string {
+	return fmt.Sprintf("%ds:%ds", a.Interval, a.Retention)
+}
+
+// Policy describes how a series is rolled up.
+type Policy struct {
+	Archives     []Archive
+	Method       Aggregator
+	XFilesFactor float64
+}
+
+// DefaultXFilesFactor matches carbon's default.
+const DefaultXFilesFactor = 0.5
+
+var units = map[byte]int64{
+	's': 1,
+	'm': 60,
+	'h': 60 * 60,
+	'd': 24 * 60 * 60,
+	'w': 7 * 24 * 60 * 60,
+	'y': 365 * 24 * 60 * 60,
+}
+
+// ParseRetentions parses a carbon style retention definition such as
+// "60s:1d,5m:30d,1h:5y" into a ladder of Archives.  As in carbon a
+// retention without a unit is a count of points, so "60:1440" is one
+// day of minutely data.  Each archive must have a coarser interval than
+// the one before that is a multiple of it.
+func ParseRetentions(s string) ([]Archive, error) {
+	var archives []Archive
+	for _, def := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(def), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid retention definition: %q", def)
+		}
+		interval, err := parseDuration(parts[0], 1)
+		if err != nil {
+			return nil, err
+		}
+		retention, err := parseDuration(parts[1], interval)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, Archive{interval, retention})
+	}
+
+	return archives, Validate(archives)
+}
+
+// parseDuration parses a number with an optional unit suffix.  Without a
+// unit the number is multiplied by scale.
+func parseDuration(s string, scale int64) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("Empty retention duration")
+	}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		s = s[:len(s)-1]
+		scale = unit
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid retention duration: %q", s)
+	}
+	return n * scale, nil
+}
+
+// Validate checks that a ladder of Archives can be downsampled from one
+// rung to the next.
+func Validate(archives []Archive) error {
+	if len(archives) == 0 {
+		return fmt.Errorf("No archives in retention ladder")
+	}
+	for i, a := range archives {
+		if a.Interval <= 0 || a.Retention < a.Interval {
+			return fmt.Errorf("Archive %s retains less than one point", a)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := archives[i-1]
+		if a.Interval <= prev.Interval || a.Interval%prev.Interval != 0 {
+			return fmt.Errorf("Archive %s interval is not a multiple of %s",
+				a, prev)
+		}
+		if a.Retention <= prev.Retention {
+			return fmt.Errorf("Archive %s does not retain more than %s",
+				a, prev)
+		}
+	}
+	return nil
+}
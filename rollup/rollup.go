@@ -0,0 +1,147 @@
+// Package rollup downsamples timeseries journals into coarser resolution
+// archives in the manner of Graphite's whisper retention ladders.
+package rollup
+
+import (
+	"fmt"
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// Aggregator consolidates the non-null values of one bucket into a single
+// value.  It is never called with an empty slice.
+type Aggregator func(values []float64) float64
+
+// Average returns the mean of the values.
+func Average(values []float64) float64 {
+	return Sum(values) / float64(len(values))
+}
+
+// Sum returns the total of the values.
+func Sum(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+// Min returns the smallest value.
+func Min(values []float64) float64 {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// Max returns the largest value.
+func Max(values []float64) float64 {
+	max := values[0]
+	for _, v := range values[1:] {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+// Last returns the most recent value.
+func Last(values []float64) float64 {
+	return values[len(values)-1]
+}
+
+// Aggregators maps the names used in configuration to Aggregators.
+var Aggregators = map[string]Aggregator{
+	"average": Average,
+	"avg":     Average,
+	"sum":     Sum,
+	"min":     Min,
+	"max":     Max,
+	"last":    Last,
+}
+
+// GetAggregator returns the Aggregator with the given name.
+func GetAggregator(name string) (Aggregator, error) {
+	fn, ok := Aggregators[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown aggregation method: %s", name)
+	}
+	return fn, nil
+}
+
+// Floats converts numeric Values to a float64 slice with nulls
+// represented as NaN.
+func Floats(values Values) ([]float64, error) {
+	switch v := values.(type) {
+	case Float64Values:
+		return []float64(v), nil
+	case Float32Values:
+		floats := make([]float64, len(v))
+		for i := range v {
+			floats[i] = float64(v[i])
+		}
+		return floats, nil
+	case Int64Values:
+		floats := make([]float64, len(v))
+		for i := range v {
+			if v[i] == math.MinInt64 {
+				floats[i] = math.NaN()
+			} else {
+				floats[i] = float64(v[i])
+			}
+		}
+		return floats, nil
+	}
+
+	return nil, fmt.Errorf("Cannot downsample values of type %T", values)
+}
+
+// Downsample consolidates values, whose first value is at timestamp start
+// with interval time units between them, into buckets of step time units
+// covering from until until (exclusive).  A bucket that has less than
+// xff (the x-files factor) of its possible values present is null.  The
+// returned slice has one value per bucket with nulls as NaN.
+func Downsample(values []float64, start, interval, from, until, step int64, fn Aggregator, xff float64) []float64 {
+	n := (until - from) / step
+	out := make([]float64, n)
+	bucket := make([]float64, 0, step/interval)
+	possible := float64(step / interval)
+
+	b := int64(0)
+	flush := func() {
+		if len(bucket) > 0 && float64(len(bucket))/possible >= xff {
+			out[b] = fn(bucket)
+		} else {
+			out[b] = math.NaN()
+		}
+		bucket = bucket[:0]
+	}
+
+	for i, v := range values {
+		ts := start + int64(i)*interval
+		if ts < from {
+			continue
+		}
+		if ts >= until {
+			break
+		}
+		for ts >= from+(b+1)*step {
+			flush()
+			b++
+		}
+		if !math.IsNaN(v) {
+			bucket = append(bucket, v)
+		}
+	}
+	for ; b < n; b++ {
+		flush()
+	}
+
+	return out
+}
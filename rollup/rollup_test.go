@@ -0,0 +1,380 @@
+package rollup
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+func floatsEq(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && !(math.IsNaN(a[i]) && math.IsNaN(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDownsample(t *testing.T) {
+	nan := math.NaN()
+	values := []float64{1, 2, 3, 4, nan, 6, nan, nan, 9}
+
+	// values start at 60, buckets of 3 starting at 0
+	out := Downsample(values, 60, 60, 0, 720, 180, Sum, 0.5)
+	want := []float64{3, 7, nan, nan}
+	if !floatsEq(out, want) {
+		t.Errorf("Downsample sum = %v, want %v", out, want)
+	}
+
+	out = Downsample(values, 60, 60, 0, 720, 180, Max, 0)
+	want = []float64{2, 4, 6, 9}
+	if !floatsEq(out, want) {
+		t.Errorf("Downsample max = %v, want %v", out, want)
+	}
+}
+
+func TestParseRetentions(t *testing.T) {
+	archives, err := ParseRetentions("60s:1d, 5m:30d,1h:5y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Archive{{60, 86400}, {300, 30 * 86400}, {3600, 5 * 365 * 86400}}
+	if len(archives) != len(want) {
+		t.Fatalf("Parsed %v, want %v", archives, want)
+	}
+	for i := range want {
+		if archives[i] != want[i] {
+			t.Errorf("Parsed %v, want %v", archives, want)
+		}
+	}
+
+	archives, err = ParseRetentions("60:1440")
+	if err != nil || archives[0] != (Archive{60, 86400}) {
+		t.Errorf("Point count retention parsed as %v, %v", archives, err)
+	}
+
+	for _, bad := range []string{"", "60s", "60x:1d", "60s:1d,90s:30d", "1m:1d,1m:2d", "1m:1d,5m:1h"} {
+		if _, err := ParseRetentions(bad); err == nil {
+			t.Errorf("Invalid retentions accepted: %q", bad)
+		}
+	}
+}
+
+func TestRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.New(dir)
+
+	j, err := s.Create("a.b", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := int64(1449240000) // a multiple of 600
+	values := make(Int64Values, 25)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	j.Write(epoch, values)
+	j.Close()
+
+	archives, _ := ParseRetentions("1m:1d,5m:30d,10m:1y")
+	policy := &Policy{Archives: archives, Method: Average, XFilesFactor: 0.5}
+	c := clock.NewFake(time.Unix(epoch, 0))
+	r := &Runner{
+		Store:  s,
+		Policy: func(string) *Policy { return policy },
+		State:  dir + "/.state",
+		Clock:  c,
+	}
+	if err = r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(r.State); !os.IsNotExist(err) {
+		t.Errorf("State file remains after a complete pass")
+	}
+
+	check := func(interval int64, want []float64) {
+		path, _ := s.ArchivePath("a.b", interval)
+		a, err := timeseries.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+		data, err := a.ReadRange(0, 1<<40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Epoch() != epoch || !floatsEq(data.(Float64Values), want) {
+			t.Errorf("%d archive has %v at %d, want %v", interval, data,
+				a.Epoch(), want)
+		}
+	}
+	check(300, []float64{2, 7, 12, 17, 22})
+	check(600, []float64{4.5, 14.5})
+
+	// Appending completes the last 10m bucket on the next pass
+	j, _ = s.Open("a.b")
+	j.Write(epoch+25*60, Int64Values{25, 26, 27, 28, 29})
+	j.Close()
+	if err = r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	check(300, []float64{2, 7, 12, 17, 22, 27})
+	check(600, []float64{4.5, 14.5, 24.5})
+
+	// A series that keeps failing does not stop the others
+	j, _ = s.Create("a.bad", 7, NewInt64ValueType(), nil)
+	j.Write(epoch, Int64Values{1})
+	j.Close()
+	for i := int64(0); i < 2; i++ {
+		j, _ = s.Open("a.b")
+		j.Write(epoch+(30+5*i)*60, Int64Values{30, 31, 32, 33, 34})
+		j.Close()
+		if err = r.Run(); err == nil {
+			t.Errorf("Pass with a failing series succeeded")
+		}
+		if _, err = os.Stat(r.State); !os.IsNotExist(err) {
+			t.Errorf("State file remains after a pass with failures")
+		}
+	}
+	check(300, []float64{2, 7, 12, 17, 22, 27, 32, 32})
+
+	// Values past their retention are dropped from the raw journal, then
+	// from the archives
+	os.Remove(dir + "/a/bad.tsj")
+	c.Advance(24*time.Hour + 20*time.Minute)
+	if err = r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	j, _ = s.Open("a.b")
+	if j.Epoch() != epoch+20*60 {
+		t.Errorf("Raw journal trimmed to %d, want %d", j.Epoch(), epoch+20*60)
+	}
+	j.Close()
+	check(300, []float64{2, 7, 12, 17, 22, 27, 32, 32})
+	c.Advance(30 * 24 * time.Hour)
+	if err = r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := s.ArchivePath("a.b", 300)
+	if a, err := timeseries.Open(path); err != nil || a.Epoch() != 0 {
+		t.Errorf("5m archive past its retention kept %v: %v", a, err)
+	} else {
+		a.Close()
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Hourly values across the spring daylight saving change
+	start := time.Date(2015, 3, 7, 0, 0, 0, 0, loc)
+	values := make([]float64, 71)
+	for i := range values {
+		values[i] = 1
+	}
+
+	buckets := CalendarBuckets(start.Unix(), 3600, start, start.Add(71*time.Hour), Day, loc)
+	if len(buckets) != 3 || buckets[1].First != 24 || buckets[1].N != 23 {
+		t.Fatalf("Daily buckets: %v", buckets)
+	}
+	if out := Calendar(values, buckets, Sum, 0); !floatsEq(out, []float64{24, 23, 24}) {
+		t.Errorf("Daily sums = %v", out)
+	}
+
+	week := Week.Truncate(start, loc)
+	if week.Weekday() != time.Monday || week.Day() != 2 {
+		t.Errorf("Week starts %s", week)
+	}
+	feb := time.Date(2015, 2, 14, 12, 0, 0, 0, loc)
+	buckets = CalendarBuckets(start.Unix(), 86400, feb, start, Month, loc)
+	if len(buckets) != 2 || buckets[0].N != 28 || buckets[1].First != -6 {
+		t.Errorf("Monthly buckets: %v", buckets)
+	}
+	if out := Calendar(values[:10], buckets[1:], Sum, 0.5); !math.IsNaN(out[0]) {
+		t.Errorf("Sparse month was not null: %v", out)
+	}
+}
+
+func TestConsolidate(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.New(dir)
+
+	j, err := s.Create("a.b", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := int64(1449240000) // a multiple of 300 but not 420
+	values := make(Int64Values, 25)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	j.Write(epoch, values)
+	j.Close()
+
+	check := func(maxPoints int, fn Aggregator, step, start int64, want []float64) {
+		c, err := ReadConsolidated(s, "a.b", epoch, epoch+24*60, maxPoints, 4, fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Step != step || c.Start != start || !floatsEq(c.Values, want) {
+			t.Errorf("Consolidated to %d points: %v every %d from %d, want %v every %d from %d",
+				maxPoints, c.Values, c.Step, c.Start, want, step, start)
+		}
+	}
+	check(5, Average, 300, epoch, []float64{2, 7, 12, 17, 22})
+	check(4, Max, 420, epoch-180, []float64{3, 10, 17, 24})
+	raw, _ := Floats(values)
+	check(0, Last, 60, epoch, raw)
+
+	if _, err = ReadConsolidated(s, "missing", epoch, epoch+60, 5, 0, Sum); !os.IsNotExist(err) {
+		t.Errorf("Consolidating a missing series returned %v", err)
+	}
+
+	nan := math.NaN()
+	c := NewConsolidation(0, 599, 60, 2, Sum)
+	c.Add(0, 60, []float64{1, nan, 3})
+	c.Add(420, 60, []float64{4, nan, nan})
+	c.Finish()
+	if c.Step != 300 || !floatsEq(c.Values, []float64{4, 4}) {
+		t.Errorf("Consolidated %v every %d", c.Values, c.Step)
+	}
+}
+
+func TestReadBest(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.New(dir)
+
+	j, err := s.Create("a.b", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := int64(1449240000) // a multiple of 1200
+	values := make(Int64Values, 30)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	j.Write(epoch, values[:25])
+	j.Close()
+
+	archives, _ := ParseRetentions("1m:1d,5m:30d,10m:1y")
+	policy := &Policy{Archives: archives, Method: Average, XFilesFactor: 0.5}
+	r := &Runner{Store: s, Policy: func(string) *Policy { return policy },
+		Clock: clock.NewFake(time.Unix(epoch, 0))}
+	if err = r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The raw journal no longer reaches back to epoch and has values the
+	// archives do not
+	j, _ = s.Open("a.b")
+	if _, err = j.Trim(epoch+900, false); err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch+25*60, values[25:])
+	j.Close()
+
+	check := func(from int64, maxPoints int, step, start int64, want []float64) {
+		c, err := ReadBest(s, "a.b", from, epoch+29*60, maxPoints, Average)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Step != step || c.Start != start || !floatsEq(c.Values, want) {
+			t.Errorf("Read from %d to %d points: %v every %d from %d, want %v every %d from %d",
+				from, maxPoints, c.Values, c.Step, c.Start, want, step, start)
+		}
+	}
+	check(epoch, 0, 300, epoch, []float64{2, 7, 12, 17, 22, 27})
+	check(epoch, 2, 1200, epoch, []float64{9.5, 24.5})
+	check(epoch+1500, 0, 60, epoch+1500, []float64{25, 26, 27, 28, 29})
+
+	if _, err = ReadBest(s, "missing", epoch, epoch+60, 0, Sum); !os.IsNotExist(err) {
+		t.Errorf("Reading a missing series returned %v", err)
+	}
+}
+
+func TestPropagate(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := store.New(dir)
+
+	j, err := s.Create("a.b", 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	epoch := int64(1449240000) // a multiple of 600
+	archives, _ := ParseRetentions("1m:1d,5m:30d,10m:1y")
+	policy := &Policy{Archives: archives, Method: Average, XFilesFactor: 0.5}
+	p := &Propagator{Store: s, Policy: func(string) *Policy { return policy }}
+
+	write := func(first, n int) {
+		points := make(map[int64]float64)
+		for i := first; i < first+n; i++ {
+			points[epoch+int64(i)*60] = float64(i)
+		}
+		if err := p.Propagate("a.b", j, points); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(interval int64, want []float64) {
+		path, _ := s.ArchivePath("a.b", interval)
+		a, err := timeseries.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+		data, err := a.ReadRange(0, 1<<40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Epoch() != epoch || !floatsEq(data.(Float64Values), want) {
+			t.Errorf("%d archive has %v at %d, want %v", interval, data,
+				a.Epoch(), want)
+		}
+	}
+
+	// The second 5m bucket is short of the x-files factor
+	write(0, 7)
+	nan := math.NaN()
+	check(300, []float64{2, nan})
+	check(600, []float64{3})
+
+	// Late points are ignored
+	write(1, 1)
+	write(7, 3)
+	check(300, []float64{2, 7})
+	check(600, []float64{4.5})
+
+	write(10, 1)
+	check(300, []float64{2, 7, nan})
+	check(600, []float64{4.5, nan})
+}
@@ -0,0 +1,246 @@
+package rollup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// chunk is the number of buckets downsampled per read of the source.
+const chunk = 4096
+
+// Runner walks a Store and brings every series' rollup archives up to
+// date with its raw journal, then trims the raw journal and each archive
+// to the Retention of its Archive.  Archives are only extended before
+// they are trimmed, so a Runner that is interrupted simply continues
+// where each archive ends.
+// Progress through the store is also recorded in the State file so that
+// a restarted pass skips series that were already finished.  A pass that
+// runs to the end removes it, even if some series failed, so that the
+// next pass tries every series again.
+type Runner struct {
+	Store *store.Store
+
+	// Policy returns the rollup policy for a series, or nil to skip it.
+	Policy func(series string) *Policy
+
+	// Workers bounds how many series are rolled up concurrently.
+	// Defaults to store.DefaultWorkers.
+	Workers int
+
+	// State is the path of the progress file.  Empty disables resuming.
+	State string
+
+	// OnError, if set, is called for each series that fails.  The pass
+	// continues with the remaining series.
+	OnError func(series string, err error)
+
+	// Clock, if set, is used in place of the system clock to find the
+	// values older than each Retention.
+	Clock clock.Clock
+}
+
+// Run makes one pass over every series in the store.  The number of
+// series that failed is reported as an error.
+func (r *Runner) Run() error {
+	series, err := r.Store.List()
+	if err != nil {
+		return err
+	}
+	done, err := r.loadState()
+	if err != nil {
+		return err
+	}
+	var state *os.File
+	if r.State != "" {
+		state, err = os.OpenFile(r.State, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		defer state.Close()
+	}
+
+	workers := r.Workers
+	if workers <= 0 {
+		workers = store.DefaultWorkers
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := 0
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				err := r.Series(name)
+				mu.Lock()
+				if err != nil {
+					failed++
+					if r.OnError != nil {
+						r.OnError(name, err)
+					}
+				} else if state != nil {
+					fmt.Fprintln(state, name)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range series {
+		if !done[name] {
+			queue <- name
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if state != nil {
+		state.Close()
+		if err = os.Remove(r.State); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Rollup failed for %d series", failed)
+	}
+	return nil
+}
+
+// loadState reads the names of the series already finished in an
+// interrupted pass.
+func (r *Runner) loadState() (map[string]bool, error) {
+	done := make(map[string]bool)
+	if r.State == "" {
+		return done, nil
+	}
+
+	fd, err := os.Open(r.State)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			done[name] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// Series brings the rollup archives of one series up to date and trims
+// them and its raw journal to their retention.  Each is trimmed only
+// after the next archive has been downsampled from it.
+func (r *Runner) Series(name string) error {
+	if r.Policy == nil {
+		return nil
+	}
+	p := r.Policy(name)
+	if p == nil || len(p.Archives) == 0 {
+		return nil
+	}
+	now := clock.Or(r.Clock).Now().Unix()
+
+	return r.Store.Do(name, func(raw *timeseries.FileJournal) error {
+		src := raw
+		for i, a := range p.Archives[1:] {
+			path, err := r.Store.ArchivePath(name, a.Interval)
+			if err != nil {
+				return err
+			}
+			dst, err := timeseries.CreateOrOpen(path, a.Interval,
+				NewFloat64ValueType(), nil, r.Store.Options)
+			if err != nil {
+				return err
+			}
+			defer dst.Close()
+
+			if err = Level(src, dst, p); err != nil {
+				return err
+			}
+			if err = trim(src, now-p.Archives[i].Retention); err != nil {
+				return err
+			}
+			src = dst
+		}
+		return trim(src, now-p.Archives[len(p.Archives)-1].Retention)
+	})
+}
+
+// trim discards the values of j older than before unless it is sealed.
+func trim(j *timeseries.FileJournal, before int64) error {
+	if j.Sealed() {
+		return nil
+	}
+	_, err := j.Trim(before, false)
+	return err
+}
+
+// Level downsamples every complete bucket of src that is newer than the
+// end of dst into dst using the policy's method and x-files factor.
+// The interval of dst must be a multiple of the interval of src.
+func Level(src, dst timeseries.Journal, p *Policy) error {
+	if src.Epoch() == 0 {
+		return nil
+	}
+	interval := src.Interval()
+	step := dst.Interval()
+	if step%interval != 0 {
+		return fmt.Errorf("Archive interval %d is not a multiple of %d",
+			step, interval)
+	}
+
+	var from int64
+	if dst.Epoch() == 0 {
+		from = src.Epoch() - src.Epoch()%step
+	} else {
+		from = dst.Last() + step
+	}
+	// Only buckets that the source has completely passed
+	end := src.Last() + interval
+	end = end - end%step
+
+	for from < end {
+		until := from + step*chunk
+		if until > end {
+			until = end
+		}
+
+		values, err := src.ReadRange(from, until-1)
+		if err != nil {
+			return err
+		}
+		floats, err := Floats(values)
+		if err != nil {
+			return err
+		}
+		start := from
+		if start < src.Epoch() {
+			start = src.Epoch()
+		}
+
+		out := Downsample(floats, start, interval, from, until, step,
+			p.Method, p.XFilesFactor)
+		if err = dst.Write(from, Float64Values(out)); err != nil {
+			return err
+		}
+		from = until
+	}
+
+	return nil
+}
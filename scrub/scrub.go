@@ -0,0 +1,273 @@
+// Package scrub slowly reads every journal of a store looking for damage
+// before it is needed: files that cannot be opened or read, partially
+// written values, and headers that break the invariants journals are
+// written with.  Journals do not carry checksums, so the values
+// themselves are only checked to be readable.
+package scrub
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/stats"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// chunk is the number of bytes read at a time.
+const chunk = 65536
+
+// skew is how far in the future a journal's modification time may be
+// before it is reported.
+const skew = time.Hour
+
+// Problem is a defect found in a journal file.
+type Problem struct {
+	Series   string
+	Path     string
+	Err      error
+	Repaired bool
+}
+
+func (p Problem) String() string {
+	if p.Repaired {
+		return fmt.Sprintf("%s: %s (repaired)", p.Path, p.Err)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Err)
+}
+
+// Scrubber checks the journals and rollup archives of a Store one at a
+// time.  Journals are held open only while their length is recorded, as
+// by Store.Snapshot, so writers are not held up while they are read.
+type Scrubber struct {
+	Store *store.Store
+
+	// Rate bounds the bytes read per second so that scrubbing can run
+	// continuously beside production traffic.  Zero is unlimited.
+	Rate int64
+
+	// Repair truncates partially written values, which make journals
+	// fail to open, as timeseries.Repair does.  Other problems are only
+	// reported.
+	Repair bool
+
+	// OnProblem, if set, is called for each problem found.
+	OnProblem func(p Problem)
+
+	// Clock, if set, is used in place of the system clock to check
+	// modification times.
+	Clock clock.Clock
+
+	// Files, Bytes, Problems, and Repaired count the files checked, the
+	// bytes read, the problems found, and those repaired.
+	Files    stats.Counter
+	Bytes    stats.Counter
+	Problems stats.Counter
+	Repaired stats.Counter
+}
+
+// Run makes one pass over every series in the store, returning early if
+// stop is closed.  The number of problems found is reported as an error.
+func (s *Scrubber) Run(stop <-chan struct{}) error {
+	series, err := s.Store.List()
+	if err != nil {
+		return err
+	}
+	t := &throttle{rate: s.Rate, stop: stop, start: time.Now()}
+	found := 0
+	for _, name := range series {
+		problems, err := s.series(name, t)
+		found += len(problems)
+		if err == errStopped {
+			break
+		}
+	}
+	if found > 0 {
+		return fmt.Errorf("Scrub found %d problems", found)
+	}
+	return nil
+}
+
+// Series checks the raw journal and rollup archives of one series.
+func (s *Scrubber) Series(name string) ([]Problem, error) {
+	return s.series(name, &throttle{rate: s.Rate, start: time.Now()})
+}
+
+// series checks one series, stopping with errStopped if t is stopped.
+func (s *Scrubber) series(name string, t *throttle) ([]Problem, error) {
+	files, err := s.Store.Files(name)
+	if err != nil {
+		return nil, err
+	}
+	raw, _ := s.Store.Path(name)
+
+	var problems []Problem
+	report := func(path string, err error, repaired bool) {
+		p := Problem{Series: name, Path: path, Err: err, Repaired: repaired}
+		problems = append(problems, p)
+		s.Problems.Add(1)
+		if repaired {
+			s.Repaired.Add(1)
+		}
+		if s.OnProblem != nil {
+			s.OnProblem(p)
+		}
+	}
+
+	for _, path := range files {
+		var snap *timeseries.Snapshot
+		check := func(j *timeseries.FileJournal) error {
+			for _, err := range s.header(j) {
+				report(path, err, false)
+			}
+			var err error
+			snap, err = j.Snapshot()
+			return err
+		}
+		if path == raw {
+			err = s.Store.View(name, check)
+		} else {
+			err = s.archive(path, check)
+		}
+
+		switch {
+		case err == timeseries.ErrLocked || os.IsNotExist(err):
+			// Busy or removed meanwhile; the next pass will see it
+			continue
+		case err == timeseries.ErrPartial && s.Repair:
+			report(path, err, s.repair(name, path) == nil)
+			continue
+		case err != nil:
+			report(path, err, false)
+			continue
+		}
+
+		s.Files.Add(1)
+		err = s.read(snap, t)
+		snap.Close()
+		if err == errStopped {
+			return problems, err
+		} else if err != nil {
+			report(path, err, false)
+		}
+	}
+	return problems, nil
+}
+
+// repair truncates the partial value at the end of the journal at path,
+// recording the change in the store's audit log first.
+func (s *Scrubber) repair(series, path string) error {
+	c, err := timeseries.Repair(path, true, s.Store.Options)
+	if err != nil {
+		return err
+	}
+	c.DryRun = false
+	if err = s.Store.AuditChange(series, c); err != nil {
+		return err
+	}
+	_, err = timeseries.Repair(path, false, s.Store.Options)
+	return err
+}
+
+// archive calls fn with the rollup archive at path opened read-only.
+func (s *Scrubber) archive(path string, fn func(j *timeseries.FileJournal) error) error {
+	opts := timeseries.DefaultOptions
+	if s.Store.Options != nil {
+		opts = *s.Store.Options
+	}
+	opts.ReadOnly = true
+	j, err := timeseries.OpenWithOptions(path, &opts)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	return fn(j)
+}
+
+// header checks the invariants that every write keeps.
+func (s *Scrubber) header(j *timeseries.FileJournal) []error {
+	var errs []error
+	epoch, interval := j.Epoch(), j.Interval()
+	if epoch%interval != 0 {
+		errs = append(errs, fmt.Errorf("Epoch %d is not a multiple of interval %d", epoch, interval))
+	}
+	if epoch == 0 && j.Points() > 0 {
+		errs = append(errs, fmt.Errorf("%d values but no epoch", j.Points()))
+	}
+	created, modified := j.Created(), j.Modified()
+	if !created.IsZero() && modified.Before(created) {
+		errs = append(errs, fmt.Errorf("Modified %s before it was created %s", modified, created))
+	}
+	if now := clock.Or(s.Clock).Now(); modified.After(now.Add(skew)) {
+		errs = append(errs, fmt.Errorf("Modified in the future at %s", modified))
+	}
+	return errs
+}
+
+// read reads every byte of snap at the throttled rate.
+func (s *Scrubber) read(snap *timeseries.Snapshot, t *throttle) error {
+	buf := make([]byte, chunk)
+	for off := int64(0); off < snap.Size(); {
+		n, err := snap.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		off += int64(n)
+		s.Bytes.Add(int64(n))
+		if err = t.wait(int64(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errStopped ends a pass early.
+var errStopped = errors.New("Scrub stopped")
+
+// throttle paces reads to an average rate in bytes per second.
+type throttle struct {
+	rate  int64
+	stop  <-chan struct{}
+	start time.Time
+	bytes int64
+}
+
+// wait records n bytes read and sleeps until the rate allows more.
+func (t *throttle) wait(n int64) error {
+	if t.rate <= 0 {
+		select {
+		case <-t.stop:
+			return errStopped
+		default:
+			return nil
+		}
+	}
+	t.bytes += n
+	due := t.start.Add(time.Duration(float64(t.bytes) / float64(t.rate) * float64(time.Second)))
+	delay := time.Until(due)
+	if delay < -time.Second {
+		// Idle time does not bank a burst
+		t.start, t.bytes = time.Now(), 0
+		return nil
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.stop:
+		return errStopped
+	}
+}
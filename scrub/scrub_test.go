@@ -0,0 +1,76 @@
+package scrub
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/store"
+)
+
+func TestScrub(t *testing.T) {
+	root := "/tmp/journal-scrub-test"
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+
+	s := store.New(root)
+	for _, name := range []string{"a.good", "a.partial"} {
+		j, err := s.Create(name, 60, NewInt64ValueType(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Write(1500000000, Int64Values{1, 2, 3})
+		j.Close()
+	}
+	path, _ := s.ArchivePath("a.good", 300)
+	archive, _ := s.Create("a.archive", 300, NewFloat64ValueType(), nil)
+	archive.Write(1500000000, Float64Values{2})
+	archive.Close()
+	src, _ := s.Path("a.archive")
+	if err := os.Rename(src, path); err != nil {
+		t.Fatal(err)
+	}
+	partial, _ := s.Path("a.partial")
+	fd, _ := os.OpenFile(partial, os.O_WRONLY|os.O_APPEND, 0)
+	fd.Write([]byte{1, 2, 3})
+	fd.Close()
+
+	var problems []string
+	sc := &Scrubber{Store: s, Rate: 1 << 20, OnProblem: func(p Problem) {
+		problems = append(problems, p.String())
+	}}
+	if err := sc.Run(nil); err == nil {
+		t.Errorf("Scrub found no problems")
+	}
+	if len(problems) != 1 || sc.Files.Value() != 2 || sc.Repaired.Value() != 0 {
+		t.Errorf("Scrub checked %d files and found %v", sc.Files.Value(), problems)
+	}
+
+	sc.Repair = true
+	found, err := sc.Series("a.partial")
+	if err != nil || len(found) != 1 || !found[0].Repaired {
+		t.Errorf("Repair found %v, %v", found, err)
+	}
+	if found, _ = sc.Series("a.partial"); len(found) != 0 {
+		t.Errorf("Repaired journal still has %v", found)
+	}
+
+	// A journal written by a clock far ahead of this one
+	sc.Clock = clock.NewFake(time.Unix(1000000000, 0))
+	if found, _ = sc.Series("a.good"); len(found) != 2 {
+		t.Errorf("Scrub with a slow clock found %v", found)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	sc = &Scrubber{Store: s, Rate: 1}
+	start := time.Now()
+	sc.Run(stop)
+	if time.Since(start) > time.Second || sc.Files.Value() != 1 {
+		t.Errorf("Stopped scrub took %s for %d files", time.Since(start), sc.Files.Value())
+	}
+}
@@ -0,0 +1,354 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+var (
+	_ ValueType = (*SketchValueType)(nil)
+	_ Values    = SketchValues(nil)
+)
+
+// sketchHeader is the size of the encoding of a Sketch before its bins.
+const sketchHeader = 36
+
+// DefaultSketchAccuracy is the relative accuracy of quantiles estimated
+// by sketches made with NewSketch when none is given.
+const DefaultSketchAccuracy = 0.01
+
+// SketchValueType implements ValueType for DDSketches of a fixed number
+// of bins, so that the distribution of values seen during each interval,
+// such as request latencies, can be stored and merged over any range of
+// intervals to estimate its quantiles.  Each sketch is stored with Little
+// Endian encoding as its accuracy, offset, zero count, minimum, maximum,
+// and sum followed by its bins.  A sketch of all 0xff bytes is null.
+type SketchValueType struct {
+	bins int
+	null []byte
+}
+
+// NewSketchValueType returns a SketchValueType for sketches of the given
+// number of bins.
+func NewSketchValueType(bins int) *SketchValueType {
+	return &SketchValueType{bins: bins}
+}
+
+// Width is the size of the sketch header and 4 bytes for each bin.
+func (t *SketchValueType) Width() int32 {
+	return int32(sketchHeader + 4*t.bins)
+}
+
+// Type returns the type encoding as stored on disk
+func (t *SketchValueType) Type() int32 {
+	return 0x21
+}
+
+// Null returns Width bytes of 0xff.
+func (t *SketchValueType) Null() []byte {
+	if t.null == nil {
+		t.null = bytes.Repeat([]byte{0xff}, int(t.Width()))
+	}
+	return t.null
+}
+
+// Decode takes a byte slice presumably read from disk and decodes it into
+// a slice of sketches.
+func (t *SketchValueType) Decode(buffer []byte) Values {
+	return SketchValues(t.decode(buffer))
+}
+
+// DecodeStream reads and decodes n sketches from r.
+func (t *SketchValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(SketchValues, 0, n)
+	err := readChunks(r, n, t.Width(), func(chunk []byte) {
+		values = append(values, t.decode(chunk)...)
+	})
+	return values, err
+}
+
+func (t *SketchValueType) decode(buffer []byte) []Sketch {
+	w := int(t.Width())
+	sketches := make([]Sketch, len(buffer)/w)
+	counts := make([]uint32, len(sketches)*t.bins)
+	for i := range sketches {
+		b := buffer[i*w : (i+1)*w]
+		s := &sketches[i]
+		s.Accuracy = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		s.Offset = int32(binary.LittleEndian.Uint32(b[4:]))
+		s.Zeros = binary.LittleEndian.Uint32(b[8:])
+		s.Min = math.Float64frombits(binary.LittleEndian.Uint64(b[12:]))
+		s.Max = math.Float64frombits(binary.LittleEndian.Uint64(b[20:]))
+		s.Sum = math.Float64frombits(binary.LittleEndian.Uint64(b[28:]))
+		s.Bins = counts[i*t.bins : (i+1)*t.bins : (i+1)*t.bins]
+		for j := range s.Bins {
+			s.Bins[j] = binary.LittleEndian.Uint32(b[sketchHeader+4*j:])
+		}
+	}
+	return sketches
+}
+
+// SketchValues implements Values and wraps a slice of sketches, all with
+// the same number of bins.
+type SketchValues []Sketch
+
+// Encode will encode (Little Endian) every sketch for writing to disk.
+func (v SketchValues) Encode() []byte {
+	n := 0
+	for _, s := range v {
+		n += sketchHeader + 4*len(s.Bins)
+	}
+	return v.EncodeTo(make([]byte, 0, n))
+}
+
+// EncodeTo appends the Little Endian encoding of every sketch to dst.
+func (v SketchValues) EncodeTo(dst []byte) []byte {
+	for i := range v {
+		dst = v[i].EncodeTo(dst)
+	}
+	return dst
+}
+
+// WriteTo writes the Little Endian encoding of every sketch to w.
+func (v SketchValues) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
+}
+
+// Len returns the number of sketches.
+func (v SketchValues) Len() int {
+	return len(v)
+}
+
+// Slice returns the sketches from index i up to j without copying.
+func (v SketchValues) Slice(i, j int) Values {
+	return v[i:j]
+}
+
+// Sketch is a DDSketch: a histogram of positive values whose bins grow
+// exponentially so that every quantile it estimates is within Accuracy
+// of the true value, relative to it.  The bins cover a window of
+// len(Bins) consecutive bin keys starting at Offset.  When values fall
+// outside the window it moves to keep the largest values, collapsing the
+// smallest into its first bin, so that the high quantiles of latencies
+// stay accurate.  Values no greater than zero are counted in Zeros.
+type Sketch struct {
+	Accuracy float64
+	Offset   int32
+	Zeros    uint32
+	Min      float64
+	Max      float64
+	Sum      float64
+	Bins     []uint32
+}
+
+// NewSketch returns an empty Sketch of the given relative accuracy, or
+// DefaultSketchAccuracy if it is not between 0 and 1, and number of bins.
+func NewSketch(accuracy float64, bins int) *Sketch {
+	if accuracy <= 0 || accuracy >= 1 {
+		accuracy = DefaultSketchAccuracy
+	}
+	// The accuracy is stored as a float32
+	accuracy = float64(float32(accuracy))
+	return &Sketch{
+		Accuracy: accuracy,
+		Min:      math.Inf(1),
+		Max:      math.Inf(-1),
+		Bins:     make([]uint32, bins),
+	}
+}
+
+// NullSketch returns the null sketch of the given number of bins.
+func NullSketch(bins int) Sketch {
+	return Sketch{Accuracy: math.NaN(), Bins: make([]uint32, bins)}
+}
+
+// IsNull reports whether s is a null sketch.
+func (s *Sketch) IsNull() bool {
+	return math.IsNaN(s.Accuracy)
+}
+
+// EncodeTo appends the Little Endian encoding of s to dst.
+func (s *Sketch) EncodeTo(dst []byte) []byte {
+	if s.IsNull() {
+		return append(dst, bytes.Repeat([]byte{0xff}, sketchHeader+4*len(s.Bins))...)
+	}
+	dst = binary.LittleEndian.AppendUint32(dst, math.Float32bits(float32(s.Accuracy)))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(s.Offset))
+	dst = binary.LittleEndian.AppendUint32(dst, s.Zeros)
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(s.Min))
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(s.Max))
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(s.Sum))
+	for _, c := range s.Bins {
+		dst = binary.LittleEndian.AppendUint32(dst, c)
+	}
+	return dst
+}
+
+// Count returns the number of values added, zero for a null sketch.
+func (s *Sketch) Count() uint64 {
+	if s.IsNull() {
+		return 0
+	}
+	n := uint64(s.Zeros)
+	for _, c := range s.Bins {
+		n += uint64(c)
+	}
+	return n
+}
+
+// gamma is the ratio between the bounds of consecutive bins.
+func (s *Sketch) gamma() float64 {
+	return (1 + s.Accuracy) / (1 - s.Accuracy)
+}
+
+// key returns the key of the bin counting v.
+func (s *Sketch) key(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / math.Log(s.gamma())))
+}
+
+// Add adds a value to the sketch.  NaNs are ignored.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if s.Count() == 0 {
+		s.Min, s.Max = v, v
+	} else {
+		s.Min, s.Max = min(s.Min, v), max(s.Max, v)
+	}
+	s.Sum += v
+	if v <= 0 {
+		s.Zeros = saturate(s.Zeros, 1)
+		return
+	}
+	s.addKey(s.key(v), 1)
+}
+
+// addKey counts n values in the bin of key k, moving the window if k is
+// outside it.
+func (s *Sketch) addKey(k int32, n uint32) {
+	m := int32(len(s.Bins))
+	if m == 0 {
+		return
+	}
+	high, ok := s.highest()
+	switch {
+	case !ok:
+		// Center the first key
+		s.Offset = k - m/2
+	case k >= s.Offset+m:
+		s.slide(k - m + 1)
+	case k < s.Offset:
+		// As low as keeps the highest key, then collapse k
+		s.slide(max(k, high-m+1))
+	}
+	i := max(k-s.Offset, 0)
+	s.Bins[i] = saturate(s.Bins[i], n)
+}
+
+// highest returns the key of the highest non-empty bin.
+func (s *Sketch) highest() (int32, bool) {
+	for i := len(s.Bins) - 1; i >= 0; i-- {
+		if s.Bins[i] != 0 {
+			return s.Offset + int32(i), true
+		}
+	}
+	return 0, false
+}
+
+// slide moves the window to start at offset, collapsing the bins that
+// fall below it into the first bin.
+func (s *Sketch) slide(offset int32) {
+	bins := make([]uint32, len(s.Bins))
+	for i, c := range s.Bins {
+		j := max(s.Offset+int32(i)-offset, 0)
+		if int(j) < len(bins) {
+			bins[j] = saturate(bins[j], c)
+		}
+	}
+	copy(s.Bins, bins)
+	s.Offset = offset
+}
+
+// Merge adds the values of o to s.  Both must have the same accuracy and
+// number of bins.  A null o is ignored and a null s becomes a copy of o.
+func (s *Sketch) Merge(o *Sketch) error {
+	if o.IsNull() {
+		return nil
+	}
+	if s.IsNull() {
+		*s = Sketch{Accuracy: o.Accuracy, Bins: make([]uint32, len(o.Bins))}
+	}
+	if s.Accuracy != o.Accuracy || len(s.Bins) != len(o.Bins) {
+		return fmt.Errorf("Cannot merge sketches of accuracy %g and %d bins with %g and %d bins",
+			s.Accuracy, len(s.Bins), o.Accuracy, len(o.Bins))
+	}
+	if o.Count() == 0 {
+		return nil
+	}
+	if s.Count() == 0 {
+		s.Min, s.Max = o.Min, o.Max
+	} else {
+		s.Min, s.Max = min(s.Min, o.Min), max(s.Max, o.Max)
+	}
+	s.Sum += o.Sum
+	s.Zeros = saturate(s.Zeros, o.Zeros)
+	// Highest first so that the window moves at most once
+	for i := len(o.Bins) - 1; i >= 0; i-- {
+		if o.Bins[i] > 0 {
+			s.addKey(o.Offset+int32(i), o.Bins[i])
+		}
+	}
+	return nil
+}
+
+// MergeSketches returns the merge of sketches with the same accuracy and
+// number of bins, such as those of consecutive intervals.  The result is
+// null if every sketch is.
+func MergeSketches(sketches ...Sketch) (Sketch, error) {
+	if len(sketches) == 0 {
+		return Sketch{Accuracy: math.NaN()}, nil
+	}
+	sum := NullSketch(len(sketches[0].Bins))
+	for i := range sketches {
+		if err := sum.Merge(&sketches[i]); err != nil {
+			return sum, err
+		}
+	}
+	return sum, nil
+}
+
+// Quantile estimates the q-quantile, 0 <= q <= 1, of the values added.
+// It returns NaN for null and empty sketches.
+func (s *Sketch) Quantile(q float64) float64 {
+	total := s.Count()
+	if total == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+
+	rank := q * float64(total-1)
+	seen := float64(s.Zeros)
+	if seen > rank {
+		return min(0, s.Max)
+	}
+	gamma := s.gamma()
+	for i, c := range s.Bins {
+		seen += float64(c)
+		if seen > rank {
+			k := float64(s.Offset) + float64(i)
+			v := 2 * math.Pow(gamma, k) / (gamma + 1)
+			return min(max(v, s.Min), s.Max)
+		}
+	}
+	return s.Max
+}
+
+// saturate returns a+b, or math.MaxUint32 if that overflows.
+func saturate(a, b uint32) uint32 {
+	return uint32(min(uint64(a)+uint64(b), math.MaxUint32))
+}
@@ -0,0 +1,61 @@
+package journal
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	// 1..1000 spans more keys than there are bins so the lowest collapse
+	s := NewSketch(0.01, 128)
+	for v := 1; v <= 1000; v++ {
+		s.Add(float64(v))
+	}
+	s.Add(0)
+	if s.Count() != 1001 || s.Min != 0 || s.Max != 1000 || s.Sum != 500500 {
+		t.Errorf("Sketch counted %d from %g to %g summing to %g", s.Count(), s.Min, s.Max, s.Sum)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		want := q * 1000
+		if got := s.Quantile(q); math.Abs(got-want)/want > 0.011 {
+			t.Errorf("Quantile %g = %g, want %g", q, got, want)
+		}
+	}
+	if s.Quantile(0) != 0 || s.Quantile(1) != 1000 {
+		t.Errorf("Extreme quantiles are %g and %g", s.Quantile(0), s.Quantile(1))
+	}
+
+	// Merging the sketches of two halves matches the sketch of the whole
+	low, high := NewSketch(0.01, 128), NewSketch(0.01, 128)
+	for v := 1; v <= 1000; v++ {
+		if v <= 500 {
+			low.Add(float64(v))
+		} else {
+			high.Add(float64(v))
+		}
+	}
+	low.Add(0)
+	merged, err := MergeSketches(NullSketch(128), *low, *high)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		if merged.Quantile(q) != s.Quantile(q) {
+			t.Errorf("Merged quantile %g = %g, want %g", q, merged.Quantile(q), s.Quantile(q))
+		}
+	}
+	if err = merged.Merge(NewSketch(0.02, 128)); err == nil {
+		t.Errorf("Merged sketches of different accuracy")
+	}
+
+	null := NullSketch(128)
+	if !null.IsNull() || null.Count() != 0 || !math.IsNaN(null.Quantile(0.5)) {
+		t.Errorf("Null sketch has %d values", null.Count())
+	}
+
+	factory := GetValueType(0x21, NewSketchValueType(128).Width()).(*SketchValueType)
+	values := factory.Decode(SketchValues{*s, null}.Encode()).(SketchValues)
+	if values[0].Quantile(0.9) != s.Quantile(0.9) || !values[1].IsNull() {
+		t.Errorf("Decoded %v", values)
+	}
+}
@@ -0,0 +1,108 @@
+// Package stats provides lock free counters and latency histograms that
+// can be published with expvar.
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of the latency buckets used by
+// NewHistogram when none are given.
+var DefaultBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Histogram counts observed durations in buckets with fixed upper bounds
+// plus an overflow bucket.  It implements expvar.Var.
+type Histogram struct {
+	bounds []time.Duration
+	counts []int64 // len(bounds) + 1
+	count  int64
+	sum    int64 // nanoseconds
+}
+
+// NewHistogram returns a Histogram with the given ascending bucket
+// bounds, or DefaultBuckets if none are given.
+func NewHistogram(bounds ...time.Duration) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// Observe records one duration.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Since records the time elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// String returns the histogram as JSON with cumulative bucket counts
+// keyed by upper bound, the observation count, and the mean in seconds.
+func (h *Histogram) String() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString(`{"buckets": {`)
+	cumulative := int64(0)
+	for i, bound := range h.bounds {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		fmt.Fprintf(buf, "%q: %d, ", bound.String(), cumulative)
+	}
+	cumulative += atomic.LoadInt64(&h.counts[len(h.bounds)])
+	fmt.Fprintf(buf, `"+Inf": %d}`, cumulative)
+
+	count := atomic.LoadInt64(&h.count)
+	mean := 0.0
+	if count > 0 {
+		mean = time.Duration(atomic.LoadInt64(&h.sum) / count).Seconds()
+	}
+	fmt.Fprintf(buf, `, "count": %d, "mean": %g}`, count, mean)
+	return buf.String()
+}
+
+// Counter is a monotonically increasing count.  It implements expvar.Var.
+type Counter struct {
+	n int64
+}
+
+// Add increments the counter by delta.
+func (c *Counter) Add(delta int64) {
+	atomic.AddInt64(&c.n, delta)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// String returns the count as JSON.
+func (c *Counter) String() string {
+	return fmt.Sprintf("%d", c.Value())
+}
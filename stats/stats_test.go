@@ -0,0 +1,42 @@
+package stats
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(time.Millisecond, time.Second)
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(10 * time.Millisecond)
+	h.Observe(time.Minute)
+
+	var out struct {
+		Buckets map[string]int64
+		Count   int64
+		Mean    float64
+	}
+	if err := json.Unmarshal([]byte(h.String()), &out); err != nil {
+		t.Fatalf("Histogram is not valid JSON: %s: %s", err, h.String())
+	}
+	if out.Buckets["1ms"] != 2 || out.Buckets["1s"] != 3 || out.Buckets["+Inf"] != 4 {
+		t.Errorf("Wrong cumulative buckets: %v", out.Buckets)
+	}
+	if out.Count != 4 || h.Count() != 4 {
+		t.Errorf("Wrong count: %d", out.Count)
+	}
+	if out.Mean < 15 || out.Mean > 15.1 {
+		t.Errorf("Wrong mean: %f", out.Mean)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	c.Add(3)
+	c.Add(4)
+	if c.Value() != 7 || c.String() != "7" {
+		t.Errorf("Counter is %s", c.String())
+	}
+}
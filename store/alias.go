@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// AliasFile is the name of the file in the root of a store that maps
+// alias series names to the series they refer to, as a JSON object.
+const AliasFile = "aliases.json"
+
+// aliases caches the contents of a store's AliasFile.
+type aliases struct {
+	mu    sync.Mutex
+	mtime time.Time
+	size  int64
+	names map[string]string
+}
+
+// load re-reads the alias file if it has changed since it was last
+// read.  The caller must hold a.mu.
+func (a *aliases) load(path string) error {
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		a.names, a.mtime, a.size = nil, time.Time{}, 0
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if a.names != nil && stat.ModTime().Equal(a.mtime) && stat.Size() == a.size {
+		return nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	if err = json.Unmarshal(buf, &names); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	a.names, a.mtime, a.size = names, stat.ModTime(), stat.Size()
+	return nil
+}
+
+func (s *Store) aliasPath() string {
+	return filepath.Join(s.Root, AliasFile)
+}
+
+// Resolve returns the series that an alias refers to, or series itself
+// if it is not an alias.
+func (s *Store) Resolve(series string) string {
+	s.aliases.mu.Lock()
+	defer s.aliases.mu.Unlock()
+	if s.aliases.load(s.aliasPath()) != nil {
+		return series
+	}
+	if target, ok := s.aliases.names[series]; ok {
+		return target
+	}
+	return series
+}
+
+// Aliases returns every alias in the store and the series it refers to.
+func (s *Store) Aliases() (map[string]string, error) {
+	s.aliases.mu.Lock()
+	defer s.aliases.mu.Unlock()
+	if err := s.aliases.load(s.aliasPath()); err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(s.aliases.names))
+	for alias, target := range s.aliases.names {
+		names[alias] = target
+	}
+	return names, nil
+}
+
+// Alias makes the series name alias refer to the journals of target so
+// that reads and writes of either name use the same data, for example
+// after a host is renamed.  Aliases do not chain: an alias of an alias
+// refers to the final target.  The alias must not have journals of its
+// own and must not be the target of other aliases.
+func (s *Store) Alias(alias, target string) error {
+	if err := Validate(alias); err != nil {
+		return err
+	}
+	if err := Validate(target); err != nil {
+		return err
+	}
+	target = s.Resolve(target)
+	if alias == target {
+		return fmt.Errorf("Cannot alias %s to itself", alias)
+	}
+	if files, err := s.files(alias); err != nil {
+		return err
+	} else if len(files) > 0 {
+		return fmt.Errorf("Series %s exists and cannot become an alias", alias)
+	}
+
+	return s.editAliases(func(names map[string]string) error {
+		for a, t := range names {
+			if t == alias {
+				return fmt.Errorf("Series %s is the target of alias %s", alias, a)
+			}
+		}
+		names[alias] = target
+		return nil
+	})
+}
+
+// Unalias removes an alias.  The series it referred to is unaffected.
+func (s *Store) Unalias(alias string) error {
+	return s.editAliases(func(names map[string]string) error {
+		if _, ok := names[alias]; !ok {
+			return fmt.Errorf("Series %s is not an alias", alias)
+		}
+		delete(names, alias)
+		return nil
+	})
+}
+
+// editAliases applies edit to the aliases and atomically replaces the
+// alias file with the result.
+func (s *Store) editAliases(edit func(names map[string]string) error) error {
+	s.aliases.mu.Lock()
+	defer s.aliases.mu.Unlock()
+	path := s.aliasPath()
+	if err := s.aliases.load(path); err != nil {
+		return err
+	}
+	names := make(map[string]string, len(s.aliases.names)+1)
+	for alias, target := range s.aliases.names {
+		names[alias] = target
+	}
+	if err := edit(names); err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.Root, timeseries.DefaultDirMode); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.aliases.names = nil // force a reload
+	return nil
+}
+
+// notAlias fails for aliases, which destructive operations must not
+// follow to the journals of their target.
+func (s *Store) notAlias(series string) error {
+	if target := s.Resolve(series); target != series {
+		return fmt.Errorf("Series %s is an alias of %s", series, target)
+	}
+	return nil
+}
+
+// findAliases returns the aliases matching a Find pattern.
+func (s *Store) findAliases(pattern string) []string {
+	names, err := s.Aliases()
+	if err != nil {
+		return nil
+	}
+	glob := strings.Replace(pattern, ".", "/", -1)
+	var matches []string
+	for alias := range names {
+		if ok, _ := filepath.Match(glob, strings.Replace(alias, ".", "/", -1)); ok {
+			matches = append(matches, alias)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/lock"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// AuditFile is the conventional name of a store's audit log in its root.
+const AuditFile = "audit.log"
+
+// auditTail is the most of the end of an audit log read to find its last
+// entry.
+const auditTail = 65536
+
+// AuditEntry records one destructive operation in an audit log.  The log
+// holds one JSON entry per line, each with the SHA-256 of the line before
+// it, so that an edited or removed entry breaks the chain.
+type AuditEntry struct {
+	Time   int64  `json:"time"` // Unix nanoseconds
+	User   string `json:"user"`
+	Op     string `json:"op"`
+	Series string `json:"series,omitempty"`
+	Path   string `json:"path,omitempty"`
+	From   int64  `json:"from,omitempty"`
+	Until  int64  `json:"until,omitempty"`
+	Points int64  `json:"points,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Prev   string `json:"prev"`
+}
+
+// AppendAudit adds an entry to the audit log at path, creating it if
+// needed.  A zero Time is the current time and an empty User the user
+// running the process.  The log is locked while the entry is appended and
+// synced to disk.
+func AppendAudit(path string, e AuditEntry) error {
+	if e.Time == 0 {
+		e.Time = time.Now().UnixNano()
+	}
+	if e.User == "" {
+		e.User = currentUser()
+	}
+
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if err = lock.Exclusive(fd); err != nil {
+		return err
+	}
+
+	last, err := lastLine(fd)
+	if err != nil {
+		return err
+	}
+	e.Prev = ""
+	if last != nil {
+		sum := sha256.Sum256(last)
+		e.Prev = hex.EncodeToString(sum[:])
+	}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+// lastLine returns the last entry of an audit log without its newline,
+// or nil if the log is empty.
+func lastLine(fd *os.File) ([]byte, error) {
+	stat, err := fd.Stat()
+	if err != nil || stat.Size() == 0 {
+		return nil, err
+	}
+	n := min(stat.Size(), auditTail)
+	buf := make([]byte, n)
+	if _, err = fd.ReadAt(buf, stat.Size()-n); err != nil {
+		return nil, err
+	}
+	if buf[len(buf)-1] != '\n' {
+		return nil, fmt.Errorf("Audit log %s ends with a partial entry", fd.Name())
+	}
+	buf = buf[:len(buf)-1]
+	i := bytes.LastIndexByte(buf, '\n')
+	if i < 0 && n < stat.Size() {
+		return nil, fmt.Errorf("Audit log %s has an entry over %d bytes", fd.Name(), auditTail)
+	}
+	return buf[i+1:], nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
+
+// VerifyAudit reads an audit log and checks that each entry follows the
+// one before it.  It returns the number of entries.
+func VerifyAudit(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, auditTail)
+	prev := ""
+	n := 0
+	for scanner.Scan() {
+		n++
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return n, fmt.Errorf("Audit entry %d: %s", n, err)
+		}
+		if e.Prev != prev {
+			return n, fmt.Errorf("Audit entry %d does not follow entry %d", n, n-1)
+		}
+		sum := sha256.Sum256(scanner.Bytes())
+		prev = hex.EncodeToString(sum[:])
+	}
+	return n, scanner.Err()
+}
+
+// Audit records an operation in the store's AuditLog, if it has one, as
+// by the AuditUser at the time of the store's clock.
+func (s *Store) Audit(e AuditEntry) error {
+	if s.AuditLog == "" {
+		return nil
+	}
+	if e.Time == 0 {
+		e.Time = clock.Or(s.options().Clock).Now().UnixNano()
+	}
+	if e.User == "" {
+		e.User = s.AuditUser
+	}
+	return AppendAudit(s.AuditLog, e)
+}
+
+// AuditChange records a change made to a journal of series, for callers
+// that modify journals directly.  Dry runs are not recorded.
+func (s *Store) AuditChange(series string, c timeseries.Change) error {
+	if c.DryRun {
+		return nil
+	}
+	return s.Audit(AuditEntry{
+		Op:     c.Op,
+		Series: series,
+		Path:   c.Path,
+		From:   c.From,
+		Until:  c.Until,
+		Points: c.Points,
+		Bytes:  c.Bytes,
+	})
+}
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// BackupBlock is the size of the blocks that incremental backups compare
+// and copy.
+const BackupBlock = 65536
+
+// backupMagic begins every backup stream.
+const backupMagic = "JBAK1\n"
+
+// Backup stream records
+const (
+	recordFile   = 'F'
+	recordRemove = 'R'
+	recordEnd    = 'E'
+)
+
+// BackupState describes the files of a store as of a backup so that the
+// next backup can include only what has changed.  It is JSON encodable
+// for keeping between runs.
+type BackupState struct {
+	Files map[string]FileState `json:"files"` // keyed by path relative to the root
+}
+
+// FileState is the state of one file as of a backup.
+type FileState struct {
+	Size    int64    `json:"size"`
+	ModTime int64    `json:"mtime"` // Unix nanoseconds
+	Blocks  []uint32 `json:"blocks"`
+}
+
+// BackupSince returns a stream holding the blocks of the store's
+// journals, rollup archives, alias file, and checkpoint file that changed since the backup
+// that returned prev, and the state to pass to the next backup.  A nil
+// prev makes a full backup.  Files whose size and modification time are
+// unchanged are not read.  Each journal is copied as it was at one
+// instant, as by Snapshot, but different journals are copied at
+// different times.  Compressed journals are copied decompressed.
+//
+// The stream is produced as it is read and the returned state is only
+// complete once the stream has been read to its end without error.
+// Restore applies streams, in order, to a directory.  With the Store's
+// Encryption set the stream is encrypted and must be decrypted with
+// Encryption.Decrypt before it is restored.
+func (s *Store) BackupSince(prev *BackupState) (io.Reader, *BackupState) {
+	next := &BackupState{Files: make(map[string]FileState)}
+	r, w := io.Pipe()
+	go func() {
+		if s.Encryption == nil {
+			w.CloseWithError(s.backup(w, prev, next))
+			return
+		}
+		ew, err := s.Encryption.Encrypt(w)
+		if err == nil {
+			if err = s.backup(ew, prev, next); err == nil {
+				err = ew.Close()
+			}
+		}
+		w.CloseWithError(err)
+	}()
+	return r, next
+}
+
+func (s *Store) backup(w io.Writer, prev, next *BackupState) error {
+	if prev == nil {
+		prev = &BackupState{}
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(backupMagic)
+
+	series, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range series {
+		err := s.View(name, func(j *timeseries.FileJournal) error {
+			files, err := s.files(name)
+			if err != nil {
+				return err
+			}
+			for _, path := range files {
+				err = s.backupFile(bw, path, prev, next, func() (*timeseries.Snapshot, error) {
+					if path == s.path(name) {
+						return j.Snapshot()
+					}
+					return s.snapshotArchive(path)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	for _, path := range []string{s.aliasPath(), s.checkpointPath()} {
+		err = s.backupFile(bw, path, prev, next, nil)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Files that are gone
+	var removed []string
+	for rel := range prev.Files {
+		if _, ok := next.Files[rel]; !ok {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(removed)
+	for _, rel := range removed {
+		bw.WriteByte(recordRemove)
+		writeString(bw, rel)
+	}
+
+	bw.WriteByte(recordEnd)
+	return bw.Flush()
+}
+
+// backupFile writes a record of the blocks of the file at path that
+// changed since prev, if any, and records its state in next.  Journals
+// are read through the Snapshot returned by snap, other files directly
+// when snap is nil.
+func (s *Store) backupFile(w *bufio.Writer, path string, prev, next *BackupState,
+	snap func() (*timeseries.Snapshot, error)) error {
+	rel, err := filepath.Rel(s.Root, uncompressed(path))
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	old := prev.Files[rel]
+	if old.Size == stat.Size() && old.ModTime == stat.ModTime().UnixNano() && old.Blocks != nil {
+		next.Files[rel] = old
+		return nil
+	}
+
+	var r io.ReaderAt
+	var size int64
+	if snap != nil {
+		sn, err := snap()
+		if err != nil {
+			return err
+		}
+		defer sn.Close()
+		r, size = sn, sn.Size()
+	} else {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(buf), int64(len(buf))
+	}
+
+	state := FileState{ModTime: stat.ModTime().UnixNano(), Size: size}
+	var changed []int
+	buf := make([]byte, BackupBlock)
+	for i := 0; int64(i)*BackupBlock < size; i++ {
+		n, err := r.ReadAt(buf, int64(i)*BackupBlock)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		sum := crc32.ChecksumIEEE(buf[:n])
+		state.Blocks = append(state.Blocks, sum)
+		if i >= len(old.Blocks) || old.Blocks[i] != sum {
+			changed = append(changed, i)
+		}
+	}
+	if state.Blocks == nil {
+		state.Blocks = []uint32{}
+	}
+	next.Files[rel] = state
+	if len(changed) == 0 && old.Size == size && old.Blocks != nil {
+		return nil
+	}
+
+	w.WriteByte(recordFile)
+	writeString(w, rel)
+	writeUvarint(w, uint64(size))
+	writeUvarint(w, uint64(len(changed)))
+	for _, i := range changed {
+		n, err := r.ReadAt(buf, int64(i)*BackupBlock)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		writeUvarint(w, uint64(i))
+		writeUvarint(w, uint64(n))
+		w.Write(buf[:n])
+	}
+	return nil
+}
+
+// Restore applies a stream from BackupSince to the store rooted at dir.
+// A full backup followed by each later incremental backup, in order,
+// recreates the store as of the last of them.
+func Restore(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != backupMagic {
+		if isEncrypted(magic) {
+			return ErrEncrypted
+		}
+		return errors.New("Not a journal backup stream")
+	}
+
+	for {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return corrupt(err)
+		}
+		if kind == recordEnd {
+			return nil
+		}
+		rel, err := readString(br)
+		if err != nil {
+			return corrupt(err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if rel == "" || strings.HasPrefix(rel, "/") || !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("Invalid path in backup stream: %q", rel)
+		}
+
+		switch kind {
+		case recordRemove:
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		case recordFile:
+			if err = restoreFile(br, path); err != nil {
+				return err
+			}
+		default:
+			return corrupt(fmt.Errorf("unknown record %q", kind))
+		}
+	}
+}
+
+// restoreFile applies a file record to the file at path.
+func restoreFile(r *bufio.Reader, path string) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return corrupt(err)
+	}
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return corrupt(err)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), timeseries.DefaultDirMode); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, timeseries.DefaultFileMode)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	buf := make([]byte, BackupBlock)
+	for ; count > 0; count-- {
+		i, err := binary.ReadUvarint(r)
+		if err != nil {
+			return corrupt(err)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > BackupBlock {
+			return corrupt(err)
+		}
+		if _, err = io.ReadFull(r, buf[:n]); err != nil {
+			return corrupt(err)
+		}
+		if _, err = fd.WriteAt(buf[:n], int64(i)*BackupBlock); err != nil {
+			return err
+		}
+	}
+	if err = fd.Truncate(int64(size)); err != nil {
+		return err
+	}
+	return fd.Sync()
+}
+
+func corrupt(err error) error {
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("Corrupt backup stream: %s", err)
+}
+
+func writeUvarint(w *bufio.Writer, x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 4096 {
+		return "", fmt.Errorf("path of %d bytes", n)
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
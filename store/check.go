@@ -0,0 +1,32 @@
+package store
+
+import (
+	"fmt"
+	"os"
+)
+
+import (
+	"github.com/jjneely/journal/lock"
+)
+
+// Check verifies that journals can be created in the store by writing,
+// syncing, and locking a temporary file in its root.
+func (s *Store) Check() error {
+	fd, err := os.CreateTemp(s.Root, ".check-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	if _, err = fd.Write([]byte("ok\n")); err != nil {
+		return err
+	}
+	if err = fd.Sync(); err != nil {
+		return err
+	}
+	if err = lock.TryExclusive(fd); err != nil {
+		return fmt.Errorf("Cannot lock %s: %s", fd.Name(), err)
+	}
+	return lock.Release(fd)
+}
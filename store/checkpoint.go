@@ -0,0 +1,147 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// CheckpointFile is the name of the file in the root of a store that
+// holds ingestion checkpoints, as a JSON object keyed by checkpoint name.
+const CheckpointFile = "checkpoints.json"
+
+// Checkpoint is the position an ingestion pipeline has reached in its
+// source, such as a Kafka offset or the timestamp of the last point it
+// processed.
+type Checkpoint struct {
+	Offset    int64  `json:"offset"`
+	Timestamp int64  `json:"timestamp"`
+	Data      string `json:"data,omitempty"` // anything else the pipeline needs to resume
+}
+
+func (s *Store) checkpointPath() string {
+	return filepath.Join(s.Root, CheckpointFile)
+}
+
+// Checkpoints returns every checkpoint in the store.
+func (s *Store) Checkpoints() (map[string]Checkpoint, error) {
+	s.checkpointMu.Lock()
+	defer s.checkpointMu.Unlock()
+	return s.loadCheckpoints()
+}
+
+// LoadCheckpoint returns the named checkpoint and whether it exists.
+func (s *Store) LoadCheckpoint(name string) (Checkpoint, bool, error) {
+	checkpoints, err := s.Checkpoints()
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	cp, ok := checkpoints[name]
+	return cp, ok, nil
+}
+
+// SaveCheckpoint records the named checkpoint once the journals of the
+// given series, which hold the data written up to it, are synced to
+// disk.  The checkpoint file is replaced atomically so that after a crash
+// a pipeline resumes from a checkpoint whose data is all on disk,
+// although data written after it may be too.  Points buffered elsewhere,
+// as by a writer.Writer, must be flushed first.
+func (s *Store) SaveCheckpoint(name string, cp Checkpoint, series ...string) error {
+	if name == "" {
+		return fmt.Errorf("Empty checkpoint name")
+	}
+	for _, sn := range series {
+		err := s.Do(sn, func(j *timeseries.FileJournal) error {
+			j.Sync()
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("%s: %s", sn, err)
+		}
+	}
+	return s.editCheckpoints(func(checkpoints map[string]Checkpoint) {
+		checkpoints[name] = cp
+	})
+}
+
+// DeleteCheckpoint removes the named checkpoint.
+func (s *Store) DeleteCheckpoint(name string) error {
+	return s.editCheckpoints(func(checkpoints map[string]Checkpoint) {
+		delete(checkpoints, name)
+	})
+}
+
+// loadCheckpoints reads the checkpoint file.  The caller must hold
+// s.checkpointMu.
+func (s *Store) loadCheckpoints() (map[string]Checkpoint, error) {
+	checkpoints := make(map[string]Checkpoint)
+	buf, err := os.ReadFile(s.checkpointPath())
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &checkpoints); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.checkpointPath(), err)
+	}
+	return checkpoints, nil
+}
+
+// editCheckpoints applies edit to the checkpoints and durably replaces
+// the checkpoint file with the result.
+func (s *Store) editCheckpoints(edit func(checkpoints map[string]Checkpoint)) error {
+	s.checkpointMu.Lock()
+	defer s.checkpointMu.Unlock()
+	checkpoints, err := s.loadCheckpoints()
+	if err != nil {
+		return err
+	}
+	edit(checkpoints)
+
+	buf, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.Root, timeseries.DefaultDirMode); err != nil {
+		return err
+	}
+	path := s.checkpointPath()
+	tmp := path + ".tmp"
+	fd, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(append(buf, '\n'))
+	if err == nil && s.options().Durability != timeseries.SyncNone {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if s.options().Durability == timeseries.SyncFull {
+		return syncDir(s.Root)
+	}
+	return nil
+}
+
+// syncDir makes a rename in the directory at path durable.
+func syncDir(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/stats"
+)
+
+// DefaultChurnWindow is how long a series stays active after its last
+// write when Churn.Window is not set.
+const DefaultChurnWindow = time.Hour
+
+// Churn tracks the series written through a Store so that operators can
+// watch its cardinality: how fast new series are created, how many are
+// being written, and how many have stopped.  A series is active once
+// written through DoCreate and stops when a Window passes without another
+// write.  Only this process's writes since it started are seen.
+type Churn struct {
+	// Window is how long a series stays active without a write, or
+	// DefaultChurnWindow if zero.
+	Window time.Duration
+
+	// Clock, if set, is used in place of the system clock.
+	Clock clock.Clock
+
+	// Created counts the series whose journals were created and Stopped
+	// those that became inactive.
+	Created stats.Counter
+	Stopped stats.Counter
+
+	mu   sync.Mutex
+	last map[string]time.Time // of each active series
+}
+
+// wrote records a write to series.
+func (c *Churn) wrote(series string) {
+	now := clock.Or(c.Clock).Now()
+	c.mu.Lock()
+	if c.last == nil {
+		c.last = make(map[string]time.Time)
+	}
+	c.last[series] = now
+	c.mu.Unlock()
+}
+
+// created records a new series.
+func (c *Churn) created(series string) {
+	c.Created.Add(1)
+	c.wrote(series)
+}
+
+// Active returns the number of series written within the Window, as of
+// the last Sweep.
+func (c *Churn) Active() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.last)
+}
+
+// Sweep stops tracking the series not written within the Window and
+// returns them, sorted.
+func (c *Churn) Sweep() []string {
+	window := c.Window
+	if window <= 0 {
+		window = DefaultChurnWindow
+	}
+	cutoff := clock.Or(c.Clock).Now().Add(-window)
+	var stopped []string
+	c.mu.Lock()
+	for series, last := range c.last {
+		if last.Before(cutoff) {
+			delete(c.last, series)
+			stopped = append(stopped, series)
+		}
+	}
+	c.mu.Unlock()
+	c.Stopped.Add(int64(len(stopped)))
+	sort.Strings(stopped)
+	return stopped
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"errors"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// DefaultChunk is the number of values a Cursor reads at a time when no
+// chunk size is given.
+const DefaultChunk = 4096
+
+// ErrChanged stops a Cursor whose series had values deleted, trimmed, or
+// truncated since it started, so that chunks already returned may not
+// match the journal any more.  The caller should start over.
+var ErrChanged = errors.New("Series changed during read")
+
+// Cursor reads a series a chunk at a time so that long ranges can be
+// processed in bounded memory.  The journal is only open while a chunk
+// is read.  Pending points are merged into each chunk as by ReadMany.
+// Changes to values already in the series are detected, making Next fail
+// with ErrChanged, when its journal stays open between chunks in the
+// Store's Pool.
+//
+//	c := s.Cursor("servers.web01.cpu", from, until, 0)
+//	for c.Next() {
+//		r := c.Result()
+//		...
+//	}
+//	if err := c.Err(); err != nil {
+//		...
+//	}
+type Cursor struct {
+	s      *Store
+	series string
+	next   int64 // start of the next chunk, 0 before the first
+	from   int64
+	until  int64
+	chunk  int64
+	gen    uint64
+	r      Result
+	err    error
+}
+
+// Cursor returns a Cursor over the values of series from through until
+// read chunk values at a time, or DefaultChunk if chunk is not positive.
+func (s *Store) Cursor(series string, from, until int64, chunk int) *Cursor {
+	if chunk <= 0 {
+		chunk = DefaultChunk
+	}
+	return &Cursor{s: s, series: series, from: from, until: until, chunk: int64(chunk)}
+}
+
+// Next reads the next chunk holding any values and reports whether there
+// was one.  It returns false at the end of the range or on error.
+func (c *Cursor) Next() bool {
+	if c.err != nil {
+		return false
+	}
+	if c.next == 0 && !c.start() {
+		return false
+	}
+
+	for c.next <= c.until {
+		end := min(c.next+(c.chunk-1)*c.r.Interval, c.until)
+		r := c.s.read(c.series, c.next, end)
+		c.next = end + c.r.Interval
+		if r.Err == nil && r.Generation != c.gen {
+			r.Err = ErrChanged
+		}
+		if r.Err != nil {
+			c.err = r.Err
+			return false
+		}
+		if r.Values.Len() > 0 {
+			c.r = r
+			return true
+		}
+	}
+	return false
+}
+
+// start finds the interval of the series and the first chunk to read.
+// Without pending points nothing is read past the newest value.
+func (c *Cursor) start() bool {
+	c.err = c.s.View(c.series, func(j *timeseries.FileJournal) error {
+		c.r.Interval = j.Interval()
+		c.gen = j.Generation()
+		c.next = max(c.from-c.from%c.r.Interval, j.Epoch())
+		if c.s.Pending == nil {
+			if j.Epoch() == 0 {
+				c.until = c.next - 1
+			} else {
+				c.until = min(c.until, j.Last())
+			}
+		}
+		return nil
+	})
+	return c.err == nil && c.next != 0
+}
+
+// Result returns the chunk read by the last call to Next.
+func (c *Cursor) Result() Result {
+	return c.r
+}
+
+// Err returns the error that stopped the Cursor, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
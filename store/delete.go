@@ -0,0 +1,153 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/lock"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Files returns the paths of the raw journal and every rollup archive of
+// the given series that exist.  Those of an alias are the files of the
+// series it refers to.
+func (s *Store) Files(series string) ([]string, error) {
+	if err := Validate(series); err != nil {
+		return nil, err
+	}
+	return s.files(s.Resolve(series))
+}
+
+// files is Files without validation or alias resolution.
+func (s *Store) files(series string) ([]string, error) {
+	path := s.path(series)
+	var files, archives []string
+	for _, ext := range journalExts() {
+		if _, err := os.Stat(path + ext); err == nil {
+			files = append(files, path+ext)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		matches, err := filepath.Glob(strings.TrimSuffix(path, Ext) + "@*" + Ext + ext)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, matches...)
+	}
+	return append(files, archives...), nil
+}
+
+// journalExts returns what may follow Ext in the name of a journal: ""
+// and the extension of each compression codec.
+func journalExts() []string {
+	exts := []string{""}
+	for _, c := range timeseries.Codecs() {
+		exts = append(exts, c.Ext)
+	}
+	return exts
+}
+
+// isJournal reports whether path is named as a journal, compressed or
+// not.
+func isJournal(path string) bool {
+	return filepath.Ext(uncompressed(path)) == Ext
+}
+
+// uncompressed returns path without the extension of a compression codec.
+func uncompressed(path string) string {
+	return strings.TrimSuffix(path, timeseries.CompressedExt(path))
+}
+
+// Delete removes the journal and rollup archives of a series.  Journals
+// locked by another process are not removed and an error is returned.
+// With dryRun set the files that would be removed are reported without
+// removing them.  Aliases must be removed with Unalias.
+//
+// With the Store's Trash set the files are instead moved into TrashDir,
+// reported as trash rather than delete changes, until Undelete restores
+// them or PurgeTrash removes them.
+func (s *Store) Delete(series string, dryRun bool) ([]timeseries.Change, error) {
+	if err := s.notAlias(series); err != nil {
+		return nil, err
+	}
+	if s.Pool != nil && !dryRun {
+		if err := s.Pool.Evict(series); err != nil {
+			return nil, err
+		}
+	}
+	files, err := s.Files(series)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, os.ErrNotExist
+	}
+	if !dryRun {
+		defer s.reindex(series)
+	}
+
+	op, now := "delete", clock.Or(s.options().Clock).Now()
+	if s.Trash {
+		op = "trash"
+	}
+	var changes []timeseries.Change
+	for _, path := range files {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return changes, err
+		}
+		c := timeseries.Change{
+			Op:     op,
+			Path:   path,
+			Bytes:  stat.Size(),
+			DryRun: dryRun,
+		}
+		if !dryRun {
+			if err = s.AuditChange(series, c); err != nil {
+				return changes, err
+			}
+			if s.Trash {
+				err = s.moveToTrash(path, now)
+			} else {
+				err = remove(path)
+			}
+			if err != nil {
+				return changes, err
+			}
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, nil
+}
+
+// remove unlinks a journal while holding its lock so that a journal in
+// use by a writer is never removed.
+func remove(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if err = lock.TryExclusive(fd); lock.IsResourceUnavailable(err) {
+		return timeseries.ErrLocked
+	} else if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	removeSidecars(path)
+	return nil
+}
+
+// removeSidecars removes the files kept beside the journal at path, such
+// as its block summaries and preview, which are rebuilt when next needed.
+func removeSidecars(path string) {
+	os.Remove(path + timeseries.BlockSummaryExt)
+	os.Remove(path + PreviewExt)
+}
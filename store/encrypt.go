@@ -0,0 +1,329 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// EncryptedExt is appended to the names of the files that Archive and
+// Snapshot encrypt.
+const EncryptedExt = ".enc"
+
+// EncryptChunk is the most plaintext sealed in each chunk of an encrypted
+// stream.
+const EncryptChunk = 65536
+
+// encryptMagic begins every encrypted stream.
+const encryptMagic = "JENC1\n"
+
+// ErrEncrypted is returned by Restore for a backup stream that must be
+// decrypted first.
+var ErrEncrypted = errors.New("Backup stream is encrypted")
+
+// Encryption encrypts files and streams with AES-GCM.  A stream records
+// the ID of its key, so that it can be decrypted after the key used for
+// new streams changes, and is sealed in chunks so that it is encrypted
+// and decrypted as it is read.  Reordered, altered, or truncated streams
+// fail to decrypt.
+type Encryption struct {
+	// KeyID names the key new streams are encrypted with.
+	KeyID string
+
+	// Key returns the AES key, of 16, 24, or 32 bytes, with the given
+	// ID.  It may fetch keys from a key management service.
+	Key func(id string) ([]byte, error)
+}
+
+// KeyFile returns an Encryption using the key in the file at path, as
+// raw bytes or hexadecimal, whose ID is the file's name.  Streams are
+// decrypted with the file of their key's ID in the same directory.
+func KeyFile(path string) *Encryption {
+	dir := filepath.Dir(path)
+	return &Encryption{
+		KeyID: filepath.Base(path),
+		Key: func(id string) ([]byte, error) {
+			if id != filepath.Base(id) || id == "." || id == ".." {
+				return nil, fmt.Errorf("Invalid key ID: %q", id)
+			}
+			buf, err := os.ReadFile(filepath.Join(dir, id))
+			if err != nil {
+				return nil, err
+			}
+			if key, err := hex.DecodeString(strings.TrimSpace(string(buf))); err == nil {
+				return key, nil
+			}
+			return buf, nil
+		},
+	}
+}
+
+func (e *Encryption) aead(id string) (cipher.AEAD, error) {
+	key, err := e.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("Key %q: %s", id, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Key %q: %s", id, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt returns a writer encrypting to w.  It must be closed to write
+// the final chunk, which does not close w.
+func (e *Encryption) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	aead, err := e.aead(e.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	ew := &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, EncryptChunk)}
+	if _, err = rand.Read(ew.nonce[:]); err != nil {
+		return nil, err
+	}
+	header := []byte(encryptMagic)
+	header = binary.AppendUvarint(header, uint64(len(e.KeyID)))
+	header = append(header, e.KeyID...)
+	header = append(header, ew.nonce[:]...)
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+// Decrypt returns a reader of the plaintext of the stream encrypted by
+// Encrypt read from r.  Reads fail if the stream has been altered.
+func (e *Encryption) Decrypt(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(encryptMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != encryptMagic {
+		return nil, errors.New("Not an encrypted stream")
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > 4096 {
+		return nil, errors.New("Corrupt encrypted stream")
+	}
+	id := make([]byte, n)
+	if _, err = io.ReadFull(br, id); err != nil {
+		return nil, errors.New("Corrupt encrypted stream")
+	}
+	aead, err := e.aead(string(id))
+	if err != nil {
+		return nil, err
+	}
+	dr := &decryptReader{r: br, aead: aead}
+	if _, err = io.ReadFull(br, dr.nonce[:]); err != nil {
+		return nil, errors.New("Corrupt encrypted stream")
+	}
+	return dr, nil
+}
+
+// chunkNonce returns the nonce of chunk i of a stream.
+func chunkNonce(base [12]byte, i uint64) []byte {
+	nonce := base
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(base[4:])^i)
+	return nonce[:]
+}
+
+// encryptWriter seals each EncryptChunk bytes written as a record of a
+// flag byte, which is 1 for the final chunk, the length of the sealed
+// chunk, and the sealed chunk.  The flag is authenticated so that a
+// stream cannot be cut short at a chunk boundary.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	nonce  [12]byte
+	chunk  uint64
+	buf    []byte
+	closed bool
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errors.New("Write to a closed encrypted stream")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(ew.buf) == EncryptChunk {
+			if err := ew.seal(0); err != nil {
+				return n - len(p), err
+			}
+		}
+		m := copy(ew.buf[len(ew.buf):EncryptChunk], p)
+		ew.buf = ew.buf[:len(ew.buf)+m]
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// Close writes the final chunk.
+func (ew *encryptWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+	return ew.seal(1)
+}
+
+func (ew *encryptWriter) seal(final byte) error {
+	flag := []byte{final}
+	record := binary.AppendUvarint(flag, uint64(len(ew.buf)+ew.aead.Overhead()))
+	record = ew.aead.Seal(record, chunkNonce(ew.nonce, ew.chunk), ew.buf, flag)
+	ew.chunk++
+	ew.buf = ew.buf[:0]
+	_, err := ew.w.Write(record)
+	return err
+}
+
+// decryptReader opens the records written by encryptWriter.
+type decryptReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	nonce [12]byte
+	chunk uint64
+	buf   []byte
+	done  bool
+	err   error
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		if dr.done {
+			return 0, io.EOF
+		}
+		dr.err = dr.open()
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+// open reads and opens the next record.
+func (dr *decryptReader) open() error {
+	corrupt := func(err error) error {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("Corrupt encrypted stream: %s", err)
+	}
+	final, err := dr.r.ReadByte()
+	if err != nil {
+		return corrupt(err)
+	}
+	n, err := binary.ReadUvarint(dr.r)
+	if err != nil {
+		return corrupt(err)
+	}
+	if final > 1 || n < uint64(dr.aead.Overhead()) || n > uint64(EncryptChunk+dr.aead.Overhead()) {
+		return corrupt(errors.New("invalid chunk"))
+	}
+	sealed := make([]byte, n)
+	if _, err = io.ReadFull(dr.r, sealed); err != nil {
+		return corrupt(err)
+	}
+	dr.buf, err = dr.aead.Open(sealed[:0], chunkNonce(dr.nonce, dr.chunk), sealed, []byte{final})
+	if err != nil {
+		return corrupt(err)
+	}
+	dr.chunk++
+	dr.done = final == 1
+	return nil
+}
+
+// createFile writes a new file at dst with the content written by fill,
+// encrypted by e if it is not nil with EncryptedExt appended to dst.  It
+// returns the path written.
+func createFile(dst string, e *Encryption, fill func(w io.Writer) error) (string, error) {
+	if e != nil {
+		dst += EncryptedExt
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), timeseries.DefaultDirMode); err != nil {
+		return dst, err
+	}
+	fd, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, timeseries.DefaultFileMode)
+	if err != nil {
+		return dst, err
+	}
+	err = func() error {
+		if e == nil {
+			return fill(fd)
+		}
+		bw := bufio.NewWriter(fd)
+		ew, err := e.Encrypt(bw)
+		if err != nil {
+			return err
+		}
+		if err = fill(ew); err != nil {
+			return err
+		}
+		if err = ew.Close(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}()
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return dst, err
+}
+
+// DecryptTree decrypts every file under dir whose name ends in
+// EncryptedExt, as written by Archive and Snapshot, replacing it with its
+// plaintext so that dir can be used as a store.  A Change is returned for
+// each file decrypted.
+func (e *Encryption) DecryptTree(dir string) ([]timeseries.Change, error) {
+	var changes []timeseries.Change
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, EncryptedExt) {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		r, err := e.Decrypt(src)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		var n int64
+		dst, err := createFile(strings.TrimSuffix(path, EncryptedExt), nil, func(w io.Writer) error {
+			n, err = io.Copy(w, r)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		changes = append(changes, timeseries.Change{Op: "decrypt", Path: dst, Bytes: n})
+		return os.Remove(path)
+	})
+	return changes, err
+}
+
+// isEncrypted reports whether buf begins an encrypted stream.
+func isEncrypted(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte(encryptMagic))
+}
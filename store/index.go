@@ -0,0 +1,240 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// SeriesIndexFile is the conventional name of a saved SeriesIndex in the
+// root of a store.
+const SeriesIndexFile = "series.json"
+
+// SeriesInfo is what a SeriesIndex records about the journal of a series.
+type SeriesInfo struct {
+	Interval int64 `json:"interval"`
+	Epoch    int64 `json:"epoch"`
+	Last     int64 `json:"last"` // zero without values
+	Size     int64 `json:"size"` // bytes
+}
+
+// SeriesIndex caches the header of every journal in a store so that
+// List and Find answer without walking the tree, which for hundreds of
+// thousands of series means as many stat calls.  A Store with an Index
+// keeps it current as it creates, writes, renames, and removes journals,
+// but changes made by other processes are only seen when the index is
+// rebuilt with BuildIndex.
+type SeriesIndex struct {
+	mu     sync.RWMutex
+	series map[string]SeriesInfo
+}
+
+// NewSeriesIndex returns an empty SeriesIndex.
+func NewSeriesIndex() *SeriesIndex {
+	return &SeriesIndex{series: make(map[string]SeriesInfo)}
+}
+
+// Get returns what the index records about series.
+func (x *SeriesIndex) Get(series string) (SeriesInfo, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	info, ok := x.series[series]
+	return info, ok
+}
+
+// Len returns the number of series in the index.
+func (x *SeriesIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.series)
+}
+
+// List returns the name of every series in the index, sorted.
+func (x *SeriesIndex) List() []string {
+	return x.Find("")
+}
+
+// Find returns the series matching a pattern as Store.Find does, sorted.
+// An empty pattern matches every series.
+func (x *SeriesIndex) Find(pattern string) []string {
+	glob := strings.Replace(pattern, ".", "/", -1)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var series []string
+	for name := range x.series {
+		if pattern != "" {
+			if ok, _ := filepath.Match(glob, strings.Replace(name, ".", "/", -1)); !ok {
+				continue
+			}
+		}
+		series = append(series, name)
+	}
+	sort.Strings(series)
+	return series
+}
+
+// Replace makes the index hold what y holds, such as a rebuilt index.
+func (x *SeriesIndex) Replace(y *SeriesIndex) {
+	y.mu.RLock()
+	series := make(map[string]SeriesInfo, len(y.series))
+	for name, info := range y.series {
+		series[name] = info
+	}
+	y.mu.RUnlock()
+	x.mu.Lock()
+	x.series = series
+	x.mu.Unlock()
+}
+
+func (x *SeriesIndex) put(series string, info SeriesInfo) {
+	x.mu.Lock()
+	x.series[series] = info
+	x.mu.Unlock()
+}
+
+func (x *SeriesIndex) remove(series string) {
+	x.mu.Lock()
+	delete(x.series, series)
+	x.mu.Unlock()
+}
+
+// record updates the entry of series from its open journal.
+func (x *SeriesIndex) record(series string, j *timeseries.FileJournal) {
+	info := SeriesInfo{Interval: j.Interval(), Epoch: j.Epoch(), Size: j.Size()}
+	if info.Epoch != 0 && j.Points() > 0 {
+		info.Last = j.Last()
+	}
+	x.put(series, info)
+}
+
+// Save atomically writes the index to path as JSON.
+func (x *SeriesIndex) Save(path string) error {
+	x.mu.RLock()
+	buf, err := json.Marshal(x.series)
+	x.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, append(buf, '\n'), 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LoadSeriesIndex reads an index written by SeriesIndex.Save.
+func LoadSeriesIndex(path string) (*SeriesIndex, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	x := NewSeriesIndex()
+	if err = json.Unmarshal(buf, &x.series); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return x, nil
+}
+
+// BuildIndex walks the store and returns a new SeriesIndex of its
+// journals.  Headers are read with timeseries.Peek so journals held by
+// writers are indexed without waiting for them.  Unreadable journals are
+// left out.
+func (s *Store) BuildIndex() (*SeriesIndex, error) {
+	x := NewSeriesIndex()
+	err := filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == TrashDir {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || !isJournal(path) {
+			return nil
+		}
+		name, err := s.series(path)
+		if err != nil {
+			return nil
+		}
+		if si, err := peekInfo(path, info.Size()); err == nil {
+			x.series[name] = si
+		}
+		return nil
+	})
+	return x, err
+}
+
+// peekInfo describes the journal at path of the given size from its
+// header alone.  Compressed journals, which are never locked, are opened.
+func peekInfo(path string, size int64) (SeriesInfo, error) {
+	if timeseries.CompressedExt(path) != "" {
+		j, err := timeseries.OpenWithOptions(path, &timeseries.Options{ReadOnly: true})
+		if err != nil {
+			return SeriesInfo{}, err
+		}
+		defer j.Close()
+		info := SeriesInfo{Interval: j.Interval(), Epoch: j.Epoch(), Size: size}
+		if info.Epoch != 0 && j.Points() > 0 {
+			info.Last = j.Last()
+		}
+		return info, nil
+	}
+	header, ext, err := timeseries.PeekExt(path)
+	if err != nil {
+		return SeriesInfo{}, err
+	}
+	info := SeriesInfo{Interval: header.Interval, Epoch: header.Epoch, Size: size}
+	points := max(size-header.ValuesOffset(ext), 0) / int64(header.Width)
+	if info.Epoch != 0 && points > 0 {
+		info.Last = info.Epoch + (points-1)*info.Interval
+	}
+	return info, nil
+}
+
+// reindex updates the Index entry of each series from its journal on
+// disk, removing those without one, and has the series of their tenants
+// counted again.
+func (s *Store) reindex(series ...string) {
+	if s.parent != nil {
+		for i, name := range series {
+			series[i] = TenantSeries(s.tenant, name)
+		}
+		s.parent.reindex(series...)
+		return
+	}
+	defer s.recount(series...)
+	if s.Index == nil {
+		return
+	}
+	for _, name := range series {
+		var path string
+		var stat os.FileInfo
+		var err error
+		for _, ext := range journalExts() {
+			path = s.path(name) + ext
+			if stat, err = os.Stat(path); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			s.Index.remove(name)
+			continue
+		}
+		if info, err := peekInfo(path, stat.Size()); err == nil {
+			s.Index.put(name, info)
+		} else {
+			s.Index.remove(name)
+		}
+	}
+}
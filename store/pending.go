@@ -0,0 +1,66 @@
+package store
+
+import (
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// merge overlays points, keyed by timestamp, on the values of a Result
+// read for from through until, extending the values with nulls if points
+// are past their end.  As when the points are written, the latest
+// timestamp in each interval wins.  Points before r.Start, which could
+// not be written, are ignored, as are the points of non-numeric journals.
+func (r *Result) merge(points map[int64]float64, until int64) {
+	slots := make(map[int64]int64, len(points))
+	n := int64(r.Values.Len())
+	for ts := range points {
+		slot := ts - ts%r.Interval
+		if slot < r.Start || slot > until {
+			continue
+		}
+		if prev, ok := slots[slot]; !ok || ts > prev {
+			slots[slot] = ts
+		}
+		n = max(n, (slot-r.Start)/r.Interval+1)
+	}
+	if len(slots) == 0 {
+		return
+	}
+
+	switch v := r.Values.(type) {
+	case Float64Values:
+		out := make(Float64Values, n)
+		for i := copy(out, v); i < len(out); i++ {
+			out[i] = math.NaN()
+		}
+		for slot, ts := range slots {
+			out[(slot-r.Start)/r.Interval] = points[ts]
+		}
+		r.Values = out
+	case Float32Values:
+		out := make(Float32Values, n)
+		for i := copy(out, v); i < len(out); i++ {
+			out[i] = float32(math.NaN())
+		}
+		for slot, ts := range slots {
+			out[(slot-r.Start)/r.Interval] = float32(points[ts])
+		}
+		r.Values = out
+	case Int64Values:
+		out := make(Int64Values, n)
+		for i := copy(out, v); i < len(out); i++ {
+			out[i] = math.MinInt64
+		}
+		for slot, ts := range slots {
+			if f := points[ts]; math.IsNaN(f) {
+				out[(slot-r.Start)/r.Interval] = math.MinInt64
+			} else {
+				out[(slot-r.Start)/r.Interval] = int64(f)
+			}
+		}
+		r.Values = out
+	}
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// DefaultPoolSize is the default number of journals a Pool keeps open.
+const DefaultPoolSize = 1024
+
+// Pool keeps recently used journals of a Store open so that frequent
+// reads and writes avoid the cost of opening and locking files.  Access
+// to each journal is serialized except for sealed journals, which cannot
+// change and are shared by concurrent readers.  When more than Size journals are open
+// the least recently used idle journals are closed.  Journals are opened
+// and closed without holding the pool's lock, so that a slow or locked
+// file only holds up the users of its own series.
+type Pool struct {
+	store *Store
+	size  int
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	lru     *list.List               // of *entry, most recently used first
+	closing map[string]chan struct{} // closed once the series is closed
+}
+
+type entry struct {
+	series string
+	mu     sync.Mutex // serializes use of j
+	j      *timeseries.FileJournal
+	err    error         // from opening j
+	ready  chan struct{} // closed once j or err is set
+	create bool          // opened with OpenOrCreate
+	elem   *list.Element
+	refs   int
+	sealed bool // set under the pool lock
+}
+
+// NewPool creates a Pool for the given Store that keeps at most size
+// journals open, or DefaultPoolSize if size is not positive.  The pool
+// is installed as the Store's Pool.
+func NewPool(s *Store, size int) *Pool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	p := &Pool{
+		store:   s,
+		size:    size,
+		entries: make(map[string]*entry),
+		lru:     list.New(),
+		closing: make(map[string]chan struct{}),
+	}
+	s.Pool = p
+	return p
+}
+
+// Do calls fn with the open journal for series, opening it if needed.
+// The journal must not be retained after fn returns.
+func (p *Pool) Do(series string, fn func(j *timeseries.FileJournal) error) error {
+	return p.do(series, false, fn)
+}
+
+// DoCreate is like Do but creates the journal with the Store's
+// OpenOrCreate if it does not exist.
+func (p *Pool) DoCreate(series string, fn func(j *timeseries.FileJournal) error) error {
+	return p.do(series, true, fn)
+}
+
+func (p *Pool) do(series string, create bool, fn func(j *timeseries.FileJournal) error) error {
+	// An alias shares the entry of its target
+	e, err := p.get(p.store.Resolve(series), create)
+	if err != nil {
+		return err
+	}
+	defer p.put(e)
+
+	p.mu.Lock()
+	sealed := e.sealed
+	p.mu.Unlock()
+	if sealed {
+		return fn(e.j)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err = fn(e.j)
+	if e.j.Sealed() {
+		p.mu.Lock()
+		e.sealed = true
+		p.mu.Unlock()
+	}
+	return err
+}
+
+// get returns the entry for series with a reference held, opening its
+// journal if needed.  The first user of a series adds its entry before
+// opening the journal and later users wait for it to be ready.
+func (p *Pool) get(series string, create bool) (*entry, error) {
+	for {
+		p.mu.Lock()
+		if e, ok := p.entries[series]; ok {
+			p.lru.MoveToFront(e.elem)
+			e.refs++
+			p.mu.Unlock()
+			<-e.ready
+			if e.err == nil {
+				return e, nil
+			}
+			p.mu.Lock()
+			e.refs--
+			p.mu.Unlock()
+			// A journal that did not exist may be created
+			if create && !e.create {
+				continue
+			}
+			return nil, e.err
+		}
+		e := &entry{series: series, ready: make(chan struct{}), create: create, refs: 1}
+		e.elem = p.lru.PushFront(e)
+		p.entries[series] = e
+		closing := p.closing[series]
+		p.mu.Unlock()
+
+		// The file stays locked until an evicted journal is closed
+		if closing != nil {
+			<-closing
+		}
+		if create {
+			e.j, e.err = p.store.OpenOrCreate(series)
+		} else {
+			e.j, e.err = p.store.Open(series)
+		}
+
+		p.mu.Lock()
+		if e.err != nil {
+			e.refs--
+			p.lru.Remove(e.elem)
+			delete(p.entries, series)
+		} else {
+			e.sealed = e.j.Sealed()
+		}
+		p.mu.Unlock()
+		close(e.ready)
+		if e.err != nil {
+			return nil, e.err
+		}
+		return e, nil
+	}
+}
+
+func (p *Pool) put(e *entry) {
+	p.mu.Lock()
+	e.refs--
+	removed := p.evict()
+	p.mu.Unlock()
+	p.close(removed)
+}
+
+// evict drops idle journals from the pool until it is within its size
+// and returns them to be closed.  The pool lock must be held.
+func (p *Pool) evict() []*entry {
+	var removed []*entry
+	for elem := p.lru.Back(); elem != nil && len(p.entries) > p.size; {
+		e := elem.Value.(*entry)
+		elem = elem.Prev()
+		if e.refs == 0 {
+			p.remove(e)
+			removed = append(removed, e)
+		}
+	}
+	return removed
+}
+
+// remove drops the journal from the pool, to be closed by close.  The
+// pool lock must be held and the entry must not be in use.
+func (p *Pool) remove(e *entry) {
+	p.lru.Remove(e.elem)
+	delete(p.entries, e.series)
+	p.closing[e.series] = make(chan struct{})
+}
+
+// close syncs and closes the journals of entries dropped by remove.  The
+// pool lock must not be held.
+func (p *Pool) close(removed []*entry) {
+	for _, e := range removed {
+		e.j.Sync()
+		e.j.Close()
+		p.mu.Lock()
+		close(p.closing[e.series])
+		delete(p.closing, e.series)
+		p.mu.Unlock()
+	}
+}
+
+// Evict closes the journal for series if it is open in the pool so that
+// the file may be modified by other means.  An error is returned if the
+// journal is in use.
+func (p *Pool) Evict(series string) error {
+	series = p.store.Resolve(series)
+	p.mu.Lock()
+	e, ok := p.entries[series]
+	if !ok {
+		p.mu.Unlock()
+		return nil
+	}
+	if e.refs > 0 {
+		p.mu.Unlock()
+		return fmt.Errorf("Journal is in use: %s", series)
+	}
+	p.remove(e)
+	p.mu.Unlock()
+	p.close([]*entry{e})
+	return nil
+}
+
+// Len returns the number of journals currently open in the pool.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// Close closes every idle journal in the pool.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	var removed []*entry
+	for _, e := range p.entries {
+		if e.refs == 0 {
+			p.remove(e)
+			removed = append(removed, e)
+		}
+	}
+	p.mu.Unlock()
+	p.close(removed)
+}
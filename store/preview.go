@@ -0,0 +1,128 @@
+package store
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const (
+	// PreviewExt is appended to the path of a journal to name the file
+	// caching its Preview.
+	PreviewExt = ".spark"
+
+	// PreviewPoints is the size of a Preview unless another is asked
+	// for.
+	PreviewPoints = 128
+)
+
+// previewMagic begins every preview file.
+var previewMagic = [4]byte{0x42, 0x4A, 0x54, 0x50} // "BJTP"
+
+// previewHeader is the size of the header of a preview file: the magic
+// number and the size asked for, then the epoch, points, and
+// modification time in Unix nanoseconds of the journal it describes and
+// the Start, Step, and number of values of the preview, all little
+// endian.
+const previewHeader = 56
+
+// Preview is a series consolidated to a few values, the average of each
+// Step, for drawing sparklines.
+type Preview struct {
+	Start, Step int64
+	Values      Float64Values
+}
+
+// previewKey identifies the state of a journal that a Preview describes.
+type previewKey struct {
+	epoch, points, modified int64
+}
+
+// Preview returns the whole of a numeric series consolidated to at most
+// size values, which is at least two and PreviewPoints if it is not
+// positive.  The Preview is cached in a file beside the journal until
+// the journal changes, and with timeseries.Options.BlockSummaries is
+// computed from them.
+func (s *Store) Preview(series string, size int) (Preview, error) {
+	if size <= 0 {
+		size = PreviewPoints
+	}
+	size = max(size, 2)
+	path, err := s.Path(series)
+	if err != nil {
+		return Preview{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	var p Preview
+	err = s.View(series, func(j *timeseries.FileJournal) error {
+		key := previewKey{j.Epoch(), j.Points(), stat.ModTime().UnixNano()}
+		if cached, ok := loadPreview(path+PreviewExt, key, size); ok {
+			p = cached
+			return nil
+		}
+		if j.Epoch() == 0 {
+			p = Preview{Step: j.Interval()}
+			return nil
+		}
+		// Steps are aligned, so the first and last may be partial
+		n := (j.Points() + int64(size) - 2) / int64(size-1)
+		p.Step = max(n, 1) * j.Interval()
+		var err error
+		if p.Start, p.Values, err = j.ReadAggregate(j.Epoch(), j.Last(), p.Step, timeseries.AggAverage); err != nil {
+			return err
+		}
+		// The cache is only an optimization
+		savePreview(path+PreviewExt, key, size, p)
+		return nil
+	})
+	return p, err
+}
+
+// loadPreview returns the Preview of the given size cached at path if it
+// describes the journal in the state given by key.
+func loadPreview(path string, key previewKey, size int) (Preview, bool) {
+	buf, err := os.ReadFile(path)
+	if err != nil || len(buf) < previewHeader || [4]byte(buf[:4]) != previewMagic {
+		return Preview{}, false
+	}
+	word := func(i int) int64 { return int64(binary.LittleEndian.Uint64(buf[8+8*i:])) }
+	count := word(5)
+	if int(binary.LittleEndian.Uint32(buf[4:])) != size || (previewKey{word(0), word(1), word(2)}) != key ||
+		count < 0 || int64(len(buf)) != previewHeader+8*count {
+		return Preview{}, false
+	}
+	p := Preview{Start: word(3), Step: word(4), Values: make(Float64Values, count)}
+	for i := range p.Values {
+		p.Values[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[previewHeader+8*i:]))
+	}
+	return p, true
+}
+
+// savePreview caches p, of the given size, at path, replacing any earlier
+// cache atomically.
+func savePreview(path string, key previewKey, size int, p Preview) {
+	buf := append([]byte(nil), previewMagic[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(size))
+	for _, n := range []int64{key.epoch, key.points, key.modified, p.Start, p.Step, int64(len(p.Values))} {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(n))
+	}
+	for _, v := range p.Values {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(v))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// mergeChunk is the number of values merged at a time.
+const mergeChunk = 65536
+
+// Rename moves the journal and rollup archives of series oldName to
+// newName, creating directories for the new name and removing those left
+// empty by the old one.  Journals locked by another process are not
+// moved and ErrLocked is returned.
+//
+// If newName already has a journal Rename fails unless merge is set, in
+// which case the two histories are combined: the existing values of
+// newName are kept and the values of oldName fill its nulls and extend
+// it in either direction.  Both journals must then have the same type and
+// interval.  Nothing is moved unless every file can be.  Neither name
+// may be an alias.
+func (s *Store) Rename(oldName, newName string, merge bool) error {
+	if err := Validate(newName); err != nil {
+		return err
+	}
+	if oldName == newName {
+		return fmt.Errorf("Cannot rename %s to itself", oldName)
+	}
+	for _, name := range []string{oldName, newName} {
+		if err := s.notAlias(name); err != nil {
+			return err
+		}
+	}
+	if s.Pool != nil {
+		if err := s.Pool.Evict(oldName); err != nil {
+			return err
+		}
+		if err := s.Pool.Evict(newName); err != nil {
+			return err
+		}
+	}
+	files, err := s.Files(oldName)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return os.ErrNotExist
+	}
+
+	// Work out every destination before moving anything
+	oldPath, _ := s.Path(oldName)
+	dsts := make([]string, len(files))
+	for i, path := range files {
+		if dsts[i], err = s.renamed(path, oldPath, newName); err != nil {
+			return err
+		}
+		if _, err = os.Lstat(dsts[i]); err == nil && !merge {
+			return &os.PathError{Op: "rename", Path: dsts[i], Err: os.ErrExist}
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if TenantOf(newName) != TenantOf(oldName) {
+		if err = s.checkQuota(newName); err != nil {
+			return err
+		}
+	}
+
+	detail := "to " + newName
+	if merge {
+		detail += " merging"
+	}
+	if err = s.Audit(AuditEntry{Op: "rename", Series: oldName, Detail: detail}); err != nil {
+		return err
+	}
+	defer s.reindex(oldName, newName)
+	for i, path := range files {
+		if _, err = os.Lstat(dsts[i]); err == nil {
+			err = s.mergeFile(path, dsts[i])
+		} else {
+			err = move(path, dsts[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.prune(filepath.Dir(oldPath))
+	return nil
+}
+
+// renamed returns the path that the journal or archive at path of the
+// series stored at oldPath has once renamed to newName.
+func (s *Store) renamed(path, oldPath, newName string) (string, error) {
+	if path == oldPath {
+		return s.Path(newName)
+	}
+	if ext := timeseries.CompressedExt(path); ext != "" {
+		dst, err := s.renamed(strings.TrimSuffix(path, ext), oldPath, newName)
+		return dst + ext, err
+	}
+	var interval int64
+	suffix := strings.TrimPrefix(path, strings.TrimSuffix(oldPath, Ext))
+	if _, err := fmt.Sscanf(suffix, "@%d"+Ext, &interval); err != nil {
+		return "", fmt.Errorf("Not a rollup archive: %s", path)
+	}
+	return s.ArchivePath(newName, interval)
+}
+
+// mergeFile combines the journal at src into the journal at dst as
+// described by Rename and removes src.  Values can only be written from
+// a journal's epoch onward, so when src is older the merged values are
+// written to it and it replaces dst.
+func (s *Store) mergeFile(src, dst string) error {
+	from, err := timeseries.OpenWithOptions(src, s.Options)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := timeseries.OpenWithOptions(dst, s.Options)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+	if from.Factory().Type() != to.Factory().Type() || from.Width() != to.Width() ||
+		from.Interval() != to.Interval() {
+		return fmt.Errorf("Cannot merge journals of different types: %s into %s", src, dst)
+	}
+
+	if from.Epoch() != 0 && (to.Epoch() == 0 || from.Epoch() < to.Epoch()) {
+		// Keep the values of dst by writing them over src
+		if err = overlay(from, to, true); err != nil {
+			return err
+		}
+		from.Sync()
+		removeSidecars(src)
+		removeSidecars(dst)
+		return os.Rename(src, dst)
+	}
+	if err = overlay(to, from, false); err != nil {
+		return err
+	}
+	to.Sync()
+	removeSidecars(src)
+	return os.Remove(src)
+}
+
+// overlay writes the values of src into dst.  If replace is set the
+// non-null values of src replace those of dst, otherwise they only fill
+// the nulls of dst and extend it.
+func overlay(dst, src *timeseries.FileJournal, replace bool) error {
+	if src.Epoch() == 0 {
+		return nil
+	}
+	null := dst.Factory().Null()
+	isNull := func(v Values, i int) bool {
+		return bytes.Equal(v.Slice(i, i+1).Encode(), null)
+	}
+
+	interval := src.Interval()
+	for start := src.Epoch(); start <= src.Last(); start += mergeChunk * interval {
+		values, err := src.ReadRange(start, start+(mergeChunk-1)*interval)
+		if err != nil {
+			return err
+		}
+		existing, err := dst.ReadRange(start, start+int64(values.Len()-1)*interval)
+		if err != nil {
+			return err
+		}
+
+		// Write each run of values that should change.  The epoch of
+		// dst is never after start so existing lines up with values.
+		keep := func(i int) bool {
+			if isNull(values, i) {
+				return true
+			}
+			return !replace && i < existing.Len() && !isNull(existing, i)
+		}
+		for i := 0; i < values.Len(); {
+			if keep(i) {
+				i++
+				continue
+			}
+			j := i + 1
+			for j < values.Len() && !keep(j) {
+				j++
+			}
+			if err = dst.Write(start+int64(i)*interval, values.Slice(i, j)); err != nil {
+				return err
+			}
+			i = j
+		}
+	}
+	return nil
+}
+
+// prune removes dir and its parents up to the store's root while they
+// are empty.
+func (s *Store) prune(dir string) {
+	root := filepath.Clean(s.Root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
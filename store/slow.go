@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// SlowLog reports the operations that take longer than a threshold, to
+// find disk contention.  A zero threshold reports nothing of that kind.
+type SlowLog struct {
+	Read  time.Duration // of a series through ReadMany or a Cursor
+	Write time.Duration // as reported by writers
+	Query time.Duration // as reported by servers
+
+	// Log is called with each slow operation.
+	Log func(op SlowOp)
+}
+
+// SlowOp describes an operation reported to a SlowLog.
+type SlowOp struct {
+	Op          string // read, write, or query
+	Series      string // several are comma separated
+	From, Until int64
+	Points      int64
+	Bytes       int64
+	Wait        time.Duration // before the journal was available, as for the Pool
+	Duration    time.Duration // of the whole operation, including Wait
+}
+
+func (op SlowOp) String() string {
+	return fmt.Sprintf("%s %s from %d until %d: %d points (%d bytes) in %s, %s waiting",
+		op.Op, op.Series, op.From, op.Until, op.Points, op.Bytes, op.Duration, op.Wait)
+}
+
+// Observe reports op if it took longer than the threshold for its kind.
+// A nil SlowLog reports nothing.
+func (l *SlowLog) Observe(op SlowOp) {
+	if l == nil || l.Log == nil {
+		return
+	}
+	var threshold time.Duration
+	switch op.Op {
+	case "read":
+		threshold = l.Read
+	case "write":
+		threshold = l.Write
+	case "query":
+		threshold = l.Query
+	}
+	if threshold > 0 && op.Duration >= threshold {
+		l.Log(op)
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+import (
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Snapshot copies the journals and rollup archives of the given series
+// into dir so that a store rooted at dir holds them as they all were at
+// one instant, for backups of a whole namespace.  Every file is held
+// open at once, which blocks writers, only while its length is recorded.
+// The values are copied afterward.  Aliases are skipped as they share
+// their target's journals, and the alias and checkpoint files are copied as
+// they are.  Compressed journals are copied decompressed.
+//
+// With the Store's Encryption set every file is encrypted, with
+// EncryptedExt appended to its name, and Encryption.DecryptTree turns dir
+// back into a store.
+//
+// Files locked by another process make Snapshot fail with ErrLocked
+// unless Options.LockTimeout lets it wait for them.  A Change is
+// returned for each file copied.
+func (s *Store) Snapshot(series []string, dir string) ([]timeseries.Change, error) {
+	names := make([]string, 0, len(series))
+	seen := make(map[string]bool)
+	for _, name := range series {
+		if err := Validate(name); err != nil {
+			return nil, err
+		}
+		if s.Resolve(name) == name && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	// A consistent order keeps concurrent Snapshots from deadlocking
+	sort.Strings(names)
+
+	var snaps []*timeseries.Snapshot
+	var paths []string
+	defer func() {
+		for _, snap := range snaps {
+			snap.Close()
+		}
+	}()
+	var take func(i int) error
+	take = func(i int) error {
+		if i == len(names) {
+			return nil
+		}
+		return s.Do(names[i], func(j *timeseries.FileJournal) error {
+			files, err := s.files(names[i])
+			if err != nil {
+				return err
+			}
+			for _, path := range files {
+				var snap *timeseries.Snapshot
+				if path == s.path(names[i]) {
+					snap, err = j.Snapshot()
+				} else {
+					snap, err = s.snapshotArchive(path)
+				}
+				if err != nil {
+					return err
+				}
+				snaps = append(snaps, snap)
+				paths = append(paths, path)
+			}
+			return take(i + 1)
+		})
+	}
+	if err := take(0); err != nil {
+		return nil, err
+	}
+
+	var changes []timeseries.Change
+	for i, snap := range snaps {
+		rel, err := filepath.Rel(s.Root, uncompressed(paths[i]))
+		if err != nil {
+			return changes, err
+		}
+		dst, err := createFile(filepath.Join(dir, rel), s.Encryption, func(w io.Writer) error {
+			_, err := snap.WriteTo(w)
+			return err
+		})
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, timeseries.Change{Op: "snapshot", Path: dst, Bytes: snap.Size()})
+	}
+
+	for _, name := range []string{AliasFile, CheckpointFile} {
+		buf, err := os.ReadFile(filepath.Join(s.Root, name))
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return changes, err
+		case s.Encryption == nil:
+			err = os.WriteFile(filepath.Join(dir, name), buf, 0644)
+		default:
+			_, err = createFile(filepath.Join(dir, name), s.Encryption, func(w io.Writer) error {
+				_, err := w.Write(buf)
+				return err
+			})
+		}
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// snapshotArchive takes a Snapshot of the rollup archive at path.
+func (s *Store) snapshotArchive(path string) (*timeseries.Snapshot, error) {
+	opts := *s.options()
+	opts.ReadOnly = true
+	j, err := timeseries.OpenWithOptions(path, &opts)
+	if err != nil {
+		return nil, err
+	}
+	defer j.Close()
+	return j.Snapshot()
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/lock"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// StaleSeries describes a series that has not been written recently.
+type StaleSeries struct {
+	Series   string
+	Last     int64     // timestamp of the newest value, 0 if empty
+	Modified time.Time // zero for journals that do not record it
+}
+
+// Stale returns the series that hold no values at or after the Unix
+// timestamp before and, for journals that record it, were last modified
+// before it.  The modification time keeps series that are being
+// backfilled with old values from being reported.  Series that cannot be
+// read are skipped.
+func (s *Store) Stale(before int64) ([]StaleSeries, error) {
+	series, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []StaleSeries
+	for _, name := range series {
+		var st StaleSeries
+		isStale := false
+		err := s.View(name, func(j *timeseries.FileJournal) error {
+			st = StaleSeries{Series: name, Modified: j.Modified()}
+			if j.Epoch() != 0 {
+				st.Last = j.Last()
+			}
+			isStale = st.Last < before &&
+				(st.Modified.IsZero() || st.Modified.Unix() < before)
+			return nil
+		})
+		if err == nil && isStale {
+			stale = append(stale, st)
+		}
+	}
+	return stale, nil
+}
+
+// Archive moves the journal and rollup archives of a series into the
+// directory dir, keeping their paths relative to the store's root, so
+// that a store rooted at dir holds the series.  Like Delete, journals
+// locked by another process are not moved and dryRun reports the files
+// without moving them.  With the Store's Encryption set each file is
+// instead encrypted into dir, with EncryptedExt appended to its name,
+// and removed.
+func (s *Store) Archive(series, dir string, dryRun bool) ([]timeseries.Change, error) {
+	if err := s.notAlias(series); err != nil {
+		return nil, err
+	}
+	if s.Pool != nil && !dryRun {
+		if err := s.Pool.Evict(series); err != nil {
+			return nil, err
+		}
+	}
+	files, err := s.Files(series)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, os.ErrNotExist
+	}
+	if !dryRun {
+		defer s.reindex(series)
+	}
+
+	var changes []timeseries.Change
+	for _, path := range files {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return changes, err
+		}
+		c := timeseries.Change{
+			Op:     "archive",
+			Path:   path,
+			Bytes:  stat.Size(),
+			DryRun: dryRun,
+		}
+		if !dryRun {
+			rel, err := filepath.Rel(s.Root, path)
+			if err != nil {
+				return changes, err
+			}
+			dst := filepath.Join(dir, rel)
+			err = s.Audit(AuditEntry{Op: c.Op, Series: series, Path: path, Bytes: c.Bytes, Detail: "to " + dst})
+			if err != nil {
+				return changes, err
+			}
+			if s.Encryption != nil {
+				err = moveEncrypted(path, dst, s.Encryption)
+			} else {
+				err = move(path, dst)
+			}
+			if err != nil {
+				return changes, err
+			}
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, nil
+}
+
+// move renames a journal while holding its lock so that a journal in use
+// by a writer is never moved.  The destination must not exist.
+func move(path, dst string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if err = lock.TryExclusive(fd); lock.IsResourceUnavailable(err) {
+		return timeseries.ErrLocked
+	} else if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if _, err = os.Lstat(dst); err == nil {
+		return &os.PathError{Op: "archive", Path: dst, Err: os.ErrExist}
+	}
+	if err = os.Rename(path, dst); err != nil {
+		return err
+	}
+	removeSidecars(path)
+	return nil
+}
+
+// moveEncrypted is move for a Store with Encryption: the journal is
+// encrypted to dst, with EncryptedExt appended, and removed while its
+// lock is held.
+func moveEncrypted(path, dst string, e *Encryption) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if err = lock.TryExclusive(fd); lock.IsResourceUnavailable(err) {
+		return timeseries.ErrLocked
+	} else if err != nil {
+		return err
+	}
+
+	_, err = createFile(dst, e, func(w io.Writer) error {
+		_, err := io.Copy(w, fd)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	removeSidecars(path)
+	return nil
+}
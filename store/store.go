@@ -0,0 +1,546 @@
+// Package store manages a directory tree of timeseries journals addressed
+// by dotted series names in the style of Graphite.  The series
+// "servers.web01.cpu" is stored in <root>/servers/web01/cpu.tsj.
+package store
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const (
+	// Ext is the file name extension of journals in a store.
+	Ext = ".tsj"
+
+	// DefaultWorkers is the number of journals batch operations work on
+	// concurrently.
+	DefaultWorkers = 8
+)
+
+// Store is a directory tree of timeseries journals.
+type Store struct {
+	// Root is the base directory of the store.
+	Root string
+
+	// Options are used when opening and creating journals.  Nil uses
+	// timeseries.DefaultOptions.
+	Options *timeseries.Options
+
+	// Pool, if set, is used to access journals rather than opening and
+	// closing them for every operation.
+	Pool *Pool
+
+	// Index, if set, answers List and Find in place of walking the
+	// tree.  The Store keeps it current through Create, OpenOrCreate,
+	// Do, DoCreate, Delete, Archive, and Rename.
+	Index *SeriesIndex
+
+	// Churn, if set, counts the series created by OpenOrCreate and
+	// tracks those written through DoCreate.
+	Churn *Churn
+
+	// Slow, if set, reports reads slower than its threshold.  Writers
+	// and servers using the Store report to it too.
+	Slow *SlowLog
+
+	// Workers bounds the concurrency of batch operations such as ReadMany.
+	Workers int
+
+	// Schema, if set, supplies the interval and value type used by
+	// OpenOrCreate to create journals for new series.
+	Schema func(series string) (int64, ValueType, error)
+
+	// CarryForward, if set, reports whether the journal of a series is
+	// opened with timeseries.Options.CarryForward so that gaps written
+	// to it repeat the last value.
+	CarryForward func(series string) bool
+
+	// AuditLog, if set, is the path of an append-only log, usually
+	// AuditFile in Root, where Delete, Archive, and Rename record what
+	// they are about to do.  AuditUser names who does it, by default the
+	// user running the process.
+	AuditLog  string
+	AuditUser string
+
+	// Pending, if set, returns the points of a series from through until
+	// that have been accepted but not yet written, keyed by timestamp,
+	// such as those buffered by a writer.Writer.  ReadMany merges them
+	// with the values read so that points are visible as soon as they
+	// are ingested.  Series without a journal are not read at all.
+	Pending func(series string, from, until int64) map[int64]float64
+
+	// Encryption, if set, encrypts the files that Archive and Snapshot
+	// write, which are named with EncryptedExt, and the streams returned
+	// by BackupSince.
+	Encryption *Encryption
+
+	// Tenants, if set, divides the store into the namespaces of the
+	// tenants it names, keyed by the first node of their series.
+	// Series of other tenants cannot be created, and Create and
+	// OpenOrCreate fail with a *QuotaError once a tenant has as many
+	// series as it may.
+	Tenants map[string]*Tenant
+
+	// Trash, if set, makes Delete move the files of series into
+	// TrashDir rather than removing them.
+	Trash bool
+
+	aliases      aliases
+	checkpointMu sync.Mutex
+
+	tenantMu     sync.Mutex
+	tenantSeries map[string]int // series counted per tenant
+	parent       *Store         // of a Store returned by Scope
+	tenant       string
+}
+
+// New returns a Store rooted at the given directory.
+func New(root string) *Store {
+	return &Store{
+		Root:    root,
+		Workers: DefaultWorkers,
+	}
+}
+
+// Path returns the file system path of the journal for the given series.
+// The path of an alias is that of the series it refers to.
+func (s *Store) Path(series string) (string, error) {
+	if err := Validate(series); err != nil {
+		return "", err
+	}
+	return s.path(s.Resolve(series)), nil
+}
+
+// path is Path without validation or alias resolution.
+func (s *Store) path(series string) string {
+	return filepath.Join(s.Root, strings.Replace(series, ".", "/", -1)+Ext)
+}
+
+// ArchivePath returns the file system path of the rollup archive of the
+// given series at the given interval.  Archives live beside the raw
+// journal as <name>@<interval>.tsj.
+func (s *Store) ArchivePath(series string, interval int64) (string, error) {
+	path, err := s.Path(series)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s@%d%s", strings.TrimSuffix(path, Ext), interval, Ext), nil
+}
+
+// Archives returns the intervals of the rollup archives of series that
+// exist, finest first.
+func (s *Store) Archives(series string) ([]int64, error) {
+	files, err := s.Files(series)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(s.path(s.Resolve(series)), Ext)
+	var intervals []int64
+	for _, path := range files {
+		var interval int64
+		path = uncompressed(path)
+		if _, err := fmt.Sscanf(strings.TrimPrefix(path, prefix), "@%d"+Ext, &interval); err == nil {
+			intervals = append(intervals, interval)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals, nil
+}
+
+// Validate checks that a series name is usable as a path in the store.
+func Validate(series string) error {
+	if series == "" {
+		return fmt.Errorf("Empty series name")
+	}
+	if strings.ContainsAny(series, "/@\x00") {
+		return fmt.Errorf("Invalid character in series name: %q", series)
+	}
+	for _, node := range strings.Split(series, ".") {
+		if node == "" {
+			return fmt.Errorf("Empty node in series name: %q", series)
+		}
+	}
+	return nil
+}
+
+// Open opens the journal for the given series.
+func (s *Store) Open(series string) (*timeseries.FileJournal, error) {
+	path, err := s.Path(series)
+	if err != nil {
+		return nil, err
+	}
+	return timeseries.OpenWithOptions(path, s.seriesOptions(series))
+}
+
+// Create creates a new journal for the given series.
+func (s *Store) Create(series string, interval int64, factory ValueType, meta []int64) (*timeseries.FileJournal, error) {
+	path, err := s.Path(series)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.checkQuota(s.Resolve(series)); err != nil {
+		return nil, err
+	}
+	j, err := timeseries.CreateWithOptions(path, interval, factory, meta, s.seriesOptions(series))
+	if err == nil {
+		s.created(s.Resolve(series), j)
+	}
+	if err == nil && s.Index != nil {
+		s.Index.record(s.Resolve(series), j)
+	}
+	return j, err
+}
+
+// OpenOrCreate opens the journal for the given series, creating it as
+// described by the Store's Schema if it does not exist.
+func (s *Store) OpenOrCreate(series string) (*timeseries.FileJournal, error) {
+	j, err := s.Open(series)
+	if !os.IsNotExist(err) || s.Schema == nil {
+		return j, err
+	}
+
+	interval, factory, err := s.Schema(series)
+	if err != nil {
+		return nil, err
+	}
+	path, err := s.Path(series)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.checkQuota(s.Resolve(series)); err != nil {
+		return nil, err
+	}
+	j, err = timeseries.CreateOrOpen(path, interval, factory, nil, s.seriesOptions(series))
+	if err == nil {
+		s.created(s.Resolve(series), j)
+	}
+	if err == nil && s.Index != nil {
+		s.Index.record(s.Resolve(series), j)
+	}
+	if err == nil && s.Churn != nil {
+		s.Churn.created(s.Resolve(series))
+	}
+	return j, err
+}
+
+// List walks the store, or consults its Index, and returns the names of
+// every series in it.  Rollup archives are not included.
+func (s *Store) List() ([]string, error) {
+	if s.Index != nil {
+		return s.Index.List(), nil
+	}
+	var series []string
+	err := filepath.Walk(s.Root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == TrashDir {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || !isJournal(path) {
+			return nil
+		}
+		name, err := s.series(path)
+		if err != nil {
+			return nil
+		}
+		series = append(series, name)
+		return nil
+	})
+
+	return series, err
+}
+
+// series converts the path of a journal in the store to its series name.
+func (s *Store) series(path string) (string, error) {
+	rel, err := filepath.Rel(s.Root, path)
+	if err != nil {
+		return "", err
+	}
+	name := strings.Replace(strings.TrimSuffix(uncompressed(rel), Ext), string(filepath.Separator), ".", -1)
+	if err := Validate(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Do calls fn with the journal for series, either from the Pool or by
+// opening and closing the journal around the call.  The journal must not
+// be retained after fn returns.
+func (s *Store) Do(series string, fn func(j *timeseries.FileJournal) error) error {
+	fn = s.indexed(series, fn)
+	if s.Pool != nil {
+		return s.Pool.Do(series, fn)
+	}
+
+	j, err := s.Open(series)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	return fn(j)
+}
+
+// DoCreate is like Do but creates the journal with OpenOrCreate if it
+// does not exist.
+func (s *Store) DoCreate(series string, fn func(j *timeseries.FileJournal) error) error {
+	fn = s.indexed(series, fn)
+	if s.Churn != nil {
+		s.Churn.wrote(s.Resolve(series))
+	}
+	if s.Pool != nil {
+		return s.Pool.DoCreate(series, fn)
+	}
+
+	j, err := s.OpenOrCreate(series)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	return fn(j)
+}
+
+// indexed wraps fn to record the journal of series in the Index after
+// fn is done with it.
+func (s *Store) indexed(series string, fn func(j *timeseries.FileJournal) error) func(j *timeseries.FileJournal) error {
+	if s.Index == nil {
+		return fn
+	}
+	return func(j *timeseries.FileJournal) error {
+		err := fn(j)
+		s.Index.record(s.Resolve(series), j)
+		return err
+	}
+}
+
+// Find returns the series matching a Graphite style glob pattern where
+// each dot separated node may contain the wildcards accepted by
+// filepath.Match, e.g. "servers.*.cpu".  Matching aliases follow the
+// series found on disk.
+func (s *Store) Find(pattern string) ([]string, error) {
+	if s.Index != nil {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		return append(s.Index.Find(pattern), s.findAliases(pattern)...), nil
+	}
+	glob := filepath.Join(s.Root, strings.Replace(pattern, ".", "/", -1)+Ext)
+	var series []string
+	for _, ext := range journalExts() {
+		paths, err := filepath.Glob(glob + ext)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			if name, err := s.series(path); err == nil {
+				series = append(series, name)
+			}
+		}
+	}
+	sort.Strings(series)
+	return append(series, s.findAliases(pattern)...), nil
+}
+
+// View is like Do but without a Pool the journal is opened read-only so
+// that concurrent readers do not exclude each other.
+func (s *Store) View(series string, fn func(j *timeseries.FileJournal) error) error {
+	if s.Pool != nil {
+		return s.Pool.Do(series, fn)
+	}
+
+	path, err := s.Path(series)
+	if err != nil {
+		return err
+	}
+	return s.view(path, fn)
+}
+
+// ViewArchive is like View for the rollup archive of series at the given
+// interval, which is always opened read-only.
+func (s *Store) ViewArchive(series string, interval int64, fn func(j *timeseries.FileJournal) error) error {
+	path, err := s.ArchivePath(series, interval)
+	if err != nil {
+		return err
+	}
+	return s.view(path, fn)
+}
+
+// view calls fn with the journal at path opened read-only.
+func (s *Store) view(path string, fn func(j *timeseries.FileJournal) error) error {
+	opts := *s.options()
+	opts.ReadOnly = true
+	j, err := timeseries.OpenWithOptions(path, &opts)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	return fn(j)
+}
+
+// seriesOptions returns the Options for writing the journal of series.
+func (s *Store) seriesOptions(series string) *timeseries.Options {
+	if s.CarryForward == nil || !s.CarryForward(s.Resolve(series)) {
+		return s.Options
+	}
+	opts := *s.options()
+	opts.CarryForward = true
+	return &opts
+}
+
+func (s *Store) options() *timeseries.Options {
+	if s.Options == nil {
+		return &timeseries.DefaultOptions
+	}
+	return s.Options
+}
+
+// Result is the outcome of reading one series in a batch.
+type Result struct {
+	Start    int64  // timestamp of the first value
+	Interval int64  // time units between values
+	Values   Values // nil if Err is set
+	Err      error
+
+	// Generation is the journal's generation when it was read, as
+	// described by FileJournal.Generation.
+	Generation uint64
+}
+
+// ReadMany reads the range from through until of each of the given series
+// concurrently, bounded by Workers, and returns the results keyed by
+// series name.  Failures are reported per series in Result.Err.
+func (s *Store) ReadMany(series []string, from, until int64) map[string]Result {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	results := make(map[string]Result, len(series))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				r := s.read(name, from, until)
+				mu.Lock()
+				results[name] = r
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, name := range series {
+		queue <- name
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+func (s *Store) read(series string, from, until int64) Result {
+	var r Result
+	start := time.Now()
+	var began time.Time
+	var width int64
+	if s.Slow != nil {
+		defer func() {
+			op := SlowOp{Op: "read", Series: series, From: from, Until: until,
+				Duration: time.Since(start)}
+			if !began.IsZero() {
+				op.Wait = began.Sub(start)
+			}
+			if r.Values != nil {
+				op.Points = int64(r.Values.Len())
+				op.Bytes = op.Points * width
+			}
+			s.Slow.Observe(op)
+		}()
+	}
+	r.Err = s.View(series, func(j *timeseries.FileJournal) error {
+		began = time.Now()
+		width = int64(j.Width())
+		r.Interval = j.Interval()
+		r.Generation = j.Generation()
+		r.Start = from - from%r.Interval
+		if r.Start < j.Epoch() {
+			r.Start = j.Epoch()
+		}
+
+		var err error
+		r.Values, err = j.ReadRange(from, until)
+		return err
+	})
+	if r.Err == nil && s.Pending != nil {
+		if points := s.Pending(series, from, until); len(points) > 0 {
+			r.merge(points, until)
+		}
+	}
+	return r
+}
+
+// ReadAggregate reads the range from through until of a series
+// consolidated into one value every step by fn, as
+// FileJournal.ReadAggregate does.  The Interval of the Result is step.
+// Points still pending in the writer are included, but then every value
+// in the range is read.
+func (s *Store) ReadAggregate(series string, from, until, step int64, fn timeseries.AggFunc) Result {
+	var r Result
+	var values Float64Values
+	if s.Pending != nil && len(s.Pending(series, from, until)) > 0 {
+		r = s.read(series, from, until)
+		if r.Err == nil {
+			r.Start, values, r.Err = timeseries.AggregateValues(r.Start, r.Interval, r.Values, step, fn)
+		}
+	} else {
+		r.Err = s.View(series, func(j *timeseries.FileJournal) error {
+			r.Generation = j.Generation()
+			var err error
+			r.Start, values, err = j.ReadAggregate(from, until, step, fn)
+			return err
+		})
+	}
+	r.Values = nil
+	if r.Err == nil {
+		r.Interval = step
+		r.Values = values
+	}
+	return r
+}
+
+// Latest returns the timestamp and value of the newest non-null value of
+// a numeric series, or a zero timestamp if it has none, as
+// FileJournal.LastValue does.  Points still pending in the writer are
+// included.
+func (s *Store) Latest(series string) (int64, float64, error) {
+	var last int64
+	var value float64
+	err := s.View(series, func(j *timeseries.FileJournal) error {
+		var err error
+		last, value, err = j.LastValue()
+		return err
+	})
+	if err != nil || s.Pending == nil {
+		return last, value, err
+	}
+	for ts, f := range s.Pending(series, last+1, math.MaxInt64) {
+		if ts > last && !math.IsNaN(f) {
+			last, value = ts, f
+		}
+	}
+	return last, value, nil
+}
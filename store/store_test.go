@@ -0,0 +1,1045 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const epoch = int64(1449240540)
+
+func testStore(t *testing.T, series ...string) *Store {
+	dir, err := ioutil.TempDir("/tmp", "journal-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(dir)
+	for i, name := range series {
+		j, err := s.Create(name, 60, NewInt64ValueType(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Write(epoch, Int64Values{int64(i), int64(i + 1), int64(i + 2)})
+		j.Close()
+	}
+	return s
+}
+
+func TestPath(t *testing.T) {
+	s := New("/data")
+	path, err := s.Path("servers.web01.cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join("/data", "servers/web01/cpu.tsj") {
+		t.Errorf("Wrong path for series: %s", path)
+	}
+
+	path, err = s.ArchivePath("servers.web01.cpu", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join("/data", "servers/web01/cpu@300.tsj") {
+		t.Errorf("Wrong archive path for series: %s", path)
+	}
+
+	for _, bad := range []string{"", "a..b", ".a", "a.", "a/b", "a.b/../c", "a@60"} {
+		if _, err := s.Path(bad); err == nil {
+			t.Errorf("Invalid series name accepted: %q", bad)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	s := testStore(t, "a.b", "a.c.d", "e")
+	defer os.RemoveAll(s.Root)
+
+	series, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(series)
+	if len(series) != 3 || series[0] != "a.b" || series[1] != "a.c.d" ||
+		series[2] != "e" {
+		t.Errorf("List returned the wrong series: %v", series)
+	}
+}
+
+func TestReadMany(t *testing.T) {
+	s := testStore(t, "a.b", "a.c", "d")
+	defer os.RemoveAll(s.Root)
+	s.Workers = 2
+
+	results := s.ReadMany([]string{"a.b", "a.c", "d", "missing"}, epoch+60, epoch+600)
+	if len(results) != 4 {
+		t.Fatalf("ReadMany returned %d results instead of 4", len(results))
+	}
+	for i, name := range []string{"a.b", "a.c", "d"} {
+		r := results[name]
+		if r.Err != nil {
+			t.Fatalf("%s: %s", name, r.Err)
+		}
+		values := r.Values.(Int64Values)
+		if r.Start != epoch+60 || r.Interval != 60 || len(values) != 2 ||
+			values[0] != int64(i+1) {
+			t.Errorf("%s: unexpected result %+v", name, r)
+		}
+	}
+	if results["missing"].Err == nil {
+		t.Errorf("Missing series did not report an error")
+	}
+}
+
+func TestReadAggregate(t *testing.T) {
+	s := testStore(t, "a")
+	defer os.RemoveAll(s.Root)
+
+	r := s.ReadAggregate("a", 0, epoch+600, 180, timeseries.AggSum)
+	if r.Err != nil || r.Start != epoch-60 || r.Interval != 180 || fmt.Sprint(r.Values) != "[1 2]" {
+		t.Errorf("ReadAggregate returned %+v", r)
+	}
+
+	s.Pending = func(series string, from, until int64) map[int64]float64 {
+		return map[int64]float64{epoch + 300: 9}
+	}
+	r = s.ReadAggregate("a", 0, epoch+600, 180, timeseries.AggSum)
+	if r.Err != nil || r.Start != epoch-60 || fmt.Sprint(r.Values) != "[1 2 9]" {
+		t.Errorf("ReadAggregate with pending points returned %+v", r)
+	}
+
+	if r = s.ReadAggregate("missing", 0, epoch, 60, timeseries.AggSum); !os.IsNotExist(r.Err) {
+		t.Errorf("ReadAggregate of a missing series returned %v", r.Err)
+	}
+}
+
+func TestPool(t *testing.T) {
+	s := testStore(t, "a", "b", "c")
+	defer os.RemoveAll(s.Root)
+	p := NewPool(s, 2)
+	defer p.Close()
+
+	for _, name := range []string{"a", "b", "c", "a"} {
+		err := s.Do(name, func(j *timeseries.FileJournal) error {
+			return j.Write(epoch+180, Int64Values{42})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.Len() != 2 {
+		t.Errorf("Pool holds %d journals, should be 2", p.Len())
+	}
+
+	// Pooled journals are shared by readers
+	results := s.ReadMany([]string{"a", "c"}, epoch+180, epoch+180)
+	for name, r := range results {
+		if r.Err != nil || r.Values.(Int64Values)[0] != 42 {
+			t.Errorf("%s: unexpected result %+v", name, r)
+		}
+	}
+}
+
+func TestPoolLockedOpen(t *testing.T) {
+	s := testStore(t, "a", "b")
+	defer os.RemoveAll(s.Root)
+	s.Options = &timeseries.Options{LockTimeout: 5 * time.Second}
+	p := NewPool(s, 2)
+	defer p.Close()
+
+	// Opening a locked journal holds up only its own series
+	path, err := s.Path("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err := timeseries.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- p.Do("a", func(j *timeseries.FileJournal) error { return nil })
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if err = p.Do("b", func(j *timeseries.FileJournal) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Opening b waited %s for a to be opened", d)
+	}
+
+	locked.Close()
+	if err = <-done; err != nil {
+		t.Errorf("Do of a returned %v", err)
+	}
+}
+
+func TestPoolEvictReopen(t *testing.T) {
+	s := testStore(t, "a", "b")
+	defer os.RemoveAll(s.Root)
+	p := NewPool(s, 1)
+	defer p.Close()
+
+	// Evicted journals are closed after the pool is unlocked, and are
+	// not reopened until they are
+	errs := make(chan error)
+	for _, name := range []string{"a", "b", "a", "b"} {
+		go func(name string) {
+			var err error
+			for i := 0; i < 1000 && err == nil; i++ {
+				err = p.Do(name, func(j *timeseries.FileJournal) error {
+					return j.Write(epoch+180, Int64Values{int64(i)})
+				})
+			}
+			errs <- err
+		}(name)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Do returned %v", err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	s := testStore(t, "a.b.c", "a.d.c", "a.d.e", "f")
+	defer os.RemoveAll(s.Root)
+
+	// Archives are not series
+	path, _ := s.ArchivePath("a.b.c", 300)
+	ioutil.WriteFile(path, nil, 0644)
+
+	series, err := s.Find("a.*.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(series)
+	if len(series) != 2 || series[0] != "a.b.c" || series[1] != "a.d.c" {
+		t.Errorf("Find returned the wrong series: %v", series)
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	s := testStore(t, "a.b", "a.c")
+	defer os.RemoveAll(s.Root)
+	archive, _ := s.ArchivePath("a.b", 300)
+	j, _ := timeseries.Create(archive, 300, NewInt64ValueType(), nil)
+	j.Write(epoch, Int64Values{5})
+	j.Close()
+	for _, path := range []string{archive, s.path("a.b")} {
+		j, _ := timeseries.Open(path)
+		j.Seal()
+		j.Close()
+		if _, err := timeseries.CompressJournal(path, timeseries.Gzip, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if series, _ := s.Find("a.*"); fmt.Sprint(series) != "[a.b a.c]" {
+		t.Errorf("Find returned %v", series)
+	}
+	if series, _ := s.List(); len(series) != 2 {
+		t.Errorf("List returned %v", series)
+	}
+	if intervals, _ := s.Archives("a.b"); fmt.Sprint(intervals) != "[300]" {
+		t.Errorf("Archives returned %v", intervals)
+	}
+	err := s.View("a.b", func(j *timeseries.FileJournal) error {
+		v, err := j.Read(epoch, 3)
+		if fmt.Sprint(v) != "[0 1 2]" {
+			t.Errorf("Compressed journal read %v", v)
+		}
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	dir, _ := ioutil.TempDir("/tmp", "journal-snapshot")
+	defer os.RemoveAll(dir)
+	if _, err = s.Snapshot([]string{"a.b"}, dir); err != nil {
+		t.Fatal(err)
+	}
+	j, err = New(dir).Open("a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := j.Read(epoch, 3)
+	j.Close()
+	if fmt.Sprint(v) != "[0 1 2]" {
+		t.Errorf("Snapshot of compressed journal read %v", v)
+	}
+
+	if _, err = s.Delete("a.b", false); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := s.Files("a.b"); len(files) != 0 {
+		t.Errorf("Delete left %v", files)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s := testStore(t, "a.b", "a.c")
+	defer os.RemoveAll(s.Root)
+	archive, _ := s.ArchivePath("a.b", 300)
+	ioutil.WriteFile(archive, nil, 0644)
+
+	changes, err := s.Delete("a.b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || !changes[0].DryRun {
+		t.Errorf("Delete dry run reported %v", changes)
+	}
+	if files, _ := s.Files("a.b"); len(files) != 2 {
+		t.Errorf("Delete dry run removed files")
+	}
+
+	// Journals in use are not removed
+	j, _ := s.Open("a.b")
+	if _, err = s.Delete("a.b", false); err != timeseries.ErrLocked {
+		t.Errorf("Delete of a locked journal returned %v", err)
+	}
+	j.Close()
+
+	if _, err = s.Delete("a.b", false); err != nil {
+		t.Fatal(err)
+	}
+	series, _ := s.List()
+	if len(series) != 1 || series[0] != "a.c" {
+		t.Errorf("Series remaining after Delete: %v", series)
+	}
+	if _, err = s.Delete("a.b", false); !os.IsNotExist(err) {
+		t.Errorf("Delete of a missing series returned %v", err)
+	}
+}
+
+func TestStale(t *testing.T) {
+	s := testStore(t, "a.old", "a.new")
+	defer os.RemoveAll(s.Root)
+	now := time.Now().Unix()
+	s.Do("a.new", func(j *timeseries.FileJournal) error {
+		return j.Write(now+7200, Int64Values{1})
+	})
+
+	// Every journal was just modified
+	if stale, err := s.Stale(epoch + 3600); err != nil || len(stale) != 0 {
+		t.Errorf("Recently modified series are stale: %v %v", stale, err)
+	}
+	stale, err := s.Stale(now + 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != 1 || stale[0].Series != "a.old" || stale[0].Last != epoch+120 {
+		t.Fatalf("Stale returned %v", stale)
+	}
+
+	dir := s.Root + ".archive"
+	defer os.RemoveAll(dir)
+	if _, err = s.Archive("a.old", dir, false); err != nil {
+		t.Fatal(err)
+	}
+	if series, _ := s.List(); len(series) != 1 || series[0] != "a.new" {
+		t.Errorf("Series remaining after Archive: %v", series)
+	}
+	if series, _ := New(dir).List(); len(series) != 1 || series[0] != "a.old" {
+		t.Errorf("Archived series: %v", series)
+	}
+}
+
+func TestRename(t *testing.T) {
+	s := testStore(t, "a.b.c", "x.y")
+	defer os.RemoveAll(s.Root)
+	NewPool(s, 10)
+	defer s.Pool.Close()
+	archive, _ := s.ArchivePath("a.b.c", 300)
+	a, _ := timeseries.Create(archive, 300, NewInt64ValueType(), nil)
+	a.Close()
+	s.Do("a.b.c", func(j *timeseries.FileJournal) error { return nil })
+
+	if err := s.Rename("a.b.c", "x.y", false); !os.IsExist(err) {
+		t.Errorf("Rename over an existing series returned %v", err)
+	}
+	if err := s.Rename("a.b.c", "d.e", false); err != nil {
+		t.Fatal(err)
+	}
+	series, _ := s.List()
+	sort.Strings(series)
+	if len(series) != 2 || series[0] != "d.e" {
+		t.Errorf("Series after Rename: %v", series)
+	}
+	if files, _ := s.Files("d.e"); len(files) != 2 {
+		t.Errorf("Renamed series has files %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(s.Root, "a")); !os.IsNotExist(err) {
+		t.Errorf("Empty directories left behind: %v", err)
+	}
+
+	// An older journal fills the nulls of x.y, which holds 1 2 3
+	s.Do("x.y", func(j *timeseries.FileJournal) error {
+		return j.Write(epoch+60, Int64Values{math.MinInt64})
+	})
+	old, _ := s.Create("p.old", 60, NewInt64ValueType(), nil)
+	old.Write(epoch-120, Int64Values{-2, -1, 9, 9, 9, 9, 9})
+	old.Close()
+	floats, _ := s.Create("p.floats", 60, NewFloat64ValueType(), nil)
+	floats.Close()
+	if err := s.Rename("p.floats", "x.y", true); err == nil {
+		t.Errorf("Merge of journals with different types succeeded")
+	}
+	if err := s.Rename("p.old", "x.y", true); err != nil {
+		t.Fatal(err)
+	}
+	r := s.ReadMany([]string{"x.y"}, 0, epoch+600)["x.y"]
+	if fmt.Sprint(r.Values) != "[-2 -1 1 9 3 9 9]" {
+		t.Errorf("Merged series contains %v: %v", r.Values, r.Err)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	s := testStore(t, "hosts.old.cpu", "hosts.other.cpu")
+	defer os.RemoveAll(s.Root)
+	NewPool(s, 10)
+	defer s.Pool.Close()
+
+	if err := s.Alias("hosts.other.cpu", "hosts.old.cpu"); err == nil {
+		t.Errorf("Existing series became an alias")
+	}
+	if err := s.Alias("hosts.new.cpu", "hosts.old.cpu"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Alias("hosts.newer.cpu", "hosts.new.cpu"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Resolve("hosts.newer.cpu") != "hosts.old.cpu" {
+		t.Errorf("Alias of an alias resolves to %s", s.Resolve("hosts.newer.cpu"))
+	}
+
+	// Writes through the alias share the pooled journal of the target
+	err := s.Do("hosts.new.cpu", func(j *timeseries.FileJournal) error {
+		return j.Write(epoch+180, Int64Values{3})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := s.ReadMany([]string{"hosts.old.cpu", "hosts.new.cpu"}, epoch, epoch+600)
+	for name, r := range results {
+		if fmt.Sprint(r.Values) != "[0 1 2 3]" {
+			t.Errorf("%s contains %v: %v", name, r.Values, r.Err)
+		}
+	}
+	found, _ := s.Find("hosts.*.cpu")
+	if fmt.Sprint(found) != "[hosts.old.cpu hosts.other.cpu hosts.new.cpu hosts.newer.cpu]" {
+		t.Errorf("Find returned %v", found)
+	}
+
+	if _, err = s.Delete("hosts.new.cpu", false); err == nil {
+		t.Errorf("Delete followed an alias")
+	}
+	if err = s.Unalias("hosts.new.cpu"); err != nil {
+		t.Fatal(err)
+	}
+	if aliases, _ := s.Aliases(); len(aliases) != 1 {
+		t.Errorf("Aliases after Unalias: %v", aliases)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	s := testStore(t, "a")
+	defer os.RemoveAll(s.Root)
+
+	var chunks []string
+	c := s.Cursor("a", 0, epoch+600, 2)
+	for c.Next() {
+		r := c.Result()
+		chunks = append(chunks, fmt.Sprint(r.Start-epoch, r.Values))
+	}
+	if c.Err() != nil || fmt.Sprint(chunks) != "[0 [0 1] 120 [2]]" {
+		t.Errorf("Cursor read %v, %v", chunks, c.Err())
+	}
+
+	s.Pending = func(series string, from, until int64) map[int64]float64 {
+		if from <= epoch+300 && epoch+300 <= until {
+			return map[int64]float64{epoch + 300: 9}
+		}
+		return nil
+	}
+	chunks = nil
+	c = s.Cursor("a", epoch+60, epoch+600, 2)
+	for c.Next() {
+		r := c.Result()
+		chunks = append(chunks, fmt.Sprint(r.Start-epoch, r.Values))
+	}
+	if c.Err() != nil || fmt.Sprint(chunks) != "[60 [1 2] 300 [9]]" {
+		t.Errorf("Cursor with pending points read %v, %v", chunks, c.Err())
+	}
+
+	c = s.Cursor("missing", 0, epoch, 0)
+	if c.Next() || !os.IsNotExist(c.Err()) {
+		t.Errorf("Cursor over a missing series returned %v", c.Err())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := testStore(t, "a.b", "a.c")
+	defer os.RemoveAll(s.Root)
+	NewPool(s, 10)
+	defer s.Pool.Close()
+	if err := s.Alias("x", "a.b"); err != nil {
+		t.Fatal(err)
+	}
+	archive, _ := s.ArchivePath("a.b", 300)
+	j, err := timeseries.Create(archive, 300, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch-epoch%300, Int64Values{5})
+	j.Close()
+
+	dir := s.Root + "-snapshot"
+	defer os.RemoveAll(dir)
+	changes, err := s.Snapshot([]string{"a.c", "x", "a.b", "a.b"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("Snapshot copied %v", changes)
+	}
+
+	// The snapshot is a store holding the same values
+	copied := New(dir)
+	results := copied.ReadMany([]string{"a.b", "a.c", "x"}, epoch, epoch+600)
+	for name, want := range map[string]string{"a.b": "[0 1 2]", "a.c": "[1 2 3]", "x": "[0 1 2]"} {
+		if r := results[name]; r.Err != nil || fmt.Sprint(r.Values) != want {
+			t.Errorf("Snapshot of %s holds %v, %v", name, r.Values, r.Err)
+		}
+	}
+	if _, err = os.Stat(strings.Replace(archive, s.Root, dir, 1)); err != nil {
+		t.Errorf("Archive was not copied: %s", err)
+	}
+
+	if _, err = s.Snapshot([]string{"a.b"}, dir); !os.IsExist(err) {
+		t.Errorf("Snapshot over existing files returned %v", err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	s := testStore(t, "a.b", "a.c")
+	defer os.RemoveAll(s.Root)
+	keys := s.Root + "-keys"
+	defer os.RemoveAll(keys)
+	os.MkdirAll(keys, 0700)
+	os.WriteFile(filepath.Join(keys, "old"), bytes.Repeat([]byte{1}, 16), 0600)
+	os.WriteFile(filepath.Join(keys, "new"), []byte(strings.Repeat("ab", 32)+"\n"), 0600)
+	s.Encryption = KeyFile(filepath.Join(keys, "old"))
+
+	// Streams span chunks and are decrypted after the key changes
+	plain := bytes.Repeat([]byte("journal"), EncryptChunk/3)
+	var buf bytes.Buffer
+	w, err := s.Encryption.Encrypt(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	w.Close()
+	sealed := buf.Bytes()
+	e := KeyFile(filepath.Join(keys, "new"))
+	r, err := e.Decrypt(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Decrypted %d bytes of %d: %v", len(got), len(plain), err)
+	}
+	for name, bad := range map[string][]byte{
+		"truncated": sealed[:len(sealed)-40],
+		"altered":   append(append([]byte(nil), sealed[:100]...), append([]byte{sealed[100] ^ 1}, sealed[101:]...)...),
+	} {
+		r, _ := e.Decrypt(bytes.NewReader(bad))
+		if _, err = ioutil.ReadAll(r); err == nil {
+			t.Errorf("Decrypted a %s stream", name)
+		}
+	}
+
+	// Snapshots are encrypted and decrypted back into a store
+	dir := s.Root + "-snapshot"
+	defer os.RemoveAll(dir)
+	changes, err := s.Snapshot([]string{"a.b"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || !strings.HasSuffix(changes[0].Path, "b.tsj"+EncryptedExt) {
+		t.Errorf("Encrypted Snapshot wrote %v", changes)
+	}
+	if _, err = e.DecryptTree(dir); err != nil {
+		t.Fatal(err)
+	}
+	if r := New(dir).ReadMany([]string{"a.b"}, epoch, epoch+600)["a.b"]; fmt.Sprint(r.Values) != "[0 1 2]" {
+		t.Errorf("Decrypted snapshot holds %v, %v", r.Values, r.Err)
+	}
+
+	// Archived series are encrypted and removed
+	archived := s.Root + "-archive"
+	defer os.RemoveAll(archived)
+	if _, err = s.Archive("a.c", archived, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(archived, "a", "c.tsj"+EncryptedExt)); err != nil {
+		t.Errorf("Archive did not encrypt a.c: %v", err)
+	}
+	if _, err = s.Open("a.c"); !os.IsNotExist(err) {
+		t.Errorf("Archive left a.c in place: %v", err)
+	}
+
+	// Backups must be decrypted to be restored
+	stream, _ := s.BackupSince(nil)
+	sealed, _ = ioutil.ReadAll(stream)
+	restored := s.Root + "-restored"
+	defer os.RemoveAll(restored)
+	if err = Restore(bytes.NewReader(sealed), restored); err != ErrEncrypted {
+		t.Errorf("Restore of an encrypted stream returned %v", err)
+	}
+	r, _ = e.Decrypt(bytes.NewReader(sealed))
+	if err = Restore(r, restored); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	s := testStore(t, "a.b", "a.c")
+	defer os.RemoveAll(s.Root)
+	dir := s.Root + "-restore"
+	defer os.RemoveAll(dir)
+
+	backup := func(prev *BackupState) (*BackupState, int) {
+		r, state := s.BackupSince(prev)
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Restore(bytes.NewReader(buf), dir); err != nil {
+			t.Fatal(err)
+		}
+		return state, len(buf)
+	}
+	check := func(want map[string]string) {
+		results := New(dir).ReadMany([]string{"a.b", "a.c", "d"}, epoch, epoch+600)
+		for name, r := range results {
+			got := fmt.Sprint(r.Values)
+			if r.Err != nil {
+				got = "missing"
+			}
+			if got != want[name] {
+				t.Errorf("Restored %s holds %s, want %s", name, got, want[name])
+			}
+		}
+	}
+
+	state, full := backup(nil)
+	check(map[string]string{"a.b": "[0 1 2]", "a.c": "[1 2 3]", "d": "missing"})
+
+	// Nothing changed
+	state, n := backup(state)
+	if n >= full/4 {
+		t.Errorf("Empty incremental backup is %d bytes, full backup %d", n, full)
+	}
+
+	j, err := s.Open("a.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch+180, Int64Values{9})
+	j.Close()
+	if _, err = s.Delete("a.c", false); err != nil {
+		t.Fatal(err)
+	}
+	j, err = s.Create("d", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch, Int64Values{4})
+	j.Close()
+
+	state, _ = backup(state)
+	check(map[string]string{"a.b": "[0 1 2 9]", "a.c": "missing", "d": "[4]"})
+	if len(state.Files) != 2 {
+		t.Errorf("Backup state lists %v", state.Files)
+	}
+
+	if err = Restore(strings.NewReader("garbage"), dir); err == nil {
+		t.Errorf("Restored garbage")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	s := testStore(t, "a.b")
+	defer os.RemoveAll(s.Root)
+
+	if _, ok, err := s.LoadCheckpoint("kafka"); ok || err != nil {
+		t.Errorf("Missing checkpoint loaded: %v", err)
+	}
+	cp := Checkpoint{Offset: 1234, Timestamp: epoch + 120, Data: "partition=3"}
+	if err := s.SaveCheckpoint("kafka", cp, "a.b", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	s.SaveCheckpoint("other", Checkpoint{Offset: 1})
+	if err := s.SaveCheckpoint("", cp); err == nil {
+		t.Errorf("Checkpoint without a name saved")
+	}
+
+	// Checkpoints survive in the file, not just this Store
+	loaded, ok, err := New(s.Root).LoadCheckpoint("kafka")
+	if err != nil || !ok || loaded != cp {
+		t.Errorf("Loaded %+v, %v, %v", loaded, ok, err)
+	}
+	if err = s.DeleteCheckpoint("other"); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := s.Checkpoints(); len(all) != 1 {
+		t.Errorf("Checkpoints after delete: %v", all)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	s := testStore(t, "a.b", "a.c", "a.d")
+	defer os.RemoveAll(s.Root)
+	s.AuditLog = filepath.Join(s.Root, AuditFile)
+	s.AuditUser = "tester"
+
+	if _, err := s.Delete("a.b", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.AuditLog); !os.IsNotExist(err) {
+		t.Errorf("Dry run was audited: %v", err)
+	}
+	if _, err := s.Delete("a.b", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rename("a.c", "a.e", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendAudit(s.AuditLog, AuditEntry{Op: "trim", Path: "x.tsj", Points: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := os.ReadFile(s.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := VerifyAudit(bytes.NewReader(buf))
+	if n != 3 || err != nil {
+		t.Errorf("Verified %d entries: %v", n, err)
+	}
+	if !bytes.Contains(buf, []byte(`"user":"tester","op":"delete","series":"a.b"`)) ||
+		!bytes.Contains(buf, []byte(`"op":"rename","series":"a.c","detail":"to a.e"`)) {
+		t.Errorf("Audit log is missing entries:\n%s", buf)
+	}
+
+	// Changing or removing an entry breaks the chain
+	tampered := bytes.Replace(buf, []byte("a.b"), []byte("a.x"), 1)
+	if _, err = VerifyAudit(bytes.NewReader(tampered)); err == nil {
+		t.Errorf("Edited audit log verified")
+	}
+	lines := bytes.SplitAfter(buf, []byte("\n"))
+	if _, err = VerifyAudit(bytes.NewReader(bytes.Join(lines[1:], nil))); err == nil {
+		t.Errorf("Truncated audit log verified")
+	}
+}
+
+func TestCursorChanged(t *testing.T) {
+	s := testStore(t, "a")
+	defer os.RemoveAll(s.Root)
+	p := NewPool(s, 0)
+	defer p.Close()
+
+	c := s.Cursor("a", 0, epoch+600, 1)
+	if !c.Next() {
+		t.Fatal(c.Err())
+	}
+	s.Do("a", func(j *timeseries.FileJournal) error {
+		_, err := j.Trim(epoch+60, false)
+		return err
+	})
+	if c.Next() || c.Err() != ErrChanged {
+		t.Errorf("Cursor over a trimmed series returned %v", c.Err())
+	}
+
+	// Appending is not a change
+	c = s.Cursor("a", 0, epoch+600, 1)
+	c.Next()
+	s.Do("a", func(j *timeseries.FileJournal) error {
+		return j.Write(epoch+180, Int64Values{3})
+	})
+	for c.Next() {
+	}
+	if c.Err() != nil {
+		t.Errorf("Cursor over an appended series returned %v", c.Err())
+	}
+}
+
+func TestSeriesIndex(t *testing.T) {
+	s := testStore(t, "a.b", "a.c", "d")
+	defer os.RemoveAll(s.Root)
+
+	x, err := s.BuildIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, ok := x.Get("a.c")
+	if !ok || info.Interval != 60 || info.Epoch != epoch || info.Last != epoch+120 {
+		t.Errorf("Indexed a.c as %+v, %v", info, ok)
+	}
+	s.Index = x
+
+	// Changes through the Store keep the index current
+	s.Schema = func(series string) (int64, ValueType, error) {
+		return 60, NewInt64ValueType(), nil
+	}
+	s.DoCreate("a.e", func(j *timeseries.FileJournal) error {
+		return j.Write(epoch, Int64Values{1, 2})
+	})
+	s.Delete("d", false)
+	s.Rename("a.b", "f", false)
+	s.Alias("a.x", "a.c")
+	os.WriteFile(filepath.Join(s.Root, "a", "g"+Ext), nil, 0644)
+
+	list, _ := s.List()
+	found, _ := s.Find("a.*")
+	if fmt.Sprint(list) != "[a.c a.e f]" || fmt.Sprint(found) != "[a.c a.e a.x]" {
+		t.Errorf("Indexed store lists %v and finds %v", list, found)
+	}
+	if info, _ = x.Get("a.e"); info.Last != epoch+60 {
+		t.Errorf("Write through DoCreate indexed %+v", info)
+	}
+
+	path := filepath.Join(s.Root, SeriesIndexFile)
+	if err = x.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadSeriesIndex(path)
+	if err != nil || fmt.Sprint(loaded.List()) != fmt.Sprint(list) {
+		t.Errorf("Loaded index lists %v: %v", loaded.List(), err)
+	}
+}
+
+func TestChurn(t *testing.T) {
+	s := testStore(t, "a")
+	defer os.RemoveAll(s.Root)
+	fake := clock.NewFake(time.Unix(epoch, 0))
+	s.Churn = &Churn{Window: time.Minute, Clock: fake}
+	s.Schema = func(series string) (int64, ValueType, error) {
+		return 60, NewInt64ValueType(), nil
+	}
+	write := func(series string) {
+		s.DoCreate(series, func(j *timeseries.FileJournal) error {
+			return j.Write(fake.Now().Unix(), Int64Values{1})
+		})
+	}
+
+	write("a")
+	write("b")
+	write("c")
+	if s.Churn.Created.Value() != 2 || s.Churn.Active() != 3 {
+		t.Errorf("Created %d series with %d active", s.Churn.Created.Value(), s.Churn.Active())
+	}
+	fake.Advance(45 * time.Second)
+	write("a")
+	fake.Advance(30 * time.Second)
+	stopped := s.Churn.Sweep()
+	if fmt.Sprint(stopped) != "[b c]" || s.Churn.Active() != 1 || s.Churn.Stopped.Value() != 2 {
+		t.Errorf("Sweep stopped %v leaving %d active", stopped, s.Churn.Active())
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	s := testStore(t, "a.b", "a.c")
+	defer os.RemoveAll(s.Root)
+	var ops []SlowOp
+	s.Slow = &SlowLog{Read: time.Nanosecond, Log: func(op SlowOp) { ops = append(ops, op) }}
+	s.Workers = 1
+
+	s.ReadMany([]string{"a.b"}, epoch, epoch+60)
+	if len(ops) != 1 || ops[0].Series != "a.b" || ops[0].Points != 2 || ops[0].Bytes != 16 ||
+		ops[0].Duration < ops[0].Wait {
+		t.Errorf("Slow reads logged %v", ops)
+	}
+	s.Slow.Observe(SlowOp{Op: "write", Duration: time.Hour})
+	s.Slow.Read = time.Hour
+	s.ReadMany([]string{"a.c"}, epoch, epoch+60)
+	if len(ops) != 1 {
+		t.Errorf("Fast operations logged %v", ops[1:])
+	}
+}
+
+func TestTenants(t *testing.T) {
+	s := testStore(t, "red.a", "red.b", "blue.a")
+	defer os.RemoveAll(s.Root)
+	s.Tenants = map[string]*Tenant{"red": {MaxSeries: 3}, "blue": {}}
+
+	if _, err := s.Create("green.a", 60, NewInt64ValueType(), nil); err == nil {
+		t.Errorf("Created a series of an unknown tenant")
+	}
+	j, err := s.Create("red.c", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if _, err = s.Create("red.d", 60, NewInt64ValueType(), nil); !IsQuota(err) {
+		t.Errorf("Create over quota returned %v", err)
+	}
+
+	red, err := s.Scope("red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = red.Create("d", 60, NewInt64ValueType(), nil); !IsQuota(err) {
+		t.Errorf("Scoped Create over quota returned %v", err)
+	}
+	series, err := red.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(series)
+	if fmt.Sprint(series) != "[a b c]" {
+		t.Errorf("Scoped List returned %v", series)
+	}
+	if r := red.read("a", epoch, epoch+120); r.Err != nil || fmt.Sprint(r.Values) != "[0 1 2]" {
+		t.Errorf("Scoped Read returned %v, %v", r.Values, r.Err)
+	}
+	if found, _ := red.Find("*"); len(found) != 3 {
+		t.Errorf("Scoped Find returned %v", found)
+	}
+
+	if _, err = s.Delete("red.a", false); err != nil {
+		t.Fatal(err)
+	}
+	j, err = red.Create("d", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Errorf("Create after Delete: %s", err)
+	} else {
+		j.Close()
+	}
+	if _, err = os.Stat(filepath.Join(s.Root, "red", "d.tsj")); err != nil {
+		t.Errorf("Scoped series not in the tenant's subtree: %s", err)
+	}
+
+	if _, err = s.Scope("red.x"); err == nil {
+		t.Errorf("Scoped to an invalid tenant")
+	}
+}
+
+func TestTrash(t *testing.T) {
+	s := testStore(t, "a.b", "a.c")
+	defer os.RemoveAll(s.Root)
+	fake := clock.NewFake(time.Unix(epoch, 0))
+	s.Options = &timeseries.Options{Clock: fake}
+	s.Trash = true
+
+	changes, err := s.Delete("a.b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Op != "trash" {
+		t.Errorf("Delete made changes %v", changes)
+	}
+	fake.Advance(time.Hour)
+	if _, err = s.Delete("a.c", false); err != nil {
+		t.Fatal(err)
+	}
+	if series, _ := s.List(); len(series) != 0 {
+		t.Errorf("Deleted series still listed: %v", series)
+	}
+	trashed, err := s.Trashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) != 2 || trashed[0].Series != "a.b" || trashed[1].Series != "a.c" ||
+		trashed[0].Deleted.Unix() != epoch || len(trashed[0].Files) != 1 {
+		t.Errorf("Trash holds %v", trashed)
+	}
+
+	if err = s.Undelete("a.b"); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.read("a.b", epoch, epoch+120); r.Err != nil || fmt.Sprint(r.Values) != "[0 1 2]" {
+		t.Errorf("Undeleted series read %v, %v", r.Values, r.Err)
+	}
+	if err = s.Undelete("a.b"); !os.IsNotExist(err) {
+		t.Errorf("Second Undelete returned %v", err)
+	}
+
+	purged, err := s.PurgeTrash(time.Unix(epoch+3600, 0))
+	if err != nil || len(purged) != 0 {
+		t.Errorf("Purged %v, %v", purged, err)
+	}
+	purged, err = s.PurgeTrash(fake.Now().Add(time.Second))
+	if err != nil || len(purged) != 1 || purged[0].Series != "a.c" {
+		t.Errorf("Purged %v, %v", purged, err)
+	}
+	if _, err = os.Stat(filepath.Join(s.Root, TrashDir)); !os.IsNotExist(err) {
+		t.Errorf("Empty trash left behind: %v", err)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	s := testStore(t)
+	defer os.RemoveAll(s.Root)
+	j, err := s.Create("a.b", 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(Float64Values, 1000)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	j.Write(epoch, values)
+	j.Close()
+
+	p, err := s.Preview("a.b", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Values) > 10 || p.Step != 112*60 || p.Start > epoch || p.Start+p.Step <= epoch {
+		t.Errorf("Preview of %d values from %d every %d", len(p.Values), p.Start, p.Step)
+	}
+	path, _ := s.Path("a.b")
+	if _, err = os.Stat(path + PreviewExt); err != nil {
+		t.Errorf("Preview not cached: %s", err)
+	}
+	if cached, err := s.Preview("a.b", 10); err != nil || fmt.Sprint(cached) != fmt.Sprint(p) {
+		t.Errorf("Cached preview %v, %v", cached, err)
+	}
+
+	// Writing replaces the cached preview
+	time.Sleep(10 * time.Millisecond)
+	s.Do("a.b", func(j *timeseries.FileJournal) error {
+		return j.Write(epoch, Float64Values{5000})
+	})
+	if fresh, err := s.Preview("a.b", 10); err != nil || fresh.Values[0] == p.Values[0] {
+		t.Errorf("Preview after write %v, %v", fresh, err)
+	}
+	if other, err := s.Preview("a.b", 0); err != nil || len(other.Values) > PreviewPoints || len(other.Values) < 10 {
+		t.Errorf("Default preview of %d values: %v", len(other.Values), err)
+	}
+
+	if _, err = s.Delete("a.b", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + PreviewExt); !os.IsNotExist(err) {
+		t.Errorf("Cached preview left behind: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Tenant is a team given its own namespace in a Store: the series whose
+// first node is the tenant's name, which are stored in the subtree of
+// Root of that name.  Scope returns a Store confined to one.
+type Tenant struct {
+	// MaxSeries bounds the number of series the tenant may have, not
+	// counting rollup archives.  Zero is unlimited.
+	MaxSeries int `json:"max_series"`
+}
+
+// QuotaError is returned when creating a series would exceed the quota of
+// its tenant.
+type QuotaError struct {
+	Tenant string
+	Series string
+	Limit  int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("Tenant %s is at its limit of %d series: %s", e.Tenant, e.Limit, e.Series)
+}
+
+// IsQuota reports whether err is, or wraps, a *QuotaError.
+func IsQuota(err error) bool {
+	var quota *QuotaError
+	return errors.As(err, &quota)
+}
+
+// TenantOf returns the tenant whose namespace holds series: its first
+// node.
+func TenantOf(series string) string {
+	tenant, _, _ := strings.Cut(series, ".")
+	return tenant
+}
+
+// TenantSeries returns the full name of series in the namespace of
+// tenant.
+func TenantSeries(tenant, series string) string {
+	return tenant + "." + series
+}
+
+// Scope returns a Store of the series of tenant, which are named without
+// the tenant's prefix and kept under the tenant's subtree of Root.  It
+// shares the Options, Schema, CarryForward, Pending, Slow, Workers,
+// AuditLog, and Encryption of s, each given full series names, but not
+// its Pool, Index, or Churn, which hold full names, though series it
+// creates are added to the Index of s and count against the tenant's
+// quota.  Its aliases are its own.
+func (s *Store) Scope(tenant string) (*Store, error) {
+	if err := Validate(tenant); err != nil || strings.Contains(tenant, ".") {
+		return nil, fmt.Errorf("Invalid tenant name: %q", tenant)
+	}
+	full := func(series string) string { return TenantSeries(tenant, series) }
+	scoped := &Store{
+		Root:       filepath.Join(s.Root, tenant),
+		Options:    s.Options,
+		Slow:       s.Slow,
+		Workers:    s.Workers,
+		AuditLog:   s.AuditLog,
+		AuditUser:  s.AuditUser,
+		Encryption: s.Encryption,
+		parent:     s,
+		tenant:     tenant,
+	}
+	if schema := s.Schema; schema != nil {
+		scoped.Schema = func(series string) (int64, ValueType, error) {
+			return schema(full(series))
+		}
+	}
+	if carry := s.CarryForward; carry != nil {
+		scoped.CarryForward = func(series string) bool { return carry(full(series)) }
+	}
+	if pending := s.Pending; pending != nil {
+		scoped.Pending = func(series string, from, until int64) map[int64]float64 {
+			return pending(full(series), from, until)
+		}
+	}
+	return scoped, nil
+}
+
+// checkQuota fails if series may not be created because its tenant is
+// unknown or has as many series as it may.
+func (s *Store) checkQuota(series string) error {
+	if s.parent != nil {
+		return s.parent.checkQuota(TenantSeries(s.tenant, series))
+	}
+	if s.Tenants == nil {
+		return nil
+	}
+	name := TenantOf(series)
+	t, ok := s.Tenants[name]
+	if !ok {
+		return fmt.Errorf("Unknown tenant %q: %s", name, series)
+	}
+	if t == nil || t.MaxSeries <= 0 {
+		return nil
+	}
+
+	s.tenantMu.Lock()
+	defer s.tenantMu.Unlock()
+	n, ok := s.tenantSeries[name]
+	if !ok {
+		var err error
+		if n, err = s.countSeries(name); err != nil {
+			return err
+		}
+		if s.tenantSeries == nil {
+			s.tenantSeries = make(map[string]int)
+		}
+		s.tenantSeries[name] = n
+	}
+	if n >= t.MaxSeries {
+		return &QuotaError{Tenant: name, Series: series, Limit: t.MaxSeries}
+	}
+	return nil
+}
+
+// countSeries returns the number of series of tenant.
+func (s *Store) countSeries(tenant string) (int, error) {
+	if s.Index != nil {
+		n := 0
+		for _, series := range s.Index.List() {
+			if TenantOf(series) == tenant {
+				n++
+			}
+		}
+		return n, nil
+	}
+	scoped := &Store{Root: filepath.Join(s.Root, tenant)}
+	series, err := scoped.List()
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return len(series), err
+}
+
+// created counts a series created against the quota of its tenant.  A
+// Store returned by Scope also records it in the Index of its parent.
+func (s *Store) created(series string, j *timeseries.FileJournal) {
+	if s.parent != nil {
+		if s.parent.Index != nil {
+			s.parent.Index.record(TenantSeries(s.tenant, series), j)
+		}
+		s.parent.created(TenantSeries(s.tenant, series), j)
+		return
+	}
+	s.tenantMu.Lock()
+	defer s.tenantMu.Unlock()
+	if n, ok := s.tenantSeries[TenantOf(series)]; ok {
+		s.tenantSeries[TenantOf(series)] = n + 1
+	}
+}
+
+// recount forgets the number of series of the tenants of the given
+// series so that they are counted again when next needed.
+func (s *Store) recount(series ...string) {
+	if s.parent != nil {
+		for _, name := range series {
+			s.parent.recount(TenantSeries(s.tenant, name))
+		}
+		return
+	}
+	s.tenantMu.Lock()
+	defer s.tenantMu.Unlock()
+	for _, name := range series {
+		delete(s.tenantSeries, TenantOf(name))
+	}
+}
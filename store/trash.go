@@ -0,0 +1,183 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrashDir is the directory in Root into which Delete moves the files of
+// series when the Store's Trash is set.  Each deletion is kept in a
+// directory named by its time in Unix nanoseconds, holding the files at
+// their paths relative to Root.  Its name is not a valid series node, so
+// it is never mistaken for part of the store.
+const TrashDir = ".trash"
+
+// TrashEntry describes the files of one series removed by one Delete.
+type TrashEntry struct {
+	Series  string
+	Deleted time.Time
+	Files   []string // paths in the trash
+}
+
+// unscoped returns the Store that is not scoped to a tenant and the name
+// in it of the series of s.
+func (s *Store) unscoped(series string) (*Store, string) {
+	if s.parent == nil {
+		return s, series
+	}
+	return s.parent.unscoped(TenantSeries(s.tenant, series))
+}
+
+// moveToTrash moves the file at path, deleted at the given time, into the
+// trash.  Scoped stores share the trash of their parent.
+func (s *Store) moveToTrash(path string, deleted time.Time) error {
+	top, _ := s.unscoped("")
+	rel, err := filepath.Rel(top.Root, path)
+	if err != nil {
+		return err
+	}
+	stamp := strconv.FormatInt(deleted.UnixNano(), 10)
+	return move(path, filepath.Join(top.Root, TrashDir, stamp, rel))
+}
+
+// Trashed lists the series in the trash, oldest deletions first.
+func (s *Store) Trashed() ([]TrashEntry, error) {
+	top, prefix := s.unscoped("")
+	dir := filepath.Join(top.Root, TrashDir)
+	stamps, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []TrashEntry
+	for _, stamp := range stamps {
+		nanos, err := strconv.ParseInt(stamp.Name(), 10, 64)
+		if err != nil || !stamp.IsDir() {
+			continue
+		}
+		found := make(map[string]*TrashEntry)
+		base := filepath.Join(dir, stamp.Name())
+		err = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() || !isJournal(path) {
+				return err
+			}
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			name, ok := strings.CutPrefix(trashedSeries(rel), prefix)
+			if !ok {
+				return nil
+			}
+			if found[name] == nil {
+				found[name] = &TrashEntry{Series: name, Deleted: time.Unix(0, nanos)}
+			}
+			found[name].Files = append(found[name].Files, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for name := range found {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entries = append(entries, *found[name])
+		}
+	}
+	return entries, nil
+}
+
+// trashedSeries returns the series of the journal or rollup archive at
+// rel, a path relative to the root of a store.
+func trashedSeries(rel string) string {
+	name := strings.TrimSuffix(uncompressed(rel), Ext)
+	dir, file := filepath.Split(name)
+	file, _, _ = strings.Cut(file, "@")
+	return strings.Replace(filepath.Join(dir, file), string(filepath.Separator), ".", -1)
+}
+
+// Undelete restores the files of series most recently moved to the
+// trash.  It fails with an error satisfying os.IsNotExist if none are
+// there and os.IsExist if the series has since been created again.
+func (s *Store) Undelete(series string) error {
+	if err := Validate(series); err != nil {
+		return err
+	}
+	entries, err := s.Trashed()
+	if err != nil {
+		return err
+	}
+	var entry *TrashEntry
+	for i := range entries {
+		if entries[i].Series == series {
+			entry = &entries[i]
+		}
+	}
+	if entry == nil {
+		return &os.PathError{Op: "undelete", Path: series, Err: os.ErrNotExist}
+	}
+	files, err := s.Files(series)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return &os.PathError{Op: "undelete", Path: files[0], Err: os.ErrExist}
+	}
+
+	top, name := s.unscoped(series)
+	stamp := filepath.Join(top.Root, TrashDir, strconv.FormatInt(entry.Deleted.UnixNano(), 10))
+	err = s.Audit(AuditEntry{Op: "undelete", Series: series, Detail: "from " + stamp})
+	if err != nil {
+		return err
+	}
+	defer top.reindex(name)
+	for _, path := range entry.Files {
+		rel, err := filepath.Rel(stamp, path)
+		if err != nil {
+			return err
+		}
+		if err = move(path, filepath.Join(top.Root, rel)); err != nil {
+			return err
+		}
+		top.prune(filepath.Dir(path))
+	}
+	return nil
+}
+
+// PurgeTrash permanently removes the series deleted before the given
+// time from the trash and returns them.
+func (s *Store) PurgeTrash(before time.Time) ([]TrashEntry, error) {
+	entries, err := s.Trashed()
+	if err != nil {
+		return nil, err
+	}
+	top, _ := s.unscoped("")
+	var purged []TrashEntry
+	for _, entry := range entries {
+		if !entry.Deleted.Before(before) {
+			continue
+		}
+		detail := fmt.Sprintf("deleted %s", entry.Deleted.UTC().Format(time.RFC3339))
+		if err = s.Audit(AuditEntry{Op: "purge", Series: entry.Series, Detail: detail}); err != nil {
+			return purged, err
+		}
+		for _, path := range entry.Files {
+			if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return purged, err
+			}
+			top.prune(filepath.Dir(path))
+		}
+		purged = append(purged, entry)
+	}
+	return purged, nil
+}
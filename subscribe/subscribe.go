@@ -0,0 +1,212 @@
+// Package subscribe streams points to clients as they are written, so
+// that downstream processors can consume live data without polling the
+// store.  A Hub is fed every point written and served over HTTP:
+//
+//	GET /subscribe?target=servers.*.cpu
+//
+// responds with a stream of one JSON object per line for as long as the
+// client stays connected:
+//
+//	{"series": "servers.web01.cpu", "timestamp": 1449240540, "value": 1.5}
+//
+// Targets are Graphite style glob patterns as accepted by
+// store.Store.Find and may be repeated.  Null values are encoded as JSON
+// null.
+//
+// Subscribers never hold up writes.  Each has a buffer of BufferSize
+// points, and points that arrive while it is full are dropped for that
+// subscriber and counted in Hub.Dropped.
+package subscribe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/jjneely/journal/ingest"
+	"github.com/jjneely/journal/stats"
+)
+
+// BufferSize is the number of points each subscription buffers before
+// more are dropped.
+const BufferSize = 4096
+
+// ErrClosed is returned by Subscribe once the Hub is closed.
+var ErrClosed = errors.New("Hub closed")
+
+// Hub fans points out to the Subscriptions whose patterns match them.
+type Hub struct {
+	// Published counts the points delivered to subscriptions and
+	// Dropped those discarded because a subscription's buffer was full.
+	Published stats.Counter
+	Dropped   stats.Counter
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscription receives the points matching its patterns on C until it
+// or its Hub is closed, when C is closed.
+type Subscription struct {
+	C <-chan ingest.Point
+
+	c     chan ingest.Point
+	globs []string
+	hub   *Hub
+}
+
+// NewHub returns a Hub without subscriptions.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a Subscription to the points of the series matching
+// any of the patterns.
+func (h *Hub) Subscribe(patterns ...string) (*Subscription, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("No patterns to subscribe to")
+	}
+	s := &Subscription{c: make(chan ingest.Point, BufferSize), hub: h}
+	s.C = s.c
+	for _, pattern := range patterns {
+		glob := strings.Replace(pattern, ".", "/", -1)
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("Pattern %s: %s", pattern, err)
+		}
+		s.globs = append(s.globs, glob)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+	h.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Subscribers returns the number of open subscriptions.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Publish delivers a point written to every subscription matching it.
+// It never blocks.
+func (h *Hub) Publish(p ingest.Point) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) == 0 {
+		return
+	}
+	name := strings.Replace(p.Series, ".", "/", -1)
+	for s := range h.subs {
+		if !s.match(name) {
+			continue
+		}
+		select {
+		case s.c <- p:
+			h.Published.Add(1)
+		default:
+			h.Dropped.Add(1)
+		}
+	}
+}
+
+// Close closes every subscription and refuses new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// match reports whether a series, with its dots replaced by slashes,
+// matches any of the subscription's patterns.
+func (s *Subscription) match(name string) bool {
+	for _, glob := range s.globs {
+		if ok, _ := filepath.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops the subscription and closes C.  It may be called more than
+// once.
+func (s *Subscription) Close() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// ServeHTTP streams the points of the series matching the target
+// parameters until the client disconnects or the Hub is closed.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	targets := r.Form["target"]
+	if len(targets) == 0 {
+		http.Error(w, "Missing target", http.StatusBadRequest)
+		return
+	}
+	s, err := h.Subscribe(targets...)
+	if err == ErrClosed {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer s.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case p, ok := <-s.C:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(encode(p)); err != nil {
+				return
+			}
+			// Points already waiting are written before flushing
+			if flusher != nil && len(s.C) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// encode returns a point as a line of JSON.
+func encode(p ingest.Point) []byte {
+	series, _ := json.Marshal(p.Series)
+	value := "null"
+	if !math.IsNaN(p.Value) && !math.IsInf(p.Value, 0) {
+		value = strconv.FormatFloat(p.Value, 'g', -1, 64)
+	}
+	return []byte(fmt.Sprintf("{\"series\":%s,\"timestamp\":%d,\"value\":%s}\n", series, p.Timestamp, value))
+}
@@ -0,0 +1,115 @@
+package subscribe
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/jjneely/journal/ingest"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	cpu, err := h.Subscribe("servers.*.cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := h.Subscribe("servers.*.*", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = h.Subscribe("[a"); err == nil {
+		t.Errorf("Invalid pattern accepted")
+	}
+
+	h.Publish(ingest.Point{Series: "servers.web01.cpu", Timestamp: 60, Value: 1})
+	h.Publish(ingest.Point{Series: "servers.web01.mem", Timestamp: 60, Value: 2})
+	h.Publish(ingest.Point{Series: "servers.web01.cpu.user", Timestamp: 60, Value: 3})
+	h.Publish(ingest.Point{Series: "other", Timestamp: 60, Value: 4})
+	cpu.Close()
+	cpu.Close()
+	h.Publish(ingest.Point{Series: "servers.web02.cpu", Timestamp: 60, Value: 5})
+
+	var got []string
+	for p := range cpu.C {
+		got = append(got, p.Series)
+	}
+	if fmt.Sprint(got) != "[servers.web01.cpu]" {
+		t.Errorf("Subscription to cpu received %v", got)
+	}
+	if h.Subscribers() != 1 || h.Published.Value() != 5 {
+		t.Errorf("%d subscribers, %d published", h.Subscribers(), h.Published.Value())
+	}
+
+	for i := 0; i < BufferSize; i++ {
+		h.Publish(ingest.Point{Series: "other", Timestamp: int64(i)})
+	}
+	if h.Dropped.Value() != 4 {
+		t.Errorf("Dropped %d points", h.Dropped.Value())
+	}
+
+	h.Close()
+	got = nil
+	for p := range all.C {
+		if p.Series != "other" {
+			got = append(got, p.Series)
+		}
+	}
+	if fmt.Sprint(got) != "[servers.web01.cpu servers.web01.mem servers.web02.cpu]" {
+		t.Errorf("Subscription to all received %v", got)
+	}
+	if _, err = h.Subscribe("a"); err != ErrClosed {
+		t.Errorf("Subscribe after Close returned %v", err)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := NewHub()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?target=a.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Subscribe returned %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for h.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	h.Publish(ingest.Point{Series: "a.b", Timestamp: 60, Value: 1.5})
+	h.Publish(ingest.Point{Series: "b.c", Timestamp: 60, Value: 2})
+	h.Publish(ingest.Point{Series: "a.c", Timestamp: 120, Value: math.NaN()})
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 2 && lines.Scan() {
+		got = append(got, lines.Text())
+	}
+	want := `[{"series":"a.b","timestamp":60,"value":1.5} {"series":"a.c","timestamp":120,"value":null}]`
+	if fmt.Sprint(got) != want {
+		t.Errorf("Streamed %v", got)
+	}
+
+	h.Close()
+	if lines.Scan() {
+		t.Errorf("Stream continued after Close: %s", lines.Text())
+	}
+
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("Missing target returned %d", resp.StatusCode)
+	}
+}
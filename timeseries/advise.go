@@ -0,0 +1,60 @@
+package timeseries
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// Advice is an access pattern hint passed to the kernel for a journal.
+type Advice int
+
+const (
+	Normal     Advice = iota // no special treatment
+	Sequential               // expect sequential reads, read ahead aggressively
+	Random                   // expect random reads, disable read ahead
+)
+
+// Advise tells the kernel how the journal's data is about to be accessed
+// so that read ahead can be tuned.  This is only a hint and is a no-op
+// on platforms without posix_fadvise.
+func (ts *FileJournal) Advise(advice Advice) error {
+	return fadvise(ts.fd, 0, 0, advice)
+}
+
+// ReadRange returns the values for the timestamps from through until
+// inclusive, clamped to the data stored in the journal.  The kernel is
+// advised that the range will be read sequentially.
+func (ts *FileJournal) ReadRange(from, until int64) (Values, error) {
+	if err := ts.begin(false); err != nil {
+		return nil, err
+	}
+	defer ts.end()
+
+	first, n := ts.span(from, until)
+	if n > 0 {
+		width := int64(ts.header.Width)
+		fadvise(ts.fd, ts.base+first*width, n*width, Sequential)
+	}
+	return ts.read(ts.header.Epoch+first*ts.header.Interval, int(n))
+}
+
+// span converts an inclusive timestamp range into the index of the first
+// point and the number of points, clamped to the points in the journal.
+func (ts *FileJournal) span(from, until int64) (int64, int64) {
+	if ts.header.Epoch == 0 || ts.points == 0 {
+		return 0, 0
+	}
+	from = adjust(from, ts.header.Interval)
+	until = adjust(until, ts.header.Interval)
+	if from < ts.header.Epoch {
+		from = ts.header.Epoch
+	}
+	if last := ts.Last(); until > last {
+		until = last
+	}
+	if until < from {
+		return 0, 0
+	}
+
+	first := (from - ts.header.Epoch) / ts.header.Interval
+	return first, (until-from)/ts.header.Interval + 1
+}
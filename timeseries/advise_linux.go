@@ -0,0 +1,30 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package timeseries
+
+import (
+	"os"
+	"syscall"
+)
+
+// POSIX_FADV_* values from <fcntl.h>
+var fadvice = map[Advice]uintptr{
+	Normal:     0,
+	Random:     1,
+	Sequential: 2,
+}
+
+func fadvise(file File, offset, length int64, advice Advice) error {
+	fd, ok := file.(*os.File)
+	if !ok {
+		return nil
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, fd.Fd(),
+		uintptr(offset), uintptr(length), fadvice[advice], 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
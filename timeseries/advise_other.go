@@ -0,0 +1,9 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package timeseries
+
+// fadvise is only implemented on 64 bit Linux.
+func fadvise(fd File, offset, length int64, advice Advice) error {
+	return nil
+}
@@ -0,0 +1,152 @@
+package timeseries
+
+import (
+	"fmt"
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// AggFunc consolidates the summary of the non-null values of one step of
+// ReadAggregate into a single value.  Steps without values are given to
+// it with a zero Count.
+type AggFunc func(s BlockSummary) float64
+
+// AggAverage returns the mean of the values.
+func AggAverage(s BlockSummary) float64 {
+	return s.Mean()
+}
+
+// AggSum returns the total of the values.
+func AggSum(s BlockSummary) float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Sum
+}
+
+// AggMin returns the smallest value.
+func AggMin(s BlockSummary) float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Min
+}
+
+// AggMax returns the largest value.
+func AggMax(s BlockSummary) float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Max
+}
+
+// AggCount returns the number of non-null values, which is never NaN.
+func AggCount(s BlockSummary) float64 {
+	return float64(s.Count)
+}
+
+// AggFuncs maps the names used in queries to AggFuncs.
+var AggFuncs = map[string]AggFunc{
+	"average": AggAverage,
+	"avg":     AggAverage,
+	"sum":     AggSum,
+	"min":     AggMin,
+	"max":     AggMax,
+	"count":   AggCount,
+}
+
+// GetAggFunc returns the AggFunc with the given name.
+func GetAggFunc(name string) (AggFunc, error) {
+	fn, ok := AggFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown aggregation method: %s", name)
+	}
+	return fn, nil
+}
+
+// ReadAggregate consolidates the values of a numeric journal at timestamps
+// from through until inclusive into one value every step, which must be
+// a multiple of the interval.  Steps start at multiples of step and the
+// first covers the first value in the range.  It returns the timestamp of
+// the first step and the value of each, NaN for those without values
+// unless fn says otherwise.  With Options.BlockSummaries the summaries
+// of whole blocks are read rather than their values.  MaxReadPoints
+// limits the number of steps.
+func (ts *FileJournal) ReadAggregate(from, until, step int64, fn AggFunc) (int64, Float64Values, error) {
+	if err := ts.begin(false); err != nil {
+		return 0, nil, err
+	}
+	defer ts.end()
+
+	if !summarizable(ts.factory, 2) {
+		return 0, nil, fmt.Errorf("Journal is not numeric: %s", ts.fd.Name())
+	}
+	if err := checkStep(step, ts.header.Interval); err != nil {
+		return 0, nil, err
+	}
+	if ts.header.Epoch == 0 || ts.points == 0 {
+		return 0, nil, nil
+	}
+	from = max(from, ts.header.Epoch)
+	until = min(until, ts.Last())
+	if until < from {
+		return 0, nil, nil
+	}
+	start := adjust(from, step)
+	steps := (adjust(until, step)-start)/step + 1
+	if limit := ts.opts.MaxReadPoints; limit > 0 && steps > limit {
+		return 0, nil, &ReadLimitError{Path: ts.fd.Name(), Points: steps, Limit: limit}
+	}
+
+	sums, err := ts.sums()
+	if err != nil {
+		return 0, nil, err
+	}
+	values := make(Float64Values, steps)
+	for i := range values {
+		t := start + int64(i)*step
+		first, n := ts.span(max(t, from), min(t+step-1, until))
+		s, err := ts.summarize(sums, first, n)
+		if err != nil {
+			return 0, nil, err
+		}
+		values[i] = fn(s)
+	}
+	return start, values, nil
+}
+
+// AggregateValues is ReadAggregate for numeric values already read, the
+// first at start and the rest every interval after it.
+func AggregateValues(start, interval int64, values Values, step int64, fn AggFunc) (int64, Float64Values, error) {
+	if err := checkStep(step, interval); err != nil {
+		return 0, nil, err
+	}
+	if values.Len() == 0 {
+		return 0, nil, nil
+	}
+	floats, err := toFloats(values)
+	if err != nil {
+		return 0, nil, err
+	}
+	first := adjust(start, step)
+	last := start + int64(len(floats)-1)*interval
+	sums := make([]BlockSummary, (adjust(last, step)-first)/step+1)
+	for i, x := range floats {
+		sums[(start+int64(i)*interval-first)/step].add(x)
+	}
+	result := make(Float64Values, len(sums))
+	for i, s := range sums {
+		result[i] = fn(s)
+	}
+	return first, result, nil
+}
+
+func checkStep(step, interval int64) error {
+	if step <= 0 || step%interval != 0 {
+		return fmt.Errorf("Step %d is not a multiple of the interval %d", step, interval)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package timeseries
+
+import (
+	"fmt"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// typed is implemented by journals that can report their ValueType, such
+// as FileJournal.
+type typed interface {
+	Factory() ValueType
+}
+
+// Appendable checks that src can be appended to dst and returns the
+// timestamp of the first value of src that is newer than dst.Last() along
+// with the number of values of src from there on.  Without allowGap src
+// must continue exactly where dst ends or overlap it.
+func Appendable(dst, src Journal, allowGap bool) (int64, int64, error) {
+	if dst.Width() != src.Width() || dst.Interval() != src.Interval() {
+		return 0, 0, fmt.Errorf("Journals differ in width or interval")
+	}
+	if d, ok := dst.(typed); ok {
+		if s, ok := src.(typed); ok && d.Factory().Type() != s.Factory().Type() {
+			return 0, 0, fmt.Errorf("Journals differ in type")
+		}
+	}
+	if src.Epoch() == 0 {
+		return 0, 0, nil
+	}
+
+	from := src.Epoch()
+	if dst.Epoch() != 0 {
+		next := dst.Last() + dst.Interval()
+		if from > next && !allowGap {
+			return 0, 0, fmt.Errorf("Gap of %d points between journals",
+				(from-next)/dst.Interval())
+		}
+		if from < next {
+			from = next
+		}
+	}
+	if from > src.Last() {
+		return from, 0, nil
+	}
+	return from, (src.Last()-from)/src.Interval() + 1, nil
+}
+
+// Append copies the values of src that are newer than dst.Last() onto the
+// end of dst, for stitching together segments of the same series.  The
+// journals must have the same type, width, and interval.  With allowGap
+// a gap between the journals is filled with nulls, otherwise it is an
+// error.  The number of values appended is returned.
+func Append(dst, src Journal, allowGap bool) (int64, error) {
+	from, n, err := Appendable(dst, src, allowGap)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	interval := src.Interval()
+	for done := int64(0); done < n; done += editChunk {
+		count := n - done
+		if count > editChunk {
+			count = editChunk
+		}
+		start := from + done*interval
+		values, err := src.ReadRange(start, start+(count-1)*interval)
+		if err != nil {
+			return done, err
+		}
+		if err = dst.Write(start, values); err != nil {
+			return done, err
+		}
+	}
+	return n, nil
+}
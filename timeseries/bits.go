@@ -0,0 +1,46 @@
+package timeseries
+
+import (
+	"fmt"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// BitSet returns the runs of timestamps from through until inclusive
+// whose values have the given bit set, in time order, for journals of
+// BitfieldValueType such as the history of a health check.  Null values
+// have no bits set.  As with Gaps nothing is decoded.
+func (ts *FileJournal) BitSet(bit int, from, until int64) ([]Range, error) {
+	if _, ok := ts.factory.(*BitfieldValueType); !ok {
+		return nil, fmt.Errorf("Journal type %#x is not a bitfield", ts.factory.Type())
+	}
+	if bit < 0 || bit >= 8*int(ts.header.Width) {
+		return nil, fmt.Errorf("Bit %d is beyond %d byte masks", bit, ts.header.Width)
+	}
+	if err := ts.begin(false); err != nil {
+		return nil, err
+	}
+	defer ts.end()
+
+	var runs []Range
+	interval := ts.header.Interval
+	first, n := ts.span(from, until)
+	err := ts.scan(first, n, func(i int64, value []byte) {
+		m := Bitmask(value)
+		if !m.Bit(bit) || m.IsNull() {
+			return
+		}
+		t := ts.header.Epoch + i*interval
+		if k := len(runs); k > 0 && runs[k-1].Until+interval == t {
+			runs[k-1].Until = t
+		} else {
+			runs = append(runs, Range{t, t})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
@@ -0,0 +1,321 @@
+package timeseries
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// BlockSummaryExt is appended to the path of a journal to name the file
+// of its block summaries.
+const BlockSummaryExt = ".sum"
+
+// SummaryBlock is the number of values, counted from the epoch, that each
+// block summary describes.
+const SummaryBlock = 1024
+
+// blockSummaryMagic begins every block summary file.
+var blockSummaryMagic = [4]byte{0x42, 0x4A, 0x54, 0x42} // "BJTB"
+
+const (
+	// blockFileHeader is the size of the header of a block summary file:
+	// the magic number, the block size, and the epoch and number of
+	// values of the journal it describes, all little endian.
+	blockFileHeader = 24
+
+	// blockRecord is the size of each summary that follows the header.
+	blockRecord = 32
+)
+
+// BlockSummary aggregates the non-null values of a run of values.
+type BlockSummary struct {
+	Min, Max, Sum float64
+	Count         int64 // of non-null values
+}
+
+// Mean returns the mean of the values, or NaN if there are none.
+func (s BlockSummary) Mean() float64 {
+	if s.Count == 0 {
+		return math.NaN()
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Merge returns the summary of the values of s and o together.
+func (s BlockSummary) Merge(o BlockSummary) BlockSummary {
+	switch {
+	case o.Count == 0:
+		return s
+	case s.Count == 0:
+		return o
+	}
+	return BlockSummary{
+		Min:   min(s.Min, o.Min),
+		Max:   max(s.Max, o.Max),
+		Sum:   s.Sum + o.Sum,
+		Count: s.Count + o.Count,
+	}
+}
+
+// add includes x, unless it is NaN, in s.
+func (s *BlockSummary) add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	*s = s.Merge(BlockSummary{Min: x, Max: x, Sum: x, Count: 1})
+}
+
+// blockSums are the block summaries of a journal opened with
+// Options.BlockSummaries, mirrored in memory.  They describe the journal
+// only while their epoch and points match its own.
+type blockSums struct {
+	fd     File
+	epoch  int64
+	points int64
+	blocks []BlockSummary
+}
+
+// sums returns the block summaries of the journal, loading them on first
+// use and rebuilding them if they do not match the journal or it was not
+// synced after its last write.  It returns nil if the journal does not
+// keep them or they cannot be used, as for read-only journals whose
+// summaries are missing or out of date.
+func (ts *FileJournal) sums() (*blockSums, error) {
+	if !ts.opts.BlockSummaries || ts.opts.Cooperative || !summarizable(ts.factory, 2) {
+		return nil, nil
+	}
+	if ts.blocks != nil {
+		return ts.blocks, nil
+	}
+	flag := os.O_RDWR | os.O_CREATE
+	if ts.readonly {
+		flag = os.O_RDONLY
+	}
+	fd, err := ts.opts.fs().OpenFile(ts.fd.Name()+BlockSummaryExt, flag, ts.opts.fileMode())
+	if os.IsNotExist(err) && ts.readonly {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s := &blockSums{fd: fd}
+	if s.load() && s.epoch == ts.header.Epoch && s.points == ts.points && !ts.Dirty() {
+		ts.blocks = s
+		return s, nil
+	}
+	if ts.readonly {
+		fd.Close()
+		return nil, nil
+	}
+	if err = s.rebuild(ts); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	ts.blocks = s
+	return s, nil
+}
+
+// load reads the summaries from the file and reports whether they are
+// complete.
+func (s *blockSums) load() bool {
+	stat, err := s.fd.Stat()
+	if err != nil || stat.Size() < blockFileHeader {
+		return false
+	}
+	buf := make([]byte, stat.Size())
+	if _, err = s.fd.ReadAt(buf, 0); err != nil {
+		return false
+	}
+	if [4]byte(buf[:4]) != blockSummaryMagic || binary.LittleEndian.Uint32(buf[4:]) != SummaryBlock {
+		return false
+	}
+	s.epoch = int64(binary.LittleEndian.Uint64(buf[8:]))
+	s.points = int64(binary.LittleEndian.Uint64(buf[16:]))
+	if s.points < 0 || int64(len(buf)) != blockFileHeader+blockRecord*blockCount(s.points) {
+		return false
+	}
+	s.blocks = make([]BlockSummary, blockCount(s.points))
+	for i := range s.blocks {
+		r := buf[blockFileHeader+blockRecord*i:]
+		s.blocks[i] = BlockSummary{
+			Min:   math.Float64frombits(binary.LittleEndian.Uint64(r)),
+			Max:   math.Float64frombits(binary.LittleEndian.Uint64(r[8:])),
+			Sum:   math.Float64frombits(binary.LittleEndian.Uint64(r[16:])),
+			Count: int64(binary.LittleEndian.Uint64(r[24:])),
+		}
+	}
+	return true
+}
+
+// blockCount returns the number of blocks holding n values.
+func blockCount(n int64) int64 {
+	return (n + SummaryBlock - 1) / SummaryBlock
+}
+
+// rebuild computes every summary from the journal's values and rewrites
+// the file.
+func (s *blockSums) rebuild(ts *FileJournal) error {
+	s.blocks = nil
+	if err := s.fd.Truncate(0); err != nil {
+		return err
+	}
+	return s.refresh(ts, 0, ts.points)
+}
+
+// wrote updates the summaries after floats were written at index, when
+// the journal held before values.
+func (s *blockSums) wrote(ts *FileJournal, index, before int64, floats []float64) error {
+	if index < before || (index > before && ts.opts.CarryForward) {
+		// Overwritten or filled values must be read back
+		from := min(index, before)
+		return s.refresh(ts, from, index+int64(len(floats))-from)
+	}
+	s.resize(ts)
+	for i, x := range floats {
+		s.blocks[(index+int64(i))/SummaryBlock].add(x)
+	}
+	return s.save(index/SummaryBlock, blockCount(index+int64(len(floats))))
+}
+
+// refresh recomputes the summaries of the blocks holding the n values
+// starting at index first from the journal.
+func (s *blockSums) refresh(ts *FileJournal, first, n int64) error {
+	s.resize(ts)
+	start := first / SummaryBlock
+	end := min(blockCount(first+n), int64(len(s.blocks)))
+	for i := start; i < end; i++ {
+		s.blocks[i] = BlockSummary{}
+	}
+	from := start * SummaryBlock
+	var convertErr error
+	err := ts.scanChunks(from, min(end*SummaryBlock, ts.points)-from, func(first int64, chunk []byte) bool {
+		floats, err := toFloats(ts.factory.Decode(chunk))
+		if err != nil {
+			convertErr = err
+			return false
+		}
+		for i, x := range floats {
+			s.blocks[(first+int64(i))/SummaryBlock].add(x)
+		}
+		return true
+	})
+	if err == nil {
+		err = convertErr
+	}
+	if err != nil {
+		return err
+	}
+	return s.save(start, end)
+}
+
+// resize matches the summaries to the journal's epoch and size.
+func (s *blockSums) resize(ts *FileJournal) {
+	s.epoch, s.points = ts.header.Epoch, ts.points
+	n := blockCount(s.points)
+	for int64(len(s.blocks)) < n {
+		s.blocks = append(s.blocks, BlockSummary{})
+	}
+	s.blocks = s.blocks[:n]
+}
+
+// save writes the header and the summaries of blocks start up to end to
+// the file.
+func (s *blockSums) save(start, end int64) error {
+	buf := append([]byte(nil), blockSummaryMagic[:]...)
+	buf = binary.LittleEndian.AppendUint32(buf, SummaryBlock)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(s.epoch))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(s.points))
+	if _, err := s.fd.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	buf = buf[:0]
+	for _, b := range s.blocks[start:end] {
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(b.Min))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(b.Max))
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(b.Sum))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(b.Count))
+	}
+	if _, err := s.fd.WriteAt(buf, blockFileHeader+blockRecord*start); err != nil {
+		return err
+	}
+	return s.fd.Truncate(blockFileHeader + blockRecord*int64(len(s.blocks)))
+}
+
+// dropSums discards the block summaries of a journal whose values moved,
+// such as by Trim, so that they are rebuilt when next needed.
+func (ts *FileJournal) dropSums() {
+	if ts.blocks != nil {
+		ts.blocks.fd.Close()
+		ts.blocks = nil
+	}
+	ts.opts.fs().Remove(ts.fd.Name() + BlockSummaryExt)
+}
+
+// RangeSummary returns the summary of the non-null values at timestamps
+// from through until inclusive of a numeric journal.  With
+// Options.BlockSummaries only the values in the partial blocks at either
+// end of the range are read and the rest is taken from the summaries.
+func (ts *FileJournal) RangeSummary(from, until int64) (BlockSummary, error) {
+	if err := ts.begin(false); err != nil {
+		return BlockSummary{}, err
+	}
+	defer ts.end()
+
+	if !summarizable(ts.factory, 2) {
+		return BlockSummary{}, fmt.Errorf("Journal is not numeric: %s", ts.fd.Name())
+	}
+	first, n := ts.span(from, until)
+	if n == 0 {
+		return BlockSummary{}, nil
+	}
+	sums, err := ts.sums()
+	if err != nil {
+		return BlockSummary{}, err
+	}
+	return ts.summarize(sums, first, n)
+}
+
+// summarize returns the summary of the n values starting at index first,
+// using sums for the whole blocks among them if it is not nil.
+func (ts *FileJournal) summarize(sums *blockSums, first, n int64) (BlockSummary, error) {
+	start := (first + SummaryBlock - 1) / SummaryBlock
+	end := (first + n) / SummaryBlock
+	if sums == nil || start >= end {
+		return ts.summarizeValues(first, n)
+	}
+	head, err := ts.summarizeValues(first, start*SummaryBlock-first)
+	if err != nil {
+		return head, err
+	}
+	tail, err := ts.summarizeValues(end*SummaryBlock, first+n-end*SummaryBlock)
+	if err != nil {
+		return tail, err
+	}
+	s := head.Merge(tail)
+	for _, b := range sums.blocks[start:end] {
+		s = s.Merge(b)
+	}
+	return s, nil
+}
+
+// summarizeValues reads the n values starting at index first to
+// summarize them.
+func (ts *FileJournal) summarizeValues(first, n int64) (BlockSummary, error) {
+	var s BlockSummary
+	var convertErr error
+	err := ts.scanChunks(first, n, func(_ int64, chunk []byte) bool {
+		floats, err := toFloats(ts.factory.Decode(chunk))
+		if err != nil {
+			convertErr = err
+			return false
+		}
+		for _, x := range floats {
+			s.add(x)
+		}
+		return true
+	})
+	if err == nil {
+		err = convertErr
+	}
+	return s, err
+}
@@ -0,0 +1,18 @@
+package timeseries
+
+import (
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which a write buffer is left for
+// the garbage collector rather than kept for reuse.
+const maxPooledBuffer = 1 << 20
+
+// writeBuffers holds the buffers Write encodes values into so that a
+// high rate of small writes does not allocate for every call.
+var writeBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 4096)
+		return &b
+	},
+}
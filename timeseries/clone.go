@@ -0,0 +1,57 @@
+package timeseries
+
+import (
+	"io"
+	"os"
+)
+
+// CloneJournal copies the journal at src to a new file at dst.  On file
+// systems that support reflinks, such as Btrfs, XFS, and ZFS, the copy
+// is made instantly and shares its blocks with src until either is
+// written.  Otherwise the data is copied in the kernel with
+// copy_file_range where available, or streamed.
+//
+// The source is opened read-only as by Open, so a journal held open for
+// writing by another process makes CloneJournal fail with ErrLocked.
+// Sealed journals, which cannot change, can always be cloned.  dst must
+// not exist.
+func CloneJournal(src, dst string) error {
+	j, err := OpenWithOptions(src, &Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+	if err = j.begin(false); err != nil {
+		return err
+	}
+	defer j.end()
+
+	stat, err := j.fd.Stat()
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if err = reflink(out, in); err != nil {
+		// os.File.ReadFrom uses copy_file_range when it can
+		_, err = io.Copy(out, io.LimitReader(in, stat.Size()))
+	}
+	if err == nil {
+		err = DefaultOptions.sync(out)
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package timeseries
+
+import (
+	"os"
+	"syscall"
+)
+
+// reflink is only implemented on Linux.
+func reflink(dst, src *os.File) error {
+	return syscall.ENOTSUP
+}
@@ -0,0 +1,607 @@
+package timeseries
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CompressBlock is the number of bytes of a journal file compressed in
+// each block of a compressed journal, and so the least that is
+// decompressed to read any value.
+const CompressBlock = 65536
+
+// compressMagic begins and ends every compressed journal.
+const compressMagic = "TSJZ"
+
+// compressTrailer is the size of the index offset and magic that end a
+// compressed journal.
+const compressTrailer = 8 + len(compressMagic)
+
+// Codec compresses the blocks of compressed journals.  Gzip is built in,
+// and other codecs, such as zstd, may be added with RegisterCodec.
+type Codec struct {
+	// Name is recorded in compressed journals to find the codec that
+	// reads them.
+	Name string
+
+	// Ext is appended to the path of a journal compressed with the
+	// codec, such as ".gz".
+	Ext string
+
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip is the built in Codec using compress/gzip.
+var Gzip = &Codec{
+	Name: "gzip",
+	Ext:  ".gz",
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	},
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = []*Codec{Gzip}
+)
+
+// RegisterCodec makes a codec available to Open and CompressJournal.  A
+// codec with the same name or extension as a registered one replaces it.
+func RegisterCodec(c *Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	kept := codecs[:0]
+	for _, old := range codecs {
+		if old.Name != c.Name && old.Ext != c.Ext {
+			kept = append(kept, old)
+		}
+	}
+	codecs = append(kept, c)
+}
+
+// Codecs returns the registered codecs.
+func Codecs() []*Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return append([]*Codec(nil), codecs...)
+}
+
+// GetCodec returns the registered codec with the given name, or nil.
+func GetCodec(name string) *Codec {
+	for _, c := range Codecs() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// CompressedExt returns the extension of the registered codec that path
+// ends with, or "" if it is not the path of a compressed journal.
+func CompressedExt(path string) string {
+	for _, c := range Codecs() {
+		if strings.HasSuffix(path, c.Ext) {
+			return c.Ext
+		}
+	}
+	return ""
+}
+
+// openCompressed opens the compressed form of the journal at path, with
+// the extension of a registered codec appended, for Open.  The error of
+// the first codec is returned if none exists.
+func openCompressed(path string, opts *Options) (File, error) {
+	var first error
+	for _, c := range Codecs() {
+		fd, err := opts.openCompressedFile(path + c.Ext)
+		if !os.IsNotExist(err) {
+			return fd, err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	if first == nil {
+		first = &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return nil, first
+}
+
+// openCompressedFile opens the compressed journal at path.
+func (o *Options) openCompressedFile(path string) (File, error) {
+	fd, err := o.fs().OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := readCompressed(fd, o.blockCache())
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return cf, nil
+}
+
+// CompressJournal replaces the sealed journal at path with a copy
+// compressed by codec, with the codec's Ext appended to its name, for
+// cold data that is rarely read.  Open reads the copy transparently when
+// given the original path, decompressing blocks as they are read.  The
+// copy is read back and compared with the journal before the journal is
+// removed.  With dryRun set the journal is compressed only to report the
+// bytes it would save.
+func CompressJournal(path string, codec *Codec, dryRun bool, opts *Options) (Change, error) {
+	opts = opts.orDefault()
+	c := Change{Op: "compress", Path: path + codec.Ext, DryRun: dryRun}
+	j, err := OpenWithOptions(path, &Options{ReadOnly: true, FS: opts.FS, LockTimeout: opts.LockTimeout})
+	if err != nil {
+		return c, err
+	}
+	defer j.Close()
+	if _, ok := j.fd.(*compressedFile); ok {
+		return c, fmt.Errorf("Journal is already compressed: %s", j.fd.Name())
+	}
+	if !j.Sealed() {
+		return c, fmt.Errorf("Only sealed journals can be compressed: %s", path)
+	}
+	stat, err := j.fd.Stat()
+	if err != nil {
+		return c, err
+	}
+	original := io.NewSectionReader(j.fd, 0, stat.Size())
+
+	if dryRun {
+		cw := &countWriter{w: io.Discard}
+		if err = compress(cw, original, stat.Size(), codec); err != nil {
+			return c, err
+		}
+		c.Bytes = stat.Size() - cw.n
+		return c, nil
+	}
+	written, err := writeFile(opts, c.Path, stat.Mode().Perm(), func(w io.Writer) error {
+		return compress(w, original, stat.Size(), codec)
+	})
+	if err == nil {
+		err = verifyCompressed(c.Path, j.fd, stat.Size(), opts)
+		if err != nil {
+			opts.fs().Remove(c.Path)
+		}
+	}
+	if err != nil {
+		return c, err
+	}
+	c.Bytes = stat.Size() - written
+	return c, opts.fs().Remove(path)
+}
+
+// verifyCompressed reads back every block of the compressed journal at
+// path, bypassing the block cache, and compares it with the size bytes of
+// the original.
+func verifyCompressed(path string, original io.ReaderAt, size int64, opts *Options) error {
+	fd, err := opts.fs().OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	cf, err := readCompressed(fd, NewBlockCache(1))
+	if err != nil {
+		return err
+	}
+	if cf.size != size {
+		return fmt.Errorf("Compressed journal holds %d bytes, not %d: %s", cf.size, size, path)
+	}
+	want := make([]byte, cf.blockSize)
+	for i := range cf.index {
+		got, err := cf.block(int64(i))
+		if err != nil {
+			return err
+		}
+		if _, err = original.ReadAt(want[:len(got)], int64(i)*cf.blockSize); err != nil && err != io.EOF {
+			return err
+		}
+		if !bytes.Equal(got, want[:len(got)]) {
+			return fmt.Errorf("Compressed journal differs from the original in block %d: %s", i, path)
+		}
+	}
+	return nil
+}
+
+// DecompressJournal replaces the compressed journal at path, the path of
+// the journal before it was compressed, with its original file.  Every
+// block is checked against the checksum recorded when it was compressed.
+// With dryRun set the bytes the original takes are reported without
+// writing it.
+func DecompressJournal(path string, dryRun bool, opts *Options) (Change, error) {
+	opts = opts.orDefault()
+	c := Change{Op: "decompress", Path: path, DryRun: dryRun}
+	fd, err := openCompressed(path, opts)
+	if err != nil {
+		return c, err
+	}
+	defer fd.Close()
+	cf := fd.(*compressedFile)
+	stat, err := cf.fd.Stat()
+	if err != nil {
+		return c, err
+	}
+	c.Bytes = cf.size - stat.Size()
+	if dryRun {
+		return c, nil
+	}
+
+	_, err = writeFile(opts, path, stat.Mode().Perm(), func(w io.Writer) error {
+		_, err := io.Copy(w, io.NewSectionReader(cf, 0, cf.size))
+		return err
+	})
+	if err != nil {
+		return c, err
+	}
+	return c, opts.fs().Remove(cf.Name())
+}
+
+// writeFile creates the file at path, which must not exist, with the
+// content written by fill via a temporary file renamed into place, and
+// returns its size.
+func writeFile(opts *Options, path string, perm os.FileMode, fill func(w io.Writer) error) (int64, error) {
+	fs := opts.fs()
+	if _, err := fs.Stat(path); err == nil {
+		return 0, &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	dst, err := fs.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return 0, err
+	}
+	cw := &countWriter{w: dst}
+	bw := bufio.NewWriter(cw)
+	err = fill(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && opts.Durability != SyncNone {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fs.Rename(tmp, path)
+	}
+	if err != nil {
+		fs.Remove(tmp)
+		return 0, err
+	}
+	if _, ok := dst.(*os.File); ok && opts.Durability == SyncFull {
+		err = syncDir(filepath.Dir(path))
+	}
+	return cw.n, err
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// compressedBlock locates one compressed block in a compressed journal.
+type compressedBlock struct {
+	offset int64
+	length uint32
+	crc    uint32
+}
+
+// compress writes the size bytes of r as a compressed journal: a header
+// of the magic, the codec's name, the block size, and the original size,
+// then each CompressBlock bytes compressed on their own, then an index of
+// the offset, compressed length, and CRC-32 of the original bytes of each
+// block, the offset of the index, and the magic again.
+func compress(w io.Writer, r io.Reader, size int64, codec *Codec) error {
+	cw := &countWriter{w: w}
+	header := []byte(compressMagic)
+	header = append(header, byte(len(codec.Name)))
+	header = append(header, codec.Name...)
+	header = binary.LittleEndian.AppendUint32(header, CompressBlock)
+	header = binary.LittleEndian.AppendUint64(header, uint64(size))
+	if _, err := cw.Write(header); err != nil {
+		return err
+	}
+
+	var index []compressedBlock
+	buf := make([]byte, CompressBlock)
+	for remaining := size; remaining > 0; {
+		n, err := io.ReadFull(r, buf[:min(remaining, CompressBlock)])
+		if err != nil {
+			return err
+		}
+		remaining -= int64(n)
+		block := compressedBlock{offset: cw.n, crc: crc32.ChecksumIEEE(buf[:n])}
+		zw, err := codec.NewWriter(cw)
+		if err != nil {
+			return err
+		}
+		if _, err = zw.Write(buf[:n]); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+		block.length = uint32(cw.n - block.offset)
+		index = append(index, block)
+	}
+
+	trailer := make([]byte, 0, len(index)*16+compressTrailer)
+	for _, b := range index {
+		trailer = binary.LittleEndian.AppendUint64(trailer, uint64(b.offset))
+		trailer = binary.LittleEndian.AppendUint32(trailer, b.length)
+		trailer = binary.LittleEndian.AppendUint32(trailer, b.crc)
+	}
+	trailer = binary.LittleEndian.AppendUint64(trailer, uint64(cw.n))
+	trailer = append(trailer, compressMagic...)
+	_, err := cw.Write(trailer)
+	return err
+}
+
+// compressedFile is the read-only File of a compressed journal, reading
+// the original bytes of the journal by decompressing the blocks holding
+// them through a BlockCache.
+type compressedFile struct {
+	fd        File
+	codec     *Codec
+	blockSize int64
+	size      int64
+	index     []compressedBlock
+	cache     *BlockCache
+	key       blockKey
+	offset    int64
+}
+
+// readCompressed reads the header and index of a compressed journal.
+func readCompressed(fd File, cache *BlockCache) (*compressedFile, error) {
+	corrupt := fmt.Errorf("Corrupt compressed journal: %s", fd.Name())
+	stat, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(compressMagic)+1+255+12)
+	n, err := fd.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header = header[:n]
+	if !bytes.HasPrefix(header, []byte(compressMagic)) || len(header) < len(compressMagic)+1 {
+		return nil, fmt.Errorf("Not a compressed journal: %s", fd.Name())
+	}
+	header = header[len(compressMagic):]
+	nameLen := int(header[0])
+	if len(header) < 1+nameLen+12 {
+		return nil, corrupt
+	}
+	name := string(header[1 : 1+nameLen])
+	cf := &compressedFile{
+		fd:        fd,
+		codec:     GetCodec(name),
+		blockSize: int64(binary.LittleEndian.Uint32(header[1+nameLen:])),
+		size:      int64(binary.LittleEndian.Uint64(header[5+nameLen:])),
+		cache:     cache,
+		key:       blockKey{name: fd.Name(), modified: stat.ModTime(), size: stat.Size()},
+	}
+	if cf.codec == nil {
+		return nil, fmt.Errorf("Unknown compression codec %q: %s", name, fd.Name())
+	}
+	if cf.blockSize <= 0 || cf.size < 0 {
+		return nil, corrupt
+	}
+
+	blocks := (cf.size + cf.blockSize - 1) / cf.blockSize
+	end := stat.Size() - int64(compressTrailer)
+	if end < 0 || blocks*16 > end {
+		return nil, corrupt
+	}
+	trailer := make([]byte, compressTrailer)
+	if _, err = fd.ReadAt(trailer, end); err != nil {
+		return nil, err
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	if string(trailer[8:]) != compressMagic || indexOffset != end-blocks*16 {
+		return nil, corrupt
+	}
+	index := make([]byte, blocks*16)
+	if _, err = fd.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+	cf.index = make([]compressedBlock, blocks)
+	for i := range cf.index {
+		b := index[i*16:]
+		cf.index[i] = compressedBlock{
+			offset: int64(binary.LittleEndian.Uint64(b)),
+			length: binary.LittleEndian.Uint32(b[8:]),
+			crc:    binary.LittleEndian.Uint32(b[12:]),
+		}
+		if cf.index[i].offset+int64(cf.index[i].length) > indexOffset {
+			return nil, corrupt
+		}
+	}
+	return cf, nil
+}
+
+// block returns the original bytes of block i.
+func (cf *compressedFile) block(i int64) ([]byte, error) {
+	key := cf.key
+	key.block = i
+	return cf.cache.get(key, func() ([]byte, error) {
+		b := cf.index[i]
+		zr, err := cf.codec.NewReader(io.NewSectionReader(cf.fd, b.offset, int64(b.length)))
+		if err != nil {
+			return nil, fmt.Errorf("Corrupt compressed journal: %s: %s", cf.fd.Name(), err)
+		}
+		defer zr.Close()
+		want := min(cf.blockSize, cf.size-i*cf.blockSize)
+		buf := make([]byte, want)
+		if _, err = io.ReadFull(zr, buf); err != nil {
+			return nil, fmt.Errorf("Corrupt compressed journal: %s: %s", cf.fd.Name(), err)
+		}
+		if crc32.ChecksumIEEE(buf) != b.crc {
+			return nil, fmt.Errorf("Corrupt compressed journal: %s: checksum mismatch in block %d", cf.fd.Name(), i)
+		}
+		return buf, nil
+	})
+}
+
+func (cf *compressedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	n := 0
+	for n < len(p) && off < cf.size {
+		i := off / cf.blockSize
+		buf, err := cf.block(i)
+		if err != nil {
+			return n, err
+		}
+		m := copy(p[n:], buf[off-i*cf.blockSize:])
+		n += m
+		off += int64(m)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (cf *compressedFile) Read(p []byte) (int, error) {
+	n, err := cf.ReadAt(p, cf.offset)
+	cf.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Writes fail as compressed journals are always sealed.
+func (cf *compressedFile) Write(p []byte) (int, error)              { return 0, ErrSealed }
+func (cf *compressedFile) WriteAt(p []byte, off int64) (int, error) { return 0, ErrSealed }
+func (cf *compressedFile) Truncate(size int64) error                { return ErrSealed }
+func (cf *compressedFile) Chmod(mode os.FileMode) error             { return ErrSealed }
+
+func (cf *compressedFile) Sync() error  { return nil }
+func (cf *compressedFile) Close() error { return cf.fd.Close() }
+func (cf *compressedFile) Name() string { return cf.fd.Name() }
+
+// Stat describes the compressed file with the size of the original.
+func (cf *compressedFile) Stat() (os.FileInfo, error) {
+	stat, err := cf.fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return compressedInfo{stat, cf.size}, nil
+}
+
+// compressedInfo is the os.FileInfo of a compressedFile.
+type compressedInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (ci compressedInfo) Size() int64 { return ci.size }
+
+// Unwrap returns the os.FileInfo of the compressed file itself.
+func (ci compressedInfo) Unwrap() os.FileInfo { return ci.FileInfo }
+
+// DefaultBlockCache caches decompressed blocks for journals opened with
+// no Options.BlockCache: 256 blocks, or 16MiB.
+var DefaultBlockCache = NewBlockCache(256)
+
+// BlockCache holds the most recently read decompressed blocks of
+// compressed journals so that repeated reads of cold data do not
+// decompress it again.  It is safe for concurrent use and may be shared
+// by any number of journals.
+type BlockCache struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List
+	entries map[blockKey]*list.Element
+	hits    int64
+	misses  int64
+}
+
+// blockKey identifies a block of a compressed file.  The file's size and
+// modification time make blocks of a replaced file miss.
+type blockKey struct {
+	name     string
+	modified time.Time
+	size     int64
+	block    int64
+}
+
+type blockEntry struct {
+	key blockKey
+	buf []byte
+}
+
+// NewBlockCache returns a BlockCache holding up to the given number of
+// blocks.
+func NewBlockCache(blocks int) *BlockCache {
+	return &BlockCache{
+		max:     max(blocks, 1),
+		lru:     list.New(),
+		entries: make(map[blockKey]*list.Element),
+	}
+}
+
+// Stats returns the number of reads of blocks found in the cache and of
+// those decompressed.
+func (c *BlockCache) Stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// get returns the block for key, calling load to fill it on a miss.
+// Blocks are never modified once cached.
+func (c *BlockCache) get(key blockKey, load func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.hits++
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*blockEntry).buf, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	buf, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.lru.PushFront(&blockEntry{key, buf})
+		for c.lru.Len() > c.max {
+			e := c.lru.Back()
+			c.lru.Remove(e)
+			delete(c.entries, e.Value.(*blockEntry).key)
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,180 @@
+package timeseries
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// Rounding selects how Convert rounds values that the destination type
+// cannot represent exactly.
+type Rounding int
+
+const (
+	// RoundNearest rounds to the nearest representable value.
+	RoundNearest Rounding = iota
+
+	// RoundDown rounds toward negative infinity.
+	RoundDown
+
+	// RoundUp rounds toward positive infinity.
+	RoundUp
+
+	// RoundTowardZero truncates.
+	RoundTowardZero
+)
+
+// integer rounds x to an integral value.
+func (r Rounding) integer(x float64) float64 {
+	switch r {
+	case RoundDown:
+		return math.Floor(x)
+	case RoundUp:
+		return math.Ceil(x)
+	case RoundTowardZero:
+		return math.Trunc(x)
+	}
+	return math.Round(x)
+}
+
+// float32 rounds x to a float32.
+func (r Rounding) float32(x float64) float32 {
+	f := float32(x)
+	switch {
+	case r == RoundDown && float64(f) > x:
+		f = math.Nextafter32(f, float32(math.Inf(-1)))
+	case r == RoundUp && float64(f) < x:
+		f = math.Nextafter32(f, float32(math.Inf(1)))
+	case r == RoundTowardZero && math.Abs(float64(f)) > math.Abs(x):
+		f = math.Nextafter32(f, 0)
+	}
+	return f
+}
+
+// Convert creates a journal at path with the interval and metadata of src
+// holding the values of src converted to dstFactory's type.  Numeric
+// types convert between each other with values rounded as requested, and
+// each type's null sentinel is translated to the other's.  Byte journals
+// convert only to byte journals of the same width.  On error the new
+// journal is removed.
+func Convert(src Journal, dstFactory ValueType, path string, rounding Rounding) (*FileJournal, error) {
+	dst, err := Create(path, src.Interval(), dstFactory, src.Meta())
+	if err != nil {
+		return nil, err
+	}
+	if err = convert(dst, src, rounding); err != nil {
+		dst.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return dst, nil
+}
+
+func convert(dst *FileJournal, src Journal, rounding Rounding) error {
+	if src.Epoch() == 0 {
+		return nil
+	}
+	var srcNull []byte
+	if s, ok := src.(typed); ok {
+		srcNull = s.Factory().Null()
+	}
+
+	interval := src.Interval()
+	n := (src.Last()-src.Epoch())/interval + 1
+	for done := int64(0); done < n; done += editChunk {
+		count := n - done
+		if count > editChunk {
+			count = editChunk
+		}
+		start := src.Epoch() + done*interval
+		values, err := src.ReadRange(start, start+(count-1)*interval)
+		if err != nil {
+			return err
+		}
+		out, err := convertValues(values, srcNull, dst.factory, rounding)
+		if err != nil {
+			return err
+		}
+		if err = dst.Write(start, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertValues converts values to the type of factory.  srcNull is the
+// null sentinel of byte values, if known.
+func convertValues(values Values, srcNull []byte, factory ValueType, rounding Rounding) (Values, error) {
+	if b, ok := values.(ByteValues); ok {
+		if _, ok = factory.(*ByteValueType); !ok || len(factory.Null()) != len(srcNull) {
+			return nil, fmt.Errorf("Byte journals convert only to byte journals of the same width")
+		}
+		out := make(ByteValues, len(b))
+		for i := range b {
+			if bytes.Equal(b[i], srcNull) {
+				out[i] = factory.Null()
+			} else {
+				out[i] = b[i]
+			}
+		}
+		return out, nil
+	}
+
+	var floats []float64
+	switch v := values.(type) {
+	case Float64Values:
+		floats = v
+	case Float32Values:
+		floats = make([]float64, len(v))
+		for i := range v {
+			floats[i] = float64(v[i])
+		}
+	case Int64Values:
+		if _, ok := factory.(*Int64ValueType); ok {
+			return v, nil
+		}
+		floats = make([]float64, len(v))
+		for i := range v {
+			if v[i] == math.MinInt64 {
+				floats[i] = math.NaN()
+			} else {
+				floats[i] = float64(v[i])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("Cannot convert values of type %T", values)
+	}
+
+	switch factory.(type) {
+	case *Float64ValueType:
+		return Float64Values(floats), nil
+	case *Float32ValueType:
+		out := make(Float32Values, len(floats))
+		for i, f := range floats {
+			out[i] = rounding.float32(f)
+		}
+		return out, nil
+	case *Int64ValueType:
+		out := make(Int64Values, len(floats))
+		for i, f := range floats {
+			if math.IsNaN(f) {
+				out[i] = math.MinInt64
+				continue
+			}
+			f = rounding.integer(f)
+			// MinInt64 is the null sentinel so it is also out of range
+			if f <= math.MinInt64 || f >= math.MaxInt64 {
+				return nil, fmt.Errorf("Value %g is out of range for int64", f)
+			}
+			out[i] = int64(f)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("Cannot convert numeric values to journal type %#x",
+		factory.Type())
+}
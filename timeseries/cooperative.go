@@ -0,0 +1,132 @@
+package timeseries
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// begin starts an operation on a journal opened with Options.Cooperative
+// by taking the file lock, exclusive for writers, and then reloading the
+// state other processes may have changed since the last operation.  It is
+// a no-op for sealed journals and for journals that hold their lock for
+// their whole life other than to pick up changes noticed by a Watcher.
+func (ts *FileJournal) begin(exclusive bool) error {
+	if !ts.opts.Cooperative || ts.Sealed() {
+		return ts.fresh()
+	}
+	atomic.StoreInt32(&ts.stale, 0)
+	if err := ts.opts.acquire(ts.fd, !exclusive); err != nil {
+		return err
+	}
+	if err := ts.reload(!exclusive); err != nil {
+		release(ts.fd)
+		return err
+	}
+	return nil
+}
+
+// fresh reloads the journal if a Watcher has seen its file change since
+// the last operation.
+func (ts *FileJournal) fresh() error {
+	if atomic.CompareAndSwapInt32(&ts.stale, 1, 0) {
+		return ts.reload(ts.readonly)
+	}
+	return nil
+}
+
+// end finishes an operation started with begin.
+func (ts *FileJournal) end() {
+	if ts.opts.Cooperative {
+		release(ts.fd)
+	}
+}
+
+// reload re-reads the header and size of the journal.  If the file at the
+// journal's path has been replaced, as Trim does, the new file is opened
+// and locked in its place.  The caller must hold the lock on ts.fd.
+func (ts *FileJournal) reload(shared bool) error {
+	path := ts.fd.Name()
+	current, err := ts.fd.Stat()
+	if err != nil {
+		return err
+	}
+	named, err := ts.opts.fs().Stat(path)
+	if err != nil {
+		return err
+	}
+	replaced := !sameFile(current, named)
+	if replaced {
+		if err = ts.reopen(path, shared); err != nil {
+			return err
+		}
+	}
+
+	r := io.NewSectionReader(ts.fd, 0, summaryOffset+summarySize)
+	header, ext, err := readHeader(r, path)
+	if err != nil {
+		return err
+	}
+	// Trim makes version 2 journals version 3 in place
+	if headerSize(header.Version) != headerSize(ts.header.Version) || header.Type != ts.header.Type ||
+		header.Width != ts.header.Width || header.Interval != ts.header.Interval {
+		return fmt.Errorf("Journal header changed unexpectedly: %s", path)
+	}
+	base := headerSize(header.Version) + ext.Dropped*int64(header.Width)
+	stat, err := ts.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if (stat.Size()-base)%int64(header.Width) != 0 {
+		return ErrPartial
+	}
+
+	points := (stat.Size() - base) / int64(header.Width)
+	if replaced || points < ts.points || (ts.header.Epoch != 0 && header.Epoch != ts.header.Epoch) {
+		ts.gen++
+	}
+	ts.header = header
+	if !ts.touched {
+		ts.ext = ext
+	}
+	ts.ext.Dropped = ext.Dropped
+	ts.base = base
+	ts.points = points
+	return nil
+}
+
+// reopen swaps ts.fd for a locked descriptor of the file now at path.
+func (ts *FileJournal) reopen(path string, shared bool) error {
+	flag := os.O_RDWR
+	if ts.readonly {
+		flag = os.O_RDONLY
+	}
+	fd, err := ts.opts.fs().OpenFile(path, flag, 0)
+	if err != nil {
+		return err
+	}
+	if err = ts.opts.acquire(fd, shared); err != nil {
+		fd.Close()
+		return err
+	}
+	ts.fd.Close()
+	ts.fd = fd
+	return nil
+}
+
+// Refresh re-stats the journal file and re-reads its header so that
+// Epoch, Last, and Points reflect values appended by other processes
+// without closing and reopening the journal.  A journal whose file was
+// replaced, for example by Trim, is reopened.  Journals opened with
+// Options.Cooperative take the shared lock for the duration of the call.
+func (ts *FileJournal) Refresh() error {
+	if ts.opts.Cooperative {
+		if err := ts.begin(false); err != nil {
+			return err
+		}
+		ts.end()
+		return nil
+	}
+	return ts.reload(ts.readonly)
+}
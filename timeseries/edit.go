@@ -0,0 +1,407 @@
+package timeseries
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrPartial is returned by Open when the data in a journal does not end
+// on a value boundary, usually the result of an interrupted Write.  Use
+// Repair to truncate the partial value.
+var ErrPartial = errors.New("Corrupt or partial data!")
+
+// editChunk is the number of values processed at a time when rewriting
+// large ranges.
+const editChunk = 65536
+
+// Change describes the effect of a destructive operation.  Operations
+// called with dryRun set return the Change they would have made without
+// modifying anything.
+type Change struct {
+	Op          string // delete, trim, rebase, repair, or trash
+	Path        string
+	From, Until int64 // timestamps of the affected values, if any
+	Points      int64 // number of values nulled or removed
+	Bytes       int64 // bytes overwritten or removed from the file
+	DryRun      bool
+}
+
+// String describes the change for operators.
+func (c Change) String() string {
+	verb := "changed"
+	if c.DryRun {
+		verb = "would change"
+	}
+	if c.Points == 0 && c.Bytes == 0 {
+		return fmt.Sprintf("%s %s: nothing to do", c.Op, c.Path)
+	}
+	if c.Points == 0 {
+		return fmt.Sprintf("%s %s: %s %d bytes", c.Op, c.Path, verb, c.Bytes)
+	}
+	return fmt.Sprintf("%s %s: %s %d points from %d until %d (%d bytes)",
+		c.Op, c.Path, verb, c.Points, c.From, c.Until, c.Bytes)
+}
+
+// Delete replaces the values at timestamps from through until inclusive
+// with nulls.  The journal does not change size.
+func (ts *FileJournal) Delete(from, until int64, dryRun bool) (Change, error) {
+	if err := ts.begin(!dryRun); err != nil {
+		return Change{}, err
+	}
+	defer ts.end()
+
+	c := Change{Op: "delete", Path: ts.fd.Name(), DryRun: dryRun}
+	first, n := ts.span(from, until)
+	if n == 0 {
+		return c, nil
+	}
+	width := int64(ts.header.Width)
+	c.From = ts.header.Epoch + first*ts.header.Interval
+	c.Until = c.From + (n-1)*ts.header.Interval
+	c.Points = n
+	c.Bytes = n * width
+	if dryRun {
+		return c, nil
+	}
+	sums, err := ts.sums()
+	if err != nil {
+		return c, err
+	}
+	if err := ts.markDirty(); err != nil {
+		return c, err
+	}
+
+	for done := int64(0); done < n; done += editChunk {
+		count := n - done
+		if count > editChunk {
+			count = editChunk
+		}
+		buf := bytes.Repeat(ts.factory.Null(), int(count))
+		if _, err := ts.fd.WriteAt(buf, ts.base+(first+done)*width); err != nil {
+			return c, err
+		}
+	}
+	if sums != nil {
+		if err := sums.refresh(ts, first, n); err != nil {
+			return c, err
+		}
+	}
+	if last := ts.ext.Summary.Last; ts.header.Flags&FlagSummary != 0 && last >= c.From && last <= c.Until {
+		if err := ts.rebuildSummary(); err != nil {
+			return c, err
+		}
+	}
+	ts.gen++
+	return c, ts.touch()
+}
+
+// Trim discards the values older than the given timestamp, moving the
+// journal's epoch forward.  The journal is rewritten to a new file which
+// atomically replaces the original, except that page aligned journals
+// on file systems that can punch holes keep their file: a hole is punched
+// over the discarded values and the header records how many there are,
+// so that trimming a huge journal does not copy the values it keeps.
+// Such journals become version 3, which older versions of this package
+// cannot read.
+func (ts *FileJournal) Trim(before int64, dryRun bool) (Change, error) {
+	if err := ts.begin(!dryRun); err != nil {
+		return Change{}, err
+	}
+	defer ts.end()
+
+	c := Change{Op: "trim", Path: ts.fd.Name(), DryRun: dryRun}
+	if ts.header.Epoch == 0 || before <= ts.header.Epoch {
+		return c, nil
+	}
+	_, drop := ts.span(ts.header.Epoch, before-1)
+	width := int64(ts.header.Width)
+	c.From = ts.header.Epoch
+	c.Until = c.From + (drop-1)*ts.header.Interval
+	c.Points = drop
+	c.Bytes = drop * width
+	if dryRun {
+		return c, nil
+	}
+	if ts.Sealed() {
+		return c, ErrSealed
+	}
+	return c, ts.trimFront(drop)
+}
+
+// Rebase discards the run of nulls at the start of a journal, usually
+// left by a bad first write, if it is at least minNulls long, moving the
+// epoch forward to the first value as Trim does.  A journal of nothing
+// but nulls is emptied.
+func (ts *FileJournal) Rebase(minNulls int64, dryRun bool) (Change, error) {
+	if err := ts.begin(!dryRun); err != nil {
+		return Change{}, err
+	}
+	defer ts.end()
+
+	c := Change{Op: "rebase", Path: ts.fd.Name(), DryRun: dryRun}
+	if ts.header.Epoch == 0 {
+		return c, nil
+	}
+	drop, err := ts.leadingNulls()
+	if err != nil || drop == 0 || drop < minNulls {
+		return c, err
+	}
+	c.From = ts.header.Epoch
+	c.Until = c.From + (drop-1)*ts.header.Interval
+	c.Points = drop
+	c.Bytes = drop * int64(ts.header.Width)
+	if dryRun {
+		return c, nil
+	}
+	if ts.Sealed() {
+		return c, ErrSealed
+	}
+	return c, ts.trimFront(drop)
+}
+
+// trimFront discards the first drop values, punching a hole over them in
+// page aligned journals or else rewriting the journal.
+func (ts *FileJournal) trimFront(drop int64) error {
+	defer ts.dropSums()
+	width := int64(ts.header.Width)
+	if ts.ext.Summary.Last < ts.header.Epoch+drop*ts.header.Interval {
+		// Only nulls remain
+		ts.ext.Summary = Summary{}
+	}
+	if drop < ts.points && ts.header.Version >= 2 &&
+		punchHole(ts.fd, ts.base, ts.base+drop*width) == nil {
+		return ts.dropFront(drop)
+	}
+
+	header := ts.header
+	if drop == ts.points {
+		header.Epoch = 0
+	} else {
+		header.Epoch = ts.header.Epoch + drop*ts.header.Interval
+	}
+	err := ts.replace(header, func(dst File) error {
+		return ts.copyValues(dst, drop, ts.points-drop)
+	})
+	if err != nil {
+		return err
+	}
+	ts.points -= drop
+	ts.gen++
+	return nil
+}
+
+// dropFront records that the first n values, over which a hole has been
+// punched, are dropped by moving the epoch past them and counting them in
+// the header, which makes the journal version 3.
+func (ts *FileJournal) dropFront(n int64) error {
+	header := ts.header
+	header.Version = max(header.Version, 3)
+	header.Epoch += n * header.Interval
+	ext := ts.ext
+	ext.Dropped += n
+	buf := new(bytes.Buffer)
+	if err := writeHeader(buf, header, ext); err != nil {
+		return err
+	}
+	if _, err := ts.fd.WriteAt(buf.Bytes()[:summaryOffset+summarySize], 0); err != nil {
+		return err
+	}
+	if ts.opts.Durability != SyncNone {
+		if err := ts.fd.Sync(); err != nil {
+			return err
+		}
+	}
+	ts.header = header
+	ts.ext.Dropped = ext.Dropped
+	ts.base += n * int64(header.Width)
+	ts.points -= n
+	ts.gen++
+	return nil
+}
+
+// TruncateAfter discards the values newer than the given timestamp,
+// shrinking the journal, so that a source can be replayed from that point.
+// Discarding every value leaves the journal as it was before its first
+// write.
+func (ts *FileJournal) TruncateAfter(timestamp int64) error {
+	if err := ts.begin(true); err != nil {
+		return err
+	}
+	defer ts.end()
+
+	if ts.header.Epoch == 0 || timestamp >= ts.Last() {
+		return nil
+	}
+	keep := int64(0)
+	if timestamp >= ts.header.Epoch {
+		keep = (adjust(timestamp, ts.header.Interval)-ts.header.Epoch)/ts.header.Interval + 1
+	}
+	if err := ts.markDirty(); err != nil {
+		return err
+	}
+	ts.dropSums()
+	if err := ts.fd.Truncate(ts.base + keep*int64(ts.header.Width)); err != nil {
+		return err
+	}
+	if keep == 0 {
+		if _, err := ts.fd.WriteAt(make([]byte, 8), HeaderSize-8); err != nil {
+			return err
+		}
+		ts.header.Epoch = 0
+	}
+	ts.points = keep
+	if ts.header.Flags&FlagSummary != 0 && ts.ext.Summary.Last > timestamp {
+		if err := ts.rebuildSummary(); err != nil {
+			return err
+		}
+	}
+	ts.gen++
+	return ts.touch()
+}
+
+// copyValues writes n values starting at index first to w, translating
+// sparse file holes to nulls.
+func (ts *FileJournal) copyValues(w io.Writer, first, n int64) error {
+	width := int64(ts.header.Width)
+	buf := make([]byte, editChunk*width)
+	for done := int64(0); done < n; done += editChunk {
+		count := n - done
+		if count > editChunk {
+			count = editChunk
+		}
+		off := ts.base + (first+done)*width
+		chunk := buf[:count*width]
+		if _, err := ts.fd.ReadAt(chunk, off); err != nil {
+			return err
+		}
+		ts.fillHoles(chunk, off)
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace writes a new journal file with the given header followed by
+// the data written by fill, and atomically renames it over this journal.
+// The journal then refers to the new file.
+func (ts *FileJournal) replace(header FileHeader, fill func(dst File) error) error {
+	if ts.readonly {
+		return fmt.Errorf("Journal is read-only: %s", ts.fd.Name())
+	}
+	path := ts.fd.Name()
+	stat, err := ts.fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	fs := ts.opts.fs()
+	// The journal is locked, so a temporary file left behind was
+	// abandoned by a rewrite that crashed
+	fs.Remove(tmp)
+	dst, err := fs.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	ext := ts.ext
+	ext.Dropped = 0
+	if header.Version >= 1 {
+		ext.Modified = ts.opts.now().UnixNano()
+	}
+	err = ts.opts.acquire(dst, false)
+	if err == nil {
+		err = writeHeader(dst, header, ext)
+	}
+	if err == nil {
+		err = fill(dst)
+	}
+	if err == nil && ts.opts.Durability != SyncNone {
+		err = dst.Sync()
+	}
+	if err == nil {
+		err = fs.Rename(tmp, path)
+	}
+	if err != nil {
+		dst.Close()
+		fs.Remove(tmp)
+		return err
+	}
+
+	renamed, err := renamedFile(fs, dst, path, ts.opts.Durability)
+	if err != nil {
+		return err
+	}
+	ts.fd.Close()
+	ts.fd = renamed
+	ts.header = header
+	ts.ext = ext
+	ts.base = headerSize(header.Version)
+	ts.touched = false
+	return nil
+}
+
+// renamedFile returns dst, which was just renamed to path, as a File
+// whose Name is path.
+func renamedFile(fs FS, dst File, path string, durability Durability) (File, error) {
+	fd, ok := dst.(*os.File)
+	if !ok {
+		dst.Close()
+		return fs.OpenFile(path, os.O_RDWR, 0)
+	}
+	if durability == SyncFull {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+
+	// os.File.Name() must keep reporting the journal's path.  A duplicate
+	// descriptor shares the open file and therefore its lock.
+	dup, err := syscall.Dup(int(fd.Fd()))
+	fd.Close()
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(dup), path), nil
+}
+
+// Repair truncates a partially written value from the end of the journal
+// at path, which makes Open fail with ErrPartial.  The journal must not
+// be open elsewhere.
+func Repair(path string, dryRun bool, opts *Options) (Change, error) {
+	opts = opts.orDefault()
+	c := Change{Op: "repair", Path: path, DryRun: dryRun}
+	fd, err := opts.fs().OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return c, err
+	}
+	defer fd.Close()
+	if err = opts.acquire(fd, false); err != nil {
+		return c, err
+	}
+
+	j := FileJournal{fd: fd, opts: opts}
+	err = j.load(path)
+	if err != ErrPartial {
+		return c, err
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		return c, err
+	}
+	c.Bytes = (stat.Size() - j.base) % int64(j.header.Width)
+	if dryRun {
+		return c, nil
+	}
+	if err = fd.Truncate(stat.Size() - c.Bytes); err != nil {
+		return c, err
+	}
+	return c, opts.sync(fd)
+}
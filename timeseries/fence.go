@@ -0,0 +1,79 @@
+package timeseries
+
+import (
+	"errors"
+	"fmt"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/stats"
+)
+
+// Fence limits how far past the current time values may be written.
+// Without one a writer whose clock runs ahead, such as a skewed agent,
+// extends the journal with a gap of nulls up to its timestamp, growing
+// the file at once.  A Fence shared by several journals counts for all of
+// them.
+type Fence struct {
+	// MaxFuture is the most intervals after the current one, according
+	// to Options.Clock, that a value may be written for.  Zero allows
+	// only the current interval.
+	MaxFuture int64
+
+	// Clamp writes the values up to the limit and discards the rest
+	// rather than failing the whole Write with a *FutureError.
+	Clamp bool
+
+	// Rejected counts the Writes failed and Discarded the values left
+	// out by Clamp.
+	Rejected  stats.Counter
+	Discarded stats.Counter
+}
+
+// FutureError is returned by Write for values past the limit of the
+// journal's Fence.  Nothing is written.
+type FutureError struct {
+	Path      string
+	Timestamp int64 // of the first value past the limit
+	Limit     int64 // the last timestamp that may be written
+}
+
+func (e *FutureError) Error() string {
+	return fmt.Sprintf("Time stamp %d is %d seconds past the future limit %d: %s",
+		e.Timestamp, e.Timestamp-e.Limit, e.Limit, e.Path)
+}
+
+// IsFuture reports whether err is, or wraps, a *FutureError.
+func IsFuture(err error) bool {
+	var future *FutureError
+	return errors.As(err, &future)
+}
+
+// fence applies Options.Fence to values about to be written at timestamp
+// and returns the values to write, or nil if Clamp discarded them all.
+func (ts *FileJournal) fence(timestamp int64, values Values) (Values, error) {
+	f := ts.opts.Fence
+	if f == nil || values.Len() == 0 {
+		return values, nil
+	}
+	interval := ts.header.Interval
+	limit := adjust(ts.opts.now().Unix(), interval) + f.MaxFuture*interval
+	last := timestamp + int64(values.Len()-1)*interval
+	if last <= limit {
+		return values, nil
+	}
+	if !f.Clamp {
+		f.Rejected.Add(1)
+		return nil, &FutureError{Path: ts.fd.Name(), Timestamp: max(timestamp, limit+interval), Limit: limit}
+	}
+	keep := 0
+	if timestamp <= limit {
+		keep = int((limit-timestamp)/interval) + 1
+	}
+	f.Discarded.Add(int64(values.Len() - keep))
+	if keep == 0 {
+		return nil, nil
+	}
+	return values.Slice(0, keep), nil
+}
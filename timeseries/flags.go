@@ -0,0 +1,119 @@
+package timeseries
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Flags record the state of a journal in its header.
+type Flags int64
+
+const (
+	// FlagDirty is set before the first write after a Sync and cleared
+	// by Sync.  A journal opened with it set was not synced after its
+	// last write and may have lost data in a crash.
+	FlagDirty Flags = 1 << iota
+
+	// FlagSealed marks a journal that accepts no more writes.
+	FlagSealed
+
+	// FlagCompressed marks a journal whose values are compressed and
+	// which cannot be read by this version.
+	FlagCompressed
+
+	// FlagSummary marks a page aligned journal that keeps a Summary of
+	// its values in its header.
+	FlagSummary
+
+	knownFlags = FlagDirty | FlagSealed | FlagCompressed | FlagSummary
+)
+
+// flagsOffset is the position of the flags in the on disk header of
+// version 1 and later journals.  Version 0 journals keep HeaderExt.LegacyMeta
+// there instead and have no flags.
+const flagsOffset = HeaderSize - 16
+
+var (
+	// ErrSealed is returned by operations that would modify a sealed
+	// journal.
+	ErrSealed = errors.New("Journal is sealed")
+
+	// ErrCompressed is returned by Open for compressed journals.
+	ErrCompressed = errors.New("Journal is compressed")
+)
+
+func (f Flags) String() string {
+	var names []string
+	for _, flag := range []struct {
+		f    Flags
+		name string
+	}{{FlagDirty, "dirty"}, {FlagSealed, "sealed"}, {FlagCompressed, "compressed"}, {FlagSummary, "summary"}} {
+		if f&flag.f != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	if unknown := f &^ knownFlags; unknown != 0 {
+		names = append(names, fmt.Sprintf("%#x", int64(unknown)))
+	}
+	return strings.Join(names, ",")
+}
+
+// Flags returns the flags stored in the journal's header.
+func (ts *FileJournal) Flags() Flags {
+	return ts.header.Flags
+}
+
+// Dirty reports whether the journal has been written since it was last
+// synced, possibly by a process that crashed.
+func (ts *FileJournal) Dirty() bool {
+	return ts.header.Flags&FlagDirty != 0
+}
+
+// Sealed reports whether the journal accepts no more writes.
+func (ts *FileJournal) Sealed() bool {
+	return ts.header.Flags&FlagSealed != 0
+}
+
+// Compressed reports whether the journal's values are compressed.
+func (ts *FileJournal) Compressed() bool {
+	return ts.header.Flags&FlagCompressed != 0
+}
+
+// checkFlags rejects journals with flags this version cannot honor.
+func (ts *FileJournal) checkFlags(path string) error {
+	if unknown := ts.header.Flags &^ knownFlags; unknown != 0 {
+		return fmt.Errorf("Unsupported journal flags %s: %s", unknown, path)
+	}
+	if ts.Compressed() {
+		return ErrCompressed
+	}
+	return nil
+}
+
+// markDirty is called before modifying the journal's values.  It fails
+// for sealed journals and otherwise sets the Dirty flag if needed.
+func (ts *FileJournal) markDirty() error {
+	if ts.Sealed() {
+		return ErrSealed
+	}
+	if ts.Dirty() || ts.header.Version < 1 {
+		return nil
+	}
+	return ts.setFlags(ts.header.Flags | FlagDirty)
+}
+
+// setFlags writes new flags to the header.  Version 0 journals cannot
+// keep flags.
+func (ts *FileJournal) setFlags(flags Flags) error {
+	if ts.header.Version < 1 {
+		return fmt.Errorf("Version 0 journals have no flags: %s", ts.fd.Name())
+	}
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(flags))
+	if _, err := ts.fd.WriteAt(buf, flagsOffset); err != nil {
+		return err
+	}
+	ts.header.Flags = flags
+	return nil
+}
@@ -0,0 +1,93 @@
+package timeseries
+
+import (
+	"io"
+	"os"
+)
+
+import (
+	"github.com/jjneely/journal/lock"
+)
+
+// File is an open journal file.  *os.File implements it.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+	Chmod(mode os.FileMode) error
+}
+
+// FS is the file system that journals are kept in, as chosen by
+// Options.FS.  Errors should be those of package os, such as
+// os.ErrNotExist, so that callers can test them with os.IsNotExist.
+//
+// Only files that are *os.File are locked, advised, and checked for
+// sparse holes, as other file systems are not shared with other
+// processes.
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// OSFS is the operating system's file system, used when Options.FS is
+// nil.
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fd, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// A nil *os.File would make a non-nil File
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// release unlocks fd if it is an operating system file.
+func release(fd File) error {
+	if f, ok := fd.(*os.File); ok {
+		return lock.Release(f)
+	}
+	return nil
+}
+
+// sameFile reports whether a and b describe the same file, as
+// os.SameFile does for files of the operating system.  Other file
+// systems are expected to return the same Sys value for a file.  The
+// FileInfo of a compressed journal is unwrapped.
+func sameFile(a, b os.FileInfo) bool {
+	if u, ok := a.(interface{ Unwrap() os.FileInfo }); ok {
+		a = u.Unwrap()
+	}
+	if os.SameFile(a, b) {
+		return true
+	}
+	sys := a.Sys()
+	return sys != nil && sys == b.Sys()
+}
@@ -0,0 +1,171 @@
+package timeseries
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// IndexExt is appended to the path of a journal to name its block index.
+const IndexExt = ".idx"
+
+// indexMagic begins every block index file.
+var indexMagic = [4]byte{0x42, 0x4A, 0x54, 0x49} // "BJTI"
+
+// ErrIndexOrder is returned by BlockIndex.Add for blocks that do not
+// follow the last one.
+var ErrIndexOrder = errors.New("Blocks must be added in time and file order")
+
+// Block locates one block of values in a journal file.
+type Block struct {
+	Timestamp int64 // of the first value in the block
+	Offset    int64 // of the block in the file
+}
+
+// BlockIndex maps time to file offsets for journals whose values are not
+// of a fixed width, such as compressed journals, which cannot compute the
+// position of a timestamp.  Values are stored in blocks and the index
+// records where each block starts, so that a read decodes only the blocks
+// it needs after a binary search.
+type BlockIndex struct {
+	Blocks []Block
+}
+
+// Add records a block that follows every block already in the index.
+func (x *BlockIndex) Add(timestamp, offset int64) error {
+	if n := len(x.Blocks); n > 0 {
+		last := x.Blocks[n-1]
+		if timestamp <= last.Timestamp || offset <= last.Offset {
+			return ErrIndexOrder
+		}
+	}
+	x.Blocks = append(x.Blocks, Block{timestamp, offset})
+	return nil
+}
+
+// Find returns the position of the block holding timestamp: the last
+// block starting at or before it.  It returns -1 if timestamp is before
+// the first block.
+func (x *BlockIndex) Find(timestamp int64) int {
+	return sort.Search(len(x.Blocks), func(i int) bool {
+		return x.Blocks[i].Timestamp > timestamp
+	}) - 1
+}
+
+// Range returns the positions of the first and last blocks holding
+// values from through until inclusive.  first > last if there are none.
+func (x *BlockIndex) Range(from, until int64) (first, last int) {
+	first = max(x.Find(from), 0)
+	last = x.Find(until)
+	if until < from {
+		last = first - 1
+	}
+	return first, last
+}
+
+// Extent returns the byte range [start, end) of the block at i in a
+// file of the given size.
+func (x *BlockIndex) Extent(i int, size int64) (int64, int64) {
+	if i+1 < len(x.Blocks) {
+		return x.Blocks[i].Offset, x.Blocks[i+1].Offset
+	}
+	return x.Blocks[i].Offset, size
+}
+
+// WriteTo encodes the index to w: a magic number, the block count, the
+// blocks, and a CRC-32 of everything before it, all little endian.
+func (x *BlockIndex) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, 0, 12+16*len(x.Blocks)+4)
+	buf = append(buf, indexMagic[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(x.Blocks)))
+	for _, b := range x.Blocks {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(b.Timestamp))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(b.Offset))
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// ReadIndex decodes an index written by BlockIndex.WriteTo.
+func ReadIndex(r io.Reader) (*BlockIndex, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, corruptIndex(err)
+	}
+	if [4]byte(head[:4]) != indexMagic {
+		return nil, errors.New("Not a journal block index")
+	}
+	count := binary.LittleEndian.Uint64(head[4:])
+	if count > 1<<26 {
+		return nil, corruptIndex(fmt.Errorf("%d blocks", count))
+	}
+	body := make([]byte, 16*count+4)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, corruptIndex(err)
+	}
+	sum := crc32.Update(crc32.ChecksumIEEE(head), crc32.IEEETable, body[:16*count])
+	if sum != binary.LittleEndian.Uint32(body[16*count:]) {
+		return nil, corruptIndex(errors.New("checksum mismatch"))
+	}
+
+	x := &BlockIndex{Blocks: make([]Block, count)}
+	for i := range x.Blocks {
+		x.Blocks[i].Timestamp = int64(binary.LittleEndian.Uint64(body[16*i:]))
+		x.Blocks[i].Offset = int64(binary.LittleEndian.Uint64(body[16*i+8:]))
+	}
+	return x, nil
+}
+
+// LoadIndex reads the block index of the journal at path.
+func LoadIndex(path string) (*BlockIndex, error) {
+	fd, err := os.Open(path + IndexExt)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return ReadIndex(fd)
+}
+
+// Save atomically replaces the block index of the journal at path.
+func (x *BlockIndex) Save(path string, opts *Options) error {
+	opts = opts.orDefault()
+	path += IndexExt
+	tmp := path + ".tmp"
+	fd, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, opts.fileMode())
+	if err != nil {
+		return err
+	}
+	_, err = x.WriteTo(fd)
+	if err == nil && opts.Durability != SyncNone {
+		err = fd.Sync()
+	}
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if opts.Durability == SyncFull {
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+func corruptIndex(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("Corrupt block index: %s", err)
+}
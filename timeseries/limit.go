@@ -0,0 +1,76 @@
+package timeseries
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReadLimitError is returned by Read and ReadRange when a read would
+// return more than Options.MaxReadPoints values.
+type ReadLimitError struct {
+	Path   string
+	Points int64 // values the read would have returned
+	Limit  int64
+}
+
+func (e *ReadLimitError) Error() string {
+	return fmt.Sprintf("Read of %d points exceeds the limit of %d: %s",
+		e.Points, e.Limit, e.Path)
+}
+
+// IsReadLimit reports whether err is, or wraps, a *ReadLimitError.
+func IsReadLimit(err error) bool {
+	var limit *ReadLimitError
+	return errors.As(err, &limit)
+}
+
+// checkReadLimit fails if reading n values starting at timestamp, which
+// is not before the epoch, would return more than MaxReadPoints values.
+func (ts *FileJournal) checkReadLimit(timestamp int64, n int) error {
+	limit := ts.opts.MaxReadPoints
+	if limit <= 0 || int64(n) <= limit || ts.header.Epoch == 0 {
+		return nil
+	}
+	points := ts.points - offset(ts, timestamp)/int64(ts.header.Width)
+	if points > int64(n) {
+		points = int64(n)
+	}
+	if points <= limit {
+		return nil
+	}
+	return &ReadLimitError{Path: ts.fd.Name(), Points: points, Limit: limit}
+}
+
+// GapError is returned by Write when the values would start more than
+// Options.MaxGap intervals after the last value, leaving a gap of that
+// many nulls.  Nothing is written.
+type GapError struct {
+	Path  string
+	Gap   int64 // null values the write would have filled
+	Limit int64
+}
+
+func (e *GapError) Error() string {
+	return fmt.Sprintf("Write leaves a gap of %d points, more than the limit of %d: %s",
+		e.Gap, e.Limit, e.Path)
+}
+
+// IsGap reports whether err is, or wraps, a *GapError.
+func IsGap(err error) bool {
+	var gap *GapError
+	return errors.As(err, &gap)
+}
+
+// checkGap fails if writing at timestamp, which is not before the epoch,
+// would fill more than MaxGap nulls.
+func (ts *FileJournal) checkGap(timestamp int64) error {
+	limit := ts.opts.MaxGap
+	if limit <= 0 || ts.header.Epoch == 0 {
+		return nil
+	}
+	gap := (timestamp-ts.header.Epoch)/ts.header.Interval - ts.points
+	if gap <= limit {
+		return nil
+	}
+	return &GapError{Path: ts.fd.Name(), Gap: gap, Limit: limit}
+}
@@ -0,0 +1,74 @@
+package timeseries
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+import (
+	"github.com/jjneely/journal/lock"
+)
+
+// LockStatus describes a locked journal found by Locks.
+type LockStatus struct {
+	Path    string
+	Holders []lock.Holder // empty when the holders cannot be discovered
+}
+
+// Locks walks the directory tree rooted at dir and returns the status of
+// every timeseries journal that is currently locked by another file
+// descriptor.  Files that are not journals are ignored.
+func Locks(dir string) ([]LockStatus, error) {
+	var locked []LockStatus
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !isJournal(path) {
+			return nil
+		}
+
+		ok, err := lock.IsLocked(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		holders, err := lock.Holders(path)
+		if err != nil {
+			return err
+		}
+		locked = append(locked, LockStatus{Path: path, Holders: holders})
+		return nil
+	})
+
+	return locked, err
+}
+
+// ForceUnlock breaks any locks held on the journal at path.  See lock.Break
+// for the consequences to processes still holding the journal open.
+func ForceUnlock(path string) error {
+	if !isJournal(path) {
+		return fmt.Errorf("Not a journal timeseries: %s", path)
+	}
+	return lock.Break(path)
+}
+
+// isJournal reports whether the file at path begins with the journal
+// magic number.  No locks are taken.
+func isJournal(path string) bool {
+	fd, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+
+	var magic [4]byte
+	if _, err := fd.ReadAt(magic[:], 0); err != nil {
+		return false
+	}
+	return magic == Magic
+}
@@ -0,0 +1,64 @@
+package timeseries
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// checkReadNull verifies that the ReadNull option is one value of the
+// journal's width.
+func (o *Options) checkReadNull(factory ValueType) error {
+	if o.ReadNull == nil {
+		return nil
+	}
+	if o.ReadNull.Len() != 1 || len(o.ReadNull.Encode()) != int(factory.Width()) {
+		return fmt.Errorf("ReadNull must be one value of the journal's type")
+	}
+	return nil
+}
+
+// remapNulls replaces encoded null values in buf with the ReadNull
+// option.
+func (ts *FileJournal) remapNulls(buf []byte) {
+	if ts.opts.ReadNull == nil {
+		return
+	}
+	null := ts.factory.Null()
+	replacement := ts.opts.ReadNull.Encode()
+	width := len(null)
+	for i := 0; i+width <= len(buf); i += width {
+		if bytes.Equal(buf[i:i+width], null) {
+			copy(buf[i:], replacement)
+		}
+	}
+}
+
+// ReadFloat64 returns the values for the timestamps from through until
+// inclusive of a numeric journal as float64s with nulls replaced by the
+// given value, which may be NaN.  This lets int64 journals be exposed
+// through float APIs without their math.MinInt64 sentinel.
+func (ts *FileJournal) ReadFloat64(from, until int64, null float64) ([]float64, error) {
+	values, err := ts.ReadRange(from, until)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := convertValues(values, nil, NewFloat64ValueType(), RoundNearest)
+	if err != nil {
+		return nil, err
+	}
+
+	floats := []float64(converted.(Float64Values))
+	if !math.IsNaN(null) {
+		for i := range floats {
+			if math.IsNaN(floats[i]) {
+				floats[i] = null
+			}
+		}
+	}
+	return floats, nil
+}
@@ -0,0 +1,149 @@
+package timeseries
+
+import (
+	"bytes"
+)
+
+// Nulls returns the number of null values stored in the journal.  Values
+// are compared in their encoded form so nothing is decoded.
+func (ts *FileJournal) Nulls() (int64, error) {
+	if err := ts.begin(false); err != nil {
+		return 0, err
+	}
+	defer ts.end()
+
+	nulls := int64(0)
+	err := ts.scanNulls(0, ts.points, func(int64) { nulls++ })
+	return nulls, err
+}
+
+// Range is the span of timestamps from From through Until inclusive.
+type Range struct {
+	From, Until int64
+}
+
+// Gaps returns the runs of missing values for the timestamps from through
+// until inclusive, in time order.  Values are missing if they are null or
+// are after the last value in the journal.  Timestamps before the epoch of
+// a journal with data cannot be written and are never reported.  As with
+// Nulls nothing is decoded.
+func (ts *FileJournal) Gaps(from, until int64) ([]Range, error) {
+	if err := ts.begin(false); err != nil {
+		return nil, err
+	}
+	defer ts.end()
+
+	interval := ts.header.Interval
+	from = adjust(from, interval)
+	until = adjust(until, interval)
+	if epoch := ts.header.Epoch; epoch != 0 && from < epoch {
+		from = epoch
+	}
+	if until < from {
+		return nil, nil
+	}
+	if ts.header.Epoch == 0 || ts.points == 0 {
+		return []Range{{from, until}}, nil
+	}
+
+	var gaps []Range
+	add := func(start, end int64) {
+		if n := len(gaps); n > 0 && gaps[n-1].Until+interval == start {
+			gaps[n-1].Until = end
+		} else {
+			gaps = append(gaps, Range{start, end})
+		}
+	}
+	first, n := ts.span(from, until)
+	err := ts.scanNulls(first, n, func(i int64) {
+		t := ts.header.Epoch + i*interval
+		add(t, t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if next := ts.Last() + interval; until >= next {
+		if next < from {
+			next = from
+		}
+		add(next, until)
+	}
+	return gaps, nil
+}
+
+// scanNulls calls fn with the index of each null among the n values
+// starting at index first.
+func (ts *FileJournal) scanNulls(first, n int64, fn func(i int64)) error {
+	null := ts.factory.Null()
+	return ts.scan(first, n, func(i int64, value []byte) {
+		if bytes.Equal(value, null) {
+			fn(i)
+		}
+	})
+}
+
+// leadingNulls returns the number of null values before the first value
+// that is not null, reading only as far as that value.
+func (ts *FileJournal) leadingNulls() (int64, error) {
+	width := int64(ts.header.Width)
+	null := ts.factory.Null()
+	n := ts.points
+	err := ts.scanChunks(0, ts.points, func(first int64, chunk []byte) bool {
+		for i := int64(0); i < int64(len(chunk))/width; i++ {
+			if !bytes.Equal(chunk[i*width:(i+1)*width], null) {
+				n = first + i
+				return false
+			}
+		}
+		return true
+	})
+	return n, err
+}
+
+// scan calls fn with the index and encoded form of each of the n values
+// starting at index first.  The value is only valid during the call.
+func (ts *FileJournal) scan(first, n int64, fn func(i int64, value []byte)) error {
+	width := int64(ts.header.Width)
+	return ts.scanChunks(first, n, func(start int64, chunk []byte) bool {
+		for i := int64(0); i < int64(len(chunk))/width; i++ {
+			fn(start+i, chunk[i*width:(i+1)*width])
+		}
+		return true
+	})
+}
+
+// scanChunks calls fn with the index of the first value and the encoded
+// form of each chunk of up to editChunk of the n values starting at index
+// first, until fn returns false.  The chunk is only valid during the call.
+func (ts *FileJournal) scanChunks(first, n int64, fn func(first int64, chunk []byte) bool) error {
+	width := int64(ts.header.Width)
+	buf := make([]byte, min(n, editChunk)*width)
+	fadvise(ts.fd, ts.base+first*width, n*width, Sequential)
+
+	for done := int64(0); done < n; done += editChunk {
+		count := min(n-done, editChunk)
+		off := ts.base + (first+done)*width
+		chunk := buf[:count*width]
+		if _, err := ts.fd.ReadAt(chunk, off); err != nil {
+			return err
+		}
+		ts.fillHoles(chunk, off)
+		if !fn(first+done, chunk) {
+			break
+		}
+	}
+	return nil
+}
+
+// Points returns the number of values, including nulls, stored in the
+// journal.
+func (ts *FileJournal) Points() int64 {
+	ts.fresh()
+	return ts.points
+}
+
+// Size returns the size of the journal file: its header and values.
+func (ts *FileJournal) Size() int64 {
+	ts.fresh()
+	return ts.base + ts.points*int64(ts.header.Width)
+}
@@ -0,0 +1,260 @@
+package timeseries
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/lock"
+)
+
+// ErrLocked is returned by Open and Create when the journal's file lock
+// is held by another file descriptor and could not be obtained within
+// Options.LockTimeout.
+var ErrLocked = errors.New("Journal is locked by another process")
+
+// lockRetry is how often a contended lock is retried.
+const lockRetry = 10 * time.Millisecond
+
+// Options tune how journals are opened and created.  A nil *Options is
+// equivalent to DefaultOptions.
+type Options struct {
+	// LockTimeout is how long to keep retrying a contended file lock
+	// before returning ErrLocked.  Zero fails immediately.  A negative
+	// value blocks until the lock is obtained.
+	LockTimeout time.Duration
+
+	// ReadOnly makes Open use a read-only file descriptor and a shared
+	// lock even when the journal is writable.
+	ReadOnly bool
+
+	// Overwrite allows Create to truncate an existing journal.  By default
+	// Create fails if the file already exists.
+	Overwrite bool
+
+	// FileMode and DirMode are the permissions used for new journal files
+	// and for any directories Create makes to hold them.  As with any
+	// file creation the process umask is applied.  Zero values use
+	// DefaultFileMode and DefaultDirMode.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// Durability controls which fsync calls are made when journal files
+	// are created or replaced.  The zero value is SyncFull.
+	Durability Durability
+
+	// Sparse makes large gap writes leave a hole in the file rather than
+	// writing every null value.  This requires a file system that
+	// supports sparse files and SEEK_HOLE.  Reads always translate holes
+	// to null values.
+	Sparse bool
+
+	// PageAligned makes Create write version 2 journals, whose header is
+	// padded to PageSize so that values start on a page boundary and,
+	// for types whose width is a power of two, no value straddles a
+	// page.  This suits O_DIRECT and mmap access.  Open reads every
+	// version regardless.
+	PageAligned bool
+
+	// Summarize makes Create keep a Summary of the values of numeric
+	// journals in their header, which makes them page aligned.
+	Summarize bool
+
+	// BlockSummaries keeps the minimum, maximum, sum, and count of every
+	// SummaryBlock values of numeric journals in a file beside each, named
+	// with BlockSummaryExt, for RangeSummary to read rather than the
+	// values.  Every writer of a journal must use the same setting.  The
+	// file is rebuilt when it does not match its journal, and is not kept
+	// for Cooperative journals.
+	BlockSummaries bool
+
+	// Cooperative holds the file lock only for the duration of each
+	// operation rather than for the life of the open journal, and
+	// re-reads the header and size before every operation.  This lets
+	// several processes, such as cron jobs, append to the same journal.
+	// LockTimeout then bounds how long each operation waits for the lock.
+	Cooperative bool
+
+	// ReadNull, if set, is a single value of the journal's type that
+	// Read and ReadRange return in place of the type's null sentinel, for
+	// consumers that cannot represent it, such as Int64Values{0} instead
+	// of math.MinInt64.  Streamed ranges are not remapped.
+	ReadNull Values
+
+	// SealReadOnly makes Seal remove the write permissions of the
+	// journal's file as well as setting its Sealed flag.
+	SealReadOnly bool
+
+	// MaxReadPoints, if positive, is the most values a single Read or
+	// ReadRange may return.  Larger reads fail with a *ReadLimitError
+	// before any memory is allocated for them.
+	MaxReadPoints int64
+
+	// MaxGap, if positive, is the most null values a Write may fill
+	// between the last value and its own, protecting against the file
+	// growth a bad time stamp causes.  Larger gaps fail with a *GapError.
+	MaxGap int64
+
+	// CarryForward makes writes that leave a gap after the last value
+	// fill it with copies of that value rather than nulls, for series
+	// that record a state which holds until it changes.  Such gaps are
+	// never left as sparse file holes.
+	CarryForward bool
+
+	// Validation, if set, checks the values given to Write.
+	Validation *Validation
+
+	// Fence, if set, rejects or clamps writes of values too far past the
+	// current time.
+	Fence *Fence
+
+	// Clock, if set, supplies the creation and modification times
+	// recorded in journals in place of the system clock.
+	Clock clock.Clock
+
+	// FS, if set, is the file system journals are opened, created, and
+	// rewritten in, such as a MemFS for tests.  Nil is the operating
+	// system's.  Functions that take only a path, like the lock tools,
+	// always use the operating system's.
+	FS FS
+
+	// BlockCache, if set, caches the decompressed blocks of journals
+	// compressed by CompressJournal in place of DefaultBlockCache.
+	BlockCache *BlockCache
+}
+
+// Durability selects how hard Create and file replacing operations work
+// to ensure their results survive a crash.
+type Durability int
+
+const (
+	// SyncFull fsyncs the file and then its parent directory so that the
+	// directory entry for a new or renamed file is also on disk.
+	SyncFull Durability = iota
+
+	// SyncFile fsyncs only the file contents.
+	SyncFile
+
+	// SyncNone leaves flushing entirely to the operating system.
+	SyncNone
+)
+
+const (
+	DefaultFileMode os.FileMode = 0644
+	DefaultDirMode  os.FileMode = 0755
+)
+
+// DefaultOptions are the options used by Open and Create.  Their zero
+// LockTimeout fails at once on a locked journal, so that tools such as
+// the scrubber skip journals held open by a daemon instead of blocking.
+var DefaultOptions = Options{
+	LockTimeout: 0,
+	FileMode:    DefaultFileMode,
+	DirMode:     DefaultDirMode,
+}
+
+func (o *Options) orDefault() *Options {
+	if o == nil {
+		return &DefaultOptions
+	}
+	return o
+}
+
+func (o *Options) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return DefaultFileMode
+	}
+	return o.FileMode
+}
+
+func (o *Options) dirMode() os.FileMode {
+	if o.DirMode == 0 {
+		return DefaultDirMode
+	}
+	return o.DirMode
+}
+
+// now returns the current time according to the Clock option.
+func (o *Options) now() time.Time {
+	return clock.Or(o.Clock).Now()
+}
+
+// fs returns the FS journals are kept in.
+func (o *Options) fs() FS {
+	if o.FS == nil {
+		return OSFS
+	}
+	return o.FS
+}
+
+// blockCache returns the cache of decompressed blocks.
+func (o *Options) blockCache() *BlockCache {
+	if o.BlockCache == nil {
+		return DefaultBlockCache
+	}
+	return o.BlockCache
+}
+
+// sync flushes fd and, with SyncFull, the directory containing it
+// according to the Durability option.
+func (o *Options) sync(fd File) error {
+	if o.Durability == SyncNone {
+		return nil
+	}
+	if err := fd.Sync(); err != nil {
+		return err
+	}
+	if _, ok := fd.(*os.File); ok && o.Durability == SyncFull {
+		return syncDir(filepath.Dir(fd.Name()))
+	}
+	return nil
+}
+
+// syncDir fsyncs a directory so that recently created or renamed entries
+// are durable.
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
+
+// acquire locks the file, shared if readonly, otherwise exclusively,
+// according to the LockTimeout option.  Files not of the operating system
+// are not locked.
+func (o *Options) acquire(file File, readonly bool) error {
+	fd, ok := file.(*os.File)
+	if !ok {
+		return nil
+	}
+	if o.LockTimeout < 0 {
+		if readonly {
+			return lock.Share(fd)
+		}
+		return lock.Exclusive(fd)
+	}
+
+	deadline := time.Now().Add(o.LockTimeout)
+	for {
+		var err error
+		if readonly {
+			err = lock.TryShare(fd)
+		} else {
+			err = lock.TryExclusive(fd)
+		}
+		if !lock.IsResourceUnavailable(err) {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(lockRetry)
+	}
+}
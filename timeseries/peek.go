@@ -0,0 +1,69 @@
+package timeseries
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Peek reads the 64 byte header of the journal at path without locking
+// it or reading further, for tools that scan many journals and must not
+// wait on, or hold up, the processes writing them.  The header is
+// checked as Open checks it but may be read while a write changes it.
+func Peek(path string) (FileHeader, error) {
+	header, _, err := PeekExt(path)
+	return header, err
+}
+
+// PeekExt is Peek that also reads the HeaderExt of version 1 and later
+// journals, which is needed to find the values of version 3 journals and
+// holds the Summary of journals that keep one.
+func PeekExt(path string) (FileHeader, HeaderExt, error) {
+	var header FileHeader
+	var ext HeaderExt
+	fd, err := os.Open(path)
+	if err != nil {
+		return header, ext, err
+	}
+	defer fd.Close()
+
+	buf := make([]byte, summaryOffset+summarySize)
+	n, err := io.ReadFull(fd, buf)
+	if n < HeaderSize {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return header, ext, fmt.Errorf("Not a journal timeseries: %s", path)
+		}
+		return header, ext, err
+	}
+	if _, err = binary.Decode(buf, binary.LittleEndian, &header); err != nil {
+		return header, ext, err
+	}
+	switch {
+	case header.Magic != Magic:
+		return header, ext, fmt.Errorf("Not a journal timeseries: %s", path)
+	case header.Version < 0 || header.Version > Version:
+		return header, ext, fmt.Errorf("Unsupported journal version %d: %s", header.Version, path)
+	case header.Width <= 0 || header.Interval <= 0:
+		return header, ext, fmt.Errorf("Corrupt journal header: %s", path)
+	}
+	if int64(n) < min(header.DataOffset(), summaryOffset+summarySize) {
+		return header, ext, fmt.Errorf("Corrupt journal header: %s", path)
+	}
+	return readHeader(bytes.NewReader(buf[:n]), path)
+}
+
+// DataOffset returns the size of the header of a journal with this
+// header, after which its values are stored.
+func (h FileHeader) DataOffset() int64 {
+	return headerSize(h.Version)
+}
+
+// ValuesOffset returns the offset of the first value in a journal with
+// this header and extension, so that the number of values is the file
+// size less ValuesOffset divided by Width.  It is DataOffset unless Trim
+// dropped values in place.
+func (h FileHeader) ValuesOffset(ext HeaderExt) int64 {
+	return h.DataOffset() + ext.Dropped*int64(h.Width)
+}
@@ -0,0 +1,47 @@
+package timeseries
+
+import (
+	"fmt"
+	"os"
+)
+
+// Seal makes the journal immutable: its data is synced and the Sealed
+// flag is set, after which writes fail with ErrSealed.  Because a sealed
+// journal cannot change, Open does not keep it locked and readers share
+// it freely.  With Options.SealReadOnly the file's write permissions are
+// also removed.
+func (ts *FileJournal) Seal() error {
+	if ts.Sealed() {
+		return nil
+	}
+	if ts.readonly {
+		return fmt.Errorf("Journal is read-only: %s", ts.fd.Name())
+	}
+	if err := ts.begin(true); err != nil {
+		return err
+	}
+	defer ts.end()
+
+	err := ts.fd.Sync()
+	if err == nil {
+		err = ts.setFlags(ts.header.Flags&^FlagDirty | FlagSealed)
+	}
+	if err == nil {
+		err = ts.opts.sync(ts.fd)
+	}
+	if err == nil && ts.opts.SealReadOnly {
+		var stat os.FileInfo
+		if stat, err = ts.fd.Stat(); err == nil {
+			err = ts.fd.Chmod(stat.Mode().Perm() &^ 0222)
+		}
+	}
+	return err
+}
+
+// unlockSealed releases the lock on a sealed journal, which is no longer
+// needed because the journal cannot change.
+func (ts *FileJournal) unlockSealed() {
+	if ts.Sealed() {
+		release(ts.fd)
+	}
+}
@@ -0,0 +1,105 @@
+package timeseries
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Snapshot is a journal as it was when the Snapshot was taken: its header
+// and the values it then held.  Values appended later are not part of the
+// Snapshot, so Snapshots of several journals taken while all of them are
+// held open describe the same instant.  Values overwritten in place after
+// the Snapshot is taken may still be seen, and values that Trim drops in
+// place read as nulls.
+type Snapshot struct {
+	fd     File
+	header []byte
+	size   int64 // end of the values in the file
+	shift  int64 // offset of the values in fd less that in the copy
+	null   []byte
+}
+
+// Snapshot records the journal's header and length.  The file stays open
+// so that the Snapshot is unaffected if the journal is replaced or
+// removed.  The Snapshot must be closed.
+func (ts *FileJournal) Snapshot() (*Snapshot, error) {
+	if err := ts.begin(false); err != nil {
+		return nil, err
+	}
+	defer ts.end()
+
+	var fd File
+	var err error
+	if _, ok := ts.fd.(*compressedFile); ok {
+		fd, err = ts.opts.openCompressedFile(ts.fd.Name())
+	} else {
+		fd, err = ts.opts.fs().OpenFile(ts.fd.Name(), os.O_RDONLY, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The values are complete so the copy is not dirty, and values
+	// dropped in place are left out
+	header := ts.header
+	header.Flags &^= FlagDirty
+	ext := ts.ext
+	ext.Dropped = 0
+	buf := new(bytes.Buffer)
+	if err = writeHeader(buf, header, ext); err != nil {
+		fd.Close()
+		return nil, err
+	}
+	return &Snapshot{
+		fd:     fd,
+		header: buf.Bytes(),
+		size:   int64(buf.Len()) + ts.points*int64(ts.header.Width),
+		shift:  ts.base - int64(buf.Len()),
+		null:   ts.factory.Null(),
+	}, nil
+}
+
+// Size returns the size of the journal file the Snapshot writes.
+func (s *Snapshot) Size() int64 {
+	return s.size
+}
+
+// WriteTo writes the journal file as of the Snapshot to w.  Holes left by
+// sparse writes are written as nulls.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(s, 0, s.size))
+}
+
+// ReadAt reads the journal file as of the Snapshot, as WriteTo writes it.
+func (s *Snapshot) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	var short error
+	if int64(len(p)) > s.size-off {
+		p, short = p[:s.size-off], io.EOF
+	}
+	n := 0
+	if base := int64(len(s.header)); off < base {
+		n = copy(p, s.header[off:])
+		off = base
+	}
+	if n < len(p) {
+		// Read whole records so that holes can be nulled
+		base, width := int64(len(s.header)), int64(len(s.null))
+		start := base + (off-base)/width*width
+		end := off + int64(len(p)-n)
+		buf := make([]byte, base+(end-base+width-1)/width*width-start)
+		if m, err := s.fd.ReadAt(buf, start+s.shift); err != nil && !(err == io.EOF && start+int64(m) >= end) {
+			return n, err
+		}
+		nullHoles(s.fd, s.null, buf, start+s.shift)
+		n += copy(p[n:], buf[off-start:])
+	}
+	return n, short
+}
+
+// Close releases the Snapshot's file.
+func (s *Snapshot) Close() error {
+	return s.fd.Close()
+}
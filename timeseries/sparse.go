@@ -0,0 +1,72 @@
+package timeseries
+
+import (
+	"bytes"
+	"errors"
+)
+
+// PageSize is the granularity at which sparse gap writes leave holes.
+const PageSize = 4096
+
+// errNoPunch is returned by punchHole where holes cannot be punched.
+var errNoPunch = errors.New("Hole punching is not supported")
+
+// writeHole prepares a sparse gap write that will begin with the point at
+// index seekPoint.  Nulls are written from the current end of data up to
+// the start of the hole, which is left between page boundaries that are
+// also record boundaries so that no page holds both a hole and a record,
+// or part of one, that fillHoles would not see.  The index of the first
+// point that the caller must still fill with nulls is returned.  If the
+// gap is too small to leave a hole the current number of points is
+// returned and nothing is written.
+func (ts *FileJournal) writeHole(seekPoint int64) (int64, error) {
+	width := int64(ts.header.Width)
+	start := ts.base + ts.points*width
+	end := ts.base + seekPoint*width
+
+	// Round inward to page boundaries between records
+	holeStart := (start + PageSize - 1) / PageSize * PageSize
+	for holeStart < end && (holeStart-ts.base)%width != 0 {
+		holeStart += PageSize
+	}
+	holeEnd := end / PageSize * PageSize
+	for holeEnd > holeStart && (holeEnd-ts.base)%width != 0 {
+		holeEnd -= PageSize
+	}
+	head := (holeStart - ts.base) / width
+	tail := (holeEnd - ts.base) / width
+	if head >= tail {
+		return ts.points, nil
+	}
+
+	buffer := bytes.Repeat(ts.factory.Null(), int(head-ts.points))
+	if _, err := ts.fd.WriteAt(buffer, start); err != nil {
+		return 0, err
+	}
+	return tail, nil
+}
+
+// fillHoles overwrites every record in buf, which was read from the file
+// at offset off, that lies in a hole with the null value.  Holes read
+// back as zeros which is only correct for types whose null value is zero.
+func (ts *FileJournal) fillHoles(buf []byte, off int64) {
+	nullHoles(ts.fd, ts.factory.Null(), buf, off)
+}
+
+// nullHoles is fillHoles for the file fd holding records of the given
+// null value.
+func nullHoles(fd File, null, buf []byte, off int64) {
+	if bytes.Count(null, []byte{0}) == len(null) {
+		return
+	}
+
+	width := int64(len(null))
+	for _, h := range holes(fd, off, off+int64(len(buf))) {
+		// Only whole records inside the hole
+		first := (h[0] - off + width - 1) / width
+		last := (h[1] - off) / width
+		for i := first; i < last; i++ {
+			copy(buf[i*width:(i+1)*width], null)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package timeseries
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3 // SEEK_DATA
+	seekHole = 4 // SEEK_HOLE
+
+	fallocKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// punchHole deallocates the bytes of fd from start to end, which then read
+// as zeros, without changing the size of the file.  It fails for files
+// not of the operating system and file systems that cannot punch holes.
+func punchHole(file File, start, end int64) error {
+	fd, ok := file.(*os.File)
+	if !ok {
+		return errNoPunch
+	}
+	return syscall.Fallocate(int(fd.Fd()), fallocPunchHole|fallocKeepSize, start, end-start)
+}
+
+// holes returns the [start, end) byte ranges of fd between the given
+// offsets that are holes in a sparse file.  File systems that do not
+// support SEEK_HOLE, and files not of the operating system, report no
+// holes.
+func holes(file File, start, end int64) [][2]int64 {
+	fd, ok := file.(*os.File)
+	if !ok {
+		return nil
+	}
+	var h [][2]int64
+	pos := start
+	for pos < end {
+		data, err := syscall.Seek(int(fd.Fd()), pos, seekData)
+		if err == syscall.ENXIO {
+			// No more data before EOF
+			data = end
+		} else if err != nil {
+			return h
+		}
+		if data > end {
+			data = end
+		}
+		if data > pos {
+			h = append(h, [2]int64{pos, data})
+		}
+		if data >= end {
+			break
+		}
+
+		pos, err = syscall.Seek(int(fd.Fd()), data, seekHole)
+		if err != nil {
+			return h
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package timeseries
+
+// holes only detects sparse file holes on Linux.
+func holes(fd File, start, end int64) [][2]int64 {
+	return nil
+}
+
+// punchHole is only supported on Linux.
+func punchHole(fd File, start, end int64) error {
+	return errNoPunch
+}
@@ -0,0 +1,168 @@
+package timeseries
+
+import (
+	"fmt"
+	"io"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// WriteRangeTo writes the encoded values for the timestamps from through
+// until inclusive to w without decoding them, translating sparse holes
+// to nulls.  It returns the timestamp of the first value and the number
+// of values written.  The output can be read back with the journal's
+// ValueType.DecodeStream or WriteStream.
+func (ts *FileJournal) WriteRangeTo(w io.Writer, from, until int64) (int64, int64, error) {
+	if err := ts.begin(false); err != nil {
+		return 0, 0, err
+	}
+	defer ts.end()
+
+	first, n := ts.span(from, until)
+	if n == 0 {
+		return 0, 0, nil
+	}
+	return ts.header.Epoch + first*ts.header.Interval, n, ts.copyValues(w, first, n)
+}
+
+// WriteStream decodes n values from r and writes them starting at the
+// given timestamp, one chunk at a time so that the whole stream is never
+// held in memory.
+func (ts *FileJournal) WriteStream(timestamp int64, r io.Reader, n int64) error {
+	for n > 0 {
+		count := n
+		if count > editChunk {
+			count = editChunk
+		}
+		values, err := ts.factory.DecodeStream(r, int(count))
+		if values != nil && values.Len() > 0 {
+			if werr := ts.Write(timestamp, values); werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		timestamp = adjust(timestamp, ts.header.Interval) + count*ts.header.Interval
+		n -= count
+	}
+	return nil
+}
+
+// WriteRaw writes values encoded as the journal's ValueType encodes them,
+// such as those read with RawReader, starting at the given timestamp
+// without decoding them.  Validation is not applied to raw values.
+func (ts *FileJournal) WriteRaw(timestamp int64, data []byte) error {
+	width := ts.Width()
+	if len(data)%int(width) != 0 {
+		return fmt.Errorf("Raw values of %d bytes are not a multiple of %d bytes", len(data), width)
+	}
+	return ts.Write(timestamp, NewByteValueType(width, nil).Decode(data))
+}
+
+// RangeReader reads the encoded values of a range of a journal as
+// WriteRangeTo writes them.  It is also an io.Seeker over the bytes of
+// the range.
+type RangeReader struct {
+	ts    *FileJournal
+	start int64 // timestamp of the first value
+	size  int64 // bytes in the range
+	pos   int64 // offset of the next byte read
+	buf   []byte
+	err   error
+}
+
+// RawReader returns a reader of the encoded values for the timestamps
+// from through until inclusive, translating sparse holes to nulls, so that
+// they can be piped to converters, compressors, or replicas without being
+// decoded.  The range is clamped to the journal when RawReader is called
+// and each Read locks the journal as other reads do.  Reads fail if the
+// journal no longer holds the whole range.
+func (ts *FileJournal) RawReader(from, until int64) *RangeReader {
+	r := &RangeReader{ts: ts}
+	if r.err = ts.begin(false); r.err != nil {
+		return r
+	}
+	defer ts.end()
+
+	first, n := ts.span(from, until)
+	if n > 0 {
+		r.start = ts.header.Epoch + first*ts.header.Interval
+		r.size = n * int64(ts.header.Width)
+	}
+	return r
+}
+
+// Start returns the timestamp of the first value, or 0 if the range is
+// empty.
+func (r *RangeReader) Start() int64 {
+	return r.start
+}
+
+// Size returns the number of bytes in the range.
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+// Read reads up to len(p) bytes of the range, at most editChunk values at
+// a time.
+func (r *RangeReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	ts := r.ts
+	if err := ts.begin(false); err != nil {
+		return 0, err
+	}
+	defer ts.end()
+
+	width := int64(ts.header.Width)
+	first := (r.start - ts.header.Epoch) / ts.header.Interval
+	if ts.header.Epoch == 0 || first < 0 || first*width+r.size > ts.points*width {
+		return 0, fmt.Errorf("Journal no longer holds the values from %d: %s",
+			r.start, ts.fd.Name())
+	}
+
+	// Read whole values so that holes can be filled
+	index := r.pos / width
+	end := min(r.pos+int64(len(p)), r.size)
+	count := min((end+width-1)/width-index, editChunk)
+	if int64(cap(r.buf)) < count*width {
+		r.buf = make([]byte, count*width)
+	}
+	buf := r.buf[:count*width]
+	off := ts.base + (first+index)*width
+	if _, err := ts.fd.ReadAt(buf, off); err != nil {
+		return 0, err
+	}
+	ts.fillHoles(buf, off)
+	n := copy(p, buf[r.pos-index*width:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset of the next Read within the range.
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.pos, fmt.Errorf("Invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return r.pos, fmt.Errorf("Negative offset: %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
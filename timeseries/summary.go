@@ -0,0 +1,200 @@
+package timeseries
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// summaryOffset is the position of HeaderExt.Summary in the padding of the
+// on disk header of page aligned journals.
+const summaryOffset = droppedOffset + 8
+
+// summarySize is the size of the on disk Summary.
+const summarySize = 32
+
+// Summary describes the non-null values of a numeric journal.  Journals
+// with FlagSummary keep it in their header, updated by every Write, so
+// that questions like whether any value exceeds a threshold can often be
+// answered without reading values.
+//
+// Min and Max bound the values but may be looser than them after values
+// are overwritten or trimmed.  RebuildSummary makes them exact.
+type Summary struct {
+	Min, Max  float64
+	Last      int64 // time stamp of the newest non-null value, 0 if none
+	LastValue float64
+}
+
+// Empty reports whether the journal holds no non-null values.
+func (s Summary) Empty() bool {
+	return s.Last == 0
+}
+
+// add includes values written at timestamp, nulls being NaN, in s.
+func (s *Summary) add(timestamp, interval int64, floats []float64) {
+	for i, x := range floats {
+		if math.IsNaN(x) {
+			continue
+		}
+		if s.Empty() {
+			s.Min, s.Max = x, x
+		} else {
+			s.Min, s.Max = min(s.Min, x), max(s.Max, x)
+		}
+		if t := timestamp + int64(i)*interval; t >= s.Last {
+			s.Last, s.LastValue = t, x
+		}
+	}
+}
+
+func appendSummary(buf []byte, s Summary) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.Min))
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.Max))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(s.Last))
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.LastValue))
+}
+
+func decodeSummary(buf []byte) Summary {
+	return Summary{
+		Min:       math.Float64frombits(binary.LittleEndian.Uint64(buf)),
+		Max:       math.Float64frombits(binary.LittleEndian.Uint64(buf[8:])),
+		Last:      int64(binary.LittleEndian.Uint64(buf[16:])),
+		LastValue: math.Float64frombits(binary.LittleEndian.Uint64(buf[24:])),
+	}
+}
+
+// summarizable reports whether a Summary can be kept for journals of the
+// given type in a header of the given version.
+func summarizable(factory ValueType, version int32) bool {
+	switch factory.(type) {
+	case *Float64ValueType, *Float32ValueType, *Int64ValueType:
+		return version >= 2
+	}
+	return false
+}
+
+// toFloats converts numeric values to float64s with nulls as NaN.
+func toFloats(values Values) ([]float64, error) {
+	converted, err := convertValues(values, nil, NewFloat64ValueType(), RoundNearest)
+	if err != nil {
+		return nil, err
+	}
+	return []float64(converted.(Float64Values)), nil
+}
+
+// Summary returns the summary of the journal's values and whether the
+// journal keeps one.
+func (ts *FileJournal) Summary() (Summary, bool) {
+	ts.fresh()
+	return ts.ext.Summary, ts.header.Flags&FlagSummary != 0
+}
+
+// RebuildSummary reads every value to compute an exact Summary and starts
+// keeping it in the header if the journal did not already.  Only page
+// aligned journals of numbers can keep a Summary, and once they do they
+// cannot be opened by versions of this package that predate it.
+func (ts *FileJournal) RebuildSummary() error {
+	if err := ts.begin(true); err != nil {
+		return err
+	}
+	defer ts.end()
+
+	if ts.Sealed() {
+		return ErrSealed
+	}
+	if !summarizable(ts.factory, ts.header.Version) {
+		return fmt.Errorf("Journal cannot keep a summary: %s", ts.fd.Name())
+	}
+	if err := ts.rebuildSummary(); err != nil {
+		return err
+	}
+	if err := ts.saveSummary(); err != nil {
+		return err
+	}
+	if ts.header.Flags&FlagSummary != 0 {
+		return nil
+	}
+	return ts.setFlags(ts.header.Flags | FlagSummary)
+}
+
+// rebuildSummary recomputes the Summary from the values in the journal.
+// The caller records it in the file.
+func (ts *FileJournal) rebuildSummary() error {
+	var s Summary
+	var convertErr error
+	err := ts.scanChunks(0, ts.points, func(first int64, chunk []byte) bool {
+		floats, err := toFloats(ts.factory.Decode(chunk))
+		if err != nil {
+			convertErr = err
+			return false
+		}
+		s.add(ts.header.Epoch+first*ts.header.Interval, ts.header.Interval, floats)
+		return true
+	})
+	if err == nil {
+		err = convertErr
+	}
+	if err != nil {
+		return err
+	}
+	ts.ext.Summary = s
+	return nil
+}
+
+// saveSummary writes the Summary to the header, which must be page
+// aligned.
+func (ts *FileJournal) saveSummary() error {
+	_, err := ts.fd.WriteAt(appendSummary(nil, ts.ext.Summary), summaryOffset)
+	return err
+}
+
+// LastValue returns the timestamp and value of the newest non-null value
+// of a numeric journal, or a zero timestamp if it holds none.  Journals
+// that keep a Summary answer from their header and others are read back
+// from their end.
+func (ts *FileJournal) LastValue() (int64, float64, error) {
+	if err := ts.begin(false); err != nil {
+		return 0, 0, err
+	}
+	defer ts.end()
+
+	if !summarizable(ts.factory, 2) {
+		return 0, 0, fmt.Errorf("Journal is not numeric: %s", ts.fd.Name())
+	}
+	if ts.header.Flags&FlagSummary != 0 {
+		return ts.ext.Summary.Last, ts.ext.Summary.LastValue, nil
+	}
+	for end := ts.points; end > 0; end -= editChunk {
+		first := max(end-editChunk, 0)
+		index, value := int64(-1), 0.0
+		var convertErr error
+		err := ts.scanChunks(first, end-first, func(first int64, chunk []byte) bool {
+			floats, err := toFloats(ts.factory.Decode(chunk))
+			if err != nil {
+				convertErr = err
+				return false
+			}
+			for i, x := range floats {
+				if !math.IsNaN(x) {
+					index, value = first+int64(i), x
+				}
+			}
+			return true
+		})
+		if err == nil {
+			err = convertErr
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if index >= 0 {
+			return ts.header.Epoch + index*ts.header.Interval, value, nil
+		}
+	}
+	return 0, 0, nil
+}
@@ -0,0 +1,190 @@
+package timeseries
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HeaderSizeV1 is the size of the version 1 header: the 64 byte version 0
+// header followed by a HeaderExt.
+const HeaderSizeV1 = HeaderSize + 16
+
+// HeaderSizeV2 is the size of the version 2 header: a version 1 header
+// padded with zeros to PageSize so that values start on a page boundary.
+// Version 3 headers are the same size.
+const HeaderSizeV2 = PageSize
+
+// droppedOffset is the position of HeaderExt.Dropped in the on disk
+// header of version 3 journals.
+const droppedOffset = HeaderSizeV1
+
+// modifiedOffset is the position of HeaderExt.Modified in the on disk
+// header.
+const modifiedOffset = HeaderSize + 8
+
+// HeaderExt follows the FileHeader in version 1 journals.  The times are
+// wall clock Unix nanoseconds kept in the file itself so that they survive
+// copies and backups that disturb filesystem timestamps.
+//
+// Version 3 journals follow the times with Dropped, the number of values
+// at the front of the file that Trim discarded by punching a hole rather
+// than rewriting the file.  The value at the epoch is stored that many
+// values past the end of the header.  Page aligned journals with
+// FlagSummary follow Dropped, which is zero in version 2, with a Summary.
+//
+// Version 0 journals have no HeaderExt on disk, and their header holds a
+// fourth metadata value where later versions keep Flags.  It is read into
+// LegacyMeta so that their Flags are zero and is written back in its
+// place.
+type HeaderExt struct {
+	Created    int64 // when the journal was created
+	Modified   int64 // when values were last written
+	Dropped    int64 // values trimmed from the front of the file in place
+	Summary    Summary
+	LegacyMeta int64 // fourth metadata value of version 0 journals
+}
+
+// headerSize returns the number of bytes of the header of a journal of
+// the given version, which are followed by its values.
+func headerSize(version int32) int64 {
+	if version >= 2 {
+		return HeaderSizeV2
+	}
+	if version >= 1 {
+		return HeaderSizeV1
+	}
+	return HeaderSize
+}
+
+// readHeader decodes a header of any supported version from r.
+func readHeader(r io.Reader, path string) (FileHeader, HeaderExt, error) {
+	var header FileHeader
+	var ext HeaderExt
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return header, ext, err
+	}
+	if header.Magic != Magic {
+		return header, ext, fmt.Errorf("Not a journal timeseries: %s", path)
+	}
+	if header.Version < 0 || header.Version > Version {
+		return header, ext, fmt.Errorf("Unsupported journal version %d: %s",
+			header.Version, path)
+	}
+	if header.Version < 1 {
+		ext.LegacyMeta, header.Flags = int64(header.Flags), 0
+	}
+	if header.Version >= 1 {
+		var times [2]int64
+		if err := binary.Read(r, binary.LittleEndian, &times); err != nil {
+			return header, ext, err
+		}
+		ext.Created, ext.Modified = times[0], times[1]
+	}
+	if header.Version >= 3 {
+		if err := binary.Read(r, binary.LittleEndian, &ext.Dropped); err != nil {
+			return header, ext, err
+		}
+		if ext.Dropped < 0 {
+			return header, ext, fmt.Errorf("Corrupt journal header: %s", path)
+		}
+	}
+	if header.Version >= 2 && header.Flags&FlagSummary != 0 {
+		buf := make([]byte, summaryOffset-droppedOffset+summarySize)
+		if header.Version >= 3 {
+			buf = buf[8:]
+		}
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return header, ext, err
+		}
+		ext.Summary = decodeSummary(buf[len(buf)-summarySize:])
+	}
+	return header, ext, nil
+}
+
+// writeHeader encodes a header and, for version 1 and later, its
+// extension and padding to w.
+func writeHeader(w io.Writer, header FileHeader, ext HeaderExt) error {
+	if header.Version < 1 {
+		header.Flags = Flags(ext.LegacyMeta)
+	}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	_, err := w.Write(appendExt(nil, header.Version, ext))
+	return err
+}
+
+// appendExt appends the on disk form of the header extension and
+// padding, if the given version has them, to buf.
+func appendExt(buf []byte, version int32, ext HeaderExt) []byte {
+	if version < 1 {
+		return buf
+	}
+	start := len(buf)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(ext.Created))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(ext.Modified))
+	if version >= 2 {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(ext.Dropped))
+		buf = appendSummary(buf, ext.Summary)
+	}
+	return append(buf, make([]byte, headerSize(version)-HeaderSize-int64(len(buf)-start))...)
+}
+
+// Created returns when the journal was created.  It is the zero Time for
+// version 0 journals, which do not record it.
+func (ts *FileJournal) Created() time.Time {
+	return unixNano(ts.ext.Created)
+}
+
+// Modified returns when values were last written to the journal.  Writes
+// made through this FileJournal are reflected immediately but are only
+// recorded in the file by Sync and Close.  It is the zero Time for
+// version 0 journals, which do not record it.
+func (ts *FileJournal) Modified() time.Time {
+	ts.fresh()
+	return unixNano(ts.ext.Modified)
+}
+
+func unixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// touch records that values were just modified.  Cooperative journals
+// reload the header before every operation so the time is written to the
+// file immediately; others defer it to saveModified.
+func (ts *FileJournal) touch() error {
+	if ts.header.Version < 1 {
+		return nil
+	}
+	ts.ext.Modified = ts.opts.now().UnixNano()
+	ts.touched = true
+	if ts.opts.Cooperative {
+		return ts.saveModified()
+	}
+	return nil
+}
+
+// saveModified writes the last modification time, and the Summary of
+// journals that keep one, to the header if they changed since they were
+// last written.
+func (ts *FileJournal) saveModified() error {
+	if !ts.touched {
+		return nil
+	}
+	buf := binary.LittleEndian.AppendUint64(nil, uint64(ts.ext.Modified))
+	if _, err := ts.fd.WriteAt(buf, modifiedOffset); err != nil {
+		return err
+	}
+	if ts.header.Flags&FlagSummary != 0 {
+		if err := ts.saveSummary(); err != nil {
+			return err
+		}
+	}
+	ts.touched = false
+	return nil
+}
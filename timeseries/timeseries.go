@@ -9,7 +9,6 @@ import (
 
 import (
 	. "github.com/jjneely/journal"
-	"github.com/jjneely/journal/lock"
 )
 
 type Journal interface {
@@ -28,7 +27,9 @@ type Journal interface {
 
 	// Meta returns the optional values stored in the header as int64
 	// types.  This can be used to represent user specific metadata.
-	// The on disk file format supports 3 int64s.
+	// The on disk file format supports 3 int64s.  The fourth header int64
+	// that once held metadata holds Flags since version 1, and is
+	// FileJournal.LegacyMeta in version 0 journals.
 	Meta() []int64
 
 	// Read locates the first value at the given Unix timestamp in the journal
@@ -38,6 +39,10 @@ type Journal interface {
 	// with length of Width() * # values.
 	Read(timestamp int64, n int) (Values, error)
 
+	// ReadRange returns the values for the timestamps from through until
+	// inclusive, limited to the data stored in the journal.
+	ReadRange(from, until int64) (Values, error)
+
 	// Write seeks to the given Unix timestamp and writes the contents
 	// of the given []byte slice to the journal, extending the file length
 	// on disk if needed.  Multiple values may be written by providing
@@ -56,8 +61,11 @@ type Journal interface {
 }
 
 const (
-	Version    int32 = 0
-	MaxMeta          = 4
+	// Version is the newest journal format this package reads.  Create
+	// writes version 1 journals, or version 2 with Options.PageAligned,
+	// which Trim makes version 3 when it drops values in place.
+	Version    int32 = 3
+	MaxMeta          = 3
 	HeaderSize       = 64
 )
 
@@ -68,10 +76,17 @@ var (
 // FileJournal is a struct that represents an on disk timeseries journal.
 type FileJournal struct {
 	header   FileHeader
-	fd       *os.File
+	ext      HeaderExt
+	base     int64 // offset of the first value in the file
+	fd       File
 	readonly bool
 	points   int64
 	factory  ValueType
+	opts     *Options
+	stale    int32  // set by a Watcher when the file changes
+	touched  bool   // ext.Modified has not been written to the file
+	gen      uint64 // see Generation
+	blocks   *blockSums
 }
 
 // FileHeader represents the header information stored at the front of
@@ -82,34 +97,60 @@ type FileHeader struct {
 	Type     int32    // type code: 4 bytes
 	Width    int32    // width: 4 bytes
 	Interval int64    // interval: 8 bytes
-	Meta     [4]int64 // meta: 4 x 8 bytes
+	Meta     [3]int64 // meta: 3 x 8 bytes
+	Flags    Flags    // flags: 8 bytes, the fourth meta value in version 0
 	Epoch    int64    // epoch is last in the header, and 8 bytes
 
 	// If epoch is 0, there is no data in the file.
 	// The on disk header is 64 bytes and is designed to be constant
-	// hence no length.  This is data format version 0.
+	// hence no length.  This is data format version 0.  Version 1 adds
+	// a HeaderExt after it and version 2 pads that to HeaderSizeV2.
 }
 
 // Open finds the time series journal referenced by the given path, opens
 // the file and returns a FileJournal struct and any possible error.  Try to
 // open the underlying file read/write.  If that fails, open the file
-// read-only which means Write() calls will return an error.
+// read-only which means Write() calls will return an error.  If there is
+// no file at path but one compressed by CompressJournal, with a codec's
+// extension appended, it is opened read-only instead, as is a path with
+// a codec's extension.
+//
+// Open does not wait for a journal locked by another file descriptor, as
+// it once did, but fails at once with ErrLocked.  Use OpenWithOptions with
+// a LockTimeout to wait for the lock, or a negative one to block.
 func Open(path string) (*FileJournal, error) {
-	readonly := false
-	fd, err := os.OpenFile(path, os.O_RDWR, 0666)
-	if os.IsPermission(err) {
-		fd, err = os.Open(path)
+	return OpenWithOptions(path, nil)
+}
+
+// OpenWithOptions is Open with the behavior tuned by the given Options.
+// A nil opts uses DefaultOptions.
+func OpenWithOptions(path string, opts *Options) (*FileJournal, error) {
+	opts = opts.orDefault()
+	readonly := opts.ReadOnly
+	var fd File
+	var err error
+	if CompressedExt(path) != "" {
+		fd, err = opts.openCompressedFile(path)
 		readonly = true
+	} else {
+		if !readonly {
+			fd, err = opts.fs().OpenFile(path, os.O_RDWR, 0666)
+		}
+		if readonly || os.IsPermission(err) {
+			fd, err = opts.fs().OpenFile(path, os.O_RDONLY, 0)
+			readonly = true
+		}
+		if os.IsNotExist(err) {
+			if cfd, cerr := openCompressed(path, opts); !os.IsNotExist(cerr) {
+				fd, err, readonly = cfd, cerr, true
+			}
+		}
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	if readonly {
-		err = lock.Share(fd)
-	} else {
-		err = lock.Exclusive(fd)
-	}
+	err = opts.acquire(fd, readonly)
 	if err != nil {
 		fd.Close()
 		return nil, err
@@ -118,33 +159,58 @@ func Open(path string) (*FileJournal, error) {
 	j := FileJournal{}
 	j.fd = fd
 	j.readonly = readonly
+	j.opts = opts
 
-	err = binary.Read(j.fd, binary.LittleEndian, &(j.header))
+	err = j.load(path)
 	if err != nil {
-		// We couldn't fill the header struct -- corrupt file?
+		fd.Close()
 		return nil, err
 	}
+	j.end()
+	j.unlockSealed()
+	return &j, nil
+}
+
+// load reads the header and size of the open journal file.
+func (j *FileJournal) load(path string) error {
+	var err error
+	j.header, j.ext, err = readHeader(j.fd, path)
+	if err != nil {
+		// We couldn't fill the header struct -- corrupt file?
+		return err
+	}
+	j.base = headerSize(j.header.Version) + j.ext.Dropped*int64(j.header.Width)
 
-	if j.header.Magic != Magic {
-		return nil, fmt.Errorf("Not a journal timeseries: %s", path)
+	if j.header.Width <= 0 || j.header.Interval <= 0 {
+		return fmt.Errorf("Corrupt journal header: %s", path)
+	}
+	if err = j.checkFlags(path); err != nil {
+		return err
 	}
 
 	// Type factory
 	j.factory = GetValueType(j.header.Type, j.header.Width)
+	if j.factory.Width() != j.header.Width {
+		return fmt.Errorf("Corrupt journal header, width %d for type %#x: %s",
+			j.header.Width, j.header.Type, path)
+	}
+	if err = j.opts.checkReadNull(j.factory); err != nil {
+		return err
+	}
 
 	// How large are we?
 	stat, err := j.fd.Stat()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if (stat.Size()-HeaderSize)%int64(j.header.Width) != 0 {
-		// XXX: How can we recover from a partial Write()?
-		return nil, fmt.Errorf("Corrupt or partial data!")
+	if (stat.Size()-j.base)%int64(j.header.Width) != 0 {
+		// Repair can recover from a partial Write()
+		return ErrPartial
 	}
 
-	j.points = (stat.Size() - HeaderSize) / int64(j.header.Width)
-	return &j, nil
+	j.points = (stat.Size() - j.base) / int64(j.header.Width)
+	return nil
 }
 
 // Create attempts to create a FileJournal at the given path, creating
@@ -153,13 +219,25 @@ func Open(path string) (*FileJournal, error) {
 // time units between each data point must also be given.  For a time
 // series file that records data points every 60 seconds must have interval
 // set to 60.  The meta parameter is a value defined by the application.
+// Create will not replace an existing file; the error returned in that
+// case satisfies os.IsExist.  Set Options.Overwrite to truncate it instead.
+// Like Open, Create fails with ErrLocked rather than waiting when another
+// file descriptor holds the lock.
 func Create(path string, interval int64, factory ValueType, meta []int64) (*FileJournal, error) {
+	return CreateWithOptions(path, interval, factory, meta, nil)
+}
+
+// CreateWithOptions is Create with the behavior tuned by the given Options.
+// A nil opts uses DefaultOptions.
+func CreateWithOptions(path string, interval int64, factory ValueType, meta []int64, opts *Options) (*FileJournal, error) {
+	opts = opts.orDefault()
+
 	// Create the base directory, if needed
 	dir := filepath.Dir(path)
-	dirInfo, err := os.Stat(dir)
+	dirInfo, err := opts.fs().Stat(dir)
 	if os.IsNotExist(err) {
-		err2 := os.MkdirAll(dir, 0777)
-		if err2 != nil {
+		err = opts.fs().MkdirAll(dir, opts.dirMode())
+		if err != nil {
 			return nil, err
 		}
 	} else if err != nil {
@@ -172,45 +250,93 @@ func Create(path string, interval int64, factory ValueType, meta []int64) (*File
 	if len(meta) > MaxMeta {
 		return nil, fmt.Errorf("Length of metadata slice too long")
 	}
+	if err = opts.checkReadNull(factory); err != nil {
+		return nil, err
+	}
 
-	// Open a file handle -- truncates existing file, lock new file
-	fd, err := os.Create(path)
+	// Open a file handle -- never truncate until we hold the lock
+	flags := os.O_RDWR | os.O_CREATE
+	if !opts.Overwrite {
+		flags |= os.O_EXCL
+	}
+	fd, err := opts.fs().OpenFile(path, flags, opts.fileMode())
 	if err != nil {
 		return nil, err
 	}
-	err = lock.Exclusive(fd)
+	err = opts.acquire(fd, false)
+	if err == nil && opts.Overwrite {
+		err = fd.Truncate(0)
+		opts.fs().Remove(path + BlockSummaryExt)
+	}
 	if err != nil {
 		fd.Close()
 		return nil, err
 	}
 
 	// Allocate and fill in our structs
+	now := opts.now().UnixNano()
+	version := int32(1)
+	if opts.PageAligned || (opts.Summarize && summarizable(factory, 2)) {
+		version = 2
+	}
 	j := FileJournal{
 		header: FileHeader{
 			Magic:    Magic,
-			Version:  Version,
+			Version:  version,
 			Type:     factory.Type(),
 			Width:    factory.Width(),
 			Interval: interval,
 			Epoch:    0,
 		},
+		ext:      HeaderExt{Created: now, Modified: now},
+		base:     headerSize(version),
 		fd:       fd,
 		readonly: false,
 		points:   0,
 		factory:  factory,
+		opts:     opts,
 	}
 	copy(j.header.Meta[:], meta)
+	if opts.Summarize && summarizable(factory, version) {
+		j.header.Flags = FlagSummary
+	}
 
 	// Write out the header
-	err = binary.Write(j.fd, binary.LittleEndian, j.header)
+	err = writeHeader(j.fd, j.header, j.ext)
+	if err == nil {
+		err = opts.sync(j.fd)
+	}
 	if err != nil {
+		j.fd.Close()
 		return nil, err
 	}
-	j.fd.Sync()
+	j.end()
 
 	return &j, nil
 }
 
+// CreateOrOpen opens the journal at path if it exists, otherwise it is
+// created as with CreateWithOptions.  An existing journal must have the
+// same type, width, and interval as requested or an error is returned.
+func CreateOrOpen(path string, interval int64, factory ValueType, meta []int64, opts *Options) (*FileJournal, error) {
+	j, err := CreateWithOptions(path, interval, factory, meta, opts)
+	if !os.IsExist(err) {
+		return j, err
+	}
+
+	j, err = OpenWithOptions(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if j.header.Type != factory.Type() || j.header.Width != factory.Width() ||
+		j.header.Interval != interval {
+		j.Close()
+		return nil, fmt.Errorf("Existing journal does not match: %s", path)
+	}
+
+	return j, nil
+}
+
 func adjust(timestamp, interval int64) int64 {
 	return timestamp - (timestamp % interval)
 }
@@ -225,57 +351,147 @@ func offset(ts *FileJournal, timestamp int64) int64 {
 // on disk if needed.  Multiple values may be written by providing
 // them in the given byte slice.  They must be for sequential timestamps.
 func (ts *FileJournal) Write(timestamp int64, values Values) error {
+	if err := ts.begin(true); err != nil {
+		return err
+	}
+	defer ts.end()
+	return ts.write(timestamp, values)
+}
+
+// write is Write for callers that have already called begin.
+func (ts *FileJournal) write(timestamp int64, values Values) error {
 	var err error
 	timestamp = adjust(timestamp, ts.header.Interval)
+	if ts.header.Epoch != 0 && timestamp < ts.header.Epoch {
+		return fmt.Errorf("Time stamp is before journal epoch")
+	}
+	if values, err = ts.fence(timestamp, values); err != nil || values == nil {
+		// Clamped to nothing
+		return err
+	}
+	if err = ts.checkGap(timestamp); err != nil {
+		return err
+	}
+	if values, err = ts.validate(timestamp, values); err != nil {
+		return err
+	}
+	sums, err := ts.sums()
+	if err != nil {
+		return err
+	}
+	var floats []float64
+	if ts.header.Flags&FlagSummary != 0 || sums != nil {
+		if floats, err = toFloats(values); err != nil {
+			return err
+		}
+	}
+	summary := ts.ext.Summary
+	if ts.header.Flags&FlagSummary != 0 {
+		summary.add(timestamp, ts.header.Interval, floats)
+	}
+	if err = ts.markDirty(); err != nil {
+		return err
+	}
 	seekPoint := (timestamp - ts.header.Epoch) / ts.header.Interval
 	addedPoints := int64(values.Len())
-	buffer := make([]byte, 0)
+	pooled := writeBuffers.Get().(*[]byte)
+	buffer := (*pooled)[:0]
+	defer func() {
+		if cap(buffer) <= maxPooledBuffer {
+			*pooled = buffer[:0]
+			writeBuffers.Put(pooled)
+		}
+	}()
 	seek := int64(0)
 
 	if ts.header.Epoch == 0 {
-		// First write, we must write the epoch
+		// First write, we must write the epoch and anything between it
+		// and the data
 		seek = HeaderSize - 8
-		buf := make([]byte, 8)
-		binary.LittleEndian.PutUint64(buf, uint64(timestamp))
-		buffer = append(buffer, buf...)
+		ext := ts.ext
+		ext.Summary = summary
+		buffer = binary.LittleEndian.AppendUint64(buffer, uint64(timestamp))
+		buffer = appendExt(buffer, ts.header.Version, ext)
 	} else if seekPoint <= ts.points {
 		// a "normal" write
-		seek = HeaderSize + (seekPoint * int64(ts.header.Width))
+		seek = ts.base + (seekPoint * int64(ts.header.Width))
 		if addedPoints < ts.points-seekPoint {
 			addedPoints = 0
 		} else {
 			addedPoints = addedPoints - (ts.points - seekPoint)
 		}
-	} else if seekPoint > ts.points {
+	} else {
 		// a "gap" write
 		gapPoints := seekPoint - ts.points
-		for i := int64(0); i < gapPoints; i++ {
-			buffer = append(buffer, ts.factory.Null()...)
+		fill := ts.points
+		filler := ts.factory.Null()
+		if ts.opts.CarryForward && ts.points > 0 {
+			if filler, err = ts.lastRecord(); err != nil {
+				return err
+			}
+		} else if ts.opts.Sparse {
+			fill, err = ts.writeHole(seekPoint)
+			if err != nil {
+				return err
+			}
+		}
+		for i := fill; i < seekPoint; i++ {
+			buffer = append(buffer, filler...)
 		}
 		addedPoints = addedPoints + gapPoints
-		seek = HeaderSize + (ts.points * int64(ts.header.Width))
-	} else {
-		// XXX: Timestamp is before journal epoch
-		return fmt.Errorf("Time stamp is before journal epoch")
+		seek = ts.base + (fill * int64(ts.header.Width))
 	}
 
 	// Make one Write() call
-	buffer = append(buffer, values.Encode()...)
+	start := len(buffer)
+	buffer = values.EncodeTo(buffer)
+	if len(buffer)-start != values.Len()*int(ts.header.Width) {
+		return fmt.Errorf("%T values are not %d bytes wide", values, ts.header.Width)
+	}
 	_, err = ts.fd.WriteAt(buffer, seek) // XXX: Deal with partial writes
 	if err != nil {
 		return err
 	}
+	ts.ext.Summary = summary
+	if err = ts.touch(); err != nil {
+		return err
+	}
 
 	// Book keeping
+	before := ts.points
 	ts.points = ts.points + addedPoints
 	if ts.header.Epoch == 0 {
 		ts.header.Epoch = timestamp
 	}
+	if sums != nil {
+		index := (timestamp - ts.header.Epoch) / ts.header.Interval
+		return sums.wrote(ts, index, before, floats)
+	}
 
 	return nil
 }
 
+// lastRecord returns the encoded last value of the journal.
+func (ts *FileJournal) lastRecord() ([]byte, error) {
+	width := int64(ts.header.Width)
+	off := ts.base + (ts.points-1)*width
+	buf := make([]byte, width)
+	if _, err := ts.fd.ReadAt(buf, off); err != nil {
+		return nil, err
+	}
+	ts.fillHoles(buf, off)
+	return buf, nil
+}
+
 func (ts *FileJournal) Read(timestamp int64, n int) (Values, error) {
+	if err := ts.begin(false); err != nil {
+		return nil, err
+	}
+	defer ts.end()
+	return ts.read(timestamp, n)
+}
+
+func (ts *FileJournal) read(timestamp int64, n int) (Values, error) {
 	// Sanity check out inputs
 	if timestamp < ts.header.Epoch {
 		timestamp = ts.header.Epoch
@@ -284,27 +500,47 @@ func (ts *FileJournal) Read(timestamp int64, n int) (Values, error) {
 	if n > int(ts.points) {
 		n = int(ts.points)
 	}
+	if err := ts.checkReadLimit(timestamp, n); err != nil {
+		return nil, err
+	}
 
 	buf := make([]byte, int64(n)*int64(ts.header.Width))
 	offsetBytes := offset(ts, timestamp) // This adjusts the timestamp
-	n, err := ts.fd.ReadAt(buf, offsetBytes+HeaderSize)
+	n, err := ts.fd.ReadAt(buf, offsetBytes+ts.base)
+	if n > 0 {
+		ts.fillHoles(buf[:n], offsetBytes+ts.base)
+		ts.remapNulls(buf[:n])
+	}
 	return ts.factory.Decode(buf[:n]), err
 }
 
 // Close will close the underlying file.  Future read/write operations will
 // result in an error.  All file locks are released.
 func (ts *FileJournal) Close() {
+	ts.saveModified()
+	if ts.blocks != nil {
+		ts.blocks.fd.Close()
+	}
 	ts.fd.Close()
 }
 
-// Sync will flush file contents to disk.
+// Sync will flush file contents to disk and then clear the Dirty flag.
 func (ts *FileJournal) Sync() {
-	ts.fd.Sync()
+	if ts.saveModified() != nil || ts.fd.Sync() != nil || !ts.Dirty() {
+		return
+	}
+	if ts.blocks != nil && ts.blocks.fd.Sync() != nil {
+		return
+	}
+	if ts.setFlags(ts.header.Flags&^FlagDirty) == nil {
+		ts.fd.Sync()
+	}
 }
 
 // Epoch returns the UNIX time stamp of the first value in this time series
 // journal.  A 0 value indicates the journal contains no data.
 func (ts *FileJournal) Epoch() int64 {
+	ts.fresh()
 	return ts.header.Epoch
 }
 
@@ -314,12 +550,67 @@ func (ts *FileJournal) Meta() []int64 {
 	return ts.header.Meta[:]
 }
 
+// LegacyMeta returns the fourth metadata value of a version 0 journal,
+// which later versions replace with Flags, or zero for later versions.
+func (ts *FileJournal) LegacyMeta() int64 {
+	return ts.ext.LegacyMeta
+}
+
+// metaOffset is the position of the metadata in the on disk header.
+const metaOffset = flagsOffset - 8*MaxMeta
+
+// SetMeta replaces the metadata stored in the file header.  Values not
+// given are set to zero.
+func (ts *FileJournal) SetMeta(meta []int64) error {
+	if len(meta) > MaxMeta {
+		return fmt.Errorf("Length of metadata slice too long")
+	}
+	if err := ts.begin(true); err != nil {
+		return err
+	}
+	defer ts.end()
+	if ts.Sealed() {
+		return ErrSealed
+	}
+
+	var m [MaxMeta]int64
+	copy(m[:], meta)
+	buf := make([]byte, 0, 8*MaxMeta)
+	for _, v := range m {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(v))
+	}
+	if _, err := ts.fd.WriteAt(buf, metaOffset); err != nil {
+		return err
+	}
+	ts.header.Meta = m
+	return nil
+}
+
 // Width returns the width of the data values stored in the time series
 // journal in bytes.  This is specified at creation time.
 func (ts *FileJournal) Width() int32 {
 	return ts.header.Width
 }
 
+// Factory returns the ValueType that encodes and decodes the values
+// stored in the journal.
+func (ts *FileJournal) Factory() ValueType {
+	return ts.factory
+}
+
+// Generation returns a counter that increases whenever values already
+// in the journal may have moved or changed: when they are deleted,
+// trimmed, or truncated, and when Refresh or a reload finds the epoch
+// moved, the file shrunk, or the file replaced by another process.
+// Appending does not change it.  A caller that reads a journal a piece
+// at a time compares generations to know it must start over.
+// Generations belong to this FileJournal and start at zero when it is
+// opened.
+func (ts *FileJournal) Generation() uint64 {
+	ts.fresh()
+	return ts.gen
+}
+
 // Interval returns the time unit interval between data values.  If the
 // time series journal contains data points every 60 seconds then this
 // function returns 60.
@@ -330,5 +621,6 @@ func (ts *FileJournal) Interval() int64 {
 // Last returns the most recent timestamp with a corresponding value in this
 // journal.
 func (ts *FileJournal) Last() int64 {
+	ts.fresh()
 	return ts.header.Epoch + (ts.header.Interval * (ts.points - 1))
 }
@@ -1,17 +1,31 @@
 package timeseries
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
-import . "github.com/jjneely/journal"
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/lock"
+)
 
 func TestFileCreateOpen(t *testing.T) {
-	meta := make([]int64, 4)
+	meta := make([]int64, MaxMeta)
 	fillInt64(meta)
 	null := []byte("NULL    ")
+	os.Remove("/tmp/test.tsj")
 	j, err := Create("/tmp/test.tsj", 60, NewByteValueType(8, null), meta)
 	if err != nil {
 		t.Fatal(err)
@@ -43,16 +57,17 @@ func checkSize(t *testing.T, j *FileJournal) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if stat.Size() != HeaderSize+j.points*int64(j.Width()) {
+	if stat.Size() != j.base+j.points*int64(j.Width()) {
 		t.Errorf("Produced file does not have the right size: %d != %d",
-			stat.Size(), HeaderSize+j.points*int64(j.Width()))
+			stat.Size(), j.base+j.points*int64(j.Width()))
 	}
 }
 
 func TestReadWrite(t *testing.T) {
 	epoch := int64(1449240543)
-	meta := make([]int64, 4)
+	meta := make([]int64, MaxMeta)
 	fillInt64(meta)
+	os.Remove("/tmp/test-readwrite.tsj")
 	j, err := Create("/tmp/test-readwrite.tsj", 60, NewInt64ValueType(), meta)
 	if err != nil {
 		t.Fatalf("Error creating ts journal: %s", err)
@@ -93,7 +108,7 @@ func TestReadWrite(t *testing.T) {
 		t.Fatal(err)
 	}
 	if j.header.Type != 0x11 {
-		t.Errorf("int64 journal did not re-open with the same type: %s", j.header.Type)
+		t.Errorf("int64 journal did not re-open with the same type: %x", j.header.Type)
 	}
 	if j.points != 30 {
 		t.Errorf("Re-open does not see the correct number of data points: %d != %d",
@@ -148,3 +163,2068 @@ func metaEq(a, b []int64) bool {
 
 	return true
 }
+
+func TestLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := Create(filepath.Join(dir, "a/locked.tsj"), 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	ioutil.WriteFile(filepath.Join(dir, "other"), []byte("not a journal"), 0644)
+
+	locked, err := Locks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 1 || locked[0].Path != j.fd.Name() {
+		t.Fatalf("Expected only %s to be locked: %v", j.fd.Name(), locked)
+	}
+
+	err = ForceUnlock(locked[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err = Locks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 0 {
+		t.Errorf("Journals still locked after ForceUnlock: %v", locked)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	os.Remove("/tmp/test-locktimeout.tsj")
+	j, err := Create("/tmp/test-locktimeout.tsj", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open("/tmp/test-locktimeout.tsj")
+	if err != ErrLocked {
+		t.Errorf("Open of a locked journal returned %v instead of ErrLocked", err)
+	}
+
+	opts := &Options{LockTimeout: time.Second}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		j.Close()
+	}()
+	j2, err := OpenWithOptions("/tmp/test-locktimeout.tsj", opts)
+	if err != nil {
+		t.Fatalf("Open did not wait for the lock to be released: %s", err)
+	}
+	j2.Close()
+}
+
+func TestCreateExisting(t *testing.T) {
+	path := "/tmp/test-existing.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(1449240543, Int64Values{1, 2, 3})
+	j.Close()
+
+	_, err = Create(path, 60, NewInt64ValueType(), nil)
+	if !os.IsExist(err) {
+		t.Errorf("Create of an existing journal did not fail: %v", err)
+	}
+
+	j, err = CreateOrOpen(path, 60, NewInt64ValueType(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.points != 3 {
+		t.Errorf("CreateOrOpen lost existing data: %d points", j.points)
+	}
+	j.Close()
+
+	_, err = CreateOrOpen(path, 300, NewInt64ValueType(), nil, nil)
+	if err == nil {
+		t.Errorf("CreateOrOpen accepted a journal with a different interval")
+	}
+
+	j, err = CreateWithOptions(path, 60, NewInt64ValueType(), nil,
+		&Options{Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSize(t, j)
+	if j.points != 0 {
+		t.Errorf("Overwrite did not truncate the journal")
+	}
+	j.Close()
+}
+
+func TestFileModes(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-modes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := syscall.Umask(0022)
+	defer syscall.Umask(old)
+
+	path := filepath.Join(dir, "a/b/modes.tsj")
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	checkMode(t, filepath.Dir(path), os.ModeDir|DefaultDirMode)
+	checkMode(t, path, DefaultFileMode)
+
+	path = filepath.Join(dir, "c/modes.tsj")
+	opts := &Options{FileMode: 0600, DirMode: 0700}
+	j, err = CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	checkMode(t, filepath.Dir(path), os.ModeDir|0700)
+	checkMode(t, path, 0600)
+}
+
+func checkMode(t *testing.T, path string, mode os.FileMode) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode() != mode {
+		t.Errorf("%s has mode %s instead of %s", path, stat.Mode(), mode)
+	}
+}
+
+func TestSparseGap(t *testing.T) {
+	path := "/tmp/test-sparse.tsj"
+	os.Remove(path)
+	opts := &Options{Sparse: true}
+	j, err := CreateWithOptions(path, 1, NewFloat64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	epoch := int64(1449240543)
+	values := Float64Values{1.0, 2.0, 3.0}
+	if err = j.Write(epoch, values); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Write(epoch+100000, values); err != nil {
+		t.Fatal(err)
+	}
+	checkSize(t, j)
+	if j.points != 100003 {
+		t.Fatalf("Sparse journal has %d points instead of 100003", j.points)
+	}
+
+	data, err := j.Read(epoch, int(j.points))
+	if err != nil {
+		t.Fatal(err)
+	}
+	floats := data.(Float64Values)
+	for i, v := range floats {
+		switch {
+		case i < 3 || i >= 100000:
+			if v != values[i%100000] {
+				t.Fatalf("Value %d is %f and should be %f", i, v, values[i%100000])
+			}
+		case !math.IsNaN(v):
+			t.Fatalf("Gap value %d is %f rather than null", i, v)
+		}
+	}
+}
+
+func TestSparseGapWidths(t *testing.T) {
+	path := "/tmp/test-sparse-widths.tsj"
+	for _, width := range []int32{3, 5, 7, 8, 24} {
+		os.Remove(path)
+		null := bytes.Repeat([]byte{0xFF}, int(width))
+		j, err := CreateWithOptions(path, 60, NewByteValueType(width, null), nil, &Options{Sparse: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := ByteValues{bytes.Repeat([]byte{1}, int(width))}
+		j.Write(60, record)
+		j.Write(60+60*5000, record)
+		data, err := j.Read(60, 5001)
+		j.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bad := 0
+		for _, v := range data.(ByteValues)[1:5000] {
+			if !bytes.Equal(v, null) {
+				bad++
+			}
+		}
+		if bad > 0 || data.Len() != 5001 {
+			t.Errorf("Width %d: %d of %d gap records are not null", width, bad, data.Len()-2)
+		}
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	path := "/tmp/test-readrange.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	data, err := j.ReadRange(0, 1<<40)
+	if err != nil || data.Len() != 0 {
+		t.Errorf("ReadRange of an empty journal returned %v, %v", data, err)
+	}
+
+	epoch := int64(1449240540)
+	if err = j.Write(epoch, Int64Values{0, 1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Advise(Sequential); err != nil {
+		t.Errorf("Advise failed: %s", err)
+	}
+
+	tests := []struct {
+		from, until int64
+		values      Int64Values
+	}{
+		{epoch, epoch + 120, Int64Values{0, 1, 2}},
+		{epoch - 600, epoch + 59, Int64Values{0}},
+		{epoch + 250, epoch + 6000, Int64Values{4, 5}},
+		{epoch + 600, epoch + 6000, Int64Values{}},
+		{epoch + 120, epoch, Int64Values{}},
+	}
+	for _, test := range tests {
+		data, err := j.ReadRange(test.from, test.until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !metaEq(data.(Int64Values), test.values) {
+			t.Errorf("ReadRange(%d, %d) = %v, want %v", test.from-epoch,
+				test.until-epoch, data, test.values)
+		}
+	}
+}
+
+func TestDeleteTrim(t *testing.T) {
+	path := "/tmp/test-deletetrim.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	epoch := int64(1449240540)
+	j.Write(epoch, Int64Values{0, 1, 2, 3, 4, 5, 6, 7})
+
+	null := int64(math.MinInt64)
+	c, err := j.Delete(epoch+60, epoch+120, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Points != 2 || c.Bytes != 16 || c.From != epoch+60 || c.Until != epoch+120 {
+		t.Errorf("Delete dry run reported %s", c)
+	}
+	data, _ := j.ReadRange(epoch, epoch+600)
+	if !metaEq(data.(Int64Values), Int64Values{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("Delete dry run modified the journal: %v", data)
+	}
+	if _, err = j.Delete(epoch+60, epoch+120, false); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = j.ReadRange(epoch, epoch+600)
+	if !metaEq(data.(Int64Values), Int64Values{0, null, null, 3, 4, 5, 6, 7}) {
+		t.Errorf("Delete produced %v", data)
+	}
+
+	c, err = j.Trim(epoch+180, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Points != 3 || j.Epoch() != epoch || j.points != 8 {
+		t.Errorf("Trim dry run reported %s", c)
+	}
+	// A temporary file left by a crashed Trim is replaced
+	tmp := "/tmp/.test-deletetrim.tsj.tmp"
+	if err = os.WriteFile(tmp, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = j.Trim(epoch+180, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("Trim left its temporary file: %v", err)
+	}
+	checkSize(t, j)
+	data, _ = j.ReadRange(epoch, epoch+600)
+	if j.Epoch() != epoch+180 || !metaEq(data.(Int64Values), Int64Values{3, 4, 5, 6, 7}) {
+		t.Errorf("Trim produced %v at %d", data, j.Epoch())
+	}
+
+	// Still writable, still locked
+	if err = j.Write(epoch+480, Int64Values{8}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(path); err != ErrLocked {
+		t.Errorf("Trimmed journal is not locked: %v", err)
+	}
+	j.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = j.ReadRange(epoch, epoch+600)
+	if j.Epoch() != epoch+180 || !metaEq(data.(Int64Values), Int64Values{3, 4, 5, 6, 7, 8}) {
+		t.Errorf("Re-opened trimmed journal has %v at %d", data, j.Epoch())
+	}
+}
+
+func TestTruncateAfter(t *testing.T) {
+	path := "/tmp/test-truncate.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	epoch := int64(1449240540)
+	j.Write(epoch, Int64Values{0, 1, 2, 3, 4, 5})
+
+	if err = j.TruncateAfter(epoch + 1000); err != nil || j.points != 6 {
+		t.Errorf("Truncating after the last value left %d points: %v", j.points, err)
+	}
+	if err = j.TruncateAfter(epoch + 150); err != nil {
+		t.Fatal(err)
+	}
+	checkSize(t, j)
+	data, _ := j.ReadRange(epoch, epoch+600)
+	if j.Last() != epoch+120 || !metaEq(data.(Int64Values), Int64Values{0, 1, 2}) {
+		t.Errorf("TruncateAfter produced %v ending %d", data, j.Last())
+	}
+
+	// Replaying from the checkpoint
+	j.Write(epoch+180, Int64Values{6, 7})
+	data, _ = j.ReadRange(epoch, epoch+600)
+	if !metaEq(data.(Int64Values), Int64Values{0, 1, 2, 6, 7}) {
+		t.Errorf("Replay after TruncateAfter produced %v", data)
+	}
+
+	if err = j.TruncateAfter(epoch - 60); err != nil {
+		t.Fatal(err)
+	}
+	checkSize(t, j)
+	j.Close()
+	if j, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Epoch() != 0 || j.points != 0 {
+		t.Errorf("Truncating every value left epoch %d and %d points", j.Epoch(), j.points)
+	}
+	j.Write(epoch+600, Int64Values{9})
+	if j.Epoch() != epoch+600 {
+		t.Errorf("First write after truncating everything set epoch %d", j.Epoch())
+	}
+}
+
+func TestRepair(t *testing.T) {
+	path := "/tmp/test-repair.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(1449240540, Int64Values{1, 2})
+	j.Close()
+
+	fd, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	fd.Write([]byte{1, 2, 3})
+	fd.Close()
+	if _, err = Open(path); err != ErrPartial {
+		t.Fatalf("Open of a partial journal returned %v", err)
+	}
+
+	c, err := Repair(path, true, nil)
+	if err != nil || c.Bytes != 3 {
+		t.Errorf("Repair dry run reported %s, %v", c, err)
+	}
+	if _, err = Open(path); err != ErrPartial {
+		t.Errorf("Repair dry run modified the journal")
+	}
+	if _, err = Repair(path, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.points != 2 {
+		t.Errorf("Repaired journal has %d points", j.points)
+	}
+	j.Close()
+}
+
+func TestNulls(t *testing.T) {
+	path := "/tmp/test-nulls.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(1449240540, Float64Values{1, math.NaN(), 3})
+	j.Write(1449240540+600, Float64Values{4})
+
+	nulls, err := j.Nulls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nulls != 8 || j.Points() != 11 {
+		t.Errorf("Journal has %d nulls of %d points, want 8 of 11", nulls, j.Points())
+	}
+}
+
+func TestCooperative(t *testing.T) {
+	path := "/tmp/test-cooperative.tsj"
+	os.Remove(path)
+	opts := &Options{Cooperative: true, LockTimeout: time.Second}
+	j1, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j1.Close()
+	j2, err := OpenWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("Cooperative journal is locked between operations: %s", err)
+	}
+	defer j2.Close()
+
+	if err = j1.Write(1449240540, Int64Values{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	// j2 must see the epoch and points written by j1
+	if err = j2.Write(1449240660, Int64Values{3}); err != nil {
+		t.Fatal(err)
+	}
+	if err = j1.Write(1449240720, Int64Values{4}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range []*FileJournal{j1, j2} {
+		v, err := j.Read(1449240540, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(v) != "[1 2 3 4]" {
+			t.Errorf("Cooperative writers produced %v", v)
+		}
+	}
+
+	// A Trim replaces the file; the other journal must follow it.
+	if _, err = j1.Trim(1449240660, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = j2.Write(1449240780, Int64Values{5}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := j1.Read(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(v) != "[3 4 5]" || j1.Epoch() != 1449240660 {
+		t.Errorf("Write after Trim produced %v at %d", v, j1.Epoch())
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	path := "/tmp/test-refresh.tsj"
+	os.Remove(path)
+	opts := &Options{Cooperative: true, LockTimeout: time.Second}
+	w, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := OpenWithOptions(path, &Options{Cooperative: true, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	w.Write(1449240540, Int64Values{1, 2, 3})
+	if r.Epoch() != 0 || r.Points() != 0 {
+		t.Errorf("Journal changed before Refresh")
+	}
+	if err = r.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Epoch() != 1449240540 || r.Points() != 3 || r.Last() != 1449240660 {
+		t.Errorf("Refresh saw epoch %d and %d points", r.Epoch(), r.Points())
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	path := "/tmp/test-watcher.tsj"
+	os.Remove(path)
+	opts := &Options{Cooperative: true}
+	w, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := OpenWithOptions(path, &Options{Cooperative: true, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// A cooperative journal reloads on every operation, so clear the
+	// option to rely on the Watcher alone.
+	r.opts = &Options{ReadOnly: true}
+
+	watcher, err := NewWatcher()
+	if err == ErrWatchUnsupported {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	if err = watcher.Add(r); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Remove(r)
+
+	w.Write(1449240540, Int64Values{1, 2, 3})
+	deadline := time.Now().Add(time.Second)
+	for r.Points() != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.Points() != 3 || r.Epoch() != 1449240540 {
+		t.Errorf("Watcher did not refresh journal: epoch %d with %d points",
+			r.Epoch(), r.Points())
+	}
+
+	// The directory is watched until its last journal is removed
+	os.Remove("/tmp/test-watcher-other.tsj")
+	other, err := Create("/tmp/test-watcher-other.tsj", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err = watcher.Add(other); err != nil {
+		t.Fatal(err)
+	}
+	watcher.Remove(r)
+	watcher.Remove(r)
+	if watcher.dirs["/tmp"] != 1 {
+		t.Errorf("Directory watched for %d paths after one was removed", watcher.dirs["/tmp"])
+	}
+	watcher.Remove(other)
+	if len(watcher.dirs) != 0 {
+		t.Errorf("Directories still watched: %v", watcher.dirs)
+	}
+}
+
+func TestWriteMap(t *testing.T) {
+	path := "/tmp/test-writemap.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	err = WriteFloat64Map(j, map[int64]float64{
+		1449240540: 1,
+		1449240601: 2,
+		1449240610: 3, // same interval, later timestamp wins
+		1449240780: 4,
+		1449240720: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := j.Read(1449240540, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(v) != "[1 3 NaN 5 4]" {
+		t.Errorf("WriteFloat64Map wrote %v", v)
+	}
+
+	path = "/tmp/test-writemap-int.tsj"
+	os.Remove(path)
+	j2, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j2.Close()
+	if err = WriteInt64Map(j2, map[int64]int64{1449240600: 2, 1449240540: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := j2.Read(0, 10); fmt.Sprint(v) != "[1 2]" {
+		t.Errorf("WriteInt64Map wrote %v", v)
+	}
+
+	// Points before the epoch are rejected alone, even within a run
+	err = WriteInt64Map(j2, map[int64]int64{1449240480: 0, 1449240485: 0, 1449240540: 3, 1449240720: 5})
+	if Rejected(err, 4) != 2 || !strings.Contains(fmt.Sprint(err), "before journal epoch") {
+		t.Errorf("WriteInt64Map returned %v", err)
+	}
+	if re, ok := err.(*RejectedError); !ok || len(re.Timestamps) != 2 ||
+		re.Timestamps[0]+re.Timestamps[1] != 1449240480+1449240485 {
+		t.Errorf("WriteInt64Map rejected %+v", err)
+	}
+	if v, _ := j2.Read(0, 10); fmt.Sprint(v) != "[3 2 -9223372036854775808 5]" {
+		t.Errorf("WriteInt64Map with rejected points wrote %v", v)
+	}
+	if Rejected(nil, 4) != 0 || Rejected(fmt.Errorf("Failed"), 4) != 4 {
+		t.Errorf("Rejected miscounted")
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	path := "/tmp/test-benchmark-write.tsj"
+	os.Remove(path)
+	j, err := Create(path, 1, NewFloat64ValueType(), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer j.Close()
+	values := Float64Values{1, 2, 3, 4, 5, 6, 7, 8}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err = j.Write(1449240540+int64(i%1024)*8, values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	src, dst := "/tmp/test-stream-src.tsj", "/tmp/test-stream-dst.tsj"
+	os.Remove(src)
+	os.Remove(dst)
+	a, err := Create(src, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Create(dst, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	data := make([]int64, 100000)
+	fillInt64(data)
+	if err = a.Write(1449240540, Int64Values(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		_, _, err := a.WriteRangeTo(w, 1449240540+60, 1449240540+60*99998)
+		w.CloseWithError(err)
+	}()
+	if err = b.WriteStream(1449240540+60, r, 99998); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Epoch() != 1449240600 || b.Points() != 99998 {
+		t.Errorf("Streamed journal has epoch %d and %d points", b.Epoch(), b.Points())
+	}
+	want, _ := a.Read(1449240600, 99998)
+	got, _ := b.Read(1449240600, 99998)
+	if string(got.Encode()) != string(want.Encode()) {
+		t.Errorf("Streamed values do not match")
+	}
+}
+
+func TestRawReader(t *testing.T) {
+	path := "/tmp/test-raw-reader.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(60, Float64Values{1, 2})
+	j.Write(60*100000, Float64Values{3})
+
+	var want bytes.Buffer
+	j.WriteRangeTo(&want, 90, 60*100000)
+	r := j.RawReader(90, 60*100000)
+	// Odd sized reads straddle values
+	var got []byte
+	buf := make([]byte, 13)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Start() != 60 || !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("RawReader from %d read %d bytes, want %d", r.Start(), len(got), want.Len())
+	}
+
+	if _, err = r.Seek(-12, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	n, _ := io.ReadFull(r, buf)
+	if values := NewFloat64ValueType().Decode(buf[4:n]); n != 12 || fmt.Sprint(values) != "[3]" {
+		t.Errorf("Read %d bytes after seeking holding %v", n, values)
+	}
+
+	r.Seek(0, io.SeekStart)
+	j.Trim(120, false)
+	if _, err = r.Read(buf); err == nil {
+		t.Errorf("Read of a trimmed range succeeded")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	var js []*FileJournal
+	for i, path := range []string{"/tmp/test-append-dst.tsj", "/tmp/test-append-a.tsj",
+		"/tmp/test-append-b.tsj", "/tmp/test-append-float.tsj"} {
+		os.Remove(path)
+		factory := ValueType(NewInt64ValueType())
+		if i == 3 {
+			factory = NewFloat64ValueType()
+		}
+		j, err := Create(path, 60, factory, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer j.Close()
+		js = append(js, j)
+	}
+	dst, a, b := js[0], js[1], js[2]
+	dst.Write(1449240540, Int64Values{1, 2})
+	a.Write(1449240600, Int64Values{20, 3, 4}) // overlaps by one point
+	b.Write(1449240840, Int64Values{6})        // one point gap
+
+	if _, err := Append(dst, js[3], false); err == nil {
+		t.Errorf("Append of a different type did not fail")
+	}
+	n, err := Append(dst, a, false)
+	if err != nil || n != 2 {
+		t.Errorf("Append of an overlapping journal added %d points: %v", n, err)
+	}
+	if _, err = Append(dst, b, false); err == nil {
+		t.Errorf("Append across a gap did not fail")
+	}
+	if n, err = Append(dst, b, true); err != nil || n != 1 {
+		t.Errorf("Append filling a gap added %d points: %v", n, err)
+	}
+
+	v, _ := dst.Read(0, 10)
+	if fmt.Sprint(v) != "[1 2 3 4 -9223372036854775808 6]" {
+		t.Errorf("Appended journal contains %v", v)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	path := "/tmp/test-convert-src.tsj"
+	os.Remove(path)
+	src, err := Create(path, 60, NewFloat64ValueType(), []int64{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.Write(1449240540, Float64Values{1.5, math.NaN(), -2.5, 0.1})
+
+	tests := []struct {
+		factory  ValueType
+		rounding Rounding
+		want     string
+	}{
+		{NewInt64ValueType(), RoundNearest, "[2 -9223372036854775808 -3 0]"},
+		{NewInt64ValueType(), RoundDown, "[1 -9223372036854775808 -3 0]"},
+		{NewInt64ValueType(), RoundTowardZero, "[1 -9223372036854775808 -2 0]"},
+		{NewFloat32ValueType(), RoundNearest, "[1.5 NaN -2.5 0.1]"},
+	}
+	for _, test := range tests {
+		path := "/tmp/test-convert-dst.tsj"
+		os.Remove(path)
+		dst, err := Convert(src, test.factory, path, test.rounding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _ := dst.Read(0, 10)
+		if fmt.Sprint(v) != test.want || dst.Epoch() != src.Epoch() || dst.Meta()[0] != 7 {
+			t.Errorf("Convert to %T with rounding %d gave %v", test.factory, test.rounding, v)
+		}
+		dst.Close()
+	}
+
+	// Rounding a float32 up or down brackets the exact value
+	down, up := RoundDown.float32(0.1), RoundUp.float32(0.1)
+	if !(float64(down) < 0.1 && float64(up) > 0.1) {
+		t.Errorf("float32 rounding of 0.1 gave %g and %g", down, up)
+	}
+
+	// Int64 nulls become NaN
+	os.Remove("/tmp/test-convert-int.tsj")
+	ints, err := Create("/tmp/test-convert-int.tsj", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ints.Close()
+	ints.Write(1449240540, Int64Values{3, math.MinInt64})
+	os.Remove("/tmp/test-convert-float.tsj")
+	dst, err := Convert(ints, NewFloat64ValueType(), "/tmp/test-convert-float.tsj", RoundNearest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if v, _ := dst.Read(0, 10); fmt.Sprint(v) != "[3 NaN]" {
+		t.Errorf("Convert of int64 nulls gave %v", v)
+	}
+
+	os.Remove("/tmp/test-convert-bytes.tsj")
+	if _, err = Convert(ints, NewByteValueType(8, nil), "/tmp/test-convert-bytes.tsj", RoundNearest); err == nil {
+		t.Errorf("Convert of int64 to bytes did not fail")
+	}
+	if _, err = os.Stat("/tmp/test-convert-bytes.tsj"); !os.IsNotExist(err) {
+		t.Errorf("Failed Convert left its journal behind")
+	}
+}
+
+func TestReadNull(t *testing.T) {
+	path := "/tmp/test-readnull.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(1449240540, Int64Values{1, math.MinInt64, 3})
+	j.Write(1449240780, Int64Values{5}) // gap of one null
+
+	floats, err := j.ReadFloat64(0, 1449240780, math.NaN())
+	if err != nil || fmt.Sprint(floats) != "[1 NaN 3 NaN 5]" {
+		t.Errorf("ReadFloat64 returned %v: %v", floats, err)
+	}
+	floats, _ = j.ReadFloat64(0, 1449240780, -1)
+	if fmt.Sprint(floats) != "[1 -1 3 -1 5]" {
+		t.Errorf("ReadFloat64 with a null of -1 returned %v", floats)
+	}
+	j.Close()
+
+	_, err = OpenWithOptions(path, &Options{ReadNull: Float32Values{0}})
+	if err == nil {
+		t.Errorf("Open with a ReadNull of the wrong width did not fail")
+	}
+	j, err = OpenWithOptions(path, &Options{ReadNull: Int64Values{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	v, _ := j.Read(0, 10)
+	if fmt.Sprint(v) != "[1 0 3 0 5]" {
+		t.Errorf("Read with ReadNull returned %v", v)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	path := "/tmp/test-flags.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), []int64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Dirty() {
+		t.Errorf("New journal is dirty")
+	}
+	j.Write(1449240540, Int64Values{1})
+	j.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Dirty() || j.Flags().String() != "dirty" {
+		t.Errorf("Unsynced journal has flags %q", j.Flags())
+	}
+	if !metaEq(j.Meta(), []int64{1, 2, 3}) {
+		t.Errorf("Flags overwrote metadata: %v", j.Meta())
+	}
+	j.Sync()
+	j.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Dirty() {
+		t.Errorf("Synced journal is dirty")
+	}
+	if err = j.SetMeta([]int64{4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if j, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if !metaEq(j.Meta(), []int64{4, 5, 0}) || j.Dirty() {
+		t.Errorf("SetMeta left metadata %v and flags %q", j.Meta(), j.Flags())
+	}
+
+	if err = j.setFlags(FlagSealed); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Write(1449240600, Int64Values{2}); err != ErrSealed {
+		t.Errorf("Write to a sealed journal returned %v", err)
+	}
+	if _, err = j.Delete(0, 1449240600, false); err != ErrSealed {
+		t.Errorf("Delete from a sealed journal returned %v", err)
+	}
+	if err = j.SetMeta(nil); err != ErrSealed {
+		t.Errorf("SetMeta of a sealed journal returned %v", err)
+	}
+	j.setFlags(FlagCompressed)
+	j.Close()
+
+	if _, err = Open(path); err != ErrCompressed {
+		t.Errorf("Open of a compressed journal returned %v", err)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	path := "/tmp/test-seal.tsj"
+	os.Chmod(path, 0644)
+	os.Remove(path)
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, &Options{SealReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(1449240540, Int64Values{1, 2})
+	if err = j.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if !j.Sealed() || j.Dirty() {
+		t.Errorf("Sealed journal has flags %q", j.Flags())
+	}
+	if err = j.Write(1449240660, Int64Values{3}); err != ErrSealed {
+		t.Errorf("Write after Seal returned %v", err)
+	}
+	stat, _ := os.Stat(path)
+	if stat.Mode().Perm()&0222 != 0 {
+		t.Errorf("Sealed journal is writable: %s", stat.Mode())
+	}
+
+	j.Close()
+
+	// Readers do not lock sealed journals
+	r1, err := OpenWithOptions(path, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	locked, err := lock.IsLocked(path)
+	if err != nil || locked {
+		t.Errorf("Sealed journal is locked by its readers: %v", err)
+	}
+	v, _ := r1.Read(0, 10)
+	if fmt.Sprint(v) != "[1 2]" {
+		t.Errorf("Sealed journal contains %v", v)
+	}
+}
+
+func TestCompressJournal(t *testing.T) {
+	path := "/tmp/test-compress.tsj"
+	os.Remove(path)
+	os.Remove(path + Gzip.Ext)
+	j, err := Create(path, 60, NewInt64ValueType(), []int64{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(Int64Values, 20000)
+	for i := range values {
+		values[i] = int64(i % 100)
+	}
+	j.Write(1449240540, values)
+	if _, err = CompressJournal(path, Gzip, false, nil); err == nil {
+		t.Errorf("Unsealed journal was compressed")
+	}
+	j.Seal()
+	j.Close()
+	original, _ := ioutil.ReadFile(path)
+
+	dry, err := CompressJournal(path, Gzip, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + Gzip.Ext); !os.IsNotExist(err) {
+		t.Errorf("Compress dry run wrote %s", path+Gzip.Ext)
+	}
+	c, err := CompressJournal(path, Gzip, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Path != path+Gzip.Ext || c.Bytes <= 0 || c.Bytes != dry.Bytes {
+		t.Errorf("Compress returned %v after a dry run of %v", c, dry)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Compressed journal was not removed: %v", err)
+	}
+
+	cache := NewBlockCache(4)
+	j, err = OpenWithOptions(path, &Options{BlockCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Points() != 20000 || j.Meta()[0] != 7 || !j.Sealed() {
+		t.Errorf("Compressed journal has %d points and meta %v", j.Points(), j.Meta())
+	}
+	from := int64(1449240540 + 8190*60)
+	v, err := j.Read(from, 1000)
+	if err != nil || fmt.Sprint(v.Slice(0, 4)) != "[90 91 92 93]" {
+		t.Errorf("Read across blocks returned %v", err)
+	}
+	hits, misses := cache.Stats()
+	j.Read(from, 1000)
+	if h, m := cache.Stats(); h <= hits || m != misses {
+		t.Errorf("Block cache had %d hits and %d misses, then %d and %d", hits, misses, h, m)
+	}
+	if err = j.Write(from, Int64Values{1}); err != ErrSealed {
+		t.Errorf("Write to compressed journal returned %v", err)
+	}
+	j.Close()
+
+	// The compressed file opens by its own name too
+	j, err = Open(path + Gzip.Ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ = j.Read(j.Last(), 1)
+	if fmt.Sprint(v) != "[99]" {
+		t.Errorf("Last compressed value is %v", v)
+	}
+	j.Close()
+
+	if _, err = DecompressJournal(path, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(buf, original) {
+		t.Errorf("Decompressed journal differs from the original")
+	}
+	if _, err = os.Stat(path + Gzip.Ext); !os.IsNotExist(err) {
+		t.Errorf("Decompressed journal was not removed: %v", err)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	path := "/tmp/test-times.tsj"
+	os.Remove(path)
+	before := time.Now()
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := j.Created()
+	if created.Before(before) || !j.Modified().Equal(created) {
+		t.Errorf("New journal created %s and modified %s", created, j.Modified())
+	}
+	j.Write(1449240540, Int64Values{1, 2})
+	modified := j.Modified()
+	if !modified.After(created) {
+		t.Errorf("Write did not update modification time %s", modified)
+	}
+	j.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !j.Created().Equal(created) || !j.Modified().Equal(modified) {
+		t.Errorf("Reopened journal created %s and modified %s", j.Created(), j.Modified())
+	}
+	checkSize(t, j)
+	j.Close()
+
+	// Version 0 journals have no times
+	fd, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and keep a fourth meta value where later versions keep flags
+	header := FileHeader{Magic: Magic, Version: 0, Type: 0x11, Width: 8,
+		Interval: 60, Meta: [3]int64{1, 2, 3}, Epoch: 1449240540}
+	writeHeader(fd, header, HeaderExt{LegacyMeta: int64(FlagSealed | FlagCompressed)})
+	fd.Write(Int64Values{7, 8}.Encode())
+	fd.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if !j.Created().IsZero() || !j.Modified().IsZero() {
+		t.Errorf("Version 0 journal created %s and modified %s", j.Created(), j.Modified())
+	}
+	j.Write(1449240660, Int64Values{9})
+	v, _ := j.Read(0, 10)
+	if fmt.Sprint(v) != "[7 8 9]" {
+		t.Errorf("Version 0 journal contains %v", v)
+	}
+	checkSize(t, j)
+	if j.Flags() != 0 || fmt.Sprint(j.Meta()) != "[1 2 3]" || j.LegacyMeta() != 6 {
+		t.Errorf("Version 0 journal flags %s, meta %v and %d", j.Flags(), j.Meta(), j.LegacyMeta())
+	}
+	if err = j.Seal(); err == nil {
+		t.Errorf("Sealed a version 0 journal")
+	}
+	j.Sync()
+	if _, ext, err := PeekExt(path); err != nil || ext.LegacyMeta != 6 {
+		t.Errorf("Version 0 journal legacy meta %d after writing: %v", ext.LegacyMeta, err)
+	}
+}
+
+func TestClock(t *testing.T) {
+	path := "/tmp/test-clock.tsj"
+	os.Remove(path)
+	c := clock.NewFake(time.Unix(1449240540, 0))
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, &Options{Clock: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if !j.Created().Equal(c.Now()) {
+		t.Errorf("Created %s, want %s", j.Created(), c.Now())
+	}
+	modified := c.Advance(24 * time.Hour)
+	j.Write(1449240540, Int64Values{1})
+	if !j.Modified().Equal(modified) {
+		t.Errorf("Modified %s, want %s", j.Modified(), modified)
+	}
+}
+
+func TestMaxReadPoints(t *testing.T) {
+	path := "/tmp/test-maxread.tsj"
+	os.Remove(path)
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, &Options{MaxReadPoints: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(1449240540, Int64Values{1, 2, 3, 4, 5})
+
+	if v, err := j.ReadRange(1449240600, 1449240720); err != nil || v.Len() != 3 {
+		t.Errorf("ReadRange within the limit returned %v: %v", v, err)
+	}
+	// Only the values that exist count toward the limit
+	if v, err := j.Read(1449240660, 100); IsReadLimit(err) || v.Len() != 3 {
+		t.Errorf("Read of the last values returned %v: %v", v, err)
+	}
+	_, err = j.ReadRange(0, 1449240780)
+	if limit, ok := err.(*ReadLimitError); !ok || limit.Points != 5 || !IsReadLimit(err) {
+		t.Errorf("ReadRange over the limit returned %v", err)
+	}
+}
+
+func TestMaxGap(t *testing.T) {
+	path := "/tmp/test-maxgap.tsj"
+	os.Remove(path)
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, &Options{MaxGap: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	// The first write sets the epoch and fills nothing
+	if err = j.Write(1449240540, Int64Values{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Write(1449240720, Int64Values{4}); err != nil {
+		t.Errorf("Write leaving a gap of 2 failed: %v", err)
+	}
+	err = j.Write(1449240960, Int64Values{8})
+	if gap, ok := err.(*GapError); !ok || gap.Gap != 3 || !IsGap(err) || j.Points() != 4 {
+		t.Errorf("Write leaving a gap of 3 returned %v with %d points", err, j.Points())
+	}
+	// Overwrites are never gaps
+	if err = j.Write(1449240540, Int64Values{0, 1, 2, 3, 4}); err != nil {
+		t.Errorf("Overwrite failed: %v", err)
+	}
+}
+
+func TestGaps(t *testing.T) {
+	path := "/tmp/test-gaps.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if gaps, _ := j.Gaps(0, 119); fmt.Sprint(gaps) != "[{0 60}]" {
+		t.Errorf("Gaps of an empty journal: %v", gaps)
+	}
+
+	null := int64(math.MinInt64)
+	j.Write(600, Int64Values{null, 1, null, null, 4, null})
+	gaps, err := j.Gaps(0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(gaps) != "[{600 600} {720 780} {900 960}]" {
+		t.Errorf("Gaps returned %v", gaps)
+	}
+	if gaps, _ = j.Gaps(700, 800); fmt.Sprint(gaps) != "[{720 780}]" {
+		t.Errorf("Gaps of a partial range returned %v", gaps)
+	}
+	if gaps, _ = j.Gaps(1200, 1100); gaps != nil {
+		t.Errorf("Gaps of an empty range returned %v", gaps)
+	}
+}
+
+func TestBitSet(t *testing.T) {
+	path := "/tmp/test-bitset.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewBitfieldValueType(2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	up, down := NewBitmask(2, 0, 9), NewBitmask(2, 9)
+	j.Write(600, BitfieldValues{up, up, down, NullBitmask(2), up})
+	j.Write(960, BitfieldValues{up})
+	runs, err := j.BitSet(0, 0, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(runs) != "[{600 660} {840 840} {960 960}]" {
+		t.Errorf("Bit 0 set during %v", runs)
+	}
+	if runs, _ = j.BitSet(9, 660, 800); fmt.Sprint(runs) != "[{660 720}]" {
+		t.Errorf("Bit 9 set during %v", runs)
+	}
+	if runs, _ = j.BitSet(3, 0, 2000); runs != nil {
+		t.Errorf("Bit 3 set during %v", runs)
+	}
+	if _, err = j.BitSet(16, 0, 2000); err == nil {
+		t.Errorf("BitSet accepted a bit beyond the mask")
+	}
+	values, _ := j.ReadRange(600, 600)
+	if m := values.(BitfieldValues)[0]; !m.Bit(0) || m.Bit(1) || !m.Bit(9) {
+		t.Errorf("Read mask %08b", m)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	path := "/tmp/test-validation.tsj"
+	os.Remove(path)
+	min, max := 0.0, 100.0
+	v := &Validation{Min: &min, Max: &max, Monotonic: true, RejectNull: true}
+	opts := &Options{Validation: v}
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if err = j.Write(60, Int64Values{1, 5, 3}); err == nil {
+		t.Errorf("Write of a decreasing value succeeded")
+	} else if e, ok := err.(*ValidationError); !ok || e.Timestamp != 180 {
+		t.Errorf("Write of a decreasing value returned %v", err)
+	}
+	if j.Epoch() != 0 {
+		t.Errorf("Rejected Write stored values")
+	}
+	if err = j.Write(60, Int64Values{1, math.MinInt64}); err == nil {
+		t.Errorf("Write of a null succeeded")
+	}
+
+	v.Policy = Clamp
+	values := Int64Values{10, 5, 200}
+	if err = j.Write(60, values); err != nil {
+		t.Fatal(err)
+	}
+	// The stored 100 bounds the next write
+	v.Policy = NullOut
+	j.Write(240, Int64Values{-1, 50, 150, 100})
+	got, _ := j.Read(0, 10)
+	if fmt.Sprint(got) != "[10 10 100 -9223372036854775808 -9223372036854775808 -9223372036854775808 100]" {
+		t.Errorf("Validated journal contains %v", got)
+	}
+	if values[1] != 5 {
+		t.Errorf("Validation modified the caller's values")
+	}
+	if v.BelowMin.Value() != 1 || v.AboveMax.Value() != 2 ||
+		v.Null.Value() != 1 || v.Decrease.Value() != 3 {
+		t.Errorf("Validation counted %s %s %s %s",
+			&v.BelowMin, &v.AboveMax, &v.Null, &v.Decrease)
+	}
+}
+
+func TestFence(t *testing.T) {
+	path := "/tmp/test-fence.tsj"
+	os.Remove(path)
+	now := int64(1449240540)
+	f := &Fence{MaxFuture: 2}
+	opts := &Options{Fence: f, Clock: clock.NewFake(time.Unix(now+30, 0))}
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	if err = j.Write(now, Int64Values{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	err = j.Write(now+60*100000, Int64Values{4})
+	if !IsFuture(err) || f.Rejected.Value() != 1 || j.Points() != 3 {
+		t.Errorf("Write far in the future returned %v with %d points", err, j.Points())
+	}
+
+	f.Clamp = true
+	if err = j.Write(now+120, Int64Values{3, 4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Write(now+60*100000, Int64Values{7}); err != nil {
+		t.Fatal(err)
+	}
+	values, _ := j.Read(now, 10)
+	if fmt.Sprint(values) != "[1 2 3]" || f.Discarded.Value() != 4 {
+		t.Errorf("Clamped writes left %v and discarded %d", values, f.Discarded.Value())
+	}
+}
+
+func TestWidth(t *testing.T) {
+	path := "/tmp/test-width.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewUUIDValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	// 8 byte values do not fit a journal of 16 byte values
+	if err = j.Write(60, Float64Values{1, 2}); err == nil {
+		t.Errorf("Write of the wrong width succeeded")
+	}
+	if j.Epoch() != 0 {
+		t.Errorf("Rejected Write stored values")
+	}
+	id := UUID{15: 1}
+	if err = j.Write(60, UUIDValues{{}, id}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := j.Read(60, 2)
+	if ids := got.(UUIDValues).Strings(); fmt.Sprint(ids) != "[ "+id.String()+"]" {
+		t.Errorf("Journal contains %q", ids)
+	}
+}
+
+func TestTx(t *testing.T) {
+	path := "/tmp/test-tx.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(60, Int64Values{1, 2, 3})
+
+	tx := j.Begin()
+	tx.Write(300, Int64Values{5})
+	tx.Write(120, Int64Values{20, 30})
+	tx.Write(150, Int64Values{25})
+	if j.Last() != 180 {
+		t.Errorf("Uncommitted transaction changed the journal")
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := j.Read(0, 10)
+	if fmt.Sprint(v) != "[1 25 30 -9223372036854775808 5]" {
+		t.Errorf("Committed journal contains %v", v)
+	}
+	if err = tx.Write(60, Int64Values{0}); err != ErrTxDone {
+		t.Errorf("Write after Commit returned %v", err)
+	}
+
+	// A failing Write leaves the journal untouched
+	tx = j.Begin()
+	tx.Write(360, Int64Values{6})
+	tx.Write(0, Int64Values{0})
+	if err = tx.Commit(); err == nil {
+		t.Errorf("Commit of a write before the epoch succeeded")
+	}
+	tx = j.Begin()
+	tx.Write(360, Int64Values{6})
+	tx.Rollback()
+	if err = tx.Commit(); err != ErrTxDone || j.Last() != 300 {
+		t.Errorf("Rolled back transaction returned %v with last %d", err, j.Last())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	path := "/tmp/test-snapshot.tsj"
+	os.Remove(path)
+	j, err := CreateWithOptions(path, 60, NewFloat64ValueType(), nil, &Options{Sparse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(1449240540, Float64Values{1})
+	j.Write(1449240540+60*2000, Float64Values{2})
+
+	snap, err := j.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	j.Write(1449240540+60*2001, Float64Values{3})
+
+	copyPath := "/tmp/test-snapshot-copy.tsj"
+	fd, err := os.Create(copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(copyPath)
+	if n, err := snap.WriteTo(fd); err != nil || n != snap.Size() {
+		t.Fatalf("Snapshot wrote %d of %d bytes: %v", n, snap.Size(), err)
+	}
+	fd.Close()
+
+	c, err := Open(copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	v, err := c.ReadRange(1449240540, 1449240540+60*3000)
+	if err != nil || v.Len() != 2001 {
+		t.Fatalf("Copy holds %d values: %v", v.Len(), err)
+	}
+	floats := v.(Float64Values)
+	if floats[0] != 1 || !math.IsNaN(floats[1000]) || floats[2000] != 2 {
+		t.Errorf("Copy holds %v ... %v", floats[:2], floats[1999:])
+	}
+	if c.Dirty() {
+		t.Errorf("Copy is dirty")
+	}
+}
+
+func TestCarryForward(t *testing.T) {
+	path := "/tmp/test-carry-forward.tsj"
+	os.Remove(path)
+	opts := &Options{CarryForward: true, Sparse: true}
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	null := int64(math.MinInt64)
+	j.Write(600, Int64Values{1, 2})
+	j.Write(900, Int64Values{5})
+	j.Write(720, Int64Values{3})
+	j.Write(1080, Int64Values{null})
+	j.Write(1200, Int64Values{7})
+	values, err := j.Read(600, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Int64Values{1, 2, 3, 2, 2, 5, 5, 5, null, null, 7}
+	if fmt.Sprint(values) != fmt.Sprint(want) {
+		t.Errorf("Read %v, want %v", values, want)
+	}
+}
+
+func TestPageAligned(t *testing.T) {
+	path := "/tmp/test-page-aligned.tsj"
+	os.Remove(path)
+	opts := &Options{PageAligned: true, Sparse: true}
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := int64(1449240540)
+	if err = j.Write(epoch, Int64Values{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	checkSize(t, j)
+	if stat, _ := os.Stat(path); stat.Size() != HeaderSizeV2+3*8 {
+		t.Errorf("Page aligned journal is %d bytes", stat.Size())
+	}
+	j.Write(epoch+60*1000, Int64Values{4})
+	if _, err = j.Trim(epoch+60, false); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	checkSize(t, j)
+	// Trim punched a hole over the first value
+	if j.header.Version != 3 || j.base != HeaderSizeV2+8 || j.Created().IsZero() {
+		t.Errorf("Reopened version %d with values at %d", j.header.Version, j.base)
+	}
+	data, err := j.ReadRange(epoch, epoch+60*1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := data.(Int64Values)
+	if len(values) != 1000 || values[0] != 2 || values[1] != 3 || values[998] != math.MinInt64 || values[999] != 4 {
+		t.Errorf("Read %d values starting %v", len(values), values[:3])
+	}
+
+	snap, err := j.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	buf := new(bytes.Buffer)
+	snap.WriteTo(buf)
+	disk, _ := os.ReadFile(path)
+	if !bytes.Equal(buf.Bytes()[HeaderSizeV2:], disk[j.base:]) || int64(buf.Len()) != snap.Size() {
+		t.Errorf("Snapshot of a page aligned journal differs")
+	}
+	if _, ext, _ := readHeader(buf, path); ext.Dropped != 0 {
+		t.Errorf("Snapshot records %d dropped values", ext.Dropped)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	path := "/tmp/test-rebase.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	epoch := int64(1449240540)
+	values := make(Int64Values, 5000)
+	for i := range values {
+		values[i] = math.MinInt64
+	}
+	values[4998], values[4999] = 1, 2
+	j.Write(epoch, values)
+
+	if c, err := j.Rebase(5000, false); err != nil || c.Points != 0 || j.Points() != 5000 {
+		t.Errorf("Rebase of a short run returned %v: %v", c, err)
+	}
+	c, err := j.Rebase(1000, true)
+	if err != nil || c.Points != 4998 || c.Until != epoch+60*4997 || j.Points() != 5000 {
+		t.Errorf("Rebase dry run returned %v: %v", c, err)
+	}
+	if c, err = j.Rebase(1000, false); err != nil || c.Points != 4998 {
+		t.Errorf("Rebase returned %v: %v", c, err)
+	}
+	v, _ := j.Read(0, 10)
+	if j.Epoch() != epoch+60*4998 || fmt.Sprint(v) != "[1 2]" {
+		t.Errorf("Rebase left %v from %d", v, j.Epoch())
+	}
+
+	// A journal of nothing but nulls is emptied
+	j.Write(j.Last()+60, Int64Values{math.MinInt64})
+	j.Delete(0, j.Last(), false)
+	if c, err = j.Rebase(1, false); err != nil || c.Points != 3 || j.Points() != 0 || j.Epoch() != 0 {
+		t.Errorf("Rebase of nulls returned %v leaving %d points: %v", c, j.Points(), err)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	path := "/tmp/test-summary.tsj"
+	os.Remove(path)
+	j, err := CreateWithOptions(path, 60, NewFloat64ValueType(), nil, &Options{Summarize: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := j.Summary(); !ok || !s.Empty() || j.header.Version != 2 {
+		t.Errorf("New journal version %d has summary %v, %v", j.header.Version, s, ok)
+	}
+	epoch := int64(1449240540)
+	j.Write(epoch, Float64Values{3, -1, 7, math.NaN()})
+	j.Write(epoch+60*10, Float64Values{2, math.NaN()})
+	want := Summary{Min: -1, Max: 7, Last: epoch + 60*10, LastValue: 2}
+	if s, _ := j.Summary(); s != want {
+		t.Errorf("Summary is %+v", s)
+	}
+	// Overwriting older values leaves the newest alone
+	j.Write(epoch, Float64Values{10})
+	want.Max = 10
+	j.Close()
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if s, ok := j.Summary(); !ok || s != want {
+		t.Errorf("Reopened summary is %+v, %v", s, ok)
+	}
+	if v, _ := j.Read(epoch, 3); fmt.Sprint(v) != "[10 -1 7]" {
+		t.Errorf("Read %v", v)
+	}
+
+	// Removing the newest value rebuilds the summary
+	j.Delete(epoch+60*10, epoch+60*10, false)
+	want = Summary{Min: -1, Max: 10, Last: epoch + 60*2, LastValue: 7}
+	if s, _ := j.Summary(); s != want {
+		t.Errorf("Summary after Delete is %+v", s)
+	}
+	j.TruncateAfter(epoch + 60)
+	want = Summary{Min: -1, Max: 10, Last: epoch + 60, LastValue: -1}
+	if s, _ := j.Summary(); s != want {
+		t.Errorf("Summary after TruncateAfter is %+v", s)
+	}
+	j.Write(epoch+60*2, Float64Values{math.NaN()})
+	j.Trim(epoch+60*2, false)
+	if s, _ := j.Summary(); !s.Empty() || j.Points() != 1 {
+		t.Errorf("Summary of nulls is %+v", s)
+	}
+
+	// Existing page aligned journals can start keeping one
+	other := "/tmp/test-summary-rebuild.tsj"
+	os.Remove(other)
+	k, err := CreateWithOptions(other, 60, NewInt64ValueType(), nil, &Options{PageAligned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Write(epoch, Int64Values{5, 4, math.MinInt64})
+	if _, ok := k.Summary(); ok {
+		t.Errorf("Journal keeps a summary before RebuildSummary")
+	}
+	if err = k.RebuildSummary(); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	header, ext, err := PeekExt(other)
+	want = Summary{Min: 4, Max: 5, Last: epoch + 60, LastValue: 4}
+	if err != nil || header.Flags&FlagSummary == 0 || ext.Summary != want {
+		t.Errorf("Peeked flags %s and summary %+v: %v", header.Flags, ext.Summary, err)
+	}
+}
+
+func TestLastValue(t *testing.T) {
+	epoch := int64(1449240540)
+	for _, opts := range []*Options{{}, {Summarize: true}} {
+		path := "/tmp/test-last-value.tsj"
+		os.Remove(path)
+		j, err := CreateWithOptions(path, 60, NewFloat64ValueType(), nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts, _, err := j.LastValue(); err != nil || ts != 0 {
+			t.Errorf("Empty journal has last value at %d: %v", ts, err)
+		}
+		j.Write(epoch, Float64Values{1, 2})
+		// The newest value is behind a chunk of nulls
+		j.Write(epoch+(editChunk+10)*60, Float64Values{math.NaN()})
+		if ts, v, err := j.LastValue(); err != nil || ts != epoch+60 || v != 2 {
+			t.Errorf("Summarize %v: last value %v at %d: %v", opts.Summarize, v, ts, err)
+		}
+		j.Close()
+	}
+	os.Remove("/tmp/test-last-value.tsj")
+}
+
+func TestBlockSummaries(t *testing.T) {
+	path := "/tmp/test-block-summaries.tsj"
+	os.Remove(path)
+	os.Remove(path + BlockSummaryExt)
+	opts := &Options{BlockSummaries: true}
+	j, err := CreateWithOptions(path, 60, NewFloat64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := int64(1449240540)
+	values := make(Float64Values, 3000)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	values[2000] = math.NaN()
+	j.Write(epoch, values[:1500])
+	j.Write(epoch+60*1500, values[1500:])
+
+	check := func(when string, from, until int64, want BlockSummary) {
+		s, err := j.RangeSummary(epoch+60*from, epoch+60*until)
+		if err != nil || s != want {
+			t.Errorf("%s: RangeSummary of %d through %d is %+v: %v", when, from, until, s, err)
+		}
+	}
+	check("Written", 10, 2999, BlockSummary{Min: 10, Max: 2999, Sum: 4498455 - 2000, Count: 2989})
+	check("Written", 0, 1023, BlockSummary{Min: 0, Max: 1023, Sum: 523776, Count: 1024})
+	if stat, err := os.Stat(path + BlockSummaryExt); err != nil || stat.Size() != blockFileHeader+3*blockRecord {
+		t.Errorf("Block summary file: %v", err)
+	}
+
+	// Overwrites and deletes replace the summaries of their blocks
+	j.Write(epoch+60*1100, Float64Values{-5})
+	j.Delete(epoch+60*2999, epoch+60*2999, false)
+	want := BlockSummary{Min: -5, Max: 2998, Sum: 4498455 - 2000 - 1105 - 2999, Count: 2988}
+	check("Overwritten", 10, 2999, want)
+	j.Close()
+
+	// The file is used again, or rebuilt if it does not match
+	for _, damage := range []bool{false, true} {
+		if damage {
+			os.Truncate(path+BlockSummaryExt, blockFileHeader)
+		}
+		j, err = OpenWithOptions(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(fmt.Sprint("Reopened damaged ", damage), 10, 2999, want)
+		j.Close()
+	}
+
+	// Without block summaries the values are read
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Unsummarized", 10, 2999, want)
+	j.Close()
+
+	j, err = OpenWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Trim(epoch+60*1024, false)
+	if _, err = os.Stat(path + BlockSummaryExt); !os.IsNotExist(err) {
+		t.Errorf("Trim left the block summaries: %v", err)
+	}
+	check("Trimmed", 0, 2999, BlockSummary{Min: -5, Max: 2998, Sum: 4498500 - 523776 - 2000 - 1105 - 2999, Count: 1974})
+}
+
+func TestReadAggregate(t *testing.T) {
+	path := "/tmp/test-read-aggregate.tsj"
+	os.Remove(path)
+	os.Remove(path + BlockSummaryExt)
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, &Options{BlockSummaries: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	epoch := int64(1449240540)
+	values := make(Int64Values, 5000)
+	for i := range values {
+		values[i] = int64(i % 7)
+	}
+	values[100] = math.MinInt64
+	j.Write(epoch, values)
+
+	// Steps start on multiples of the step, 9 values before the epoch
+	start, counts, err := j.ReadAggregate(0, epoch+60*5000, 60000, AggCount)
+	if err != nil || start != epoch-540 || fmt.Sprint(counts) != "[990 1000 1000 1000 1000 9]" {
+		t.Errorf("ReadAggregate counted %v from %d: %v", counts, start, err)
+	}
+
+	// Block summaries give the same results as reading every value
+	raw, _ := j.ReadRange(epoch+60*10, epoch+60*4321)
+	for _, name := range []string{"avg", "sum", "min", "max", "count"} {
+		fn, _ := GetAggFunc(name)
+		_, want, _ := AggregateValues(epoch+60*10, 60, raw, 60*2048, fn)
+		_, got, err := j.ReadAggregate(epoch+60*10, epoch+60*4321, 60*2048, fn)
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("ReadAggregate %s returned %v instead of %v: %v", name, got, want, err)
+		}
+	}
+
+	if _, _, err = j.ReadAggregate(0, epoch, 90, AggSum); err == nil {
+		t.Errorf("ReadAggregate accepted a step that is not a multiple of the interval")
+	}
+	j.opts.MaxReadPoints = 5
+	if _, _, err = j.ReadAggregate(0, epoch+60*5000, 60000, AggSum); !IsReadLimit(err) {
+		t.Errorf("ReadAggregate of 6 steps returned %v", err)
+	}
+}
+
+func TestTrimInPlace(t *testing.T) {
+	path := "/tmp/test-trim-in-place.tsj"
+	os.Remove(path)
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, &Options{PageAligned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := int64(1449240540)
+	values := make(Int64Values, 100000)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	j.Write(epoch, values)
+	j.Sync()
+	before, _ := os.Stat(path)
+
+	c, err := j.Trim(epoch+60*60000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Points != 60000 || j.Epoch() != epoch+60*60000 || j.Points() != 40000 {
+		t.Errorf("Trim returned %v leaving %d points from %d", c, j.Points(), j.Epoch())
+	}
+	after, _ := os.Stat(path)
+	if after.Size() != before.Size() || !sameFile(before, after) {
+		t.Errorf("Trim in place rewrote the journal")
+	}
+	blocks := func(info os.FileInfo) int64 { return info.Sys().(*syscall.Stat_t).Blocks }
+	if blocks(after) >= blocks(before) {
+		t.Errorf("Trim in place freed no blocks: %d then %d", blocks(before), blocks(after))
+	}
+	j.Write(epoch+60*100000, Int64Values{100000})
+	j.Close()
+
+	header, ext, err := PeekExt(path)
+	if err != nil || header.Version != 3 || ext.Dropped != 60000 {
+		t.Errorf("Peeked version %d with %d dropped: %v", header.Version, ext.Dropped, err)
+	}
+	after, _ = os.Stat(path)
+	if points := (after.Size() - header.ValuesOffset(ext)) / 8; points != 40001 {
+		t.Errorf("Peeked %d points", points)
+	}
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	v, _ := j.Read(epoch, 2)
+	if fmt.Sprint(v) != "[60000 60001]" || j.Points() != 40001 {
+		t.Errorf("Read %v from a journal of %d points", v, j.Points())
+	}
+	v, _ = j.Read(j.Last(), 1)
+	if fmt.Sprint(v) != "[100000]" {
+		t.Errorf("Last value is %v", v)
+	}
+
+	// Dropped values accumulate, and trimming everything rewrites
+	j.Trim(epoch+60*70000, false)
+	if j.ext.Dropped != 70000 {
+		t.Errorf("%d values dropped", j.ext.Dropped)
+	}
+	j.Trim(epoch+60*200000, false)
+	if j.ext.Dropped != 0 || j.base != HeaderSizeV2 || j.Points() != 0 {
+		t.Errorf("Trimmed journal has %d dropped and %d points", j.ext.Dropped, j.Points())
+	}
+}
+
+func TestBlockIndex(t *testing.T) {
+	path := "/tmp/test-block-index.tsj"
+	os.Remove(path + IndexExt)
+	defer os.Remove(path + IndexExt)
+
+	x := new(BlockIndex)
+	for i := int64(0); i < 1000; i++ {
+		// Blocks of 100 values of varying size
+		if err := x.Add(1500000000+i*6000, HeaderSizeV1+i*i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := x.Add(1500000000, 1<<30); err != ErrIndexOrder {
+		t.Errorf("Out of order block accepted: %v", err)
+	}
+	if i := x.Find(1500000000 + 6000*42 + 59); i != 42 {
+		t.Errorf("Found block %d", i)
+	}
+	if i := x.Find(0); i != -1 {
+		t.Errorf("Found block %d before the first", i)
+	}
+	if first, last := x.Range(0, 1500000000+6000*2); first != 0 || last != 2 {
+		t.Errorf("Range is blocks %d through %d", first, last)
+	}
+	if first, last := x.Range(0, 1000); first <= last {
+		t.Errorf("Range before the first block is blocks %d through %d", first, last)
+	}
+	if start, end := x.Extent(999, 1<<40); start != HeaderSizeV1+999*999 || end != 1<<40 {
+		t.Errorf("Extent of the last block is %d to %d", start, end)
+	}
+
+	if err := x.Save(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(loaded.Blocks) != fmt.Sprint(x.Blocks) {
+		t.Errorf("Loaded a different index")
+	}
+
+	buf := new(bytes.Buffer)
+	x.WriteTo(buf)
+	damaged := buf.Bytes()
+	damaged[100] ^= 1
+	if _, err = ReadIndex(bytes.NewReader(damaged)); err == nil {
+		t.Errorf("Damaged index read")
+	}
+	if _, err = ReadIndex(bytes.NewReader(damaged[:50])); err == nil {
+		t.Errorf("Truncated index read")
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	path := "/tmp/test-generation.tsj"
+	os.Remove(path)
+	opts := &Options{Cooperative: true, LockTimeout: time.Second}
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	epoch := int64(1449240540)
+	j.Write(epoch, Int64Values{0, 1, 2, 3, 4, 5})
+	if j.Generation() != 0 {
+		t.Errorf("Writes changed the generation to %d", j.Generation())
+	}
+	j.Delete(epoch, epoch, true)
+	j.Delete(epoch, epoch, false)
+	j.TruncateAfter(epoch + 240)
+	if j.Generation() != 2 {
+		t.Errorf("Delete and TruncateAfter left generation %d", j.Generation())
+	}
+
+	// Another journal trims the file out from under this one
+	other, err := OpenWithOptions(path, &Options{Cooperative: true, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	j.Write(epoch+300, Int64Values{5})
+	if err = other.Refresh(); err != nil || other.Generation() != 0 {
+		t.Errorf("Appending changed the generation to %d: %v", other.Generation(), err)
+	}
+	j.Trim(epoch+120, false)
+	if err = other.Refresh(); err != nil || other.Generation() != 1 {
+		t.Errorf("Refresh after Trim left generation %d: %v", other.Generation(), err)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	fs := NewMemFS()
+	opts := &Options{FS: fs, SealReadOnly: true}
+	path := "/tmp/memfs/test.tsj"
+	j, err := CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("MemFS journal is on disk: %v", err)
+	}
+	if _, err = CreateWithOptions(path, 60, NewInt64ValueType(), nil, opts); !os.IsExist(err) {
+		t.Errorf("Created over an existing MemFS journal: %v", err)
+	}
+
+	epoch := int64(1449240540)
+	j.Write(epoch, Int64Values{0, 1, 2, 3, 4, 5})
+	if _, err = j.Trim(epoch+120, false); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := j.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch+360, Int64Values{6})
+	j.Seal()
+	j.Close()
+	if fmt.Sprint(fs.Files()) != "["+path+"]" {
+		t.Errorf("MemFS holds %v", fs.Files())
+	}
+
+	j, err = OpenWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	data, _ := j.ReadRange(epoch, epoch+600)
+	if j.Epoch() != epoch+120 || !metaEq(data.(Int64Values), Int64Values{2, 3, 4, 5, 6}) {
+		t.Errorf("MemFS journal read %v from %d", data, j.Epoch())
+	}
+	if !j.readonly {
+		t.Errorf("Sealed read-only MemFS journal opened for writing")
+	}
+	if snap.Size() != j.base+4*8 {
+		t.Errorf("Snapshot of a MemFS journal has size %d", snap.Size())
+	}
+	snap.Close()
+	if _, err = OpenWithOptions("/tmp/memfs/missing.tsj", opts); !os.IsNotExist(err) {
+		t.Errorf("Opened a missing MemFS journal: %v", err)
+	}
+}
+
+func TestCloneJournal(t *testing.T) {
+	src, dst := "/tmp/test-clone.tsj", "/tmp/test-clone-copy.tsj"
+	os.Remove(src)
+	os.Remove(dst)
+	j, err := Create(src, 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := int64(1449240540)
+	j.Write(epoch, Int64Values{0, 1, 2})
+	if err = CloneJournal(src, dst); err != ErrLocked {
+		t.Errorf("Cloned a journal open for writing: %v", err)
+	}
+	j.Seal()
+	j.Close()
+
+	// Sealed journals are not kept locked
+	if j, err = Open(src); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if err = CloneJournal(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err = CloneJournal(src, dst); !os.IsExist(err) {
+		t.Errorf("Cloned over an existing file: %v", err)
+	}
+
+	c, err := Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	data, _ := c.ReadRange(epoch, epoch+600)
+	if !c.Sealed() || !metaEq(data.(Int64Values), Int64Values{0, 1, 2}) {
+		t.Errorf("Clone holds %v, sealed %v", data, c.Sealed())
+	}
+}
+
+func TestPeek(t *testing.T) {
+	path := "/tmp/test-peek.tsj"
+	os.Remove(path)
+	j, err := Create(path, 60, NewInt64ValueType(), []int64{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	j.Write(1449240540, Int64Values{1, 2, 3})
+	j.Sync()
+
+	// The writer's lock does not stop Peek
+	header, err := Peek(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := os.Stat(path)
+	points := (stat.Size() - header.DataOffset()) / int64(header.Width)
+	if header.Epoch != 1449240540 || header.Interval != 60 || header.Meta[0] != 7 || points != 3 {
+		t.Errorf("Peek read %+v with %d points", header, points)
+	}
+
+	os.WriteFile("/tmp/test-peek.txt", []byte("not a journal"), 0644)
+	if _, err = Peek("/tmp/test-peek.txt"); err == nil {
+		t.Errorf("Peeked at a file that is not a journal")
+	}
+}
@@ -0,0 +1,113 @@
+package timeseries
+
+import (
+	"errors"
+	"fmt"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// ErrTxDone is returned by operations on a Tx that has already been
+// committed or rolled back.
+var ErrTxDone = errors.New("Transaction has already been committed or rolled back")
+
+// Tx collects Writes to a journal in memory so that they are applied
+// together by Commit or not at all.
+type Tx struct {
+	ts     *FileJournal
+	writes []txWrite
+	done   bool
+}
+
+type txWrite struct {
+	timestamp int64
+	data      []byte
+}
+
+// Begin starts a transaction on the journal.  Nothing is locked or
+// written until Commit.
+func (ts *FileJournal) Begin() *Tx {
+	return &Tx{ts: ts}
+}
+
+// Write records values to be written at timestamp by Commit.  Later
+// Writes replace earlier ones where they overlap.  The values are copied
+// so the caller may reuse them.
+func (tx *Tx) Write(timestamp int64, values Values) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	data := values.Encode()
+	if width := int(tx.ts.header.Width); len(data)%width != 0 {
+		return fmt.Errorf("Values of width %d cannot be written to a journal of width %d",
+			len(data)/values.Len(), width)
+	}
+	tx.writes = append(tx.writes, txWrite{adjust(timestamp, tx.ts.header.Interval), data})
+	return nil
+}
+
+// Rollback discards the transaction's Writes.
+func (tx *Tx) Rollback() {
+	tx.done = true
+	tx.writes = nil
+}
+
+// Commit applies the transaction's Writes with a single Write covering
+// the span from the earliest to the latest written value.  Values in the
+// span that were not written keep what is stored, or are null if they
+// are past the end of the journal, so Writes far apart make Commit
+// rewrite everything between them.  If any Write cannot be applied, for
+// example because it is before the journal's epoch or fails Validation,
+// nothing is written.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	ts := tx.ts
+	if err := ts.begin(true); err != nil {
+		return err
+	}
+	defer ts.end()
+
+	interval, width := ts.header.Interval, int64(ts.header.Width)
+	start, end := tx.writes[0].timestamp, int64(0)
+	for _, w := range tx.writes {
+		start = min(start, w.timestamp)
+		end = max(end, w.timestamp+int64(len(w.data))/width*interval)
+	}
+	if ts.header.Epoch != 0 && start < ts.header.Epoch {
+		return fmt.Errorf("Time stamp is before journal epoch")
+	}
+
+	// Start from what is stored in the span, then apply the Writes
+	span := make([]byte, (end-start)/interval*width)
+	stored := int64(0)
+	if ts.header.Epoch != 0 {
+		first := (start - ts.header.Epoch) / interval
+		stored = max(0, min(ts.points-first, int64(len(span))/width)) * width
+		if stored > 0 {
+			off := ts.base + first*width
+			if _, err := ts.fd.ReadAt(span[:stored], off); err != nil {
+				return err
+			}
+			ts.fillHoles(span[:stored], off)
+		}
+	}
+	null := ts.factory.Null()
+	for i := stored; i < int64(len(span)); i += width {
+		copy(span[i:], null)
+	}
+	for _, w := range tx.writes {
+		copy(span[(w.timestamp-start)/interval*width:], w.data)
+	}
+
+	tx.writes = nil
+	return ts.write(start, ts.factory.Decode(span))
+}
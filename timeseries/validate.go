@@ -0,0 +1,199 @@
+package timeseries
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/stats"
+)
+
+// Policy selects what Write does with values that fail a Validation.
+type Policy int
+
+const (
+	// Reject fails the whole Write with a *ValidationError.  Nothing is
+	// written.
+	Reject Policy = iota
+
+	// Clamp replaces values outside Min and Max with the nearest bound
+	// and values that break Monotonic with the value before them.
+	Clamp
+
+	// NullOut replaces offending values with nulls.
+	NullOut
+)
+
+// Validation checks the values of numeric journals as they are written.
+// Each check that fires increments its counter, so a Validation shared by
+// several journals counts for all of them.
+type Validation struct {
+	// Min and Max, if set, bound the values.
+	Min, Max *float64
+
+	// RejectNull fails Writes containing nulls, whatever the Policy.
+	// Int64 journals store NaN given to writers such as WriteFloat64Map
+	// as nulls which makes this the way to refuse NaN.
+	RejectNull bool
+
+	// Monotonic requires each value to be no smaller than the non-null
+	// value before it, including the value already stored before the
+	// written range, as for counters.
+	Monotonic bool
+
+	// Policy is applied to values that fail Min, Max, or Monotonic.
+	Policy Policy
+
+	// BelowMin, AboveMax, Null, and Decrease count the values that
+	// failed each check.
+	BelowMin stats.Counter
+	AboveMax stats.Counter
+	Null     stats.Counter
+	Decrease stats.Counter
+}
+
+// ValidationError is returned by Write when a value fails a Validation.
+type ValidationError struct {
+	Path      string
+	Timestamp int64
+	Value     float64
+	Reason    string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid value %g at %d (%s): %s",
+		e.Value, e.Timestamp, e.Reason, e.Path)
+}
+
+// validate applies Options.Validation to values about to be written at
+// timestamp and returns the values to write.  The caller's values are
+// never modified.
+func (ts *FileJournal) validate(timestamp int64, values Values) (Values, error) {
+	v := ts.opts.Validation
+	if v == nil {
+		return values, nil
+	}
+	switch values.(type) {
+	case Float64Values, Float32Values, Int64Values:
+	default:
+		return values, nil
+	}
+	converted, err := convertValues(values, nil, NewFloat64ValueType(), RoundNearest)
+	if err != nil {
+		return nil, err
+	}
+	floats := []float64(converted.(Float64Values))
+
+	prev := math.NaN()
+	if v.Monotonic {
+		prev = ts.previous(timestamp)
+	}
+	var fixed Values
+	for i, x := range floats {
+		reason, bound := "", math.NaN()
+		switch {
+		case math.IsNaN(x):
+			if v.RejectNull {
+				v.Null.Add(1)
+				return nil, ts.invalid(timestamp, i, x, "null")
+			}
+			continue
+		case v.Min != nil && x < *v.Min:
+			v.BelowMin.Add(1)
+			reason, bound = "below minimum", *v.Min
+		case v.Max != nil && x > *v.Max:
+			v.AboveMax.Add(1)
+			reason, bound = "above maximum", *v.Max
+		case v.Monotonic && x < prev:
+			v.Decrease.Add(1)
+			reason, bound = "decreasing", prev
+		}
+
+		if reason != "" {
+			switch v.Policy {
+			case Reject:
+				return nil, ts.invalid(timestamp, i, x, reason)
+			case Clamp:
+				x = bound
+			default:
+				x = math.NaN()
+			}
+			if fixed == nil {
+				fixed = cloneValues(values)
+			}
+			setFloat(fixed, i, x)
+		}
+		if !math.IsNaN(x) {
+			prev = x
+		}
+	}
+
+	if fixed != nil {
+		return fixed, nil
+	}
+	return values, nil
+}
+
+func (ts *FileJournal) invalid(timestamp int64, i int, x float64, reason string) error {
+	return &ValidationError{
+		Path:      ts.fd.Name(),
+		Timestamp: timestamp + int64(i)*ts.header.Interval,
+		Value:     x,
+		Reason:    reason,
+	}
+}
+
+// previous returns the stored value at the interval before timestamp, or
+// NaN if it is null or not stored.
+func (ts *FileJournal) previous(timestamp int64) float64 {
+	before := timestamp - ts.header.Interval
+	if ts.header.Epoch == 0 || before < ts.header.Epoch || before > ts.Last() {
+		return math.NaN()
+	}
+	buf := make([]byte, ts.header.Width)
+	off := ts.base + offset(ts, before)
+	if _, err := ts.fd.ReadAt(buf, off); err != nil {
+		return math.NaN()
+	}
+	ts.fillHoles(buf, off)
+	if bytes.Equal(buf, ts.factory.Null()) {
+		return math.NaN()
+	}
+	converted, err := convertValues(ts.factory.Decode(buf), nil, NewFloat64ValueType(), RoundNearest)
+	if err != nil {
+		return math.NaN()
+	}
+	return converted.(Float64Values)[0]
+}
+
+// cloneValues returns a copy of numeric values.
+func cloneValues(values Values) Values {
+	switch v := values.(type) {
+	case Float64Values:
+		return append(Float64Values(nil), v...)
+	case Float32Values:
+		return append(Float32Values(nil), v...)
+	case Int64Values:
+		return append(Int64Values(nil), v...)
+	}
+	return values
+}
+
+// setFloat stores x, or null if x is NaN, at index i of numeric values.
+func setFloat(values Values, i int, x float64) {
+	switch v := values.(type) {
+	case Float64Values:
+		v[i] = x
+	case Float32Values:
+		v[i] = float32(x)
+	case Int64Values:
+		if math.IsNaN(x) {
+			v[i] = math.MinInt64
+		} else {
+			v[i] = int64(x)
+		}
+	}
+}
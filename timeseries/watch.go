@@ -0,0 +1,116 @@
+package timeseries
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrWatchUnsupported is returned by NewWatcher on platforms without
+// inotify.
+var ErrWatchUnsupported = errors.New("Watching journals is not supported on this platform")
+
+// Watcher keeps journals, typically long-lived read-only ones in a query
+// daemon, up to date with values appended by other processes.  When a
+// watched journal's file changes it is marked stale and its header and
+// size are reloaded, as by Refresh, at the start of its next operation
+// or call to Epoch, Last, or Points.  Reloading lazily keeps a journal
+// owned by a single goroutine even though events arrive on another.
+type Watcher struct {
+	// OnError, if set, is called with errors reading events.
+	OnError func(err error)
+
+	mu   sync.Mutex
+	w    *watcher                         // platform implementation
+	js   map[string]map[*FileJournal]bool // journals by path
+	dirs map[string]int                   // watched paths by directory
+}
+
+// NewWatcher starts a Watcher.  Close must be called to release it.
+func NewWatcher() (*Watcher, error) {
+	w := &Watcher{
+		js:   make(map[string]map[*FileJournal]bool),
+		dirs: make(map[string]int),
+	}
+	impl, err := newWatcher(w)
+	if err != nil {
+		return nil, err
+	}
+	w.w = impl
+	return w, nil
+}
+
+// Add starts watching the file of the given journal.
+func (w *Watcher) Add(j *FileJournal) error {
+	path, err := filepath.Abs(j.fd.Name())
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.js[path] == nil {
+		// Watch the directory so that files replaced by rename are seen
+		dir := filepath.Dir(path)
+		if w.dirs[dir] == 0 {
+			if err = w.w.add(dir); err != nil {
+				return err
+			}
+		}
+		w.dirs[dir]++
+		w.js[path] = make(map[*FileJournal]bool)
+	}
+	w.js[path][j] = true
+	return nil
+}
+
+// Remove stops watching the given journal.  It must be called before the
+// journal is closed.
+func (w *Watcher) Remove(j *FileJournal) {
+	path, err := filepath.Abs(j.fd.Name())
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.js[path][j] {
+		return
+	}
+	delete(w.js[path], j)
+	if len(w.js[path]) > 0 {
+		return
+	}
+	delete(w.js, path)
+
+	// The directory is watched until its last path is removed
+	dir := filepath.Dir(path)
+	w.dirs[dir]--
+	if w.dirs[dir] == 0 {
+		delete(w.dirs, dir)
+		if err = w.w.remove(dir); err != nil {
+			w.error(err)
+		}
+	}
+}
+
+// Close stops the Watcher.
+func (w *Watcher) Close() error {
+	return w.w.close()
+}
+
+// changed marks every journal watching path as stale.
+func (w *Watcher) changed(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for j := range w.js[path] {
+		atomic.StoreInt32(&j.stale, 1)
+	}
+}
+
+func (w *Watcher) error(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
@@ -0,0 +1,109 @@
+package timeseries
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// watchMask selects the directory events that may change a journal:
+// appends, and files renamed or created over a journal's path.
+const watchMask = syscall.IN_MODIFY | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// watcher is the inotify implementation of Watcher.
+type watcher struct {
+	fd  *os.File
+	raw int // fd.Fd() would switch the descriptor to blocking mode
+
+	mu   sync.Mutex
+	dirs map[int32]string // directories by watch descriptor
+	done chan struct{}
+}
+
+func newWatcher(w *Watcher) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	impl := &watcher{
+		// A non-blocking descriptor uses the runtime poller so that
+		// Close interrupts a pending Read.
+		fd:   os.NewFile(uintptr(fd), "inotify"),
+		raw:  fd,
+		dirs: make(map[int32]string),
+		done: make(chan struct{}),
+	}
+	go impl.run(w)
+	return impl, nil
+}
+
+func (impl *watcher) add(dir string) error {
+	impl.mu.Lock()
+	defer impl.mu.Unlock()
+	for _, d := range impl.dirs {
+		if d == dir {
+			return nil
+		}
+	}
+	wd, err := syscall.InotifyAddWatch(impl.raw, dir, watchMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	impl.dirs[int32(wd)] = dir
+	return nil
+}
+
+func (impl *watcher) remove(dir string) error {
+	impl.mu.Lock()
+	defer impl.mu.Unlock()
+	for wd, d := range impl.dirs {
+		if d == dir {
+			delete(impl.dirs, wd)
+			if _, err := syscall.InotifyRmWatch(impl.raw, uint32(wd)); err != nil {
+				return os.NewSyscallError("inotify_rm_watch", err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+func (impl *watcher) close() error {
+	err := impl.fd.Close()
+	<-impl.done
+	return err
+}
+
+// run reads inotify events until the descriptor is closed.
+func (impl *watcher) run(w *Watcher) {
+	defer close(impl.done)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := impl.fd.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.error(err)
+			}
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			impl.mu.Lock()
+			dir, ok := impl.dirs[ev.Wd]
+			impl.mu.Unlock()
+			if !ok || ev.Len == 0 {
+				continue
+			}
+			name = bytes.TrimRight(name, "\x00")
+			w.changed(filepath.Join(dir, string(name)))
+		}
+	}
+}
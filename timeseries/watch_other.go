@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package timeseries
+
+// watcher has no implementation without inotify.
+type watcher struct{}
+
+func newWatcher(w *Watcher) (*watcher, error) {
+	return nil, ErrWatchUnsupported
+}
+
+func (impl *watcher) add(dir string) error { return ErrWatchUnsupported }
+
+func (impl *watcher) remove(dir string) error { return ErrWatchUnsupported }
+
+func (impl *watcher) close() error { return nil }
@@ -0,0 +1,147 @@
+package timeseries
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+import (
+	. "github.com/jjneely/journal"
+)
+
+// RejectedError is returned by WriteFloat64Map and its kin when some
+// points could not be written, such as those before the journal's epoch.
+// The other points were written.
+type RejectedError struct {
+	Rejected   int     // points not written
+	Timestamps []int64 // of the points not written, in no order
+	Err        error   // the first error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%d points rejected: %s", e.Rejected, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Rejected returns the number of points that err, returned by
+// WriteFloat64Map or its kin, reports were not written: those of a
+// *RejectedError, or all of them for any other error.
+func Rejected(err error, points int) int {
+	var rejected *RejectedError
+	if err == nil {
+		return 0
+	} else if errors.As(err, &rejected) {
+		return rejected.Rejected
+	}
+	return points
+}
+
+// WriteFloat64Map writes points keyed by timestamp to a float64 journal
+// with one Write call per run of consecutive intervals.  Timestamps are
+// aligned to the journal's interval; when several fall in the same
+// interval the value with the latest timestamp is written.  A run that
+// cannot be written is retried an interval at a time so that only the
+// points that are refused are lost, and those are reported by a
+// *RejectedError.
+func WriteFloat64Map(j Journal, points map[int64]float64) error {
+	timestamps := make([]int64, 0, len(points))
+	for ts := range points {
+		timestamps = append(timestamps, ts)
+	}
+	return writeRuns(j, timestamps, func(run []int64) Values {
+		values := make(Float64Values, len(run))
+		for i, ts := range run {
+			values[i] = points[ts]
+		}
+		return values
+	})
+}
+
+// WriteFloat32Map is WriteFloat64Map for float32 journals.
+func WriteFloat32Map(j Journal, points map[int64]float64) error {
+	timestamps := make([]int64, 0, len(points))
+	for ts := range points {
+		timestamps = append(timestamps, ts)
+	}
+	return writeRuns(j, timestamps, func(run []int64) Values {
+		values := make(Float32Values, len(run))
+		for i, ts := range run {
+			values[i] = float32(points[ts])
+		}
+		return values
+	})
+}
+
+// WriteInt64Map is WriteFloat64Map for int64 journals.
+func WriteInt64Map(j Journal, points map[int64]int64) error {
+	timestamps := make([]int64, 0, len(points))
+	for ts := range points {
+		timestamps = append(timestamps, ts)
+	}
+	return writeRuns(j, timestamps, func(run []int64) Values {
+		values := make(Int64Values, len(run))
+		for i, ts := range run {
+			values[i] = points[ts]
+		}
+		return values
+	})
+}
+
+// writeRuns aligns timestamps to the journal's interval, keeping the
+// latest timestamp in each interval, and groups them into runs of
+// consecutive intervals.  For each run build is given the original
+// timestamps and returns the values to write at the start of the run.
+// Runs that fail are written again an interval at a time.
+func writeRuns(j Journal, timestamps []int64, build func(run []int64) Values) error {
+	interval := j.Interval()
+	latest := make(map[int64]int64, len(timestamps))
+	members := make(map[int64][]int64, len(timestamps)) // timestamps in each slot
+	for _, ts := range timestamps {
+		slot := adjust(ts, interval)
+		if prev, ok := latest[slot]; !ok || ts > prev {
+			latest[slot] = ts
+		}
+		members[slot] = append(members[slot], ts)
+	}
+	slots := make([]int64, 0, len(latest))
+	for slot := range latest {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(a, b int) bool { return slots[a] < slots[b] })
+
+	var rejected RejectedError
+	run := make([]int64, 0, len(slots))
+	for start := 0; start < len(slots); {
+		end := start + 1
+		for end < len(slots) && slots[end] == slots[end-1]+interval {
+			end++
+		}
+		run = run[:0]
+		for _, slot := range slots[start:end] {
+			run = append(run, latest[slot])
+		}
+		if err := j.Write(slots[start], build(run)); err != nil {
+			for i, slot := range slots[start:end] {
+				if end-start > 1 {
+					err = j.Write(slot, build(run[i:i+1]))
+				}
+				if err != nil {
+					rejected.Rejected += len(members[slot])
+					rejected.Timestamps = append(rejected.Timestamps, members[slot]...)
+					if rejected.Err == nil {
+						rejected.Err = err
+					}
+				}
+			}
+		}
+		start = end
+	}
+	if rejected.Err != nil {
+		return &rejected
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Package typed wraps the journals of numeric values so that they are read
+// and written as slices of their Go type, rather than as Values that must
+// be type asserted.
+package typed
+
+import (
+	"fmt"
+	"math"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/timeseries"
+)
+
+// Number is the constraint satisfied by the types of values that typed
+// journals hold.
+type Number interface {
+	float64 | float32 | int64
+}
+
+// Journal is a FileJournal of values of type T.  Every FileJournal method
+// other than Read, ReadRange, and Write is available unchanged.
+type Journal[T Number] struct {
+	*timeseries.FileJournal
+}
+
+// Factory returns the ValueType of journals of T.
+func Factory[T Number]() ValueType {
+	var zero T
+	switch any(zero).(type) {
+	case float64:
+		return NewFloat64ValueType()
+	case float32:
+		return NewFloat32ValueType()
+	default:
+		return NewInt64ValueType()
+	}
+}
+
+// Create creates a journal of T at path as timeseries.CreateWithOptions
+// does.
+func Create[T Number](path string, interval int64, meta []int64, opts *timeseries.Options) (*Journal[T], error) {
+	j, err := timeseries.CreateWithOptions(path, interval, Factory[T](), meta, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal[T]{j}, nil
+}
+
+// Open opens the journal at path as timeseries.OpenWithOptions does.  It
+// is an error if the journal does not hold values of type T.
+func Open[T Number](path string, opts *timeseries.Options) (*Journal[T], error) {
+	j, err := timeseries.OpenWithOptions(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	t, err := Wrap[T](j)
+	if err != nil {
+		j.Close()
+	}
+	return t, err
+}
+
+// CreateOrOpen opens the journal of T at path, creating it if it does not
+// exist, as timeseries.CreateOrOpen does.
+func CreateOrOpen[T Number](path string, interval int64, meta []int64, opts *timeseries.Options) (*Journal[T], error) {
+	j, err := timeseries.CreateOrOpen(path, interval, Factory[T](), meta, opts)
+	if err != nil {
+		return nil, err
+	}
+	t, err := Wrap[T](j)
+	if err != nil {
+		j.Close()
+	}
+	return t, err
+}
+
+// Wrap returns j as a Journal of T, or an error if j does not hold values
+// of type T.
+func Wrap[T Number](j *timeseries.FileJournal) (*Journal[T], error) {
+	want := Factory[T]()
+	if t := j.Factory().Type(); t != want.Type() {
+		return nil, fmt.Errorf("Journal of type %#x does not hold %T values", t, *new(T))
+	}
+	return &Journal[T]{j}, nil
+}
+
+// Read returns up to n values starting at timestamp as
+// FileJournal.Read does.
+func (j *Journal[T]) Read(timestamp int64, n int) ([]T, error) {
+	values, err := j.FileJournal.Read(timestamp, n)
+	return slice[T](values), err
+}
+
+// ReadRange returns the values for the timestamps from through until
+// inclusive as FileJournal.ReadRange does.
+func (j *Journal[T]) ReadRange(from, until int64) ([]T, error) {
+	values, err := j.FileJournal.ReadRange(from, until)
+	return slice[T](values), err
+}
+
+// Write writes values for the sequential timestamps starting at timestamp
+// as FileJournal.Write does.
+func (j *Journal[T]) Write(timestamp int64, values []T) error {
+	return j.FileJournal.Write(timestamp, ToValues(values))
+}
+
+// Null returns the null value of journals of T: NaN for floats and
+// math.MinInt64 for int64.
+func Null[T Number]() T {
+	var null T
+	switch p := any(&null).(type) {
+	case *float64:
+		*p = math.NaN()
+	case *float32:
+		*p = float32(math.NaN())
+	case *int64:
+		*p = math.MinInt64
+	}
+	return null
+}
+
+// IsNull reports whether v is the null value of journals of T.
+func IsNull[T Number](v T) bool {
+	// NaN is the only value not equal to itself
+	return v != v || v == Null[T]()
+}
+
+// ToValues returns values as the Values of their type without copying.
+func ToValues[T Number](values []T) Values {
+	switch v := any(values).(type) {
+	case []float64:
+		return Float64Values(v)
+	case []float32:
+		return Float32Values(v)
+	default:
+		return Int64Values(v.([]int64))
+	}
+}
+
+// slice returns values, which must hold T, as a slice without copying.
+func slice[T Number](values Values) []T {
+	switch v := values.(type) {
+	case Float64Values:
+		return any([]float64(v)).([]T)
+	case Float32Values:
+		return any([]float32(v)).([]T)
+	case Int64Values:
+		return any([]int64(v)).([]T)
+	}
+	return nil
+}
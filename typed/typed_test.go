@@ -0,0 +1,43 @@
+package typed
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestTyped(t *testing.T) {
+	path := "/tmp/test-typed.tsj"
+	os.Remove(path)
+	j, err := Create[int64](path, 60, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Write(60, []int64{1, 2, math.MinInt64, 4}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := j.ReadRange(120, 240)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[2 -9223372036854775808 4]" || !IsNull(got[1]) || IsNull(got[0]) {
+		t.Errorf("Typed journal contains %v", got)
+	}
+	if got, _ = j.Read(60, 1); fmt.Sprint(got) != "[1]" || j.Last() != 240 {
+		t.Errorf("Typed journal read %v ending at %d", got, j.Last())
+	}
+	j.Close()
+
+	if _, err = Open[float64](path, nil); err == nil {
+		t.Errorf("Opened a journal of int64 as float64")
+	}
+	f, err := CreateOrOpen[float32]("/tmp/test-typed-float32.tsj", 60, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if !IsNull(Null[float32]()) || IsNull[float32](0) {
+		t.Errorf("Null float32 is %v", Null[float32]())
+	}
+}
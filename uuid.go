@@ -0,0 +1,156 @@
+package journal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+var (
+	_ ValueType = (*UUIDValueType)(nil)
+	_ Values    = UUIDValues(nil)
+)
+
+// UUIDValueType implements ValueType for 16 byte identifiers such as
+// UUIDs, for journals that record which deployment or configuration was
+// active during each interval.  The all zero identifier is null.
+type UUIDValueType struct {
+	null []byte
+}
+
+// NewUUIDValueType is a constructor for a new UUIDValueType factory and
+// is equivalent to new(UUIDValueType).
+func NewUUIDValueType() *UUIDValueType {
+	return &UUIDValueType{}
+}
+
+// Width is always 16 bytes for UUID values.
+func (t *UUIDValueType) Width() int32 {
+	return 16
+}
+
+// Type returns the type encoding as stored on disk
+func (t *UUIDValueType) Type() int32 {
+	return 0x40
+}
+
+// Null returns 16 zero bytes.
+func (t *UUIDValueType) Null() []byte {
+	if t.null == nil {
+		t.null = make([]byte, 16)
+	}
+	return t.null
+}
+
+// Decode takes a byte slice presumably read from disk and copies it into
+// a slice of UUIDs.  Bytes after the last whole UUID are ignored.
+func (t *UUIDValueType) Decode(buffer []byte) Values {
+	ids := make(UUIDValues, len(buffer)/16)
+	for i := range ids {
+		copy(ids[i][:], buffer[i*16:])
+	}
+	return ids
+}
+
+// DecodeStream reads and decodes n UUIDs from r.
+func (t *UUIDValueType) DecodeStream(r io.Reader, n int) (Values, error) {
+	values := make(UUIDValues, 0, n)
+	err := readChunks(r, n, t.Width(), func(chunk []byte) {
+		values = append(values, t.Decode(chunk).(UUIDValues)...)
+	})
+	return values, err
+}
+
+// UUIDValues implements Values and wraps a slice of UUIDs.
+type UUIDValues []UUID
+
+// Encode returns the UUIDs one after the other.
+func (v UUIDValues) Encode() []byte {
+	return v.EncodeTo(make([]byte, 0, len(v)*16))
+}
+
+// EncodeTo appends each UUID to dst.
+func (v UUIDValues) EncodeTo(dst []byte) []byte {
+	for i := range v {
+		dst = append(dst, v[i][:]...)
+	}
+	return dst
+}
+
+// WriteTo writes each UUID to w.
+func (v UUIDValues) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, len(v), func(dst []byte, i, j int) []byte {
+		return v[i:j].EncodeTo(dst)
+	})
+}
+
+// Len returns the number of UUIDs.
+func (v UUIDValues) Len() int {
+	return len(v)
+}
+
+// Slice returns the UUIDs from index i up to j without copying.
+func (v UUIDValues) Slice(i, j int) Values {
+	return v[i:j]
+}
+
+// Strings returns the canonical form of each UUID, or "" for nulls.
+func (v UUIDValues) Strings() []string {
+	s := make([]string, len(v))
+	for i, id := range v {
+		if !id.IsNull() {
+			s[i] = id.String()
+		}
+	}
+	return s
+}
+
+// UUID is a 16 byte identifier.
+type UUID [16]byte
+
+// ParseUUID parses the canonical form of a UUID, 32 hexadecimal digits
+// in groups of 8, 4, 4, 4, and 12 separated by hyphens, in either case.
+func ParseUUID(s string) (UUID, error) {
+	var id UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return id, fmt.Errorf("Invalid UUID: %q", s)
+	}
+	digits := s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(id[:], []byte(digits)); err != nil {
+		return id, fmt.Errorf("Invalid UUID: %q", s)
+	}
+	return id, nil
+}
+
+// IsNull reports whether every byte of id is zero.
+func (id UUID) IsNull() bool {
+	return id == UUID{}
+}
+
+// String returns the canonical lower case form of id.
+func (id UUID) String() string {
+	buf := make([]byte, 0, 36)
+	for i, group := range [][]byte{id[:4], id[4:6], id[6:8], id[8:10], id[10:]} {
+		if i > 0 {
+			buf = append(buf, '-')
+		}
+		buf = hex.AppendEncode(buf, group)
+	}
+	return string(buf)
+}
+
+// MarshalText implements encoding.TextMarshaler with the canonical form
+// so that UUIDs are JSON strings.
+func (id UUID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler as ParseUUID does.
+func (id *UUID) UnmarshalText(text []byte) error {
+	parsed, err := ParseUUID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
@@ -0,0 +1,40 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestUUID(t *testing.T) {
+	s := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	id, err := ParseUUID("6BA7B810-9DAD-11D1-80B4-00C04FD430C8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != s || id.IsNull() {
+		t.Errorf("Parsed UUID is %s", id)
+	}
+	for _, bad := range []string{"", s[:35], s + "0", "6ba7b8109dad11d180b400c04fd430c8",
+		"6ba7b810-9dad-11d1-80b4_00c04fd430c8", "6ba7b810-9dad-11d1-80b4-00c04fd430cg",
+		"{6ba7b810-9dad-11d1-80b4-00c04fd430c}"} {
+		if _, err := ParseUUID(bad); err == nil {
+			t.Errorf("ParseUUID(%q) succeeded", bad)
+		}
+	}
+
+	vt := NewUUIDValueType()
+	values := vt.Decode(append(UUIDValues{id}.Encode(), vt.Null()...)).(UUIDValues)
+	if fmt.Sprint(values.Strings()) != "["+s+" ]" || !values[1].IsNull() {
+		t.Errorf("Decoded UUIDs are %q", values.Strings())
+	}
+
+	buf, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back UUIDValues
+	if err = json.Unmarshal(buf, &back); err != nil || fmt.Sprint(back) != fmt.Sprint(values) {
+		t.Errorf("JSON %s decoded to %v: %v", buf, back, err)
+	}
+}
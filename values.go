@@ -2,8 +2,13 @@ package journal
 
 import (
 	"bytes"
+	"io"
 )
 
+// streamChunk is the number of values DecodeStream and WriteTo encode or
+// decode at a time.
+const streamChunk = 4096
+
 // ValueType is an interface that defines the characteristics of a specific
 // type of value and can convert a byte slice into a slice of Value.
 type ValueType interface {
@@ -24,6 +29,12 @@ type ValueType interface {
 	// Width() bytes and returns a Values interface representing a slice
 	// of values of the encoded data type.
 	Decode(buffer []byte) Values
+
+	// DecodeStream reads and decodes n values from r without buffering
+	// all of their encoded bytes at once.  If r ends early the values
+	// read are returned with io.ErrUnexpectedEOF, or io.EOF if there
+	// were none.
+	DecodeStream(r io.Reader, n int) (Values, error)
 }
 
 // Values is an interface that represents an underlying slice of some
@@ -38,8 +49,20 @@ type Values interface {
 	// a fixed width as defined by the matching ValueType struct.
 	Encode() []byte
 
+	// EncodeTo appends the encoded values to dst and returns the extended
+	// slice, allowing callers to reuse buffers between writes.
+	EncodeTo(dst []byte) []byte
+
+	// WriteTo writes the encoded values to w in chunks rather than
+	// encoding them all into one byte slice.  It implements io.WriterTo.
+	WriteTo(w io.Writer) (int64, error)
+
 	// Len returns the length of the underlying slice.
 	Len() int
+
+	// Slice returns the values from index i up to but not including j.
+	// The result shares storage with the receiver as a Go slice would.
+	Slice(i, j int) Values
 }
 
 // GetValueType takes an integer encoding of a type and width as stored on
@@ -66,9 +89,60 @@ func GetValueType(t, w int32) ValueType {
 	case 0x11:
 		// int64 8 byte wide implementation, Null = MinInt64
 		return NewInt64ValueType()
+	case 0x12:
+		// 4 byte wide float32 records
+		return NewFloat32ValueType()
+	case 0x20:
+		// histograms of 4 byte wide bucket counts
+		return NewHistogramValueType(int(w / 4))
+	case 0x21:
+		// DDSketches of 4 byte wide bins after their header
+		return NewSketchValueType(int((w - sketchHeader) / 4))
+	case 0x30:
+		// bitmasks with every bit set as null
+		return NewBitfieldValueType(w)
+	case 0x40:
+		// 16 byte UUIDs with the all zero UUID as null
+		return NewUUIDValueType()
 	}
 
 	// We should not be here
 	panic("Unimplemented journal data type")
+}
+
+// readChunks reads n values of the given width from r, streamChunk values
+// at a time, passing each chunk of encoded values to fn.
+func readChunks(r io.Reader, n int, width int32, fn func(chunk []byte)) error {
+	w := int(width)
+	buf := make([]byte, w*min(n, streamChunk))
+	for read := 0; read < n; {
+		count := min(n-read, streamChunk)
+		got, err := io.ReadFull(r, buf[:count*w])
+		if got/w > 0 {
+			fn(buf[:got/w*w])
+		}
+		read += got / w
+		if err == io.ErrUnexpectedEOF || (err == io.EOF && read > 0) {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// writeChunks writes n values to w, encoding streamChunk values at a time
+// with encode, which appends the values from index i up to j to dst.
+func writeChunks(w io.Writer, n int, encode func(dst []byte, i, j int) []byte) (int64, error) {
+	var buf []byte
+	var total int64
+	for i := 0; i < n; i += streamChunk {
+		buf = encode(buf[:0], i, min(n, i+streamChunk))
+		written, err := w.Write(buf)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,173 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+)
+
+// conformance lists a sample of every Values implementation with the
+// ValueType that decodes it.
+var conformance = []struct {
+	factory ValueType
+	values  Values
+}{
+	{NewFloat64ValueType(), Float64Values{1.5, math.Inf(1), -2, 0}},
+	{NewInt64ValueType(), Int64Values{1, math.MinInt64, -2, 0}},
+	{NewFloat32ValueType(), Float32Values{1.5, float32(math.Inf(1)), -2, 0}},
+	{NewByteValueType(2, nil), ByteValues{[]byte("AA"), []byte("BB"), []byte("CC"), []byte("DD")}},
+	{GetValueType(0x00, 4), ByteValues{[]byte("abcd"), []byte("NULL"), []byte("efgh"), []byte("ijkl")}},
+	{NewHistogramValueType(3), HistogramValues{{1, 2, 3}, NullHistogram(3), {0, 0, 0}, {4, 5, 6}}},
+	{NewSketchValueType(2), SketchValues{NullSketch(2), *NewSketch(0.02, 2), NullSketch(2), *NewSketch(0, 2)}},
+	{NewBitfieldValueType(2), BitfieldValues{NewBitmask(2, 0, 9), NullBitmask(2), NewBitmask(2), NewBitmask(2, 15)}},
+	{NewUUIDValueType(), UUIDValues{{1, 2, 3}, {}, {0xff}, {15: 7}}},
+}
+
+func TestValuesConformance(t *testing.T) {
+	for _, c := range conformance {
+		raw := c.values.Encode()
+		if len(raw) != c.values.Len()*int(c.factory.Width()) {
+			t.Errorf("%T encoded %d values to %d bytes", c.values, c.values.Len(), len(raw))
+		}
+		if len(c.factory.Null()) != int(c.factory.Width()) {
+			t.Errorf("%T null is %d bytes wide", c.factory, len(c.factory.Null()))
+		}
+		if GetValueType(c.factory.Type(), c.factory.Width()).Type() != c.factory.Type() {
+			t.Errorf("%T type %#x does not round trip", c.factory, c.factory.Type())
+		}
+
+		prefix := []byte("prefix")
+		if !bytes.Equal(c.values.EncodeTo(prefix), append(prefix, raw...)) {
+			t.Errorf("%T EncodeTo does not append the encoding", c.values)
+		}
+
+		decoded := c.factory.Decode(raw)
+		if decoded.Len() != c.values.Len() || !bytes.Equal(decoded.Encode(), raw) {
+			t.Errorf("%T does not round trip through Decode", c.values)
+		}
+
+		sub := c.values.Slice(1, 3)
+		if sub.Len() != 2 {
+			t.Errorf("%T Slice(1, 3) has length %d", c.values, sub.Len())
+		}
+		width := int(c.factory.Width())
+		if !bytes.Equal(sub.Encode(), raw[width:3*width]) {
+			t.Errorf("%T Slice(1, 3) encoded to %v", c.values, sub.Encode())
+		}
+		if c.values.Slice(0, 0).Len() != 0 || len(c.values.Slice(0, 0).Encode()) != 0 {
+			t.Errorf("%T empty Slice is not empty", c.values)
+		}
+	}
+}
+
+func TestSliceShares(t *testing.T) {
+	f := Float64Values{1, 2, 3}
+	f.Slice(1, 2).(Float64Values)[0] = 20
+	i := Int64Values{1, 2, 3}
+	i.Slice(1, 2).(Int64Values)[0] = 20
+	if f[1] != 20 || i[1] != 20 {
+		t.Errorf("Slice copied the underlying values")
+	}
+}
+
+func TestStreams(t *testing.T) {
+	for _, c := range conformance {
+		var buf bytes.Buffer
+		n, err := c.values.WriteTo(&buf)
+		if err != nil || n != int64(len(c.values.Encode())) {
+			t.Errorf("%T WriteTo wrote %d bytes: %v", c.values, n, err)
+		}
+		if !bytes.Equal(buf.Bytes(), c.values.Encode()) {
+			t.Errorf("%T WriteTo does not match Encode", c.values)
+		}
+
+		raw := buf.Bytes()
+		decoded, err := c.factory.DecodeStream(bytes.NewReader(raw), 3)
+		if err != nil || decoded.Len() != 3 ||
+			!bytes.Equal(decoded.Encode(), c.values.Slice(0, 3).Encode()) {
+			t.Errorf("%T DecodeStream of 3 values returned %v", c.values, err)
+		}
+
+		decoded, err = c.factory.DecodeStream(bytes.NewReader(raw[:len(raw)-1]), 4)
+		if err != io.ErrUnexpectedEOF || decoded.Len() != 3 {
+			t.Errorf("%T DecodeStream of a short stream returned %d values and %v",
+				c.values, decoded.Len(), err)
+		}
+		_, err = c.factory.DecodeStream(bytes.NewReader(nil), 1)
+		if err != io.EOF {
+			t.Errorf("%T DecodeStream of an empty stream returned %v", c.values, err)
+		}
+	}
+}
+
+func TestStreamChunks(t *testing.T) {
+	values := make(Int64Values, streamChunk*2+10)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	var buf bytes.Buffer
+	values.WriteTo(&buf)
+	decoded, err := NewInt64ValueType().DecodeStream(&buf, len(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decoded.(Int64Values)
+	if len(got) != len(values) || got[streamChunk] != streamChunk || got[len(got)-1] != int64(len(values)-1) {
+		t.Errorf("Streaming %d values across chunks failed", len(values))
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for _, c := range conformance {
+		buf := new(bytes.Buffer)
+		if err := gob.NewEncoder(buf).Encode(&c.values); err != nil {
+			t.Fatalf("%T gob: %s", c.values, err)
+		}
+		var decoded Values
+		if err := gob.NewDecoder(buf).Decode(&decoded); err != nil {
+			t.Fatalf("%T gob: %s", c.values, err)
+		}
+		if fmt.Sprintf("%T", decoded) != fmt.Sprintf("%T", c.values) ||
+			!bytes.Equal(decoded.Encode(), c.values.Encode()) {
+			t.Errorf("%T does not round trip through gob: %v", c.values, decoded)
+		}
+	}
+
+	var b ByteValues
+	if err := b.UnmarshalBinary([]byte{2, 1, 'a'}); err == nil {
+		t.Errorf("Truncated ByteValues decoded to %q", b)
+	}
+	var f Float64Values
+	if err := f.UnmarshalBinary(make([]byte, 12)); err == nil {
+		t.Errorf("12 bytes decoded to %v", f)
+	}
+
+	raw, err := json.Marshal(Float64Values{1.5, math.NaN(), -2})
+	if err != nil || string(raw) != "[1.5,null,-2]" {
+		t.Errorf("Float64Values JSON: %s %v", raw, err)
+	}
+	if err = json.Unmarshal(raw, &f); err != nil || fmt.Sprint(f) != "[1.5 NaN -2]" {
+		t.Errorf("Float64Values from JSON: %v %v", f, err)
+	}
+	if _, err = json.Marshal(Float64Values{math.Inf(1)}); err == nil {
+		t.Errorf("Infinity encoded to JSON")
+	}
+
+	raw, err = json.Marshal(Float32Values{0.1, float32(math.NaN())})
+	if err != nil || string(raw) != "[0.1,null]" {
+		t.Errorf("Float32Values JSON: %s %v", raw, err)
+	}
+
+	raw, err = json.Marshal(Int64Values{7, math.MinInt64})
+	if err != nil || string(raw) != "[7,null]" {
+		t.Errorf("Int64Values JSON: %s %v", raw, err)
+	}
+	var i Int64Values
+	if err = json.Unmarshal(raw, &i); err != nil || i[1] != math.MinInt64 {
+		t.Errorf("Int64Values from JSON: %v %v", i, err)
+	}
+}
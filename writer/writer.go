@@ -0,0 +1,385 @@
+// Package writer buffers incoming datapoints in memory and periodically
+// writes them to the journals of a store, grouping each series' points
+// into as few Write calls as possible.
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/ingest"
+	"github.com/jjneely/journal/stats"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const (
+	// DefaultFlushInterval is how often buffered points are written.
+	DefaultFlushInterval = 10 * time.Second
+
+	// DefaultMaxPending is the default limit on buffered points.
+	DefaultMaxPending = 1000000
+)
+
+// ErrFull is returned by Add when MaxPending points are already buffered.
+var ErrFull = errors.New("Write queue is full")
+
+// ErrClosed is returned by Add after Close.
+var ErrClosed = errors.New("Writer is closed")
+
+// Writer is an in-memory queue of points waiting to be written to a store.
+type Writer struct {
+	Store *store.Store
+
+	// FlushInterval is how often the background flusher runs.
+	FlushInterval time.Duration
+
+	// AlignFlush runs the background flusher at multiples of
+	// FlushInterval since the Unix epoch, such as on every minute for an
+	// interval of one minute, as carbon-cache does.  With FlushInterval
+	// equal to the journals' interval each interval is then written
+	// complete, just after it ends, rather than partly written at an
+	// arbitrary time.
+	AlignFlush bool
+
+	// SyncOnFlush syncs each journal to disk after a Flush writes it.
+	SyncOnFlush bool
+
+	// Clock, if set, is used in place of the system clock to schedule
+	// aligned flushes and record when flushes finish.
+	Clock clock.Clock
+
+	// MaxPending bounds the number of buffered points.
+	MaxPending int
+
+	// Workers bounds how many series are written concurrently.
+	Workers int
+
+	// OnError, if set, is called for each series whose points could not
+	// all be written.  Those points are dropped; the others are written.
+	OnError func(series string, err error)
+
+	// Propagate, if set, is called with the journal of each series just
+	// after its points are written, and those points, leaving out any
+	// that were refused, such as to update its rollup archives with
+	// rollup.Propagator.  Its errors are passed to OnError
+	// but the points still count as written.
+	Propagate func(series string, j *timeseries.FileJournal, points map[int64]float64) error
+
+	// Received, Written, and Dropped count points accepted by Add,
+	// written to journals, and lost to write errors.  Points a journal
+	// refuses, such as those before its epoch, are dropped alone.
+	Received stats.Counter
+	Written  stats.Counter
+	Dropped  stats.Counter
+
+	// Latency records the time taken to write each series.
+	Latency *stats.Histogram
+
+	mu       sync.Mutex
+	pending  map[string]map[int64]float64
+	flushing map[string]map[int64]float64 // taken by the running Flush
+	count    int
+	closed   bool
+	flushMu  sync.Mutex  // serializes flushes
+	last     FlushResult // of the last Flush
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New returns a Writer for the given store with default settings.  Call
+// Start to begin flushing in the background.
+func New(s *store.Store) *Writer {
+	return &Writer{
+		Store:         s,
+		FlushInterval: DefaultFlushInterval,
+		MaxPending:    DefaultMaxPending,
+		Workers:       store.DefaultWorkers,
+		Latency:       stats.NewHistogram(),
+		pending:       make(map[string]map[int64]float64),
+	}
+}
+
+// Add buffers a point.  A later point for the same series and timestamp
+// replaces an earlier one.  It is an ingest.Sink.
+func (w *Writer) Add(p ingest.Point) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	points, ok := w.pending[p.Series]
+	if !ok {
+		if err := store.Validate(p.Series); err != nil {
+			return err
+		}
+		points = make(map[int64]float64)
+		w.pending[p.Series] = points
+	}
+	if _, ok := points[p.Timestamp]; !ok {
+		if w.count >= w.MaxPending {
+			return ErrFull
+		}
+		w.count++
+	}
+	points[p.Timestamp] = p.Value
+	w.Received.Add(1)
+	return nil
+}
+
+// Pending returns the number of buffered points.
+func (w *Writer) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Buffered returns the points of a series from through until inclusive
+// that have been added but not yet written, including those of a Flush
+// in progress.  It is suitable for store.Store.Pending.
+func (w *Writer) Buffered(series string, from, until int64) map[int64]float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var points map[int64]float64
+	for _, buffer := range []map[string]map[int64]float64{w.flushing, w.pending} {
+		for ts, v := range buffer[series] {
+			if ts < from || ts > until {
+				continue
+			}
+			if points == nil {
+				points = make(map[int64]float64)
+			}
+			points[ts] = v
+		}
+	}
+	return points
+}
+
+// Start runs the background flusher until Close is called.
+func (w *Writer) Start() {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		timer := time.NewTimer(w.untilFlush(clock.Or(w.Clock).Now()))
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				w.Flush()
+				timer.Reset(w.untilFlush(clock.Or(w.Clock).Now()))
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+}
+
+// untilFlush returns how long after now the background flusher should
+// next run.
+func (w *Writer) untilFlush(now time.Time) time.Duration {
+	if !w.AlignFlush {
+		return w.FlushInterval
+	}
+	interval := int64(w.FlushInterval)
+	return time.Duration(interval - now.UnixNano()%interval)
+}
+
+// Close stops accepting points, stops the background flusher, and writes
+// every buffered point.
+func (w *Writer) Close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.Flush()
+}
+
+// Flush writes every buffered point to the store.
+func (w *Writer) Flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = make(map[string]map[int64]float64)
+	w.flushing = pending
+	w.count = 0
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.flushing = nil
+		w.mu.Unlock()
+	}()
+
+	workers := w.Workers
+	if workers <= 0 {
+		workers = store.DefaultWorkers
+	}
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	result := FlushResult{Series: len(pending)}
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for series := range queue {
+				start := time.Now()
+				points := pending[series]
+				err := w.write(series, points)
+				w.Latency.Since(start)
+				dropped := timeseries.Rejected(err, len(points))
+				w.Dropped.Add(int64(dropped))
+				w.Written.Add(int64(len(points) - dropped))
+				if err != nil {
+					errMu.Lock()
+					result.Failed++
+					if result.Err == nil {
+						result.Err = fmt.Errorf("%s: %s", series, err)
+					}
+					errMu.Unlock()
+					if w.OnError != nil {
+						w.OnError(series, err)
+					}
+				}
+			}
+		}()
+	}
+	for series := range pending {
+		queue <- series
+	}
+	close(queue)
+	wg.Wait()
+
+	result.Finished = clock.Or(w.Clock).Now()
+	w.mu.Lock()
+	w.last = result
+	w.mu.Unlock()
+}
+
+// FlushResult describes how a Flush went.
+type FlushResult struct {
+	Finished time.Time // zero if no Flush has finished
+	Series   int       // series with points to write
+	Failed   int       // series with points that could not be written
+	Err      error     // the first error writing a series
+}
+
+// LastFlush returns the result of the last Flush.
+func (w *Writer) LastFlush() FlushResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last
+}
+
+// CheckQueue fails when the queue is nearly full.
+func (w *Writer) CheckQueue() error {
+	pending, limit := w.Pending(), w.MaxPending
+	if pending >= limit-limit/10 {
+		return fmt.Errorf("%d of %d points buffered", pending, limit)
+	}
+	return nil
+}
+
+// CheckFlush fails when most of the series in the last Flush could not be
+// written, which points at the store rather than at the points of a few
+// series, or no Flush has finished for three FlushIntervals since the
+// last one or, before the first, since started.
+func (w *Writer) CheckFlush(started time.Time) error {
+	result := w.LastFlush()
+	if result.Failed > 0 && result.Failed*2 > result.Series {
+		return fmt.Errorf("%d of %d series failed, first %s", result.Failed, result.Series, result.Err)
+	}
+	last := result.Finished
+	if last.IsZero() {
+		last = started
+	}
+	if late := clock.Or(w.Clock).Now().Sub(last); late > 3*w.FlushInterval {
+		return fmt.Errorf("No flush for %s", late.Round(time.Second))
+	}
+	return nil
+}
+
+// write stores the points of one series using one Write per run of
+// consecutive timestamps.
+func (w *Writer) write(series string, points map[int64]float64) error {
+	start := time.Now()
+	var began time.Time
+	var width int64
+	if w.Store.Slow != nil {
+		defer func() {
+			op := store.SlowOp{Op: "write", Series: series, Points: int64(len(points)),
+				Bytes: int64(len(points)) * width, Duration: time.Since(start)}
+			for ts := range points {
+				if op.From == 0 || ts < op.From {
+					op.From = ts
+				}
+				op.Until = max(op.Until, ts)
+			}
+			if !began.IsZero() {
+				op.Wait = began.Sub(start)
+			}
+			w.Store.Slow.Observe(op)
+		}()
+	}
+	return w.Store.DoCreate(series, func(j *timeseries.FileJournal) error {
+		began = time.Now()
+		width = int64(j.Width())
+		if w.SyncOnFlush {
+			defer j.Sync()
+		}
+		var err error
+		switch j.Factory().(type) {
+		case *Float64ValueType:
+			err = timeseries.WriteFloat64Map(j, points)
+		case *Float32ValueType:
+			err = timeseries.WriteFloat32Map(j, points)
+		case *Int64ValueType:
+			ints := make(map[int64]int64, len(points))
+			for ts, v := range points {
+				if math.IsNaN(v) {
+					ints[ts] = math.MinInt64
+				} else {
+					ints[ts] = int64(v)
+				}
+			}
+			err = timeseries.WriteInt64Map(j, ints)
+		default:
+			return fmt.Errorf("Cannot write numeric values to journal type %#x",
+				j.Factory().Type())
+		}
+		if w.Propagate == nil || timeseries.Rejected(err, len(points)) == len(points) {
+			return err
+		}
+		// Only the points written are propagated
+		written := points
+		var rejected *timeseries.RejectedError
+		if errors.As(err, &rejected) {
+			written = make(map[int64]float64, len(points))
+			for ts, v := range points {
+				written[ts] = v
+			}
+			for _, ts := range rejected.Timestamps {
+				delete(written, ts)
+			}
+		}
+		if err := w.Propagate(series, j, written); err != nil && w.OnError != nil {
+			w.OnError(series, err)
+		}
+		return err
+	})
+}
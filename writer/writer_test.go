@@ -0,0 +1,262 @@
+package writer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	. "github.com/jjneely/journal"
+	"github.com/jjneely/journal/clock"
+	"github.com/jjneely/journal/ingest"
+	"github.com/jjneely/journal/store"
+	"github.com/jjneely/journal/timeseries"
+)
+
+const epoch = int64(1449240540)
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := store.New(dir)
+	s.Schema = func(series string) (int64, ValueType, error) {
+		return 60, NewInt64ValueType(), nil
+	}
+	store.NewPool(s, 10)
+	w := New(s)
+	w.MaxPending = 4
+	var propagated, failed []string
+	w.Propagate = func(series string, j *timeseries.FileJournal, points map[int64]float64) error {
+		propagated = append(propagated, fmt.Sprint(series, " ", len(points)))
+		return fmt.Errorf("No archives")
+	}
+	w.OnError = func(series string, err error) {
+		failed = append(failed, series)
+	}
+
+	for _, ts := range []int64{epoch + 180, epoch, epoch + 60, epoch + 65} {
+		if err := w.Add(ingest.Point{Series: "a.b", Timestamp: ts, Value: float64(ts - epoch)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Add(ingest.Point{Series: "a.b", Timestamp: epoch + 240, Value: 1}); err != ErrFull {
+		t.Errorf("Add beyond MaxPending returned %v", err)
+	}
+	if err := w.Add(ingest.Point{Series: "a..b", Timestamp: epoch, Value: 1}); err == nil {
+		t.Errorf("Add accepted an invalid series name")
+	}
+	w.Close()
+	if err := w.Add(ingest.Point{Series: "a.b", Timestamp: epoch, Value: 1}); err != ErrClosed {
+		t.Errorf("Add after Close returned %v", err)
+	}
+	s.Pool.Close()
+	if w.Received.Value() != 4 || w.Written.Value() != 4 || w.Dropped.Value() != 0 {
+		t.Errorf("Counted %d received, %d written, %d dropped", w.Received.Value(),
+			w.Written.Value(), w.Dropped.Value())
+	}
+	if last := w.LastFlush(); last.Finished.IsZero() || last.Series != 1 || last.Failed != 0 || last.Err != nil {
+		t.Errorf("Last flush %+v", last)
+	}
+	if fmt.Sprint(propagated) != "[a.b 4]" || fmt.Sprint(failed) != "[a.b]" {
+		t.Errorf("Propagated %v with errors for %v", propagated, failed)
+	}
+
+	results := s.ReadMany([]string{"a.b"}, epoch, epoch+600)
+	r := results["a.b"]
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	want := Int64Values{0, 65, math.MinInt64, 180}
+	got := r.Values.(Int64Values)
+	if len(got) != len(want) {
+		t.Fatalf("Wrote %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Wrote %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := store.New(dir)
+	s.Schema = func(series string) (int64, ValueType, error) {
+		return 60, NewFloat64ValueType(), nil
+	}
+	w := New(s)
+	var failed []string
+	w.OnError = func(series string, err error) {
+		failed = append(failed, series)
+	}
+	var propagated []int64
+	w.Propagate = func(series string, j *timeseries.FileJournal, points map[int64]float64) error {
+		for ts := range points {
+			propagated = append(propagated, ts-epoch)
+		}
+		return nil
+	}
+	w.Add(ingest.Point{Series: "a.b", Timestamp: epoch, Value: 1})
+	w.Flush()
+	propagated = nil
+
+	// A late point loses only itself
+	for _, ts := range []int64{epoch - 60, epoch + 60, epoch + 120} {
+		w.Add(ingest.Point{Series: "a.b", Timestamp: ts, Value: 2})
+	}
+	w.Add(ingest.Point{Series: "a.c", Timestamp: epoch, Value: 3})
+	w.Flush()
+	if w.Written.Value() != 4 || w.Dropped.Value() != 1 || fmt.Sprint(failed) != "[a.b]" {
+		t.Errorf("Counted %d written, %d dropped with errors for %v", w.Written.Value(),
+			w.Dropped.Value(), failed)
+	}
+	if last := w.LastFlush(); last.Series != 2 || last.Failed != 1 || last.Err == nil {
+		t.Errorf("Last flush %+v", last)
+	}
+	sort.Slice(propagated, func(a, b int) bool { return propagated[a] < propagated[b] })
+	if fmt.Sprint(propagated) != "[0 60 120]" {
+		t.Errorf("Propagated %v", propagated)
+	}
+	results := s.ReadMany([]string{"a.b"}, epoch, epoch+600)
+	if r := results["a.b"]; r.Err != nil || fmt.Sprint(r.Values) != "[1 2 2]" {
+		t.Errorf("Wrote %v, %v", r.Values, r.Err)
+	}
+}
+
+func TestChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := store.New(dir)
+	s.Schema = func(series string) (int64, ValueType, error) {
+		return 60, NewFloat64ValueType(), nil
+	}
+	j, err := s.Create("a", 60, NewFloat64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch, Float64Values{1})
+	j.Close()
+	now := clock.NewFake(time.Unix(epoch, 0))
+	w := New(s)
+	w.Clock = now
+	w.FlushInterval = time.Minute
+	w.MaxPending = 10
+	started := now.Now()
+
+	// Flushes are late after three intervals
+	if err = w.CheckFlush(started); err != nil {
+		t.Errorf("CheckFlush before the first flush returned %v", err)
+	}
+	now.Advance(3*time.Minute + time.Second)
+	if err = w.CheckFlush(started); err == nil || !strings.Contains(err.Error(), "No flush") {
+		t.Errorf("CheckFlush of a stalled writer returned %v", err)
+	}
+
+	// Failing half the series is the points' fault, more the store's
+	w.Add(ingest.Point{Series: "a", Timestamp: epoch - 600, Value: 2})
+	w.Add(ingest.Point{Series: "b", Timestamp: epoch, Value: 3})
+	w.Flush()
+	if last := w.LastFlush(); last.Series != 2 || last.Failed != 1 {
+		t.Fatalf("Last flush %+v", last)
+	}
+	if err = w.CheckFlush(started); err != nil {
+		t.Errorf("CheckFlush with half the series failed returned %v", err)
+	}
+	w.Add(ingest.Point{Series: "a", Timestamp: epoch - 600, Value: 2})
+	w.Flush()
+	if err = w.CheckFlush(started); err == nil || !strings.Contains(err.Error(), "1 of 1 series failed") {
+		t.Errorf("CheckFlush with every series failed returned %v", err)
+	}
+	w.Add(ingest.Point{Series: "b", Timestamp: epoch + 60, Value: 4})
+	w.Flush()
+	now.Advance(3 * time.Minute)
+	if err = w.CheckFlush(started); err != nil {
+		t.Errorf("CheckFlush after a good flush returned %v", err)
+	}
+
+	// The queue is full at 90% of MaxPending
+	for i := 0; i < 8; i++ {
+		w.Add(ingest.Point{Series: "b", Timestamp: epoch + int64(i)*60, Value: 5})
+	}
+	if err = w.CheckQueue(); err != nil {
+		t.Errorf("CheckQueue with 8 of 10 points returned %v", err)
+	}
+	w.Add(ingest.Point{Series: "c", Timestamp: epoch, Value: 6})
+	if err = w.CheckQueue(); err == nil {
+		t.Errorf("CheckQueue with 9 of 10 points passed")
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "journal-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := store.New(dir)
+	j, err := s.Create("a.b", 60, NewInt64ValueType(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Write(epoch, Int64Values{1, 2})
+	j.Close()
+
+	w := New(s)
+	s.Pending = w.Buffered
+	w.Add(ingest.Point{Series: "a.b", Timestamp: epoch + 60, Value: 20})
+	w.Add(ingest.Point{Series: "a.b", Timestamp: epoch + 180, Value: 40})
+	w.Add(ingest.Point{Series: "a.b", Timestamp: epoch + 900, Value: 50})
+
+	r := s.ReadMany([]string{"a.b"}, epoch, epoch+180)["a.b"]
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	if fmt.Sprint(r.Values) != "[1 20 -9223372036854775808 40]" {
+		t.Errorf("Read with pending points returned %v", r.Values)
+	}
+
+	w.Flush()
+	if points := w.Buffered("a.b", 0, epoch+1000); points != nil {
+		t.Errorf("Flushed points still buffered: %v", points)
+	}
+	r = s.ReadMany([]string{"a.b"}, epoch, epoch+180)["a.b"]
+	if fmt.Sprint(r.Values) != "[1 20 -9223372036854775808 40]" {
+		t.Errorf("Read after Flush returned %v", r.Values)
+	}
+}
+
+func TestAlignFlush(t *testing.T) {
+	w := New(nil)
+	w.FlushInterval = time.Minute
+	now := time.Unix(epoch+15, 0)
+	if d := w.untilFlush(now); d != time.Minute {
+		t.Errorf("Unaligned flush in %s", d)
+	}
+	w.AlignFlush = true
+	if d := w.untilFlush(now); d != 45*time.Second {
+		t.Errorf("Aligned flush in %s", d)
+	}
+	if d := w.untilFlush(time.Unix(epoch, 0)); d != time.Minute {
+		t.Errorf("Aligned flush on a boundary in %s", d)
+	}
+}